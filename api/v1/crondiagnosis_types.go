@@ -0,0 +1,115 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConcurrencyPolicy describes how a diagnosis created by a cron diagnosis will be treated
+// when it is time for a new run while the previous run has not finished yet.
+type ConcurrencyPolicy string
+
+const (
+	// AllowConcurrent allows diagnoses of a cron diagnosis to run concurrently.
+	AllowConcurrent ConcurrencyPolicy = "Allow"
+	// ForbidConcurrent forbids concurrent runs, skipping the next run if the previous one
+	// has not finished yet.
+	ForbidConcurrent ConcurrencyPolicy = "Forbid"
+	// ReplaceConcurrent deletes the currently running diagnosis and replaces it with a new one.
+	ReplaceConcurrent ConcurrencyPolicy = "Replace"
+)
+
+// CronDiagnosisSpec defines the desired state of CronDiagnosis.
+type CronDiagnosisSpec struct {
+	// Schedule is the schedule in cron format. See https://en.wikipedia.org/wiki/Cron.
+	Schedule string `json:"schedule"`
+	// ConcurrencyPolicy specifies how to treat concurrent executions of a diagnosis.
+	// One of Allow, Forbid and Replace. Defaults to Allow.
+	// +optional
+	ConcurrencyPolicy ConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+	// StartingDeadlineSeconds is the deadline in seconds for starting the diagnosis if it
+	// misses its scheduled time for any reason. Missed runs will be counted as failed ones.
+	// +optional
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+	// Suspend tells the controller to suspend subsequent executions. It does not apply to
+	// already started diagnoses. Defaults to false.
+	// +optional
+	Suspend *bool `json:"suspend,omitempty"`
+	// DiagnosisTemplate is the template of the diagnosis created when executing the cron
+	// diagnosis.
+	DiagnosisTemplate CronDiagnosisTemplate `json:"diagnosisTemplate"`
+	// SuccessfulDiagnosesHistoryLimit is the number of succeeded diagnoses to retain.
+	// Older diagnoses beyond the limit are deleted. Defaults to 3 if nil.
+	// +optional
+	SuccessfulDiagnosesHistoryLimit *int32 `json:"successfulDiagnosesHistoryLimit,omitempty"`
+	// FailedDiagnosesHistoryLimit is the number of failed diagnoses to retain.
+	// Older diagnoses beyond the limit are deleted. Defaults to 1 if nil.
+	// +optional
+	FailedDiagnosesHistoryLimit *int32 `json:"failedDiagnosesHistoryLimit,omitempty"`
+}
+
+// CronDiagnosisTemplate describes the diagnosis that will be created when executing a cron
+// diagnosis.
+type CronDiagnosisTemplate struct {
+	// Standard object's metadata of the diagnoses created from this template.
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	// Specification of the desired behavior of the diagnosis.
+	Spec DiagnosisSpec `json:"spec"`
+}
+
+// CronDiagnosisStatus defines the observed state of CronDiagnosis.
+type CronDiagnosisStatus struct {
+	// Active contains the names of currently running diagnoses created by the cron diagnosis.
+	// +optional
+	Active []string `json:"active,omitempty"`
+	// LastScheduleTime is the last time the cron diagnosis was successfully scheduled.
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:JSONPath=".spec.schedule",name=Schedule,type=string
+// +kubebuilder:printcolumn:JSONPath=".spec.suspend",name=Suspend,type=boolean
+// +kubebuilder:printcolumn:JSONPath=".status.lastScheduleTime",name=LastSchedule,type=date
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// CronDiagnosis is the Schema for the crondiagnoses API. A cron diagnosis creates diagnoses
+// from a template on a cron schedule, so recurring health sweeps do not need an external
+// system creating Diagnosis objects.
+type CronDiagnosis struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CronDiagnosisSpec   `json:"spec,omitempty"`
+	Status CronDiagnosisStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// CronDiagnosisList contains a list of CronDiagnosis.
+type CronDiagnosisList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CronDiagnosis `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CronDiagnosis{}, &CronDiagnosisList{})
+}
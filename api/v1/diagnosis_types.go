@@ -45,19 +45,105 @@ const (
 	OperationSetNotReady DiagnosisConditionType = "OperationSetNotReady"
 	// OperationSetNotFound means the operation set is not found when running Diagnosis.
 	OperationSetNotFound DiagnosisConditionType = "OperationSetNotFound"
+	// OperationSetNotVerified means the signature of the operation set could not be verified
+	// when running Diagnosis.
+	OperationSetNotVerified DiagnosisConditionType = "OperationSetNotVerified"
+	// DryRunCompleted means the dry run validation of the diagnosis has been completed and the
+	// would-be execution plan has been reported.
+	DryRunCompleted DiagnosisConditionType = "DryRunCompleted"
+	// ReplaySourceInvalid means the diagnosis referenced for replay is not found, has not
+	// completed or has no recorded context.
+	ReplaySourceInvalid DiagnosisConditionType = "ReplaySourceInvalid"
+	// TargetPodNotFound means a pod referenced by the target selector is not found.
+	TargetPodNotFound DiagnosisConditionType = "TargetPodNotFound"
+	// SymptomVerified means a verification node has confirmed that the original symptom of
+	// the diagnosis has been resolved after recovery operations.
+	SymptomVerified DiagnosisConditionType = "SymptomVerified"
+	// DeadlineExceeded means the diagnosis was active longer than its active deadline and has
+	// been terminated by the system.
+	DeadlineExceeded DiagnosisConditionType = "DeadlineExceeded"
+	// DiagnosisPaused means execution of the diagnosis is suspended. No operation is
+	// dispatched until the paused flag is cleared.
+	DiagnosisPaused DiagnosisConditionType = "Paused"
+	// DependenciesReady means all diagnoses this diagnosis depends on have succeeded. The
+	// diagnosis is held in Pending phase while the condition is false.
+	DependenciesReady DiagnosisConditionType = "DependenciesReady"
+	// DependencyFailed means a diagnosis this diagnosis depends on has failed.
+	DependencyFailed DiagnosisConditionType = "DependencyFailed"
 )
 
 // DiagnosisSpec defines the desired state of Diagnosis.
 type DiagnosisSpec struct {
 	// OperationSet is the name of operation set which represents diagnosis pipeline to be executed.
-	OperationSet string `json:"operationSet"`
+	// It may be empty on creation if Template is specified, in which case it is filled in from
+	// the template on admission.
+	// +optional
+	OperationSet string `json:"operationSet,omitempty"`
+	// Template is the name of a DiagnosisTemplate to instantiate the diagnosis from. The
+	// operation set and default parameters of the template are filled in on admission.
+	// Parameters specified on the diagnosis override the template defaults.
+	// +optional
+	Template string `json:"template,omitempty"`
 	// Parameters is a set of the parameters to be passed to operations.
 	// Parameters and OperationResults are encoded into a json object and sent to operation processor when
 	// running diagnosis.
 	// +optional
 	Parameters map[string]string `json:"parameters,omitempty"`
+	// ParameterFrom is a list of parameters resolved from secret or config map keys by the
+	// agent at execution time, so credentials for operation processors never appear in the
+	// diagnosis object.
+	// +optional
+	ParameterFrom []ParameterSource `json:"parameterFrom,omitempty"`
 	// TargetSelector contains information to calculate target node to schedule tasks on.
 	TargetSelector *TargetSelector `json:"targetSelector,omitempty"`
+	// DryRun validates the diagnosis without running any operation. The operation set is
+	// resolved, all referenced operations are validated and the would-be execution plan is
+	// reported in the DryRunCompleted condition, but no task is created.
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+	// ReplayOf references a completed diagnosis whose recorded operation context is replayed.
+	// The pipeline runs against the recorded results of the referenced diagnosis and node
+	// mutating operations are skipped, so operation set changes can be tested against real
+	// historical incidents.
+	// +optional
+	ReplayOf *NamespacedName `json:"replayOf,omitempty"`
+	// DependsOn contains references to diagnoses which must succeed before this diagnosis is
+	// started. The diagnosis is held in Pending phase until all of its dependencies have
+	// completed and fails with a DependencyFailed condition if any dependency fails. Results
+	// of the dependencies are injected into the diagnosis context as parameters, so a staged
+	// investigation can collect first and deep profile on the collected results. The namespace
+	// of a dependency defaults to the namespace of the diagnosis if empty.
+	// +optional
+	DependsOn []NamespacedName `json:"dependsOn,omitempty"`
+	// Priority is the priority of the diagnosis. Tasks of a diagnosis with a higher priority
+	// are executed before tasks of a diagnosis with a lower priority by agents under load.
+	// Defaults to 0.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+	// Parallelism is the maximum number of nodes of the operation set graph executed in
+	// parallel for the diagnosis. Sibling nodes of alternative paths sharing the executed
+	// prefix of the current path are executed speculatively in parallel, so a fallback
+	// after a failed operation can adopt results which are already computed. Results of
+	// sibling nodes are merged into the operation context keyed by path and node index,
+	// so the merge is deterministic. Defaults to 1, which executes paths sequentially.
+	// +optional
+	Parallelism *int32 `json:"parallelism,omitempty"`
+	// Paused suspends execution of the diagnosis. Queued operations are not dispatched and
+	// the diagnosis parks with a Paused condition until the flag is cleared, when execution
+	// resumes from the stored checkpoint.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+	// ActiveDeadlineSeconds is the duration in seconds relative to the start time of the
+	// diagnosis that the diagnosis may be active before the system terminates it. When the
+	// deadline is exceeded, the diagnosis transitions to Failed phase with a DeadlineExceeded
+	// condition and unfinished tasks of the diagnosis are deleted.
+	// +optional
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+	// TTLSecondsAfterFinished limits the lifetime of a diagnosis that has finished execution,
+	// either Succeeded or Failed. The diagnosis and its on-disk artifacts are deleted once the
+	// deadline after completion expires. The diagnosis is not deleted automatically if nil.
+	// +optional
+	TTLSecondsAfterFinished *int64 `json:"ttlSecondsAfterFinished,omitempty"`
 }
 
 // TargetSelector contains information to calculate target node to schedule tasks on.
@@ -66,12 +152,39 @@ type TargetSelector struct {
 	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
 	// NodeNames specifies nodes which tasks should be scheduled on.
 	NodeNames []string `json:"nodeNames,omitempty"`
-	// PodSelector queries over a set of pods. A tasks will be scheduled on the node which any matched pod is on.
+	// PodSelector queries over a set of pods. One task is created for every matched pod and
+	// operation results of the tasks are aggregated by pod name.
 	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// PodNamespace is the namespace the pod selector queries over. The pod selector matches
+	// pods in all namespaces if empty.
+	// +optional
+	PodNamespace string `json:"podNamespace,omitempty"`
 	// PodReferences specifies pods which tasks should be scheduled on the same node.
 	PodReferences []PodReference `json:"podReferences,omitempty"`
 }
 
+// ParameterSource resolves the value of a parameter from a secret or config map key in the
+// namespace of the diagnosis. The value is resolved by the agent at execution time and is not
+// stored in any api object. One of SecretKeyRef and ConfigMapKeyRef must be specified.
+type ParameterSource struct {
+	// Name is the key of the parameter to set.
+	Name string `json:"name"`
+	// SecretKeyRef selects a key of a secret.
+	// +optional
+	SecretKeyRef *KeySelector `json:"secretKeyRef,omitempty"`
+	// ConfigMapKeyRef selects a key of a config map.
+	// +optional
+	ConfigMapKeyRef *KeySelector `json:"configMapKeyRef,omitempty"`
+}
+
+// KeySelector selects a key of a secret or config map.
+type KeySelector struct {
+	// Name is the name of the secret or config map.
+	Name string `json:"name"`
+	// Key is the key to select.
+	Key string `json:"key"`
+}
+
 // DiagnosisStatus defines the observed state of Diagnosis.
 type DiagnosisStatus struct {
 	// Phase is a simple, high-level summary of where the diagnosis is in its lifecycle.
@@ -96,8 +209,23 @@ type DiagnosisStatus struct {
 	// StartTime is RFC 3339 date and time at which the object was acknowledged by the system.
 	// +optional
 	StartTime metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is RFC 3339 date and time at which the diagnosis reached a terminal phase.
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Duration is the human readable time taken from start to completion.
+	// +optional
+	Duration string `json:"duration,omitempty"`
 	// NodeNames contains all nodes which tasks should be scheduled on.
 	NodeNames []string `json:"nodeNames,omitempty"`
+	// NodeStatuses contains the phase of the task running the current operation on every
+	// target node keyed by node name. It is updated as tasks complete, so partial results
+	// of a fan out diagnosis are visible while tasks on other nodes are still running.
+	// +optional
+	NodeStatuses map[string]TaskPhase `json:"nodeStatuses,omitempty"`
+	// PodReferences contains all pods matched by the pod selector of the target selector.
+	// One task is created for every referenced pod.
+	// +optional
+	PodReferences []PodReference `json:"podReferences,omitempty"`
 	// FailedPaths contains all failed paths in diagnosis pipeline.
 	// The last node in the path is the one which fails to execute operation.
 	// +optional
@@ -111,6 +239,110 @@ type DiagnosisStatus struct {
 	// Context stores all information generated during the diagnosis execution.
 	// +optional
 	Context *DiagnosisContext `json:"context,omitempty"`
+	// Progress contains the latest progress reported by running operation processors keyed
+	// by operation name. An entry is removed once the task running the operation completes.
+	// +optional
+	Progress map[string]*OperationProgress `json:"progress,omitempty"`
+	// OperationResults contains the structured results of completed operations of the
+	// diagnosis. An entry is recorded for every succeeded task whose result contains a
+	// result envelope under the "result.envelope" key.
+	// +optional
+	OperationResults []OperationResult `json:"operationResults,omitempty"`
+	// CurrentPathIndex is the index of the path of the operation set graph currently being
+	// executed.
+	// +optional
+	CurrentPathIndex int `json:"currentPathIndex,omitempty"`
+	// CurrentOperation is the name of the operation currently being executed.
+	// +optional
+	CurrentOperation string `json:"currentOperation,omitempty"`
+	// CompletedNodes is the number of nodes of the current path which have completed.
+	// +optional
+	CompletedNodes int `json:"completedNodes,omitempty"`
+	// TotalNodes is the number of nodes of the current path.
+	// +optional
+	TotalNodes int `json:"totalNodes,omitempty"`
+}
+
+// ResultEnvelopeKey is the result key under which a processor may return a JSON encoded
+// ResultEnvelope.
+const ResultEnvelopeKey = "result.envelope"
+
+// ResultEnvelope is the structured result document a processor may return under the
+// "result.envelope" result key. It is parsed and stored as typed fields in the diagnosis
+// status, so downstream tooling can consume results programmatically.
+type ResultEnvelope struct {
+	// Status reports the outcome of the operation declared by the processor. One of
+	// Succeeded and Failed.
+	Status string `json:"status"`
+	// Summary is a short human readable summary of the outcome.
+	// +optional
+	Summary string `json:"summary,omitempty"`
+	// Findings contains the individual findings of the operation.
+	// +optional
+	Findings []Finding `json:"findings,omitempty"`
+	// Artifacts contains references to artifacts produced by the operation.
+	// +optional
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+}
+
+// OperationResult is the structured result of a completed operation parsed from the result
+// envelope returned by its processor.
+type OperationResult struct {
+	// Operation is the name of the operation which produced the result.
+	Operation string `json:"operation"`
+	// Task is the name of the task which produced the result.
+	Task string `json:"task"`
+	// NodeName is the node the task ran on.
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+	// Status reports the outcome of the operation declared by the processor. One of
+	// Succeeded and Failed.
+	Status string `json:"status"`
+	// Summary is a short human readable summary of the outcome.
+	// +optional
+	Summary string `json:"summary,omitempty"`
+	// Findings contains the individual findings of the operation.
+	// +optional
+	Findings []Finding `json:"findings,omitempty"`
+	// Artifacts contains references to artifacts produced by the operation.
+	// +optional
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+}
+
+// Finding is a single finding of an operation.
+type Finding struct {
+	// Severity is the severity of the finding. One of Info, Warning and Critical.
+	// +optional
+	Severity string `json:"severity,omitempty"`
+	// Message is a human readable description of the finding.
+	Message string `json:"message"`
+	// Resource is the resource the finding refers to, such as a pod or container name.
+	// +optional
+	Resource string `json:"resource,omitempty"`
+}
+
+// Artifact is a reference to an artifact produced by an operation.
+type Artifact struct {
+	// Name is the name of the artifact.
+	Name string `json:"name"`
+	// Location is the path or url where the artifact is stored.
+	Location string `json:"location"`
+	// MediaType is the media type of the artifact.
+	// +optional
+	MediaType string `json:"mediaType,omitempty"`
+}
+
+// OperationProgress is the latest progress reported by a running operation processor.
+type OperationProgress struct {
+	// Percentage is the completion percentage of the operation between 0 and 100.
+	// +optional
+	Percentage int32 `json:"percentage,omitempty"`
+	// Message is a human readable description of the current phase of the operation.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// UpdateTime is the time at which the progress was last reported.
+	// +optional
+	UpdateTime metav1.Time `json:"updateTime,omitempty"`
 }
 
 // DiagnosisCondition contains details for the current condition of this diagnosis.
@@ -148,6 +380,28 @@ type Checkpoint struct {
 	Failed int `json:"failed"`
 	// SynchronizedTasks contains a list of synchronized task of current checkpoint.
 	SynchronizedTasks []string `json:"synchronizedTasks,omitempty"`
+	// Siblings tracks the progress of sibling nodes of alternative paths executed in
+	// parallel with the current node.
+	// +optional
+	Siblings []SiblingCheckpoint `json:"siblings,omitempty"`
+}
+
+// SiblingCheckpoint tracks the progress of a sibling node of an alternative path executed
+// in parallel with the current node of a diagnosis.
+type SiblingCheckpoint struct {
+	// PathIndex is the index of the alternative path in operation set status.
+	PathIndex int `json:"pathIndex"`
+	// The number of desired tasks.
+	Desired int `json:"desired"`
+	// The number of pending and running tasks.
+	Active int `json:"active"`
+	// The number of tasks which reached phase Succeeded.
+	Succeeded int `json:"succeeded"`
+	// The number of tasks which reached phase Failed.
+	Failed int `json:"failed"`
+	// SynchronizedTasks contains a list of synchronized task of the sibling node.
+	// +optional
+	SynchronizedTasks []string `json:"synchronizedTasks,omitempty"`
 }
 
 // DiagnosisContext stores all information generated during the diagnosis execution.
@@ -176,6 +430,11 @@ type DiagnosisConditionType string
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:JSONPath=".spec.operationSet",name=OperationSet,type=string
 // +kubebuilder:printcolumn:JSONPath=".status.phase",name=Phase,type=string
+// +kubebuilder:printcolumn:JSONPath=".status.nodeNames",name=Nodes,type=string
+// +kubebuilder:printcolumn:JSONPath=".status.currentOperation",name=Operation,type=string
+// +kubebuilder:printcolumn:JSONPath=".status.completedNodes",name=Completed,type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.totalNodes",name=Total,type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.duration",name=Duration,type=string
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // Diagnosis is the Schema for the diagnoses API.
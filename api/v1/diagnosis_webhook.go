@@ -17,6 +17,9 @@ limitations under the License.
 package v1
 
 import (
+	"context"
+	"fmt"
+
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -30,8 +33,15 @@ import (
 // diagnosislog is for logging of diagnosis webhook.
 var diagnosislog = logf.Log.WithName("diagnosis-webhook")
 
+// diagnosisWebhookClient knows how to perform CRUD operations on Kubernetes objects. It is set
+// when the Diagnosis webhook is registered with the manager and used to validate diagnosis
+// parameters against the parameter schemas of operations in the operation set.
+var diagnosisWebhookClient client.Client
+
 // SetupWebhookWithManager setups the Diagnosis webhook.
 func (r *Diagnosis) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	diagnosisWebhookClient = mgr.GetClient()
+
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
 		Complete()
@@ -47,6 +57,29 @@ func (r *Diagnosis) Default() {
 		Name:      r.Name,
 		Namespace: r.Namespace,
 	})
+
+	// Fill in the operation set and default parameters from the referenced diagnosis template.
+	if r.Spec.Template == "" || diagnosisWebhookClient == nil {
+		return
+	}
+	var template DiagnosisTemplate
+	if err := diagnosisWebhookClient.Get(context.Background(), client.ObjectKey{Name: r.Spec.Template}, &template); err != nil {
+		diagnosislog.Error(err, "unable to fetch DiagnosisTemplate", "diagnosistemplate", r.Spec.Template)
+		return
+	}
+
+	if r.Spec.OperationSet == "" {
+		r.Spec.OperationSet = template.Spec.OperationSet
+	}
+	for key, value := range template.Spec.Parameters {
+		if _, ok := r.Spec.Parameters[key]; ok {
+			continue
+		}
+		if r.Spec.Parameters == nil {
+			r.Spec.Parameters = make(map[string]string)
+		}
+		r.Spec.Parameters[key] = value
+	}
 }
 
 // +kubebuilder:webhook:verbs=create;update,path=/validate-diagnosis-kubediag-org-v1-diagnosis,mutating=false,failurePolicy=fail,groups=diagnosis.kubediag.org,resources=diagnoses,versions=v1,name=vdiagnosis.kb.io
@@ -87,10 +120,19 @@ func (r *Diagnosis) ValidateDelete() error {
 func (r *Diagnosis) validateDiagnosis() error {
 	var allErrs field.ErrorList
 
-	if r.Spec.OperationSet == "" {
+	if r.Spec.OperationSet == "" && r.Spec.Template == "" {
 		allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("operationSet"),
-			r.Spec.OperationSet, "must not be empty"))
+			r.Spec.OperationSet, "must not be empty if no template is referenced"))
+	}
+	if r.Spec.Template != "" && diagnosisWebhookClient != nil {
+		var template DiagnosisTemplate
+		if err := diagnosisWebhookClient.Get(context.Background(), client.ObjectKey{Name: r.Spec.Template}, &template); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("template"),
+				r.Spec.Template, fmt.Sprintf("unable to fetch DiagnosisTemplate: %v", err)))
+		}
 	}
+	allErrs = append(allErrs, r.validateParameters()...)
+	allErrs = append(allErrs, r.validateDependsOn()...)
 	if len(allErrs) == 0 {
 		return nil
 	}
@@ -99,3 +141,66 @@ func (r *Diagnosis) validateDiagnosis() error {
 		schema.GroupKind{Group: "diagnosis.kubediag.org", Kind: "Diagnosis"},
 		r.Name, allErrs)
 }
+
+// validateDependsOn validates the dependency references of the diagnosis. A dependency must
+// reference a diagnosis by name and a diagnosis must not depend on itself.
+func (r *Diagnosis) validateDependsOn() field.ErrorList {
+	var allErrs field.ErrorList
+	for i, reference := range r.Spec.DependsOn {
+		if reference.Name == "" {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("dependsOn").Index(i),
+				reference, "name must not be empty"))
+			continue
+		}
+		namespace := reference.Namespace
+		if namespace == "" {
+			namespace = r.Namespace
+		}
+		if reference.Name == r.Name && namespace == r.Namespace {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("dependsOn").Index(i),
+				reference, "diagnosis must not depend on itself"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateParameters validates the diagnosis parameters against the parameter schemas of all
+// operations in the operation set, so a mismatch is rejected on admission with a precise
+// message instead of failing deep in execution. Operation sets and operations which are not
+// found are skipped since their existence is verified by the diagnosis controller.
+func (r *Diagnosis) validateParameters() field.ErrorList {
+	var allErrs field.ErrorList
+	if diagnosisWebhookClient == nil || r.Spec.OperationSet == "" {
+		return allErrs
+	}
+
+	ctx := context.Background()
+	var operationSet OperationSet
+	if err := diagnosisWebhookClient.Get(ctx, client.ObjectKey{Name: r.Spec.OperationSet}, &operationSet); err != nil {
+		return allErrs
+	}
+
+	validated := make(map[string]bool)
+	for _, node := range operationSet.Spec.AdjacencyList {
+		if node.Operation == "" || validated[node.Operation] {
+			continue
+		}
+		validated[node.Operation] = true
+
+		var operation Operation
+		if err := diagnosisWebhookClient.Get(ctx, client.ObjectKey{Name: node.Operation}, &operation); err != nil {
+			continue
+		}
+		if operation.Spec.ParameterSchema == nil {
+			continue
+		}
+
+		for _, violation := range operation.Spec.ParameterSchema.Validate(r.Spec.Parameters) {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("parameters"),
+				r.Spec.Parameters, fmt.Sprintf("operation %s: %s", operation.Name, violation)))
+		}
+	}
+
+	return allErrs
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DiagnosisReportStatus defines the observed state of DiagnosisReport.
+type DiagnosisReportStatus struct {
+	// UpdateTime is RFC 3339 date and time at which the report was last updated.
+	// +optional
+	UpdateTime metav1.Time `json:"updateTime,omitempty"`
+	// Window is the human readable length of the rolling window the statistics cover.
+	// +optional
+	Window string `json:"window,omitempty"`
+	// Cluster contains aggregate statistics over all diagnoses completed in the window.
+	// +optional
+	Cluster DiagnosisStatistics `json:"cluster,omitempty"`
+	// Nodes contains aggregate statistics by node.
+	// +optional
+	Nodes []NodeDiagnosisStatistics `json:"nodes,omitempty"`
+	// OperationSets contains aggregate statistics by operation set.
+	// +optional
+	OperationSets []OperationSetDiagnosisStatistics `json:"operationSets,omitempty"`
+}
+
+// DiagnosisStatistics contains aggregate statistics of diagnoses completed in a rolling window.
+type DiagnosisStatistics struct {
+	// Total is the number of diagnoses completed in the window.
+	Total int `json:"total"`
+	// Succeeded is the number of diagnoses which reached phase Succeeded.
+	Succeeded int `json:"succeeded"`
+	// Failed is the number of diagnoses which reached phase Failed.
+	Failed int `json:"failed"`
+	// SuccessRate is the percentage of succeeded diagnoses out of total diagnoses.
+	SuccessRate int `json:"successRate"`
+	// MeanDuration is the human readable mean time taken from start to completion.
+	// +optional
+	MeanDuration string `json:"meanDuration,omitempty"`
+	// TopFailureReasons contains the most frequent failure reasons in descending order of count.
+	// +optional
+	TopFailureReasons []FailureReason `json:"topFailureReasons,omitempty"`
+}
+
+// FailureReason is the number of failed diagnoses by reason.
+type FailureReason struct {
+	// Reason is a unique, one-word, CamelCase reason for the diagnosis failure.
+	Reason string `json:"reason"`
+	// Count is the number of failed diagnoses with the reason.
+	Count int `json:"count"`
+}
+
+// NodeDiagnosisStatistics contains aggregate statistics of diagnoses run on a node.
+type NodeDiagnosisStatistics struct {
+	// NodeName specifies the name of the node.
+	NodeName string `json:"nodeName"`
+
+	DiagnosisStatistics `json:",inline"`
+}
+
+// OperationSetDiagnosisStatistics contains aggregate statistics of diagnoses of an operation set.
+type OperationSetDiagnosisStatistics struct {
+	// OperationSet specifies the name of the operation set.
+	OperationSet string `json:"operationSet"`
+
+	DiagnosisStatistics `json:",inline"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:JSONPath=".status.cluster.total",name=Total,type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.cluster.successRate",name=SuccessRate,type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.window",name=Window,type=string
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// DiagnosisReport is the Schema for the diagnosisreports API.
+type DiagnosisReport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status DiagnosisReportStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DiagnosisReportList contains a list of DiagnosisReport.
+type DiagnosisReportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DiagnosisReport `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DiagnosisReport{}, &DiagnosisReportList{})
+}
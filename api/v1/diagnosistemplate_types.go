@@ -0,0 +1,61 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DiagnosisTemplateSpec defines the desired state of DiagnosisTemplate.
+type DiagnosisTemplateSpec struct {
+	// OperationSet is the name of the operation set of diagnoses instantiated from the
+	// template.
+	OperationSet string `json:"operationSet"`
+	// Parameters contains the default parameters of diagnoses instantiated from the template.
+	// Parameters specified on a diagnosis override the defaults with the same key.
+	// +optional
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:JSONPath=".spec.operationSet",name=OperationSet,type=string
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// DiagnosisTemplate is the Schema for the diagnosistemplates API. A diagnosis template holds
+// the operation set and default parameters shared by recurring diagnoses, so a diagnosis can
+// be instantiated by referencing the template and supplying only the target and parameter
+// overrides.
+type DiagnosisTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec DiagnosisTemplateSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DiagnosisTemplateList contains a list of DiagnosisTemplate.
+type DiagnosisTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DiagnosisTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DiagnosisTemplate{}, &DiagnosisTemplateList{})
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IncidentStatus defines the observed state of Incident.
+type IncidentStatus struct {
+	// StartTime is the start time of the earliest diagnosis in the incident.
+	// +optional
+	StartTime metav1.Time `json:"startTime,omitempty"`
+	// UpdateTime is the time at which the incident summary was last updated.
+	// +optional
+	UpdateTime metav1.Time `json:"updateTime,omitempty"`
+	// AlertFingerprint is the fingerprint of the prometheus alert the diagnoses of the
+	// incident were created from. It is empty if the diagnoses were correlated by their
+	// start times instead of an alert.
+	// +optional
+	AlertFingerprint string `json:"alertFingerprint,omitempty"`
+	// Total is the number of diagnoses which belong to the incident.
+	// +optional
+	Total int `json:"total,omitempty"`
+	// Diagnoses contains the names of all diagnoses which belong to the incident.
+	// +optional
+	Diagnoses []string `json:"diagnoses,omitempty"`
+	// Nodes contains all nodes the diagnoses of the incident were scheduled on.
+	// +optional
+	Nodes []string `json:"nodes,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Total",type=integer,JSONPath=`.status.total`
+// +kubebuilder:printcolumn:name="Nodes",type=string,JSONPath=`.status.nodes`
+// +kubebuilder:printcolumn:name="StartTime",type=date,JSONPath=`.status.startTime`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Incident is the Schema for the incidents API. An incident summarizes a group of correlated
+// diagnoses which were created from the same alert or within the same time window, so related
+// diagnoses across nodes can be surveyed as one outage.
+type Incident struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status IncidentStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IncidentList contains a list of Incident.
+type IncidentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Incident `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Incident{}, &IncidentList{})
+}
@@ -17,6 +17,11 @@ limitations under the License.
 package v1
 
 import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -25,8 +30,19 @@ const (
 	Python3FunctionRuntime FunctionRuntime = "python3"
 	// GolangFunctionRuntime is the runtime for running golang functions
 	GolangFunctionRuntime FunctionRuntime = "golang-http"
+	// WASMFunctionRuntime is the runtime for running WebAssembly modules in process in the
+	// kubediag agent
+	WASMFunctionRuntime FunctionRuntime = "wasm"
+
+	// OperationReady means that the processor endpoint passed its last health check.
+	OperationReady OperationPhase = "Ready"
+	// OperationUnhealthy means that the processor endpoint failed its last health check.
+	OperationUnhealthy OperationPhase = "Unhealthy"
 )
 
+// OperationPhase is a label for the health of an operation processor at the current time.
+type OperationPhase string
+
 // OperationSpec defines the desired state of Operation.
 type OperationSpec struct {
 	// Processor describes how to register a operation processor into kubediag.
@@ -38,6 +54,15 @@ type OperationSpec struct {
 	// Operation results will not be stored if nil.
 	// +optional
 	Storage *Storage `json:"storage,omitempty"`
+	// Workspace represents the working directory of the operation on the node.
+	// The operation runs against the shared kubediag agent data root if nil.
+	// +optional
+	Workspace *Workspace `json:"workspace,omitempty"`
+	// ParameterSchema declares the parameters the operation expects. Parameters of a
+	// diagnosis are validated against the schema by the admission webhook and the executor.
+	// Parameters are not validated if nil.
+	// +optional
+	ParameterSchema *ParameterSchema `json:"parameterSchema,omitempty"`
 }
 
 // Processor describes how to register a operation processor into kubediag.
@@ -46,6 +71,12 @@ type Processor struct {
 	// HTTPServer specifies the http server to do operations.
 	// +optional
 	HTTPServer *HTTPServer `json:"httpServer,omitempty"`
+	// GRPCServer specifies the grpc server to do operations. The operation context is sent
+	// to the processor in a ProcessRequest and results are streamed back in ProcessResponse
+	// chunks as defined in api/proto/processor.proto, so large results are transferred
+	// efficiently.
+	// +optional
+	GRPCServer *GRPCServer `json:"grpcServer,omitempty"`
 	// ScriptRunner contains the information to run a script.
 	// +optional
 	ScriptRunner *ScriptRunner `json:"scriptRunner,omitempty"`
@@ -72,6 +103,134 @@ type HostPath struct {
 	Path string `json:"path"`
 }
 
+// Workspace represents the working directory of an operation on the node.
+// One and only one of the following workspace sources should be specified.
+type Workspace struct {
+	// HostPath represents a dedicated directory on the host, such as a scratch disk mount,
+	// which is shared by all tasks of the operation.
+	// +optional
+	HostPath *HostPath `json:"hostPath,omitempty"`
+	// EphemeralDir represents a size limited directory created under the data root for every
+	// diagnosis and removed when the diagnosis data is garbage collected.
+	// +optional
+	EphemeralDir *EphemeralDir `json:"ephemeralDir,omitempty"`
+}
+
+// ParameterSchema declares the parameters an operation expects.
+type ParameterSchema struct {
+	// Parameters contains the declarations of expected parameters keyed by parameter name.
+	// Parameters which are not declared in the schema are allowed and passed through.
+	Parameters map[string]ParameterDeclaration `json:"parameters"`
+}
+
+// ParameterDeclaration declares an expected parameter of an operation.
+type ParameterDeclaration struct {
+	// Type is the expected type of the parameter value. One of string, integer, number and
+	// boolean. Defaults to string if not specified.
+	// +optional
+	Type ParameterType `json:"type,omitempty"`
+	// Required indicates whether the parameter must be specified.
+	// +optional
+	Required bool `json:"required,omitempty"`
+	// Pattern is a regular expression the parameter value must match.
+	// +optional
+	Pattern string `json:"pattern,omitempty"`
+	// Enum contains all allowed values of the parameter.
+	// +optional
+	Enum []string `json:"enum,omitempty"`
+}
+
+// ParameterType is the expected type of a declared parameter value.
+type ParameterType string
+
+const (
+	// StringParameter means that the parameter value may be any string.
+	StringParameter ParameterType = "string"
+	// IntegerParameter means that the parameter value must be an integer.
+	IntegerParameter ParameterType = "integer"
+	// NumberParameter means that the parameter value must be a number.
+	NumberParameter ParameterType = "number"
+	// BooleanParameter means that the parameter value must be either true or false.
+	BooleanParameter ParameterType = "boolean"
+)
+
+// Validate validates parameters against the schema. It returns a message per violation with
+// the name of the parameter and the precise reason, and an empty list if all parameters are
+// valid.
+func (s *ParameterSchema) Validate(parameters map[string]string) []string {
+	names := make([]string, 0, len(s.Parameters))
+	for name := range s.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	violations := make([]string, 0)
+	for _, name := range names {
+		declaration := s.Parameters[name]
+		value, ok := parameters[name]
+		if !ok {
+			if declaration.Required {
+				violations = append(violations, fmt.Sprintf("parameter %q is required", name))
+			}
+			continue
+		}
+
+		switch declaration.Type {
+		case IntegerParameter:
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				violations = append(violations, fmt.Sprintf("parameter %q has value %q which is not a valid integer", name, value))
+				continue
+			}
+		case NumberParameter:
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				violations = append(violations, fmt.Sprintf("parameter %q has value %q which is not a valid number", name, value))
+				continue
+			}
+		case BooleanParameter:
+			if value != "true" && value != "false" {
+				violations = append(violations, fmt.Sprintf("parameter %q has value %q which is not either true or false", name, value))
+				continue
+			}
+		}
+
+		if declaration.Pattern != "" {
+			matched, err := regexp.MatchString(declaration.Pattern, value)
+			if err != nil {
+				violations = append(violations, fmt.Sprintf("parameter %q has an invalid pattern %q: %v", name, declaration.Pattern, err))
+				continue
+			}
+			if !matched {
+				violations = append(violations, fmt.Sprintf("parameter %q has value %q which does not match pattern %q", name, value, declaration.Pattern))
+				continue
+			}
+		}
+
+		if len(declaration.Enum) != 0 {
+			allowed := false
+			for _, enum := range declaration.Enum {
+				if value == enum {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				violations = append(violations, fmt.Sprintf("parameter %q has value %q which is not one of %v", name, value, declaration.Enum))
+			}
+		}
+	}
+
+	return violations
+}
+
+// EphemeralDir represents a size limited ephemeral working directory.
+type EphemeralDir struct {
+	// SizeLimitBytes is the maximum total size of the working directory in bytes. The task
+	// fails and the working directory is removed if the limit is exceeded after the operation
+	// has run. A non positive value disables the limit.
+	// +optional
+	SizeLimitBytes int64 `json:"sizeLimitBytes,omitempty"`
+}
+
 // HTTPServer specifies the http server to do operations.
 type HTTPServer struct {
 	// Address is the serving address of the processor. It must be either an ip or a dns address.
@@ -90,10 +249,24 @@ type HTTPServer struct {
 	Scheme *string `json:"scheme,omitempty"`
 }
 
+// GRPCServer specifies the grpc server to do operations.
+type GRPCServer struct {
+	// Address is the serving address of the processor. It must be either an ip or a dns address.
+	// Defaults to kubediag agent advertised address if not specified.
+	// +optional
+	Address *string `json:"address,omitempty"`
+	// Port is the serving port of the processor.
+	Port int32 `json:"port"`
+}
+
 // ScriptRunner contains the information to run a script.
 type ScriptRunner struct {
 	// Script is the content of shell script.
 	Script string `json:"script"`
+	// Interpreter is the command used to run the script, such as /bin/bash or /usr/bin/python3.
+	// Defaults to /bin/sh if not specified.
+	// +optional
+	Interpreter *string `json:"interpreter,omitempty"`
 	// ArgKeys contains a slice of keys in parameters or operationResults. The script arguments are generated
 	// from specified key value pairs.
 	// No argument will be passed to the script if not specified.
@@ -116,15 +289,35 @@ type Function struct {
 // FunctionRuntime is a valid value for Function.Runtime.
 type FunctionRuntime string
 
+// OperationStatus defines the observed state of Operation.
+type OperationStatus struct {
+	// Phase is a simple, high-level summary of the health of the operation processor.
+	// There are two possible phase values:
+	//
+	// OperationReady: The processor endpoint passed its last health check.
+	// OperationUnhealthy: The processor endpoint failed its last health check.
+	// +optional
+	Phase OperationPhase `json:"phase,omitempty"`
+	// LastProbeTime is the time of the last processor health check.
+	// +optional
+	LastProbeTime *metav1.Time `json:"lastProbeTime,omitempty"`
+	// Message is a human readable message indicating details of the last health check.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
 // +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:JSONPath=".status.phase",name=Phase,type=string
 
 // Operation is the Schema for the operations API.
 type Operation struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec OperationSpec `json:"spec,omitempty"`
+	Spec   OperationSpec   `json:"spec,omitempty"`
+	Status OperationStatus `json:"status,omitempty"`
 }
 
 // +kubebuilder:object:root=true
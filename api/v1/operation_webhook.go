@@ -17,7 +17,9 @@ limitations under the License.
 package v1
 
 import (
+	"fmt"
 	"net"
+	"regexp"
 
 	"github.com/asaskevich/govalidator"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -92,12 +94,23 @@ func (r *Operation) ValidateDelete() error {
 func (r *Operation) validateOperation() error {
 	var allErrs field.ErrorList
 
-	if r.Spec.Processor.HTTPServer == nil && r.Spec.Processor.ScriptRunner == nil && r.Spec.Processor.Function == nil {
+	specified := 0
+	if r.Spec.Processor.HTTPServer != nil {
+		specified++
+	}
+	if r.Spec.Processor.GRPCServer != nil {
+		specified++
+	}
+	if r.Spec.Processor.ScriptRunner != nil {
+		specified++
+	}
+	if r.Spec.Processor.Function != nil {
+		specified++
+	}
+	if specified == 0 {
 		allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("processor"),
-			r.Spec.Processor, "must specify http server, script runner or function"))
-	} else if r.Spec.Processor.HTTPServer != nil && r.Spec.Processor.ScriptRunner != nil ||
-		r.Spec.Processor.HTTPServer != nil && r.Spec.Processor.Function != nil ||
-		r.Spec.Processor.ScriptRunner != nil && r.Spec.Processor.Function != nil {
+			r.Spec.Processor, "must specify http server, grpc server, script runner or function"))
+	} else if specified > 1 {
 		allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("processor"),
 			r.Spec.Processor, "one and only one processor should be specified."))
 	} else if r.Spec.Processor.HTTPServer != nil {
@@ -119,8 +132,19 @@ func (r *Operation) validateOperation() error {
 					r.Spec.Processor.HTTPServer.Scheme, "must be either http or https"))
 			}
 		}
+	} else if r.Spec.Processor.GRPCServer != nil {
+		if r.Spec.Processor.GRPCServer.Address != nil {
+			if net.ParseIP(*r.Spec.Processor.GRPCServer.Address) == nil && !govalidator.IsDNSName(*r.Spec.Processor.GRPCServer.Address) {
+				allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("processor").Child("grpcServer").Child("address"),
+					r.Spec.Processor.GRPCServer.Address, "must be a valid ip or dns address"))
+			}
+		}
+		if r.Spec.Processor.GRPCServer.Port <= 0 || r.Spec.Processor.GRPCServer.Port > 65535 {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("processor").Child("grpcServer").Child("port"),
+				r.Spec.Processor.GRPCServer.Port, "must be greater than 0 and less equal to 65535"))
+		}
 	} else if r.Spec.Processor.Function != nil {
-		if r.Spec.Processor.Function.Runtime != Python3FunctionRuntime && r.Spec.Processor.Function.Runtime != GolangFunctionRuntime {
+		if r.Spec.Processor.Function.Runtime != Python3FunctionRuntime && r.Spec.Processor.Function.Runtime != GolangFunctionRuntime && r.Spec.Processor.Function.Runtime != WASMFunctionRuntime {
 			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("processor").Child("function").Child("runtime"),
 				r.Spec.Processor.Function.Runtime, "must be supported function runtime"))
 		}
@@ -131,6 +155,22 @@ func (r *Operation) validateOperation() error {
 				r.Spec.Processor.TimeoutSeconds, "must be greater than 0"))
 		}
 	}
+	if r.Spec.ParameterSchema != nil {
+		for name, declaration := range r.Spec.ParameterSchema.Parameters {
+			switch declaration.Type {
+			case "", StringParameter, IntegerParameter, NumberParameter, BooleanParameter:
+			default:
+				allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("parameterSchema").Child("parameters").Key(name).Child("type"),
+					declaration.Type, "must be one of string, integer, number and boolean"))
+			}
+			if declaration.Pattern != "" {
+				if _, err := regexp.Compile(declaration.Pattern); err != nil {
+					allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("parameterSchema").Child("parameters").Key(name).Child("pattern"),
+						declaration.Pattern, fmt.Sprintf("must be a valid regular expression: %v", err)))
+				}
+			}
+		}
+	}
 	if len(allErrs) == 0 {
 		return nil
 	}
@@ -25,8 +25,42 @@ type OperationSetSpec struct {
 	// AdjacencyList contains all nodes in the directed acyclic graph. The first node in the list represents the
 	// start of a diagnosis.
 	AdjacencyList []Node `json:"adjacencyList"`
+	// RevisionHistoryLimit is the maximum number of revisions of the adjacency list retained
+	// in the status to allow rollbacks and to pin running diagnoses to the revision they
+	// started with. Defaults to 10.
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// RollbackTo is the revision to roll the adjacency list back to. The field is cleared
+	// after the rollback is performed.
+	// +optional
+	RollbackTo *int64 `json:"rollbackTo,omitempty"`
+	// FailurePolicy specifies how a failed operation on a path is handled. Abort fails the
+	// diagnosis on the first failed operation without trying alternative paths, so operations
+	// with destructive side effects never run after an upstream failure. ContinueSiblingPaths
+	// marks the current path as failed and falls back to an alternative path in the graph.
+	// IgnoreAndContinue records the failure and continues with the next operation on the same
+	// path. A failed verification node always falls back to an alternative path, since
+	// ignoring an unresolved symptom would wrongly complete the diagnosis.
+	// Defaults to ContinueSiblingPaths.
+	// +optional
+	FailurePolicy FailurePolicy `json:"failurePolicy,omitempty"`
 }
 
+// FailurePolicy is the policy of handling a failed operation on a path.
+type FailurePolicy string
+
+const (
+	// FailurePolicyAbort fails the diagnosis on the first failed operation without trying
+	// alternative paths.
+	FailurePolicyAbort FailurePolicy = "Abort"
+	// FailurePolicyContinueSiblingPaths marks the current path as failed and falls back to
+	// an alternative path in the graph.
+	FailurePolicyContinueSiblingPaths FailurePolicy = "ContinueSiblingPaths"
+	// FailurePolicyIgnoreAndContinue records the failure and continues with the next
+	// operation on the same path.
+	FailurePolicyIgnoreAndContinue FailurePolicy = "IgnoreAndContinue"
+)
+
 // Node is a node in the directed acyclic graph. It contains details of the operation.
 type Node struct {
 	// ID is the unique identifier of the node.
@@ -43,6 +77,34 @@ type Node struct {
 	// Dependences is the list of depended node ids.
 	// +optional
 	Dependences NodeSet `json:"dependences,omitempty"`
+	// When restricts traversal into this node to parent operation results matching all
+	// listed expressions. Keys are result keys of the parent operation and values are
+	// regular expressions the result values must match. A path is skipped instead of
+	// being marked as failed if the results of the parent operation do not match, so one
+	// operation set can encode a decision tree branching on operation results.
+	// +optional
+	When map[string]string `json:"when,omitempty"`
+	// TimeoutSeconds overrides the timeout of the operation processor for tasks running on
+	// this node, so a hung processor cannot stall the whole path. The timeout of the operation
+	// is used if nil.
+	// +optional
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+	// Retries is the number of times a transient processor failure of the operation is
+	// retried by the executor before the node is marked as failed. The default backoff of
+	// the executor is used if nil.
+	// +optional
+	Retries *int32 `json:"retries,omitempty"`
+	// BackoffSeconds is the initial delay before the first retry of a failed operation. The
+	// delay is doubled on every subsequent retry. It is only honored if Retries is specified.
+	// +optional
+	BackoffSeconds *int32 `json:"backoffSeconds,omitempty"`
+	// Verification indicates that the operation running on the node re-checks the original
+	// symptom of the diagnosis after recovery operations have run. A diagnosis which completes
+	// a path ending in a succeeded verification node has its SymptomVerified condition set to
+	// true. If the verification fails, the diagnosis falls back to an alternative path in the
+	// graph like any other failed node.
+	// +optional
+	Verification bool `json:"verification,omitempty"`
 }
 
 // NodeSet is the set of node ids.
@@ -55,6 +117,26 @@ type OperationSetStatus struct {
 	Paths []Path `json:"paths,omitempty"`
 	// Specifies whether a valid directed acyclic graph can be generated via provided nodes.
 	Ready bool `json:"ready"`
+	// Revision is the revision number of the latest recorded revision of the adjacency list.
+	// +optional
+	Revision int64 `json:"revision,omitempty"`
+	// Revisions is the history of recorded revisions of the adjacency list, oldest first.
+	// Old revisions are pruned according to RevisionHistoryLimit.
+	// +optional
+	Revisions []OperationSetRevision `json:"revisions,omitempty"`
+}
+
+// OperationSetRevision is a recorded revision of the adjacency list of an operation set.
+type OperationSetRevision struct {
+	// Revision is the revision number of the recorded adjacency list.
+	Revision int64 `json:"revision"`
+	// Hash is the hash value calculated from the recorded adjacency list.
+	Hash string `json:"hash"`
+	// AdjacencyList contains all nodes of the recorded revision of the directed acyclic graph.
+	AdjacencyList []Node `json:"adjacencyList"`
+	// Paths is the collection of all directed paths of the recorded revision of the directed
+	// acyclic graph.
+	Paths []Path `json:"paths"`
 }
 
 // Path represents a linear ordering of nodes along the direction of every directed edge.
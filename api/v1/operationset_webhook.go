@@ -17,11 +17,16 @@ limitations under the License.
 package v1
 
 import (
+	"context"
+	"fmt"
+
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 )
@@ -29,8 +34,13 @@ import (
 // operationsetlog is for logging in this package.
 var operationsetlog = logf.Log.WithName("operationset-webhook")
 
+// operationsetWebhookClient is used to validate that operations referenced by an operation
+// set exist. It is set when the webhook is registered with the manager.
+var operationsetWebhookClient client.Client
+
 // SetupWebhookWithManager setups the OperationSet webhook.
 func (r *OperationSet) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	operationsetWebhookClient = mgr.GetClient()
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
 		Complete()
@@ -86,7 +96,58 @@ func (r *OperationSet) validateOperationSet() error {
 			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("adjacencyList").Index(0),
 				r.Spec.AdjacencyList, "must not contains any operation or dependences"))
 		}
+
+		// Validate all node links reference nodes in the adjacency list. Cycle and
+		// reachability checks are only meaningful if every link is valid.
+		validLinks := true
+		for i, node := range r.Spec.AdjacencyList {
+			for _, to := range node.To {
+				if to < 0 || to >= len(r.Spec.AdjacencyList) {
+					allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("adjacencyList").Index(i).Child("to"),
+						node.To, fmt.Sprintf("links to nonexistent node %d", to)))
+					validLinks = false
+				}
+			}
+		}
+
+		// Reject adjacency lists containing cycles or unreachable nodes, so users get
+		// immediate feedback instead of diagnoses stuck on a graph which can never be
+		// built.
+		if validLinks {
+			if hasCycle(r.Spec.AdjacencyList) {
+				allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("adjacencyList"),
+					r.Spec.AdjacencyList, "must not contain a cycle"))
+			}
+			if unreachable := unreachableNodes(r.Spec.AdjacencyList); len(unreachable) != 0 {
+				allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("adjacencyList"),
+					r.Spec.AdjacencyList, fmt.Sprintf("nodes %v are not reachable from the start node", unreachable)))
+			}
+		}
+
+		// Validate every referenced operation exists.
+		if operationsetWebhookClient != nil {
+			for i, node := range r.Spec.AdjacencyList {
+				if node.Operation == "" {
+					continue
+				}
+				var operation Operation
+				if err := operationsetWebhookClient.Get(context.Background(), types.NamespacedName{Name: node.Operation}, &operation); err != nil {
+					if apierrors.IsNotFound(err) {
+						allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("adjacencyList").Index(i).Child("operation"),
+							node.Operation, fmt.Sprintf("operation %s is not found", node.Operation)))
+					}
+				}
+			}
+		}
 	}
+
+	switch r.Spec.FailurePolicy {
+	case "", FailurePolicyAbort, FailurePolicyContinueSiblingPaths, FailurePolicyIgnoreAndContinue:
+	default:
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("failurePolicy"),
+			r.Spec.FailurePolicy, fmt.Sprintf("must be one of %s, %s and %s", FailurePolicyAbort, FailurePolicyContinueSiblingPaths, FailurePolicyIgnoreAndContinue)))
+	}
+
 	if len(allErrs) == 0 {
 		return nil
 	}
@@ -95,3 +156,64 @@ func (r *OperationSet) validateOperationSet() error {
 		schema.GroupKind{Group: "diagnosis.kubediag.org", Kind: "OperationSet"},
 		r.Name, allErrs)
 }
+
+// hasCycle reports whether the directed graph described by the adjacency list contains a
+// cycle. Nodes are processed in topological order and a cycle exists if some node can not
+// be processed.
+func hasCycle(adjacencyList []Node) bool {
+	inDegrees := make([]int, len(adjacencyList))
+	for _, node := range adjacencyList {
+		for _, to := range node.To {
+			inDegrees[to]++
+		}
+	}
+
+	queue := make([]int, 0, len(adjacencyList))
+	for i := range inDegrees {
+		if inDegrees[i] == 0 {
+			queue = append(queue, i)
+		}
+	}
+
+	processed := 0
+	for len(queue) != 0 {
+		current := queue[0]
+		queue = queue[1:]
+		processed++
+		for _, to := range adjacencyList[current].To {
+			inDegrees[to]--
+			if inDegrees[to] == 0 {
+				queue = append(queue, to)
+			}
+		}
+	}
+
+	return processed != len(adjacencyList)
+}
+
+// unreachableNodes returns the ids of nodes which can not be reached from the first node
+// of the adjacency list.
+func unreachableNodes(adjacencyList []Node) []int {
+	visited := make([]bool, len(adjacencyList))
+	visited[0] = true
+	queue := []int{0}
+	for len(queue) != 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, to := range adjacencyList[current].To {
+			if !visited[to] {
+				visited[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+
+	unreachable := make([]int, 0)
+	for i := range visited {
+		if !visited[i] {
+			unreachable = append(unreachable, i)
+		}
+	}
+
+	return unreachable
+}
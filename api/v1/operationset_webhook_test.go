@@ -0,0 +1,213 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasCycle(t *testing.T) {
+	tests := []struct {
+		adjacencyList []Node
+		expected      bool
+		desc          string
+	}{
+		{
+			adjacencyList: []Node{
+				{To: NodeSet{1}},
+				{Operation: "collect"},
+			},
+			expected: false,
+			desc:     "a linear graph has no cycle",
+		},
+		{
+			adjacencyList: []Node{
+				{To: NodeSet{1, 2}},
+				{Operation: "collect", To: NodeSet{3}},
+				{Operation: "profile", To: NodeSet{3}},
+				{Operation: "report"},
+			},
+			expected: false,
+			desc:     "a diamond shaped graph has no cycle",
+		},
+		{
+			adjacencyList: []Node{
+				{To: NodeSet{1}},
+				{Operation: "collect", To: NodeSet{2}},
+				{Operation: "profile", To: NodeSet{1}},
+			},
+			expected: true,
+			desc:     "a graph with a back edge has a cycle",
+		},
+		{
+			adjacencyList: []Node{
+				{To: NodeSet{1}},
+				{Operation: "collect", To: NodeSet{1}},
+			},
+			expected: true,
+			desc:     "a self loop is a cycle",
+		},
+		{
+			adjacencyList: []Node{
+				{To: NodeSet{1}},
+				{Operation: "collect"},
+				{Operation: "profile", To: NodeSet{3}},
+				{Operation: "report", To: NodeSet{2}},
+			},
+			expected: true,
+			desc:     "a cycle disconnected from the start node is detected",
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, hasCycle(test.adjacencyList), test.desc)
+	}
+}
+
+func TestUnreachableNodes(t *testing.T) {
+	tests := []struct {
+		adjacencyList []Node
+		expected      []int
+		desc          string
+	}{
+		{
+			adjacencyList: []Node{
+				{To: NodeSet{1}},
+				{Operation: "collect", To: NodeSet{2}},
+				{Operation: "report"},
+			},
+			expected: []int{},
+			desc:     "all nodes of a linear graph are reachable",
+		},
+		{
+			adjacencyList: []Node{
+				{To: NodeSet{1}},
+				{Operation: "collect"},
+				{Operation: "orphaned"},
+			},
+			expected: []int{2},
+			desc:     "a node without incoming links is unreachable",
+		},
+		{
+			adjacencyList: []Node{
+				{To: NodeSet{1}},
+				{Operation: "collect"},
+				{Operation: "orphaned", To: NodeSet{3}},
+				{Operation: "also-orphaned"},
+			},
+			expected: []int{2, 3},
+			desc:     "a disconnected subgraph is unreachable",
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, unreachableNodes(test.adjacencyList), test.desc)
+	}
+}
+
+func TestValidateOperationSet(t *testing.T) {
+	tests := []struct {
+		spec        OperationSetSpec
+		expectError bool
+		desc        string
+	}{
+		{
+			spec: OperationSetSpec{
+				AdjacencyList: []Node{
+					{To: NodeSet{1}},
+					{Operation: "collect"},
+				},
+			},
+			expectError: false,
+			desc:        "a valid operation set is accepted",
+		},
+		{
+			spec: OperationSetSpec{
+				AdjacencyList: []Node{
+					{To: NodeSet{1}},
+				},
+			},
+			expectError: true,
+			desc:        "an adjacency list with less than two nodes is refused",
+		},
+		{
+			spec: OperationSetSpec{
+				AdjacencyList: []Node{
+					{Operation: "collect", To: NodeSet{1}},
+					{Operation: "report"},
+				},
+			},
+			expectError: true,
+			desc:        "a start node with an operation is refused",
+		},
+		{
+			spec: OperationSetSpec{
+				AdjacencyList: []Node{
+					{To: NodeSet{5}},
+					{Operation: "collect"},
+				},
+			},
+			expectError: true,
+			desc:        "a link to a nonexistent node is refused",
+		},
+		{
+			spec: OperationSetSpec{
+				AdjacencyList: []Node{
+					{To: NodeSet{1}},
+					{Operation: "collect", To: NodeSet{2}},
+					{Operation: "profile", To: NodeSet{1}},
+				},
+			},
+			expectError: true,
+			desc:        "a cyclic graph is refused",
+		},
+		{
+			spec: OperationSetSpec{
+				AdjacencyList: []Node{
+					{To: NodeSet{1}},
+					{Operation: "collect"},
+					{Operation: "orphaned"},
+				},
+			},
+			expectError: true,
+			desc:        "a graph with unreachable nodes is refused",
+		},
+		{
+			spec: OperationSetSpec{
+				AdjacencyList: []Node{
+					{To: NodeSet{1}},
+					{Operation: "collect"},
+				},
+				FailurePolicy: "Sometimes",
+			},
+			expectError: true,
+			desc:        "an unknown failure policy is refused",
+		},
+	}
+
+	for _, test := range tests {
+		operationSet := OperationSet{Spec: test.spec}
+		err := operationSet.validateOperationSet()
+		if test.expectError {
+			assert.Error(t, err, test.desc)
+		} else {
+			assert.NoError(t, err, test.desc)
+		}
+	}
+}
@@ -43,6 +43,43 @@ const (
 	TaskIncomplete TaskConditionType = "Failed"
 	// OperationNotFound means the operation is not found when running Task.
 	OperationNotFound TaskConditionType = "OperationNotFound"
+	// OperationNotPermitted means the operation is not permitted to mutate its target by the
+	// operation policy.
+	OperationNotPermitted TaskConditionType = "OperationNotPermitted"
+	// OperationNotVerified means the signature of the operation could not be verified when
+	// running Task.
+	OperationNotVerified TaskConditionType = "OperationNotVerified"
+	// ProcessorCircuitOpen means the circuit breaker of the operation processor has tripped
+	// after consecutive failures.
+	ProcessorCircuitOpen TaskConditionType = "ProcessorCircuitOpen"
+	// NodeUnderPressure means a resource intensive operation was deferred because the node is
+	// under cpu, memory or disk pressure.
+	NodeUnderPressure TaskConditionType = "NodeUnderPressure"
+	// PodNotFound means the pod referenced by the task is not found.
+	PodNotFound TaskConditionType = "PodNotFound"
+	// InvalidParameters means the task parameters do not conform to the parameter schema
+	// declared by the operation.
+	InvalidParameters TaskConditionType = "InvalidParameters"
+	// ParameterNotResolved means a parameter referenced from a secret or config map key could
+	// not be resolved.
+	ParameterNotResolved TaskConditionType = "ParameterNotResolved"
+	// TaskDeadlineExceeded means the task was active longer than its active deadline and has
+	// been terminated.
+	TaskDeadlineExceeded TaskConditionType = "DeadlineExceeded"
+	// TaskRestarted means a failed task has been restarted according to its restart policy.
+	TaskRestarted TaskConditionType = "Restarted"
+	// OperationInterrupted means the agent was restarted while a node mutating operation was
+	// in flight and the operation may have partially applied its side effects.
+	OperationInterrupted TaskConditionType = "OperationInterrupted"
+	// ConcurrencyLimitReached means the operation was queued because the node is already
+	// running the maximum number of concurrent diagnoses.
+	ConcurrencyLimitReached TaskConditionType = "ConcurrencyLimitReached"
+
+	// TaskRestartPolicyNever means a failed task is not restarted.
+	TaskRestartPolicyNever TaskRestartPolicy = "Never"
+	// TaskRestartPolicyOnFailure means a failed task is restarted with exponential backoff
+	// until it succeeds or its backoff limit is reached.
+	TaskRestartPolicyOnFailure TaskRestartPolicy = "OnFailure"
 )
 
 // TaskPhase is a label for the condition of a task at the current time.
@@ -51,6 +88,9 @@ type TaskPhase string
 // TaskConditionType is a valid value for TaskCondition.Type.
 type TaskConditionType string
 
+// TaskRestartPolicy describes how a failed task is restarted.
+type TaskRestartPolicy string
+
 // TaskSpec defines the desired state of Task.
 type TaskSpec struct {
 	// Operation is the name of operation which represents task to be executed.
@@ -67,6 +107,45 @@ type TaskSpec struct {
 	// running a task.
 	// +optional
 	Parameters map[string]string `json:"parameters,omitempty"`
+	// ParameterFrom is a list of parameters resolved from secret or config map keys in the
+	// namespace of the task by the agent at execution time, so credentials for operation
+	// processors never appear in the task object.
+	// +optional
+	ParameterFrom []ParameterSource `json:"parameterFrom,omitempty"`
+	// Priority is the priority of the task. Tasks with a higher priority are executed before
+	// tasks with a lower priority by agents under load. It is set from the diagnosis the task
+	// was created for. Defaults to 0.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+	// TimeoutSeconds overrides the timeout of the operation processor for this task. It is
+	// set from the node of the operation set the task was created for. The timeout of the
+	// operation is used if nil.
+	// +optional
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+	// Retries is the number of times a transient processor failure is retried by the executor
+	// before the task is marked as failed. It is set from the node of the operation set the
+	// task was created for. The default backoff of the executor is used if nil.
+	// +optional
+	Retries *int32 `json:"retries,omitempty"`
+	// BackoffSeconds is the initial delay before the first retry of a failed operation. The
+	// delay is doubled on every subsequent retry. It is only honored if Retries is specified.
+	// +optional
+	BackoffSeconds *int32 `json:"backoffSeconds,omitempty"`
+	// ActiveDeadlineSeconds is the duration in seconds relative to the start time of the task
+	// that the task may be active before the system terminates it. When the deadline is
+	// exceeded, the task transitions to Failed phase with a DeadlineExceeded condition and is
+	// not restarted.
+	// +optional
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+	// RestartPolicy describes how a failed task is restarted. One of Never and OnFailure.
+	// A task with the OnFailure policy is restarted with exponential backoff until it succeeds
+	// or its backoff limit is reached. Defaults to Never.
+	// +optional
+	RestartPolicy TaskRestartPolicy `json:"restartPolicy,omitempty"`
+	// BackoffLimit is the number of restarts of a failed task before marking the task as
+	// failed permanently. It is only honored if RestartPolicy is OnFailure. Defaults to 6.
+	// +optional
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
 }
 
 // PodReference contains details of the target pod.
@@ -110,6 +189,10 @@ type TaskStatus struct {
 	// Parameters and Results are encoded into a json object and sent to operation processor when running task.
 	// +optional
 	Results map[string]string `json:"results,omitempty"`
+	// RestartCount is the number of times a failed task has been restarted according to its
+	// restart policy.
+	// +optional
+	RestartCount int32 `json:"restartCount,omitempty"`
 }
 
 // TaskCondition contains details for the current condition of this diagnosis.
@@ -0,0 +1,89 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TaskSetSpec defines the desired state of TaskSet.
+type TaskSetSpec struct {
+	// Tasks contains the task definitions of the task set. Tasks without dependences are
+	// started first and a task is started once all tasks it depends on have succeeded.
+	Tasks []TaskDefinition `json:"tasks"`
+}
+
+// TaskDefinition defines a task of a task set and its dependences.
+type TaskDefinition struct {
+	// Name is the unique name of the task within the task set.
+	Name string `json:"name"`
+	// DependsOn contains names of tasks in the task set which must succeed before this task
+	// is started.
+	// +optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+
+	TaskSpec `json:",inline"`
+}
+
+// TaskSetStatus defines the observed state of TaskSet.
+type TaskSetStatus struct {
+	// Phase is the aggregate phase of the task set. The task set is Succeeded once all tasks
+	// have succeeded and Failed once any task has failed or the dependences are invalid.
+	// +optional
+	Phase TaskPhase `json:"phase,omitempty"`
+	// StartTime is RFC 3339 date and time at which the object was acknowledged by the system.
+	// +optional
+	StartTime metav1.Time `json:"startTime,omitempty"`
+	// TaskPhases contains the phase of every started task by task definition name.
+	// +optional
+	TaskPhases map[string]TaskPhase `json:"taskPhases,omitempty"`
+	// Succeeded is the number of tasks which reached phase Succeeded.
+	// +optional
+	Succeeded int `json:"succeeded,omitempty"`
+	// Failed is the number of tasks which reached phase Failed.
+	// +optional
+	Failed int `json:"failed,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:JSONPath=".status.phase",name=Phase,type=string
+// +kubebuilder:printcolumn:JSONPath=".status.succeeded",name=Succeeded,type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.failed",name=Failed,type=integer
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// TaskSet is the Schema for the tasksets API.
+type TaskSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TaskSetSpec   `json:"spec,omitempty"`
+	Status TaskSetStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TaskSetList contains a list of TaskSet.
+type TaskSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TaskSet `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TaskSet{}, &TaskSetList{})
+}
@@ -27,6 +27,21 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Artifact) DeepCopyInto(out *Artifact) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Artifact.
+func (in *Artifact) DeepCopy() *Artifact {
+	if in == nil {
+		return nil
+	}
+	out := new(Artifact)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Checkpoint) DeepCopyInto(out *Checkpoint) {
 	*out = *in
@@ -35,6 +50,13 @@ func (in *Checkpoint) DeepCopyInto(out *Checkpoint) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Siblings != nil {
+		in, out := &in.Siblings, &out.Siblings
+		*out = make([]SiblingCheckpoint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Checkpoint.
@@ -178,6 +200,142 @@ func (in *CommonEventTemplateRegexp) DeepCopy() *CommonEventTemplateRegexp {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CronDiagnosis) DeepCopyInto(out *CronDiagnosis) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CronDiagnosis.
+func (in *CronDiagnosis) DeepCopy() *CronDiagnosis {
+	if in == nil {
+		return nil
+	}
+	out := new(CronDiagnosis)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *CronDiagnosis) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CronDiagnosisList) DeepCopyInto(out *CronDiagnosisList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]CronDiagnosis, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CronDiagnosisList.
+func (in *CronDiagnosisList) DeepCopy() *CronDiagnosisList {
+	if in == nil {
+		return nil
+	}
+	out := new(CronDiagnosisList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *CronDiagnosisList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CronDiagnosisSpec) DeepCopyInto(out *CronDiagnosisSpec) {
+	*out = *in
+	if in.StartingDeadlineSeconds != nil {
+		in, out := &in.StartingDeadlineSeconds, &out.StartingDeadlineSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Suspend != nil {
+		in, out := &in.Suspend, &out.Suspend
+		*out = new(bool)
+		**out = **in
+	}
+	in.DiagnosisTemplate.DeepCopyInto(&out.DiagnosisTemplate)
+	if in.SuccessfulDiagnosesHistoryLimit != nil {
+		in, out := &in.SuccessfulDiagnosesHistoryLimit, &out.SuccessfulDiagnosesHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.FailedDiagnosesHistoryLimit != nil {
+		in, out := &in.FailedDiagnosesHistoryLimit, &out.FailedDiagnosesHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CronDiagnosisSpec.
+func (in *CronDiagnosisSpec) DeepCopy() *CronDiagnosisSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CronDiagnosisSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CronDiagnosisStatus) DeepCopyInto(out *CronDiagnosisStatus) {
+	*out = *in
+	if in.Active != nil {
+		in, out := &in.Active, &out.Active
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastScheduleTime != nil {
+		in, out := &in.LastScheduleTime, &out.LastScheduleTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CronDiagnosisStatus.
+func (in *CronDiagnosisStatus) DeepCopy() *CronDiagnosisStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CronDiagnosisStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CronDiagnosisTemplate) DeepCopyInto(out *CronDiagnosisTemplate) {
+	*out = *in
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CronDiagnosisTemplate.
+func (in *CronDiagnosisTemplate) DeepCopy() *CronDiagnosisTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(CronDiagnosisTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CronTemplate) DeepCopyInto(out *CronTemplate) {
 	*out = *in
@@ -317,6 +475,95 @@ func (in *DiagnosisList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiagnosisReport) DeepCopyInto(out *DiagnosisReport) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiagnosisReport.
+func (in *DiagnosisReport) DeepCopy() *DiagnosisReport {
+	if in == nil {
+		return nil
+	}
+	out := new(DiagnosisReport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DiagnosisReport) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiagnosisReportList) DeepCopyInto(out *DiagnosisReportList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]DiagnosisReport, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiagnosisReportList.
+func (in *DiagnosisReportList) DeepCopy() *DiagnosisReportList {
+	if in == nil {
+		return nil
+	}
+	out := new(DiagnosisReportList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DiagnosisReportList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiagnosisReportStatus) DeepCopyInto(out *DiagnosisReportStatus) {
+	*out = *in
+	in.UpdateTime.DeepCopyInto(&out.UpdateTime)
+	in.Cluster.DeepCopyInto(&out.Cluster)
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodeDiagnosisStatistics, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.OperationSets != nil {
+		in, out := &in.OperationSets, &out.OperationSets
+		*out = make([]OperationSetDiagnosisStatistics, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiagnosisReportStatus.
+func (in *DiagnosisReportStatus) DeepCopy() *DiagnosisReportStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DiagnosisReportStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DiagnosisSpec) DeepCopyInto(out *DiagnosisSpec) {
 	*out = *in
@@ -327,11 +574,43 @@ func (in *DiagnosisSpec) DeepCopyInto(out *DiagnosisSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.ParameterFrom != nil {
+		in, out := &in.ParameterFrom, &out.ParameterFrom
+		*out = make([]ParameterSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.TargetSelector != nil {
 		in, out := &in.TargetSelector, &out.TargetSelector
 		*out = new(TargetSelector)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.ReplayOf != nil {
+		in, out := &in.ReplayOf, &out.ReplayOf
+		*out = new(NamespacedName)
+		**out = **in
+	}
+	if in.DependsOn != nil {
+		in, out := &in.DependsOn, &out.DependsOn
+		*out = make([]NamespacedName, len(*in))
+		copy(*out, *in)
+	}
+	if in.Parallelism != nil {
+		in, out := &in.Parallelism, &out.Parallelism
+		*out = new(int32)
+		**out = **in
+	}
+	if in.ActiveDeadlineSeconds != nil {
+		in, out := &in.ActiveDeadlineSeconds, &out.ActiveDeadlineSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	if in.TTLSecondsAfterFinished != nil {
+		in, out := &in.TTLSecondsAfterFinished, &out.TTLSecondsAfterFinished
+		*out = new(int64)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiagnosisSpec.
@@ -355,11 +634,27 @@ func (in *DiagnosisStatus) DeepCopyInto(out *DiagnosisStatus) {
 		}
 	}
 	in.StartTime.DeepCopyInto(&out.StartTime)
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
 	if in.NodeNames != nil {
 		in, out := &in.NodeNames, &out.NodeNames
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.NodeStatuses != nil {
+		in, out := &in.NodeStatuses, &out.NodeStatuses
+		*out = make(map[string]TaskPhase, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.PodReferences != nil {
+		in, out := &in.PodReferences, &out.PodReferences
+		*out = make([]PodReference, len(*in))
+		copy(*out, *in)
+	}
 	if in.FailedPaths != nil {
 		in, out := &in.FailedPaths, &out.FailedPaths
 		*out = make([]Path, len(*in))
@@ -385,11 +680,33 @@ func (in *DiagnosisStatus) DeepCopyInto(out *DiagnosisStatus) {
 		*out = new(Checkpoint)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Progress != nil {
+		in, out := &in.Progress, &out.Progress
+		*out = make(map[string]*OperationProgress, len(*in))
+		for key, val := range *in {
+			var outVal *OperationProgress
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = new(OperationProgress)
+				(*in).DeepCopyInto(*out)
+			}
+			(*out)[key] = outVal
+		}
+	}
 	if in.Context != nil {
 		in, out := &in.Context, &out.Context
 		*out = new(DiagnosisContext)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.OperationResults != nil {
+		in, out := &in.OperationResults, &out.OperationResults
+		*out = make([]OperationResult, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiagnosisStatus.
@@ -402,6 +719,151 @@ func (in *DiagnosisStatus) DeepCopy() *DiagnosisStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiagnosisStatistics) DeepCopyInto(out *DiagnosisStatistics) {
+	*out = *in
+	if in.TopFailureReasons != nil {
+		in, out := &in.TopFailureReasons, &out.TopFailureReasons
+		*out = make([]FailureReason, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiagnosisStatistics.
+func (in *DiagnosisStatistics) DeepCopy() *DiagnosisStatistics {
+	if in == nil {
+		return nil
+	}
+	out := new(DiagnosisStatistics)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiagnosisTemplate) DeepCopyInto(out *DiagnosisTemplate) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiagnosisTemplate.
+func (in *DiagnosisTemplate) DeepCopy() *DiagnosisTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(DiagnosisTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DiagnosisTemplate) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiagnosisTemplateList) DeepCopyInto(out *DiagnosisTemplateList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]DiagnosisTemplate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiagnosisTemplateList.
+func (in *DiagnosisTemplateList) DeepCopy() *DiagnosisTemplateList {
+	if in == nil {
+		return nil
+	}
+	out := new(DiagnosisTemplateList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DiagnosisTemplateList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiagnosisTemplateSpec) DeepCopyInto(out *DiagnosisTemplateSpec) {
+	*out = *in
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiagnosisTemplateSpec.
+func (in *DiagnosisTemplateSpec) DeepCopy() *DiagnosisTemplateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DiagnosisTemplateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EphemeralDir) DeepCopyInto(out *EphemeralDir) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EphemeralDir.
+func (in *EphemeralDir) DeepCopy() *EphemeralDir {
+	if in == nil {
+		return nil
+	}
+	out := new(EphemeralDir)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FailureReason) DeepCopyInto(out *FailureReason) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FailureReason.
+func (in *FailureReason) DeepCopy() *FailureReason {
+	if in == nil {
+		return nil
+	}
+	out := new(FailureReason)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Finding) DeepCopyInto(out *Finding) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Finding.
+func (in *Finding) DeepCopy() *Finding {
+	if in == nil {
+		return nil
+	}
+	out := new(Finding)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Function) DeepCopyInto(out *Function) {
 	*out = *in
@@ -424,6 +886,26 @@ func (in *Function) DeepCopy() *Function {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GRPCServer) DeepCopyInto(out *GRPCServer) {
+	*out = *in
+	if in.Address != nil {
+		in, out := &in.Address, &out.Address
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GRPCServer.
+func (in *GRPCServer) DeepCopy() *GRPCServer {
+	if in == nil {
+		return nil
+	}
+	out := new(GRPCServer)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HTTPServer) DeepCopyInto(out *HTTPServer) {
 	*out = *in
@@ -474,6 +956,106 @@ func (in *HostPath) DeepCopy() *HostPath {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Incident) DeepCopyInto(out *Incident) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Incident.
+func (in *Incident) DeepCopy() *Incident {
+	if in == nil {
+		return nil
+	}
+	out := new(Incident)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Incident) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IncidentList) DeepCopyInto(out *IncidentList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Incident, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IncidentList.
+func (in *IncidentList) DeepCopy() *IncidentList {
+	if in == nil {
+		return nil
+	}
+	out := new(IncidentList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IncidentList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IncidentStatus) DeepCopyInto(out *IncidentStatus) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	in.UpdateTime.DeepCopyInto(&out.UpdateTime)
+	if in.Diagnoses != nil {
+		in, out := &in.Diagnoses, &out.Diagnoses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IncidentStatus.
+func (in *IncidentStatus) DeepCopy() *IncidentStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(IncidentStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeySelector) DeepCopyInto(out *KeySelector) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeySelector.
+func (in *KeySelector) DeepCopy() *KeySelector {
+	if in == nil {
+		return nil
+	}
+	out := new(KeySelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KubernetesEventTemplate) DeepCopyInto(out *KubernetesEventTemplate) {
 	*out = *in
@@ -534,6 +1116,28 @@ func (in *Node) DeepCopyInto(out *Node) {
 		*out = make(NodeSet, len(*in))
 		copy(*out, *in)
 	}
+	if in.When != nil {
+		in, out := &in.When, &out.When
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.TimeoutSeconds != nil {
+		in, out := &in.TimeoutSeconds, &out.TimeoutSeconds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Retries != nil {
+		in, out := &in.Retries, &out.Retries
+		*out = new(int32)
+		**out = **in
+	}
+	if in.BackoffSeconds != nil {
+		in, out := &in.BackoffSeconds, &out.BackoffSeconds
+		*out = new(int32)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Node.
@@ -565,12 +1169,48 @@ func (in NodeSet) DeepCopy() NodeSet {
 	return *out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeDiagnosisStatistics) DeepCopyInto(out *NodeDiagnosisStatistics) {
+	*out = *in
+	in.DiagnosisStatistics.DeepCopyInto(&out.DiagnosisStatistics)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeDiagnosisStatistics.
+func (in *NodeDiagnosisStatistics) DeepCopy() *NodeDiagnosisStatistics {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeDiagnosisStatistics)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Operation) DeepCopyInto(out *Operation) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopyInto is a deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OperationStatus) DeepCopyInto(out *OperationStatus) {
+	*out = *in
+	if in.LastProbeTime != nil {
+		in, out := &in.LastProbeTime, &out.LastProbeTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is a deepcopy function, copying the receiver, creating a new OperationStatus.
+func (in *OperationStatus) DeepCopy() *OperationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OperationStatus)
+	in.DeepCopyInto(out)
+	return out
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Operation.
@@ -654,6 +1294,47 @@ func (in *OperationList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OperationProgress) DeepCopyInto(out *OperationProgress) {
+	*out = *in
+	in.UpdateTime.DeepCopyInto(&out.UpdateTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OperationProgress.
+func (in *OperationProgress) DeepCopy() *OperationProgress {
+	if in == nil {
+		return nil
+	}
+	out := new(OperationProgress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OperationResult) DeepCopyInto(out *OperationResult) {
+	*out = *in
+	if in.Findings != nil {
+		in, out := &in.Findings, &out.Findings
+		*out = make([]Finding, len(*in))
+		copy(*out, *in)
+	}
+	if in.Artifacts != nil {
+		in, out := &in.Artifacts, &out.Artifacts
+		*out = make([]Artifact, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OperationResult.
+func (in *OperationResult) DeepCopy() *OperationResult {
+	if in == nil {
+		return nil
+	}
+	out := new(OperationResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OperationSet) DeepCopyInto(out *OperationSet) {
 	*out = *in
@@ -681,6 +1362,22 @@ func (in *OperationSet) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OperationSetDiagnosisStatistics) DeepCopyInto(out *OperationSetDiagnosisStatistics) {
+	*out = *in
+	in.DiagnosisStatistics.DeepCopyInto(&out.DiagnosisStatistics)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OperationSetDiagnosisStatistics.
+func (in *OperationSetDiagnosisStatistics) DeepCopy() *OperationSetDiagnosisStatistics {
+	if in == nil {
+		return nil
+	}
+	out := new(OperationSetDiagnosisStatistics)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OperationSetList) DeepCopyInto(out *OperationSetList) {
 	*out = *in
@@ -713,6 +1410,41 @@ func (in *OperationSetList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OperationSetRevision) DeepCopyInto(out *OperationSetRevision) {
+	*out = *in
+	if in.AdjacencyList != nil {
+		in, out := &in.AdjacencyList, &out.AdjacencyList
+		*out = make([]Node, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Paths != nil {
+		in, out := &in.Paths, &out.Paths
+		*out = make([]Path, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = make(Path, len(*in))
+				for i := range *in {
+					(*in)[i].DeepCopyInto(&(*out)[i])
+				}
+			}
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OperationSetRevision.
+func (in *OperationSetRevision) DeepCopy() *OperationSetRevision {
+	if in == nil {
+		return nil
+	}
+	out := new(OperationSetRevision)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OperationSetSpec) DeepCopyInto(out *OperationSetSpec) {
 	*out = *in
@@ -723,6 +1455,16 @@ func (in *OperationSetSpec) DeepCopyInto(out *OperationSetSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.RevisionHistoryLimit != nil {
+		in, out := &in.RevisionHistoryLimit, &out.RevisionHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.RollbackTo != nil {
+		in, out := &in.RollbackTo, &out.RollbackTo
+		*out = new(int64)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OperationSetSpec.
@@ -751,6 +1493,13 @@ func (in *OperationSetStatus) DeepCopyInto(out *OperationSetStatus) {
 			}
 		}
 	}
+	if in.Revisions != nil {
+		in, out := &in.Revisions, &out.Revisions
+		*out = make([]OperationSetRevision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OperationSetStatus.
@@ -777,6 +1526,16 @@ func (in *OperationSpec) DeepCopyInto(out *OperationSpec) {
 		*out = new(Storage)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Workspace != nil {
+		in, out := &in.Workspace, &out.Workspace
+		*out = new(Workspace)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ParameterSchema != nil {
+		in, out := &in.ParameterSchema, &out.ParameterSchema
+		*out = new(ParameterSchema)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OperationSpec.
@@ -789,6 +1548,73 @@ func (in *OperationSpec) DeepCopy() *OperationSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ParameterDeclaration) DeepCopyInto(out *ParameterDeclaration) {
+	*out = *in
+	if in.Enum != nil {
+		in, out := &in.Enum, &out.Enum
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ParameterDeclaration.
+func (in *ParameterDeclaration) DeepCopy() *ParameterDeclaration {
+	if in == nil {
+		return nil
+	}
+	out := new(ParameterDeclaration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ParameterSchema) DeepCopyInto(out *ParameterSchema) {
+	*out = *in
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make(map[string]ParameterDeclaration, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ParameterSchema.
+func (in *ParameterSchema) DeepCopy() *ParameterSchema {
+	if in == nil {
+		return nil
+	}
+	out := new(ParameterSchema)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ParameterSource) DeepCopyInto(out *ParameterSource) {
+	*out = *in
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(KeySelector)
+		**out = **in
+	}
+	if in.ConfigMapKeyRef != nil {
+		in, out := &in.ConfigMapKeyRef, &out.ConfigMapKeyRef
+		*out = new(KeySelector)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ParameterSource.
+func (in *ParameterSource) DeepCopy() *ParameterSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ParameterSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in Path) DeepCopyInto(out *Path) {
 	{
@@ -834,6 +1660,11 @@ func (in *Processor) DeepCopyInto(out *Processor) {
 		*out = new(HTTPServer)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.GRPCServer != nil {
+		in, out := &in.GRPCServer, &out.GRPCServer
+		*out = new(GRPCServer)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.ScriptRunner != nil {
 		in, out := &in.ScriptRunner, &out.ScriptRunner
 		*out = new(ScriptRunner)
@@ -911,9 +1742,39 @@ func (in *PrometheusAlertTemplateRegexp) DeepCopy() *PrometheusAlertTemplateRege
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResultEnvelope) DeepCopyInto(out *ResultEnvelope) {
+	*out = *in
+	if in.Findings != nil {
+		in, out := &in.Findings, &out.Findings
+		*out = make([]Finding, len(*in))
+		copy(*out, *in)
+	}
+	if in.Artifacts != nil {
+		in, out := &in.Artifacts, &out.Artifacts
+		*out = make([]Artifact, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResultEnvelope.
+func (in *ResultEnvelope) DeepCopy() *ResultEnvelope {
+	if in == nil {
+		return nil
+	}
+	out := new(ResultEnvelope)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ScriptRunner) DeepCopyInto(out *ScriptRunner) {
 	*out = *in
+	if in.Interpreter != nil {
+		in, out := &in.Interpreter, &out.Interpreter
+		*out = new(string)
+		**out = **in
+	}
 	if in.ArgKeys != nil {
 		in, out := &in.ArgKeys, &out.ArgKeys
 		*out = make([]string, len(*in))
@@ -936,6 +1797,26 @@ func (in *ScriptRunner) DeepCopy() *ScriptRunner {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SiblingCheckpoint) DeepCopyInto(out *SiblingCheckpoint) {
+	*out = *in
+	if in.SynchronizedTasks != nil {
+		in, out := &in.SynchronizedTasks, &out.SynchronizedTasks
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SiblingCheckpoint.
+func (in *SiblingCheckpoint) DeepCopy() *SiblingCheckpoint {
+	if in == nil {
+		return nil
+	}
+	out := new(SiblingCheckpoint)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SourceTemplate) DeepCopyInto(out *SourceTemplate) {
 	*out = *in
@@ -1090,6 +1971,27 @@ func (in TaskContext) DeepCopy() TaskContext {
 	return *out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TaskDefinition) DeepCopyInto(out *TaskDefinition) {
+	*out = *in
+	if in.DependsOn != nil {
+		in, out := &in.DependsOn, &out.DependsOn
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.TaskSpec.DeepCopyInto(&out.TaskSpec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskDefinition.
+func (in *TaskDefinition) DeepCopy() *TaskDefinition {
+	if in == nil {
+		return nil
+	}
+	out := new(TaskDefinition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TaskList) DeepCopyInto(out *TaskList) {
 	*out = *in
@@ -1122,6 +2024,110 @@ func (in *TaskList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TaskSet) DeepCopyInto(out *TaskSet) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskSet.
+func (in *TaskSet) DeepCopy() *TaskSet {
+	if in == nil {
+		return nil
+	}
+	out := new(TaskSet)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TaskSet) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TaskSetList) DeepCopyInto(out *TaskSetList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TaskSet, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskSetList.
+func (in *TaskSetList) DeepCopy() *TaskSetList {
+	if in == nil {
+		return nil
+	}
+	out := new(TaskSetList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TaskSetList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TaskSetSpec) DeepCopyInto(out *TaskSetSpec) {
+	*out = *in
+	if in.Tasks != nil {
+		in, out := &in.Tasks, &out.Tasks
+		*out = make([]TaskDefinition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskSetSpec.
+func (in *TaskSetSpec) DeepCopy() *TaskSetSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TaskSetSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TaskSetStatus) DeepCopyInto(out *TaskSetStatus) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	if in.TaskPhases != nil {
+		in, out := &in.TaskPhases, &out.TaskPhases
+		*out = make(map[string]TaskPhase, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskSetStatus.
+func (in *TaskSetStatus) DeepCopy() *TaskSetStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TaskSetStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TaskSpec) DeepCopyInto(out *TaskSpec) {
 	*out = *in
@@ -1137,6 +2143,38 @@ func (in *TaskSpec) DeepCopyInto(out *TaskSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.ParameterFrom != nil {
+		in, out := &in.ParameterFrom, &out.ParameterFrom
+		*out = make([]ParameterSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.TimeoutSeconds != nil {
+		in, out := &in.TimeoutSeconds, &out.TimeoutSeconds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Retries != nil {
+		in, out := &in.Retries, &out.Retries
+		*out = new(int32)
+		**out = **in
+	}
+	if in.BackoffSeconds != nil {
+		in, out := &in.BackoffSeconds, &out.BackoffSeconds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.ActiveDeadlineSeconds != nil {
+		in, out := &in.ActiveDeadlineSeconds, &out.ActiveDeadlineSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	if in.BackoffLimit != nil {
+		in, out := &in.BackoffLimit, &out.BackoffLimit
+		*out = new(int32)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskSpec.
@@ -1279,3 +2317,28 @@ func (in *TriggerStatus) DeepCopy() *TriggerStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Workspace) DeepCopyInto(out *Workspace) {
+	*out = *in
+	if in.HostPath != nil {
+		in, out := &in.HostPath, &out.HostPath
+		*out = new(HostPath)
+		**out = **in
+	}
+	if in.EphemeralDir != nil {
+		in, out := &in.EphemeralDir, &out.EphemeralDir
+		*out = new(EphemeralDir)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Workspace.
+func (in *Workspace) DeepCopy() *Workspace {
+	if in == nil {
+		return nil
+	}
+	out := new(Workspace)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,450 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The kubectl-kubediag binary is a kubectl plugin for creating diagnoses from flags, watching
+// them to completion with per operation progress and downloading artifacts, so users do not
+// need to hand write diagnosis yaml during incidents. Install it by placing the binary on PATH
+// and run it as "kubectl kubediag".
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/graphbuilder"
+)
+
+// watchInterval is the interval between polls while watching a diagnosis.
+const watchInterval = 2 * time.Second
+
+func main() {
+	root := &cobra.Command{
+		Use:   "kubectl-kubediag",
+		Short: "Create, watch and inspect kubediag diagnoses.",
+	}
+	root.AddCommand(newCreateCommand())
+	root.AddCommand(newWatchCommand())
+	root.AddCommand(newArtifactsCommand())
+	root.AddCommand(newGraphCommand())
+
+	if err := root.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// newCreateCommand returns the command for creating a diagnosis from flags.
+func newCreateCommand() *cobra.Command {
+	var (
+		name         string
+		namespace    string
+		operationSet string
+		podName      string
+		podNamespace string
+		container    string
+		nodeName     string
+		parameters   []string
+		watch        bool
+		timeout      time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a diagnosis targeting a pod or node.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if operationSet == "" {
+				return fmt.Errorf("--operationset must be specified")
+			}
+			if podName == "" && nodeName == "" {
+				return fmt.Errorf("one of --pod or --node must be specified")
+			}
+			if name == "" {
+				name = fmt.Sprintf("diagnosis-%d", time.Now().Unix())
+			}
+
+			diagnosis := &diagnosisv1.Diagnosis{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Spec: diagnosisv1.DiagnosisSpec{
+					OperationSet:   operationSet,
+					TargetSelector: &diagnosisv1.TargetSelector{},
+				},
+			}
+			if nodeName != "" {
+				diagnosis.Spec.TargetSelector.NodeNames = []string{nodeName}
+			}
+			if podName != "" {
+				if podNamespace == "" {
+					podNamespace = namespace
+				}
+				diagnosis.Spec.TargetSelector.PodReferences = []diagnosisv1.PodReference{
+					{
+						NamespacedName: diagnosisv1.NamespacedName{
+							Namespace: podNamespace,
+							Name:      podName,
+						},
+						Container: container,
+					},
+				}
+			}
+			if len(parameters) != 0 {
+				diagnosis.Spec.Parameters = make(map[string]string)
+				for _, parameter := range parameters {
+					index := strings.Index(parameter, "=")
+					if index <= 0 {
+						return fmt.Errorf("invalid parameter %q: expected key=value", parameter)
+					}
+					diagnosis.Spec.Parameters[parameter[:index]] = parameter[index+1:]
+				}
+			}
+
+			cli, err := newClient()
+			if err != nil {
+				return err
+			}
+			if err := cli.Create(context.Background(), diagnosis); err != nil {
+				return fmt.Errorf("unable to create diagnosis: %v", err)
+			}
+			fmt.Printf("diagnosis %s/%s created\n", namespace, name)
+
+			if watch {
+				return watchDiagnosis(cli, namespace, name, timeout)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "", "Name of the diagnosis. A name is generated if empty.")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "Namespace of the diagnosis.")
+	cmd.Flags().StringVar(&operationSet, "operationset", "", "Name of the operation set to run.")
+	cmd.Flags().StringVar(&podName, "pod", "", "Name of the target pod.")
+	cmd.Flags().StringVar(&podNamespace, "pod-namespace", "", "Namespace of the target pod. Defaults to the diagnosis namespace.")
+	cmd.Flags().StringVar(&container, "container", "", "Name of the target container in the pod.")
+	cmd.Flags().StringVar(&nodeName, "node", "", "Name of the target node.")
+	cmd.Flags().StringSliceVar(&parameters, "parameter", parameters, "Parameter in key=value form passed to operations. May be repeated.")
+	cmd.Flags().BoolVarP(&watch, "watch", "w", false, "Watch the diagnosis to completion after creating it.")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Minute, "Maximum time to watch the diagnosis.")
+
+	return cmd
+}
+
+// newWatchCommand returns the command for watching an existing diagnosis to completion.
+func newWatchCommand() *cobra.Command {
+	var (
+		namespace string
+		timeout   time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "watch NAME",
+		Short: "Watch a diagnosis to completion with per operation progress.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cli, err := newClient()
+			if err != nil {
+				return err
+			}
+			return watchDiagnosis(cli, namespace, args[0], timeout)
+		},
+	}
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "Namespace of the diagnosis.")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Minute, "Maximum time to watch the diagnosis.")
+
+	return cmd
+}
+
+// newArtifactsCommand returns the command for listing and downloading artifacts of a diagnosis
+// through the rest api of the kubediag master.
+func newArtifactsCommand() *cobra.Command {
+	var (
+		namespace     string
+		masterAddress string
+		download      string
+		node          string
+		output        string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "artifacts NAME",
+		Short: "List or download artifacts of a diagnosis through the kubediag master.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if masterAddress == "" {
+				return fmt.Errorf("--master-address must be specified")
+			}
+
+			if download != "" {
+				if node == "" {
+					return fmt.Errorf("--node must be specified to download an artifact")
+				}
+				if output == "" {
+					output = filepath.Base(download)
+				}
+				return downloadArtifact(masterAddress, node, download, output)
+			}
+
+			return listArtifacts(masterAddress, namespace, args[0])
+		},
+	}
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "Namespace of the diagnosis.")
+	cmd.Flags().StringVar(&masterAddress, "master-address", "", "Address of the kubediag master, for example http://kubediag-master.kubediag:8090.")
+	cmd.Flags().StringVar(&download, "download", "", "Path of an artifact to download instead of listing artifacts.")
+	cmd.Flags().StringVar(&node, "node", "", "Node which produced the artifact to download.")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Local file to write the downloaded artifact to. Defaults to the artifact file name.")
+
+	return cmd
+}
+
+// newGraphCommand returns the command for rendering the graph of an operation set as dot or
+// svg, optionally with the executed path of a completed diagnosis highlighted.
+func newGraphCommand() *cobra.Command {
+	var (
+		namespace string
+		diagnosis string
+		format    string
+		output    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "graph OPERATIONSET",
+		Short: "Render the graph of an operation set as DOT or SVG.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cli, err := newClient()
+			if err != nil {
+				return err
+			}
+
+			var operationSet diagnosisv1.OperationSet
+			if err := cli.Get(context.Background(), client.ObjectKey{Name: args[0]}, &operationSet); err != nil {
+				return fmt.Errorf("unable to fetch operation set: %v", err)
+			}
+
+			var executedPath diagnosisv1.Path
+			if diagnosis != "" {
+				var completed diagnosisv1.Diagnosis
+				if err := cli.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: diagnosis}, &completed); err != nil {
+					return fmt.Errorf("unable to fetch diagnosis: %v", err)
+				}
+				executedPath = completed.Status.SucceededPath
+			}
+
+			dot := graphbuilder.RenderDOT(operationSet, executedPath)
+			var content []byte
+			switch format {
+			case "dot":
+				content = []byte(dot)
+			case "svg":
+				content, err = graphbuilder.RenderSVG(dot)
+				if err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("format %s is not supported", format)
+			}
+
+			if output == "" {
+				fmt.Print(string(content))
+				return nil
+			}
+			if err := ioutil.WriteFile(output, content, 0644); err != nil {
+				return fmt.Errorf("unable to write output file %s: %v", output, err)
+			}
+			fmt.Printf("graph written to %s\n", output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "Namespace of the diagnosis.")
+	cmd.Flags().StringVar(&diagnosis, "diagnosis", "", "Name of a completed diagnosis whose executed path is highlighted.")
+	cmd.Flags().StringVar(&format, "format", "dot", "Output format. Options are dot and svg. SVG requires graphviz.")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Local file to write the graph to. Prints to standard output if empty.")
+
+	return cmd
+}
+
+// newClient creates a client for diagnoses from the current kubeconfig context.
+func newClient() (client.Client, error) {
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kubeconfig: %v", err)
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, fmt.Errorf("unable to setup scheme: %v", err)
+	}
+	if err := diagnosisv1.AddToScheme(scheme); err != nil {
+		return nil, fmt.Errorf("unable to setup scheme: %v", err)
+	}
+
+	cli, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create client: %v", err)
+	}
+
+	return cli, nil
+}
+
+// watchDiagnosis polls the diagnosis until it succeeds, fails or the timeout expires. Phase and
+// per operation progress are printed whenever they change.
+func watchDiagnosis(cli client.Client, namespace string, name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	lastProgress := ""
+	for {
+		var diagnosis diagnosisv1.Diagnosis
+		err := cli.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: name}, &diagnosis)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return fmt.Errorf("unable to fetch diagnosis: %v", err)
+			}
+		} else {
+			progress := formatProgress(diagnosis)
+			if progress != lastProgress {
+				fmt.Println(progress)
+				lastProgress = progress
+			}
+
+			switch diagnosis.Status.Phase {
+			case diagnosisv1.DiagnosisSucceeded:
+				printPath(diagnosis.Status.SucceededPath)
+				return nil
+			case diagnosisv1.DiagnosisFailed:
+				for _, condition := range diagnosis.Status.Conditions {
+					if condition.Status == corev1.ConditionTrue && condition.Type != diagnosisv1.DiagnosisComplete {
+						fmt.Printf("  %s: %s\n", condition.Reason, condition.Message)
+					}
+				}
+				return fmt.Errorf("diagnosis %s/%s failed", namespace, name)
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for diagnosis %s/%s to complete", namespace, name)
+		}
+		time.Sleep(watchInterval)
+	}
+}
+
+// formatProgress renders the phase and checkpoint of a diagnosis as a single line.
+func formatProgress(diagnosis diagnosisv1.Diagnosis) string {
+	progress := fmt.Sprintf("phase: %s", diagnosis.Status.Phase)
+	if diagnosis.Status.Checkpoint != nil {
+		checkpoint := diagnosis.Status.Checkpoint
+		progress = fmt.Sprintf("%s, path %d, operation %d, tasks %d/%d succeeded", progress, checkpoint.PathIndex, checkpoint.NodeIndex, checkpoint.Succeeded, checkpoint.Desired)
+		if checkpoint.Failed != 0 {
+			progress = fmt.Sprintf("%s, %d failed", progress, checkpoint.Failed)
+		}
+	}
+
+	return progress
+}
+
+// printPath prints the operations of the succeeded path.
+func printPath(path diagnosisv1.Path) {
+	if len(path) == 0 {
+		return
+	}
+
+	operations := make([]string, 0, len(path))
+	for _, node := range path {
+		if node.Operation != "" {
+			operations = append(operations, node.Operation)
+		}
+	}
+	fmt.Printf("succeeded path: %s\n", strings.Join(operations, " -> "))
+}
+
+// listArtifacts lists artifacts of the diagnosis through the master rest api.
+func listArtifacts(masterAddress string, namespace string, name string) error {
+	listURL := fmt.Sprintf("%s/api/v1/diagnoses/%s/%s/artifacts", strings.TrimSuffix(masterAddress, "/"), url.PathEscape(namespace), url.PathEscape(name))
+	res, err := http.Get(listURL)
+	if err != nil {
+		return fmt.Errorf("unable to list artifacts: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("unable to list artifacts: %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	var artifacts []struct {
+		Path        string `json:"path"`
+		Operation   string `json:"operation"`
+		Description string `json:"description"`
+		Size        int64  `json:"size"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&artifacts); err != nil {
+		return fmt.Errorf("unable to decode artifacts: %v", err)
+	}
+
+	if len(artifacts) == 0 {
+		fmt.Println("no artifacts found")
+		return nil
+	}
+	for _, artifact := range artifacts {
+		fmt.Printf("%s\t%d bytes\t%s\t%s\n", artifact.Path, artifact.Size, artifact.Operation, artifact.Description)
+	}
+	return nil
+}
+
+// downloadArtifact downloads an artifact file through the master rest api.
+func downloadArtifact(masterAddress string, node string, path string, output string) error {
+	downloadURL := fmt.Sprintf("%s/api/v1/artifacts/download?node=%s&path=%s", strings.TrimSuffix(masterAddress, "/"), url.QueryEscape(node), url.QueryEscape(path))
+	res, err := http.Get(downloadURL)
+	if err != nil {
+		return fmt.Errorf("unable to download artifact: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("unable to download artifact: %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("unable to create output file %s: %v", output, err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, res.Body); err != nil {
+		return fmt.Errorf("unable to write output file %s: %v", output, err)
+	}
+	fmt.Printf("artifact written to %s\n", output)
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/kubediag/kubediag/pkg/helper"
+)
+
+// HelperOptions is the main context object for the kubediag helper.
+type HelperOptions struct {
+	// Socket is the path of the unix socket to serve on.
+	Socket string
+	// AllowedCommands contains the names of commands the helper is permitted to run.
+	AllowedCommands []string
+}
+
+// NewHelperOptions creates a new HelperOptions with default values.
+func NewHelperOptions() *HelperOptions {
+	return &HelperOptions{
+		Socket:          "/var/run/kubediag/helper.sock",
+		AllowedCommands: []string{"tcpdump", "kill", "du", "ps"},
+	}
+}
+
+func main() {
+	opts := NewHelperOptions()
+
+	cmd := &cobra.Command{
+		Use: "kubediag-helper",
+		Long: `The KubeDiag helper is a small privileged companion of the kubediag
+agent. The agent runs unprivileged and delegates the few host commands
+which need host namespaces to the helper over a unix socket. The helper
+only runs commands from its allowlist.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.Run()
+		},
+	}
+
+	opts.AddFlags(cmd.Flags())
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// Run runs the kubediag helper.
+func (opts *HelperOptions) Run() error {
+	ctrl.SetLogger(zap.New(func(o *zap.Options) {
+		o.Development = true
+	}))
+
+	stopCh := SetupSignalHandler()
+	helper := helper.NewHelper(
+		context.Background(),
+		ctrl.Log.WithName("helper"),
+		opts.Socket,
+		opts.AllowedCommands,
+	)
+	if err := helper.Run(stopCh); err != nil {
+		return fmt.Errorf("unable to run helper: %v", err)
+	}
+
+	return nil
+}
+
+// AddFlags adds flags to fs and binds them to options.
+func (opts *HelperOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&opts.Socket, "socket", opts.Socket, "Path of the unix socket to serve on.")
+	fs.StringSliceVar(&opts.AllowedCommands, "allowed-commands", opts.AllowedCommands, "Names of commands the helper is permitted to run.")
+}
+
+// SetupSignalHandler registers handlers for SIGTERM and SIGINT. A stop channel is returned
+// which is closed on one of these signals.
+func SetupSignalHandler() chan struct{} {
+	stopCh := make(chan struct{})
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		close(stopCh)
+		// Exit directly on the second signal.
+		<-c
+		os.Exit(1)
+	}()
+
+	return stopCh
+}
@@ -18,7 +18,9 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/pprof"
 	"os"
@@ -33,25 +35,55 @@ import (
 	"github.com/spf13/pflag"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/component-base/cli/flag"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
 	"github.com/kubediag/kubediag/pkg/alertmanager"
+	"github.com/kubediag/kubediag/pkg/artifacts"
+	"github.com/kubediag/kubediag/pkg/audit"
+	"github.com/kubediag/kubediag/pkg/authorizer"
+	"github.com/kubediag/kubediag/pkg/bootstrap"
+	"github.com/kubediag/kubediag/pkg/clusterhealth"
 	"github.com/kubediag/kubediag/pkg/commoneventer"
+	"github.com/kubediag/kubediag/pkg/configreloader"
+	"github.com/kubediag/kubediag/pkg/containerruntime"
 	"github.com/kubediag/kubediag/pkg/controllers"
+	"github.com/kubediag/kubediag/pkg/correlation"
 	"github.com/kubediag/kubediag/pkg/cronscheduler"
+	"github.com/kubediag/kubediag/pkg/dashboard"
+	"github.com/kubediag/kubediag/pkg/deduplication"
+	"github.com/kubediag/kubediag/pkg/diagnosisreporter"
+	"github.com/kubediag/kubediag/pkg/encryption"
 	"github.com/kubediag/kubediag/pkg/eventer"
 	"github.com/kubediag/kubediag/pkg/executor"
 	"github.com/kubediag/kubediag/pkg/features"
 	"github.com/kubediag/kubediag/pkg/garbagecollection"
+	"github.com/kubediag/kubediag/pkg/grafana"
 	"github.com/kubediag/kubediag/pkg/graphbuilder"
+	"github.com/kubediag/kubediag/pkg/healthchecker"
+	"github.com/kubediag/kubediag/pkg/helper"
 	"github.com/kubediag/kubediag/pkg/kafka"
+	"github.com/kubediag/kubediag/pkg/loki"
+	"github.com/kubediag/kubediag/pkg/notifier"
 	"github.com/kubediag/kubediag/pkg/pagerdutyeventer"
+	"github.com/kubediag/kubediag/pkg/policy"
 	"github.com/kubediag/kubediag/pkg/processors/register"
+	"github.com/kubediag/kubediag/pkg/profilerproxy"
+	"github.com/kubediag/kubediag/pkg/progress"
+	"github.com/kubediag/kubediag/pkg/queue"
+	"github.com/kubediag/kubediag/pkg/redactor"
+	"github.com/kubediag/kubediag/pkg/restapi"
+	"github.com/kubediag/kubediag/pkg/scopedcache"
+	"github.com/kubediag/kubediag/pkg/signing"
+	"github.com/kubediag/kubediag/pkg/storage"
+	"github.com/kubediag/kubediag/pkg/tlsutil"
+	"github.com/kubediag/kubediag/pkg/util"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -105,8 +137,113 @@ type KubeDiagOptions struct {
 	// FeatureGates is a map of feature names to bools that enable or disable features. This field modifies
 	// piecemeal the default values from "github.com/kubediag/kubediag/pkg/features/features.go".
 	FeatureGates map[string]bool
+	// MutualTLSCertFile is the path of the certificate presented to peers for mutual tls between
+	// master and agent http endpoints. Mutual tls is enabled if the certificate, key and ca
+	// bundle are all specified.
+	MutualTLSCertFile string
+	// MutualTLSKeyFile is the path of the private key of the mutual tls certificate.
+	MutualTLSKeyFile string
+	// MutualTLSCAFile is the path of the certificate authority bundle used to verify peers for
+	// mutual tls.
+	MutualTLSCAFile string
+	// RestrictedNetworkMode makes the agent only accept requests relayed through the master.
+	// Direct requests to the agent are rejected unless they present the master client
+	// certificate. It requires mutual tls to be enabled.
+	RestrictedNetworkMode bool
+	// MasterIdentity is the common name of the master client certificate accepted by agents in
+	// restricted network mode.
+	MasterIdentity string
+	// ArtifactEncryptionKeyFile is the path of the aes key file used to encrypt artifact files
+	// under data root at rest. Encryption at rest is disabled if empty.
+	ArtifactEncryptionKeyFile string
+	// EnableDashboard enables the web dashboard on the master for browsing diagnoses, executed
+	// graphs and artifacts.
+	EnableDashboard bool
+	// EnableCuratedLibrary installs and keeps up to date the curated operation set library
+	// shipped with the release.
+	EnableCuratedLibrary bool
+	// ClusterHealthSchedules maps cluster health checks to cron expressions. Cluster health
+	// evaluation is disabled if empty.
+	ClusterHealthSchedules map[string]string
+	// ClusterHealthTimezone is the timezone cluster health schedules are evaluated in.
+	ClusterHealthTimezone string
+	// DiagnosisReportWindow is the length of the rolling window diagnosis report statistics
+	// cover. The diagnosis report is disabled if zero.
+	DiagnosisReportWindow time.Duration
+	// CorrelationWindow is the length of the time window within which related diagnoses are
+	// correlated into one incident. Correlation is disabled if zero.
+	CorrelationWindow time.Duration
+	// DeduplicationWindow is the length of the time window within which identical pending
+	// diagnoses are merged into the earliest of them. Deduplication is disabled if zero.
+	DeduplicationWindow time.Duration
+	// ProfilerProxySecretFile is the path of the shared secret file used to authorize creation
+	// of proxied profiler sessions. The profiler proxy is disabled if empty.
+	ProfilerProxySecretFile string
+	// ProfilerProxySessionTTL is the duration after which a proxied profiler session expires.
+	ProfilerProxySessionTTL time.Duration
+	// MaxConcurrentReconciles is the maximum number of diagnoses or tasks reconciled concurrently
+	// by the Diagnosis and Task controllers.
+	MaxConcurrentReconciles int
+	// DiagnosisMaxConcurrentReconciles is the maximum number of diagnoses reconciled concurrently
+	// by the Diagnosis controller. MaxConcurrentReconciles is used if not positive.
+	DiagnosisMaxConcurrentReconciles int
+	// TaskMaxConcurrentReconciles is the maximum number of tasks reconciled concurrently by the
+	// Task controller. MaxConcurrentReconciles is used if not positive.
+	TaskMaxConcurrentReconciles int
+	// OperationSetMaxConcurrentReconciles is the maximum number of operation sets reconciled
+	// concurrently by the OperationSet controller. MaxConcurrentReconciles is used if not
+	// positive.
+	OperationSetMaxConcurrentReconciles int
+	// TaskWorkers is the number of workers processing tasks from the executor work queue on
+	// the agent.
+	TaskWorkers int
+	// MaxConcurrentDiagnoses is the maximum number of diagnoses with operations in flight on
+	// the agent node. Operations of further diagnoses are queued until a slot is free. A non
+	// positive limit disables throttling.
+	MaxConcurrentDiagnoses int
+	// AgentAuthorizationMode is the authorization mode of agent processor endpoints. Options are
+	// None, BearerToken and SharedSecret.
+	AgentAuthorizationMode string
+	// AgentSharedSecretFile is the path of the shared secret file used to authorize requests to
+	// agent processor endpoints in SharedSecret mode.
+	AgentSharedSecretFile string
+	// AgentConfigFile is the path of a watched configuration file, typically mounted from a
+	// config map, which reloads feature gates and the docker endpoint of the agent at runtime.
+	// Configuration reloading is disabled if empty.
+	AgentConfigFile string
+	// EnableAudit enables recording of executed operations to a tamper evident audit log under
+	// data root.
+	EnableAudit bool
+	// AuditSinkURL is the url of an external audit sink to ship audit entries to. Shipping is
+	// disabled if empty. It is valid only if EnableAudit is true.
+	AuditSinkURL string
+	// OperationPolicyFile is the path of the policy file which allowlists mutating operations.
+	// All mutating operations are permitted if empty.
+	OperationPolicyFile string
+	// DisableResultRedaction disables redaction of secrets from operation results.
+	DisableResultRedaction bool
+	// RedactionPatterns contains additional regular expressions matching secret material to
+	// redact from operation results.
+	RedactionPatterns []string
+	// HelperSocket is the path of the unix socket of the privileged helper. Host commands are
+	// delegated to the helper if specified, so the agent itself can run unprivileged.
+	HelperSocket string
+	// SignatureVerificationMode is the verification mode of operation and operation set
+	// signatures. Options are None and Enforce.
+	SignatureVerificationMode string
+	// SignaturePublicKeyFile is the path of the pem encoded public key used to verify operation
+	// and operation set signatures. It is required in Enforce mode.
+	SignaturePublicKeyFile string
 	// DataRoot is root directory of persistent kubediag data.
 	DataRoot string
+	// DataRootQuotaBytes is the maximum size of persistent kubediag data in bytes. The oldest
+	// diagnosis artifacts are evicted once the quota is exceeded. A non positive quota disables
+	// enforcement.
+	DataRootQuotaBytes int64
+	// DataRootArtifactTTL is amount of time to retain artifacts under data root. Artifacts
+	// which outlive the ttl are removed by the agent. A non positive ttl disables retention
+	// by age.
+	DataRootArtifactTTL time.Duration
 	// SinkEventToKafka enables the pagerduty handler to write message to kafka cluster.
 	SinkEventToKafka bool
 	// KafkaAddress is the addresses used to connect to the kafka cluster.
@@ -117,6 +254,106 @@ type KubeDiagOptions struct {
 	// WebhookAddress is the addresses used to connect to the webhook receiver.
 	// It is valid only if SinkEventToWebhookReceiver is true.
 	WebhookAddress string
+	// ChatOpsProvider is the type of the chatops webhook. It must be one of slack, dingtalk or wecom.
+	ChatOpsProvider string
+	// ChatOpsWebhookURL is the url of the chatops webhook to post diagnosis summaries to.
+	// Chatops notifications are disabled if empty.
+	ChatOpsWebhookURL string
+	// EmailSMTPServer is the address of the smtp server in host:port format.
+	// Email notifications are disabled if empty.
+	EmailSMTPServer string
+	// EmailUsername is the username for smtp authentication.
+	EmailUsername string
+	// EmailPassword is the password for smtp authentication.
+	EmailPassword string
+	// EmailFrom is the sender address of diagnosis reports.
+	EmailFrom string
+	// EmailRecipients contains the recipient addresses of diagnosis reports.
+	EmailRecipients []string
+	// EmailNamespaces filters reported diagnoses by namespace. All namespaces are reported if empty.
+	EmailNamespaces []string
+	// NotificationWebhookURL is the url of a generic http receiver to post diagnosis summaries to.
+	// Webhook notifications are disabled if empty.
+	NotificationWebhookURL string
+	// NotificationWebhookTemplateFile is the path of a template file for rendering the webhook
+	// payload. The whole summary is encoded as a json object if empty.
+	NotificationWebhookTemplateFile string
+	// S3Endpoint is the url of an s3 compatible endpoint to upload artifacts to.
+	// Artifact uploading is disabled if empty.
+	S3Endpoint string
+	// S3Bucket is the name of the bucket to upload artifacts to.
+	S3Bucket string
+	// S3Region is the region of the bucket.
+	S3Region string
+	// S3AccessKey is the access key id for authentication.
+	S3AccessKey string
+	// S3SecretKey is the secret access key for authentication.
+	S3SecretKey string
+	// S3KeyPrefix is the prefix of all uploaded object keys.
+	S3KeyPrefix string
+	// ElasticsearchAddresses contains the addresses of the elasticsearch cluster to index
+	// completed diagnosis documents into. Elasticsearch indexing is disabled if empty.
+	ElasticsearchAddresses []string
+	// ElasticsearchUsername is the username for elasticsearch authentication.
+	ElasticsearchUsername string
+	// ElasticsearchPassword is the password for elasticsearch authentication.
+	ElasticsearchPassword string
+	// ElasticsearchIndex is the name of the index to write diagnosis documents to.
+	ElasticsearchIndex string
+	// KafkaResultBrokers contains the addresses of the kafka brokers to publish completed
+	// diagnosis summaries to. Kafka result publishing is disabled if empty.
+	KafkaResultBrokers []string
+	// KafkaResultTopic is the name of the kafka topic to publish diagnosis summaries to.
+	KafkaResultTopic string
+	// KafkaResultFormat is the serialization format of published diagnosis summaries. It must be
+	// one of json or cloudevents.
+	KafkaResultFormat string
+	// GrafanaAPIURL is the base url of the grafana server to create diagnosis annotations on.
+	// Grafana annotation is disabled if empty.
+	GrafanaAPIURL string
+	// GrafanaAPIKey is the api key for grafana authentication.
+	GrafanaAPIKey string
+	// ITSMProvider is the type of the itsm system to open tickets for failed diagnoses in. It
+	// must be one of jira or servicenow.
+	ITSMProvider string
+	// ITSMAPIURL is the base url of the itsm system. Ticket creation is disabled if empty.
+	ITSMAPIURL string
+	// ITSMUsername is the username for itsm authentication.
+	ITSMUsername string
+	// ITSMPassword is the password or api token for itsm authentication.
+	ITSMPassword string
+	// ITSMProject is the project key of opened jira issues.
+	ITSMProject string
+	// ITSMNamespaces contains namespaces of diagnoses to open tickets for. Failed diagnoses in
+	// all namespaces result in tickets if empty.
+	ITSMNamespaces []string
+	// PagerDutyEventsAPIURL is the url of the pagerduty events api to send resolve events to.
+	PagerDutyEventsAPIURL string
+	// PagerDutyRoutingKey is the integration key of the pagerduty service. Incident resolution
+	// is disabled if empty.
+	PagerDutyRoutingKey string
+	// AlertmanagerSilenceURL is the base url of the alertmanager to create silences on while
+	// diagnoses are running. Silence creation is disabled if empty.
+	AlertmanagerSilenceURL string
+	// AlertmanagerSilenceDuration is the duration of created silences. It should cover the
+	// expected runtime of a recovery and its verification.
+	AlertmanagerSilenceDuration time.Duration
+	// AgentPort is the port kubediag agents serve on. It is used by the master to proxy
+	// artifact requests to agents.
+	AgentPort int
+	// LokiPushURL is the url of the loki push endpoint to ship operation results to. Result
+	// shipping is disabled if empty.
+	LokiPushURL string
+	// LokiTenant is the tenant id sent with loki push requests.
+	LokiTenant string
+	// ShardIndex is the index of the shard this master replica processes. Diagnoses are
+	// partitioned across replicas by hashing their namespaced names.
+	ShardIndex int
+	// TotalShards is the total number of master shards. Sharding is disabled if less than two.
+	TotalShards int
+	// AgentWatchNamespaces restricts the agent cache to objects in the listed namespaces. Pods
+	// are always restricted to the local node. All namespaces are watched if empty.
+	AgentWatchNamespaces []string
 }
 
 func init() {
@@ -161,21 +398,38 @@ of an Diagnosis.`,
 // NewKubeDiagOptions creates a new KubeDiagOptions with a default config.
 func NewKubeDiagOptions() (*KubeDiagOptions, error) {
 	return &KubeDiagOptions{
-		Mode:                       "agent",
-		BindAddress:                "0.0.0.0",
-		Port:                       8090,
-		MetricsPort:                10357,
-		EnableLeaderElection:       false,
-		WebhookPort:                9443,
-		CertDir:                    defaultCertDir,
-		AlertmanagerRepeatInterval: 6 * time.Hour,
-		TaskTTL:                    240 * time.Hour,
-		MinimumTaskTTLDuration:     30 * time.Minute,
-		MaximumTasksPerNode:        50,
-		CommonEventTTL:             2400 * time.Hour,
-		DataRoot:                   defaultDataRoot,
-		SinkEventToKafka:           false,
-		SinkEventToWebhookReceiver: false,
+		Mode:                        "agent",
+		BindAddress:                 "0.0.0.0",
+		Port:                        8090,
+		MetricsPort:                 10357,
+		EnableLeaderElection:        false,
+		WebhookPort:                 9443,
+		CertDir:                     defaultCertDir,
+		AlertmanagerRepeatInterval:  6 * time.Hour,
+		TaskTTL:                     240 * time.Hour,
+		MinimumTaskTTLDuration:      30 * time.Minute,
+		MaximumTasksPerNode:         50,
+		MaxConcurrentReconciles:     1,
+		TaskWorkers:                 8,
+		AgentAuthorizationMode:      authorizer.AuthorizationModeNone,
+		SignatureVerificationMode:   signing.VerificationModeNone,
+		CommonEventTTL:              2400 * time.Hour,
+		DataRoot:                    defaultDataRoot,
+		DataRootArtifactTTL:         240 * time.Hour,
+		SinkEventToKafka:            false,
+		SinkEventToWebhookReceiver:  false,
+		ChatOpsProvider:             notifier.SlackProvider,
+		KafkaResultFormat:           notifier.KafkaFormatJSON,
+		ITSMProvider:                notifier.JiraProvider,
+		PagerDutyEventsAPIURL:       notifier.DefaultPagerDutyEventsAPIURL,
+		AlertmanagerSilenceDuration: 30 * time.Minute,
+		AgentPort:                   8090,
+		TotalShards:                 1,
+		MasterIdentity:              "kubediag-master",
+		ClusterHealthTimezone:       "UTC",
+		DiagnosisReportWindow:       24 * time.Hour,
+		CorrelationWindow:           5 * time.Minute,
+		ProfilerProxySessionTTL:     30 * time.Minute,
 	}, nil
 }
 
@@ -197,6 +451,35 @@ func (opts *KubeDiagOptions) Run() error {
 	}
 	defer dockerCli.Close()
 
+	// Create a verifier for refusing unsigned or tampered operations and operation sets.
+	var signatureVerifier *signing.Verifier
+	switch opts.SignatureVerificationMode {
+	case signing.VerificationModeNone:
+	case signing.VerificationModeEnforce:
+		signatureVerifier, err = signing.NewVerifier(
+			context.Background(),
+			ctrl.Log.WithName("signing"),
+			opts.SignaturePublicKeyFile,
+		)
+		if err != nil {
+			setupLog.Error(err, "unable to create signature verifier")
+			return fmt.Errorf("unable to create signature verifier: %v", err)
+		}
+	default:
+		return fmt.Errorf("invalid signature verification mode %s, must be one of %s and %s", opts.SignatureVerificationMode, signing.VerificationModeNone, signing.VerificationModeEnforce)
+	}
+
+	// Per controller concurrency flags fall back to the shared flag if not set.
+	if opts.DiagnosisMaxConcurrentReconciles <= 0 {
+		opts.DiagnosisMaxConcurrentReconciles = opts.MaxConcurrentReconciles
+	}
+	if opts.TaskMaxConcurrentReconciles <= 0 {
+		opts.TaskMaxConcurrentReconciles = opts.MaxConcurrentReconciles
+	}
+	if opts.OperationSetMaxConcurrentReconciles <= 0 {
+		opts.OperationSetMaxConcurrentReconciles = opts.MaxConcurrentReconciles
+	}
+
 	if opts.Mode == "master" {
 		setupLog.Info("kubediag is running in master mode")
 
@@ -216,12 +499,51 @@ func (opts *KubeDiagOptions) Run() error {
 		// Collect feature gate metrics
 		features.Collect(featureGate)
 
-		// Channel for queuing kubernetes events and operation sets.
-		eventChainCh := make(chan corev1.Event, 1000)
-		diagnosisCh := make(chan diagnosisv1.Diagnosis, 1000)
-		graphBuilderCh := make(chan diagnosisv1.OperationSet, 1000)
+		// Index diagnoses by phase and node name so operators and api handlers can slice large
+		// diagnosis lists without full list scans.
+		if err := mgr.GetFieldIndexer().IndexField(&diagnosisv1.Diagnosis{}, util.DiagnosisPhaseIndexField, func(obj runtime.Object) []string {
+			diagnosis, ok := obj.(*diagnosisv1.Diagnosis)
+			if !ok {
+				return nil
+			}
+			return []string{string(diagnosis.Status.Phase)}
+		}); err != nil {
+			setupLog.Error(err, "unable to index diagnoses by phase")
+			return fmt.Errorf("unable to index diagnoses by phase: %v", err)
+		}
+		if err := mgr.GetFieldIndexer().IndexField(&diagnosisv1.Diagnosis{}, util.DiagnosisNodeNameIndexField, func(obj runtime.Object) []string {
+			diagnosis, ok := obj.(*diagnosisv1.Diagnosis)
+			if !ok {
+				return nil
+			}
+			return diagnosis.Status.NodeNames
+		}); err != nil {
+			setupLog.Error(err, "unable to index diagnoses by node name")
+			return fmt.Errorf("unable to index diagnoses by node name: %v", err)
+		}
+
+		// Work queues for queuing kubernetes events and operation sets. Unlike the channels
+		// they replace, pending items are deduplicated, retried with backoff on failure and
+		// never dropped under load.
+		eventQueue := queue.NewWorkQueue(context.Background(), ctrl.Log.WithName("queue/event"), "event")
+		operationSetQueue := queue.NewWorkQueue(context.Background(), ctrl.Log.WithName("queue/operationset"), "operationset")
 		stopCh := SetupSignalHandler()
 
+		// elected is closed once this replica has been elected leader, or on manager start if
+		// leader election is disabled. Trigger sources are gated on leadership so that an HA
+		// deployment with multiple masters does not generate duplicate diagnoses. The runnable
+		// is added without opting out of leader election, so the manager only starts it on the
+		// leading replica.
+		elected := make(chan struct{})
+		if err := mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+			close(elected)
+			<-stop
+			return nil
+		})); err != nil {
+			setupLog.Error(err, "unable to set up leader election notification")
+			return fmt.Errorf("unable to set up leader election notification: %v", err)
+		}
+
 		// Run common event reaper for garbage collection.
 		commonEventReaper := garbagecollection.NewCommonEventReaper(
 			context.Background(),
@@ -235,6 +557,79 @@ func (opts *KubeDiagOptions) Run() error {
 			commonEventReaper.Run(stopCh)
 		}(stopCh)
 
+		// Run cluster health evaluator on configured cron schedules.
+		if len(opts.ClusterHealthSchedules) != 0 {
+			clusterHealthEvaluator, err := clusterhealth.NewClusterHealthEvaluator(
+				context.Background(),
+				ctrl.Log.WithName("clusterhealth"),
+				mgr.GetClient(),
+				mgr.GetCache(),
+				opts.ClusterHealthSchedules,
+				opts.ClusterHealthTimezone,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create cluster health evaluator")
+				return fmt.Errorf("unable to create cluster health evaluator: %v", err)
+			}
+			go func(stopCh chan struct{}) {
+				clusterHealthEvaluator.Run(stopCh)
+			}(stopCh)
+		}
+
+		// Run diagnosis reporter to maintain aggregate diagnosis statistics.
+		if opts.DiagnosisReportWindow != 0 {
+			diagnosisReporter := diagnosisreporter.NewDiagnosisReporter(
+				context.Background(),
+				ctrl.Log.WithName("diagnosisreporter"),
+				mgr.GetClient(),
+				mgr.GetCache(),
+				opts.DiagnosisReportWindow,
+			)
+			go func(stopCh chan struct{}) {
+				diagnosisReporter.Run(stopCh)
+			}(stopCh)
+		}
+
+		// Run correlator to group related diagnoses into incidents.
+		if opts.CorrelationWindow != 0 {
+			correlator := correlation.NewCorrelator(
+				context.Background(),
+				ctrl.Log.WithName("correlator"),
+				mgr.GetClient(),
+				mgr.GetCache(),
+				opts.CorrelationWindow,
+			)
+			go func(stopCh chan struct{}) {
+				correlator.Run(stopCh)
+			}(stopCh)
+		}
+
+		// Run deduplicator to coalesce identical pending diagnoses generated by alert storms
+		// or event bursts.
+		if opts.DeduplicationWindow != 0 {
+			deduplicator := deduplication.NewDeduplicator(
+				context.Background(),
+				ctrl.Log.WithName("deduplicator"),
+				mgr.GetClient(),
+				mgr.GetCache(),
+				opts.DeduplicationWindow,
+			)
+			go func(stopCh chan struct{}) {
+				deduplicator.Run(stopCh)
+			}(stopCh)
+		}
+
+		// Run operation health checker for probing registered processor endpoints.
+		operationHealthChecker := healthchecker.NewOperationHealthChecker(
+			context.Background(),
+			ctrl.Log.WithName("operationhealthchecker"),
+			mgr.GetClient(),
+			mgr.GetCache(),
+		)
+		go func(stopCh chan struct{}) {
+			operationHealthChecker.Run(stopCh)
+		}(stopCh)
+
 		// Create graph builder for generating graph from operation set.
 		graphbuilder := graphbuilder.NewGraphBuilder(
 			context.Background(),
@@ -243,12 +638,39 @@ func (opts *KubeDiagOptions) Run() error {
 			mgr.GetEventRecorderFor("kubediag/graphbuilder"),
 			mgr.GetScheme(),
 			mgr.GetCache(),
-			graphBuilderCh,
+			operationSetQueue,
 		)
 		go func(stopCh chan struct{}) {
 			graphbuilder.Run(stopCh)
 		}(stopCh)
 
+		// Install and keep up to date the curated operation set library.
+		if opts.EnableCuratedLibrary {
+			curatedLibrary := bootstrap.NewBootstrap(
+				context.Background(),
+				ctrl.Log.WithName("bootstrap"),
+				mgr.GetClient(),
+			)
+			go func(stopCh chan struct{}) {
+				curatedLibrary.Run(stopCh)
+			}(stopCh)
+		}
+
+		// Create a silencer for silencing originating alerts while diagnoses are running.
+		var alertmanagerSilencer *alertmanager.Silencer
+		if opts.AlertmanagerSilenceURL != "" {
+			alertmanagerSilencer, err = alertmanager.NewSilencer(
+				context.Background(),
+				ctrl.Log.WithName("alertmanager/silencer"),
+				opts.AlertmanagerSilenceURL,
+				opts.AlertmanagerSilenceDuration,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create alertmanager silencer")
+				return fmt.Errorf("unable to create alertmanager silencer: %v", err)
+			}
+		}
+
 		// Create alertmanager for managing prometheus alerts.
 		alertmanager := alertmanager.NewAlertmanager(
 			context.Background(),
@@ -257,6 +679,7 @@ func (opts *KubeDiagOptions) Run() error {
 			mgr.GetCache(),
 			opts.AlertmanagerRepeatInterval,
 			featureGate.Enabled(features.Alertmanager),
+			elected,
 		)
 
 		// Create eventer for managing kubernetes events.
@@ -265,11 +688,16 @@ func (opts *KubeDiagOptions) Run() error {
 			ctrl.Log.WithName("eventer"),
 			mgr.GetClient(),
 			mgr.GetCache(),
-			eventChainCh,
+			eventQueue,
 			featureGate.Enabled(features.Eventer),
 		)
+		// Only the elected leader processes kubernetes events into diagnoses.
 		go func(stopCh chan struct{}) {
-			eventer.Run(stopCh)
+			select {
+			case <-elected:
+				eventer.Run(stopCh)
+			case <-stopCh:
+			}
 		}(stopCh)
 
 		// Create pagerduty eventer for managing pagerduty events.
@@ -283,6 +711,7 @@ func (opts *KubeDiagOptions) Run() error {
 			opts.SinkEventToWebhookReceiver,
 			opts.WebhookAddress,
 			featureGate.Enabled(features.PagerDutyEventer),
+			elected,
 		)
 
 		// Create commonEventer for managing common events
@@ -292,6 +721,7 @@ func (opts *KubeDiagOptions) Run() error {
 			mgr.GetClient(),
 			mgr.GetCache(),
 			featureGate.Enabled(features.CommonEventer),
+			elected,
 		)
 
 		// Create cron scheduler for managing crons.
@@ -302,8 +732,13 @@ func (opts *KubeDiagOptions) Run() error {
 			mgr.GetCache(),
 			featureGate.Enabled(features.CronScheduler),
 		)
+		// Only the elected leader schedules cron diagnoses.
 		go func(stopCh chan struct{}) {
-			cronscheduler.Run(stopCh)
+			select {
+			case <-elected:
+				cronscheduler.Run(stopCh)
+			case <-stopCh:
+			}
 		}(stopCh)
 
 		// Create kafka consumer for managing kafka messages.
@@ -320,26 +755,225 @@ func (opts *KubeDiagOptions) Run() error {
 				setupLog.Error(err, "unable to create kafka consumer")
 				return fmt.Errorf("unable to create kafka consumer: %v", err)
 			}
+			// Only the elected leader consumes kafka messages into diagnoses.
 			go func(stopCh chan struct{}) {
-				kafkaConsumer.Run(stopCh)
+				select {
+				case <-elected:
+					kafkaConsumer.Run(stopCh)
+				case <-stopCh:
+				}
 			}(stopCh)
 		}
 
+		// Mutual tls configuration for master and agent http endpoints.
+		mtlsConfig := tlsutil.Config{
+			CertFile: opts.MutualTLSCertFile,
+			KeyFile:  opts.MutualTLSKeyFile,
+			CAFile:   opts.MutualTLSCAFile,
+		}
+		var agentClientTLSConfig *tls.Config
+		if mtlsConfig.Enabled() {
+			agentClientTLSConfig, err = mtlsConfig.ClientTLSConfig()
+			if err != nil {
+				setupLog.Error(err, "unable to create client tls config")
+				return fmt.Errorf("unable to create client tls config: %v", err)
+			}
+		}
+
+		// Create a read only rest api for listing diagnoses and proxying artifacts from agents.
+		restAPI := restapi.NewAPI(
+			context.Background(),
+			ctrl.Log.WithName("restapi"),
+			mgr.GetCache(),
+			opts.AgentPort,
+			agentClientTLSConfig,
+		)
+
+		// Create the optional authenticated reverse proxy for profiler result pages.
+		var profilerProxy *profilerproxy.ProfilerProxy
+		if opts.ProfilerProxySecretFile != "" {
+			profilerProxy, err = profilerproxy.NewProfilerProxy(
+				context.Background(),
+				ctrl.Log.WithName("profilerproxy"),
+				mgr.GetCache(),
+				opts.ProfilerProxySecretFile,
+				opts.ProfilerProxySessionTTL,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create profiler proxy")
+				return fmt.Errorf("unable to create profiler proxy: %v", err)
+			}
+		}
+
+		// Create the optional web dashboard for browsing diagnoses and artifacts.
+		var webDashboard *dashboard.Dashboard
+		if opts.EnableDashboard {
+			webDashboard = dashboard.NewDashboard(
+				context.Background(),
+				ctrl.Log.WithName("dashboard"),
+				mgr.GetCache(),
+			)
+		}
+
 		// Start http server.
 		go func(stopCh chan struct{}) {
 			r := mux.NewRouter()
 			r.HandleFunc("/api/v1/alerts", alertmanager.Handler)
 			r.HandleFunc("/pagerduty", pagerdutyEventer.Handler)
 			r.HandleFunc("/api/v1/commonevent", commonEventer.Handler)
+			r.HandleFunc("/api/v1/diagnoses", restAPI.ListDiagnosesHandler)
+			r.HandleFunc("/api/v1/diagnoses/{namespace}/{name}", restAPI.GetDiagnosisHandler)
+			r.HandleFunc("/api/v1/diagnoses/{namespace}/{name}/artifacts", restAPI.ListArtifactsHandler)
+			r.HandleFunc("/api/v1/artifacts/download", restAPI.DownloadArtifactHandler)
+			r.HandleFunc("/api/v1/nodes/{node}/processors/{processor}", restAPI.ProcessorHandler)
+			r.HandleFunc("/api/v1/operationsets/{name}/graph", restAPI.GraphHandler)
+			if profilerProxy != nil {
+				r.HandleFunc("/api/v1/diagnoses/{namespace}/{name}/profilers", profilerProxy.CreateSessionHandler)
+				r.PathPrefix(profilerproxy.PathPrefix + "{token}/").HandlerFunc(profilerProxy.ProxyHandler)
+			}
+			if webDashboard != nil {
+				r.HandleFunc("/dashboard", webDashboard.ListHandler)
+				r.HandleFunc("/dashboard/diagnoses/{namespace}/{name}", webDashboard.DetailHandler)
+			}
 
 			// Start pprof server.
 			r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
-			if err := http.ListenAndServe(fmt.Sprintf("%s:%d", opts.BindAddress, opts.Port), r); err != nil {
+			if err := listenAndServe(fmt.Sprintf("%s:%d", opts.BindAddress, opts.Port), r, mtlsConfig, stopCh); err != nil {
 				setupLog.Error(err, "unable to start http server")
 				close(stopCh)
 			}
 		}(stopCh)
 
+		// Create notifiers for delivering summaries of completed diagnoses.
+		notifiers := make([]notifier.Notifier, 0)
+		if opts.ChatOpsWebhookURL != "" {
+			chatOpsNotifier, err := notifier.NewChatOpsNotifier(
+				context.Background(),
+				ctrl.Log.WithName("notifier/chatops"),
+				opts.ChatOpsProvider,
+				opts.ChatOpsWebhookURL,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create chatops notifier")
+				return fmt.Errorf("unable to create chatops notifier: %v", err)
+			}
+			notifiers = append(notifiers, chatOpsNotifier)
+		}
+		if opts.EmailSMTPServer != "" {
+			emailNotifier, err := notifier.NewEmailNotifier(
+				context.Background(),
+				ctrl.Log.WithName("notifier/email"),
+				opts.EmailSMTPServer,
+				opts.EmailUsername,
+				opts.EmailPassword,
+				opts.EmailFrom,
+				opts.EmailRecipients,
+				opts.EmailNamespaces,
+				"",
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create email notifier")
+				return fmt.Errorf("unable to create email notifier: %v", err)
+			}
+			notifiers = append(notifiers, emailNotifier)
+		}
+		if opts.NotificationWebhookURL != "" {
+			templateText := ""
+			if opts.NotificationWebhookTemplateFile != "" {
+				raw, err := ioutil.ReadFile(opts.NotificationWebhookTemplateFile)
+				if err != nil {
+					setupLog.Error(err, "unable to read notification webhook template file")
+					return fmt.Errorf("unable to read notification webhook template file: %v", err)
+				}
+				templateText = string(raw)
+			}
+			webhookNotifier, err := notifier.NewWebhookNotifier(
+				context.Background(),
+				ctrl.Log.WithName("notifier/webhook"),
+				opts.NotificationWebhookURL,
+				templateText,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create webhook notifier")
+				return fmt.Errorf("unable to create webhook notifier: %v", err)
+			}
+			notifiers = append(notifiers, webhookNotifier)
+		}
+		if len(opts.ElasticsearchAddresses) != 0 {
+			elasticsearchSink, err := notifier.NewElasticsearchSink(
+				context.Background(),
+				ctrl.Log.WithName("notifier/elasticsearch"),
+				opts.ElasticsearchAddresses,
+				opts.ElasticsearchUsername,
+				opts.ElasticsearchPassword,
+				opts.ElasticsearchIndex,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create elasticsearch sink")
+				return fmt.Errorf("unable to create elasticsearch sink: %v", err)
+			}
+			notifiers = append(notifiers, elasticsearchSink)
+		}
+		if len(opts.KafkaResultBrokers) != 0 {
+			kafkaPublisher, err := notifier.NewKafkaPublisher(
+				context.Background(),
+				ctrl.Log.WithName("notifier/kafka"),
+				opts.KafkaResultBrokers,
+				opts.KafkaResultTopic,
+				opts.KafkaResultFormat,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create kafka publisher")
+				return fmt.Errorf("unable to create kafka publisher: %v", err)
+			}
+			notifiers = append(notifiers, kafkaPublisher)
+		}
+		if opts.ITSMAPIURL != "" {
+			itsmNotifier, err := notifier.NewITSMNotifier(
+				context.Background(),
+				ctrl.Log.WithName("notifier/itsm"),
+				opts.ITSMProvider,
+				opts.ITSMAPIURL,
+				opts.ITSMUsername,
+				opts.ITSMPassword,
+				opts.ITSMProject,
+				opts.ITSMNamespaces,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create itsm notifier")
+				return fmt.Errorf("unable to create itsm notifier: %v", err)
+			}
+			notifiers = append(notifiers, itsmNotifier)
+		}
+		if opts.PagerDutyRoutingKey != "" {
+			pagerDutyResolver, err := notifier.NewPagerDutyResolver(
+				context.Background(),
+				ctrl.Log.WithName("notifier/pagerduty"),
+				opts.PagerDutyEventsAPIURL,
+				opts.PagerDutyRoutingKey,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create pagerduty resolver")
+				return fmt.Errorf("unable to create pagerduty resolver: %v", err)
+			}
+			notifiers = append(notifiers, pagerDutyResolver)
+		}
+
+		// Create a grafana annotator for recording diagnosis lifecycle transitions on dashboards.
+		var grafanaAnnotator *grafana.Annotator
+		if opts.GrafanaAPIURL != "" {
+			grafanaAnnotator, err = grafana.NewAnnotator(
+				context.Background(),
+				ctrl.Log.WithName("grafana/annotator"),
+				opts.GrafanaAPIURL,
+				opts.GrafanaAPIKey,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create grafana annotator")
+				return fmt.Errorf("unable to create grafana annotator: %v", err)
+			}
+		}
+
 		// Setup reconcilers for Diagnosis, Trigger, Operation, OperationSet and Event.
 		if err = (controllers.NewDiagnosisReconciler(
 			mgr.GetClient(),
@@ -347,11 +981,50 @@ func (opts *KubeDiagOptions) Run() error {
 			mgr.GetScheme(),
 			mgr.GetEventRecorderFor("kubediag/diagnosisreconciler"),
 			opts.NodeName,
-			diagnosisCh,
+			notifiers,
+			grafanaAnnotator,
+			alertmanagerSilencer,
+			opts.ShardIndex,
+			opts.TotalShards,
+			opts.DiagnosisMaxConcurrentReconciles,
+			signatureVerifier,
 		)).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "Diagnosis")
 			return fmt.Errorf("unable to create controller for Diagnosis: %v", err)
 		}
+		// The master backfills the node name of tasks which only reference a pod.
+		if err = (controllers.NewTaskReconciler(
+			mgr.GetClient(),
+			ctrl.Log.WithName("controllers").WithName("Task"),
+			mgr.GetScheme(),
+			mgr.GetEventRecorderFor("kubediag/taskreconciler"),
+			opts.Mode,
+			opts.NodeName,
+			nil,
+			opts.TaskMaxConcurrentReconciles,
+		)).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Task")
+			return fmt.Errorf("unable to create controller for Task: %v", err)
+		}
+		if err = (controllers.NewTaskSetReconciler(
+			mgr.GetClient(),
+			ctrl.Log.WithName("controllers").WithName("TaskSet"),
+			mgr.GetScheme(),
+			mgr.GetEventRecorderFor("kubediag/tasksetreconciler"),
+			opts.MaxConcurrentReconciles,
+		)).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "TaskSet")
+			return fmt.Errorf("unable to create controller for TaskSet: %v", err)
+		}
+		if err = (controllers.NewCronDiagnosisReconciler(
+			mgr.GetClient(),
+			ctrl.Log.WithName("controllers").WithName("CronDiagnosis"),
+			mgr.GetScheme(),
+			mgr.GetEventRecorderFor("kubediag/crondiagnosisreconciler"),
+		)).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "CronDiagnosis")
+			return fmt.Errorf("unable to create controller for CronDiagnosis: %v", err)
+		}
 		if err = (controllers.NewOperationReconciler(
 			mgr.GetClient(),
 			ctrl.Log.WithName("controllers").WithName("Operation"),
@@ -370,11 +1043,20 @@ func (opts *KubeDiagOptions) Run() error {
 			setupLog.Error(err, "unable to create controller", "controller", "Trigger")
 			return fmt.Errorf("unable to create controller for Trigger: %v", err)
 		}
+		if err = (controllers.NewDiagnosisTemplateReconciler(
+			mgr.GetClient(),
+			ctrl.Log.WithName("controllers").WithName("DiagnosisTemplate"),
+			mgr.GetScheme(),
+		)).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "DiagnosisTemplate")
+			return fmt.Errorf("unable to create controller for DiagnosisTemplate: %v", err)
+		}
 		if err = (controllers.NewOperationSetReconciler(
 			mgr.GetClient(),
 			ctrl.Log.WithName("controllers").WithName("OperationSet"),
 			mgr.GetScheme(),
-			graphBuilderCh,
+			operationSetQueue,
+			opts.OperationSetMaxConcurrentReconciles,
 		)).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "OperationSet")
 			return fmt.Errorf("unable to create controller for OperationSet: %v", err)
@@ -384,7 +1066,7 @@ func (opts *KubeDiagOptions) Run() error {
 				mgr.GetClient(),
 				ctrl.Log.WithName("controllers").WithName("Event"),
 				mgr.GetScheme(),
-				eventChainCh,
+				eventQueue,
 			)).SetupWithManager(mgr); err != nil {
 				setupLog.Error(err, "unable to create controller", "controller", "Event")
 				return fmt.Errorf("unable to create controller for Event: %v", err)
@@ -432,16 +1114,146 @@ func (opts *KubeDiagOptions) Run() error {
 			MetricsBindAddress: fmt.Sprintf("%s:%d", opts.BindAddress, opts.MetricsPort),
 			LeaderElection:     false,
 			LeaderElectionID:   "8a2b2861.kubediag.org",
+			// Restrict the agent cache to pods on the local node so memory usage does not grow
+			// with cluster size.
+			NewCache: scopedcache.NodeScopedCacheBuilder(opts.NodeName, opts.AgentWatchNamespaces),
 		})
 		if err != nil {
 			setupLog.Error(err, "unable to start manager")
 			return fmt.Errorf("unable to start manager: %v", err)
 		}
 
-		// Channel for queuing Diagnoses to pipeline for executing operations.
-		taskCh := make(chan diagnosisv1.Task, 2000)
+		// Index pods and tasks by node name so node local lookups are served from indexes instead
+		// of full list scans.
+		if err := mgr.GetFieldIndexer().IndexField(&corev1.Pod{}, util.NodeNameIndexField, func(obj runtime.Object) []string {
+			pod, ok := obj.(*corev1.Pod)
+			if !ok {
+				return nil
+			}
+			return []string{pod.Spec.NodeName}
+		}); err != nil {
+			setupLog.Error(err, "unable to index pods by node name")
+			return fmt.Errorf("unable to index pods by node name: %v", err)
+		}
+		if err := mgr.GetFieldIndexer().IndexField(&diagnosisv1.Task{}, util.NodeNameIndexField, func(obj runtime.Object) []string {
+			task, ok := obj.(*diagnosisv1.Task)
+			if !ok {
+				return nil
+			}
+			return []string{task.Spec.NodeName}
+		}); err != nil {
+			setupLog.Error(err, "unable to index tasks by node name")
+			return fmt.Errorf("unable to index tasks by node name: %v", err)
+		}
+
+		// Work queue for queuing Tasks to pipeline for executing operations.
+		taskQueue := queue.NewPriorityQueue(context.Background(), ctrl.Log.WithName("queue/task"), "task")
 		stopCh := SetupSignalHandler()
 
+		// Create artifact uploader for shipping artifacts to object storage.
+		var artifactUploader *storage.S3Uploader
+		if opts.S3Endpoint != "" {
+			artifactUploader, err = storage.NewS3Uploader(
+				context.Background(),
+				ctrl.Log.WithName("s3uploader"),
+				opts.S3Endpoint,
+				opts.S3Bucket,
+				opts.S3Region,
+				opts.S3AccessKey,
+				opts.S3SecretKey,
+				opts.S3KeyPrefix,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create s3 uploader")
+				return fmt.Errorf("unable to create s3 uploader: %v", err)
+			}
+		}
+
+		// Create a loki client for shipping operation results next to logs.
+		var resultShipper *loki.Client
+		if opts.LokiPushURL != "" {
+			resultShipper, err = loki.NewClient(
+				context.Background(),
+				ctrl.Log.WithName("loki"),
+				opts.LokiPushURL,
+				opts.LokiTenant,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create loki client")
+				return fmt.Errorf("unable to create loki client: %v", err)
+			}
+		}
+
+		// Delegate host commands to the privileged helper so the agent itself can run
+		// unprivileged.
+		if opts.HelperSocket != "" {
+			helperClient := helper.NewClient(
+				context.Background(),
+				ctrl.Log.WithName("helper"),
+				opts.HelperSocket,
+			)
+			util.SetCommandRunner(helperClient.RunCommand)
+		}
+
+		// Create an auditor for recording executed operations to the audit log.
+		var auditor *audit.Auditor
+		if opts.EnableAudit {
+			auditor, err = audit.NewAuditor(
+				context.Background(),
+				ctrl.Log.WithName("audit"),
+				opts.DataRoot,
+				opts.AuditSinkURL,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create auditor")
+				return fmt.Errorf("unable to create auditor: %v", err)
+			}
+		}
+
+		// Create an operation policy for allowlisting mutating operations.
+		var operationPolicy *policy.Policy
+		if opts.OperationPolicyFile != "" {
+			operationPolicy, err = policy.NewPolicy(
+				context.Background(),
+				ctrl.Log.WithName("policy"),
+				opts.OperationPolicyFile,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create operation policy")
+				return fmt.Errorf("unable to create operation policy: %v", err)
+			}
+		}
+
+		// Create a redactor for scrubbing secrets from operation results.
+		var resultRedactor *redactor.Redactor
+		if !opts.DisableResultRedaction {
+			resultRedactor, err = redactor.NewRedactor(
+				context.Background(),
+				ctrl.Log.WithName("redactor"),
+				opts.RedactionPatterns,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create redactor")
+				return fmt.Errorf("unable to create redactor: %v", err)
+			}
+		}
+
+		// Encrypt artifact files under data root at rest if an encryption key is specified. The
+		// encryptor is shared by the executor, which encrypts the files it writes itself, and
+		// the artifact manager, which encrypts registered files and decrypts them on download.
+		var artifactEncryptor *encryption.Encryptor
+		if opts.ArtifactEncryptionKeyFile != "" {
+			artifactEncryptor, err = encryption.NewEncryptor(
+				context.Background(),
+				ctrl.Log.WithName("encryptor"),
+				opts.ArtifactEncryptionKeyFile,
+			)
+			if err != nil {
+				setupLog.Error(err, "unable to create encryptor")
+				return fmt.Errorf("unable to create encryptor: %v", err)
+			}
+		}
+
 		// Run executor.
 		executor := executor.NewExecutor(
 			context.Background(),
@@ -455,12 +1267,31 @@ func (opts *KubeDiagOptions) Run() error {
 			opts.BindAddress,
 			opts.Port,
 			opts.DataRoot,
-			taskCh,
+			taskQueue,
+			opts.TaskWorkers,
+			opts.MaxConcurrentDiagnoses,
+			artifactUploader,
+			resultShipper,
+			auditor,
+			operationPolicy,
+			resultRedactor,
+			signatureVerifier,
+			artifactEncryptor,
 		)
 		go func(stopCh chan struct{}) {
 			executor.Run(stopCh)
 		}(stopCh)
 
+		// Stop starting new operations and terminate in-flight child processes on shutdown, so
+		// a rolling update does not leave diagnoses stuck or processes orphaned.
+		shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
+		util.SetShutdownContext(shutdownCtx)
+		go func(stopCh chan struct{}) {
+			<-stopCh
+			executor.Drain()
+			shutdownCancel()
+		}(stopCh)
+
 		// Run diagnosis reaper for garbage collection.
 		taskReaper := garbagecollection.NewTaskReaper(
 			context.Background(),
@@ -478,27 +1309,158 @@ func (opts *KubeDiagOptions) Run() error {
 			taskReaper.Run(stopCh)
 		}(stopCh)
 
+		// Run diagnosis reaper for deleting finished diagnoses with an expired ttl after finished.
+		diagnosisReaper := garbagecollection.NewDiagnosisReaper(
+			context.Background(),
+			ctrl.Log.WithName("diagnosisttlreaper"),
+			mgr.GetClient(),
+			mgr.GetCache(),
+			opts.NodeName,
+			opts.DataRoot,
+		)
+		go func(stopCh chan struct{}) {
+			diagnosisReaper.Run(stopCh)
+		}(stopCh)
+
+		dataRootQuota := garbagecollection.NewDataRootQuota(
+			context.Background(),
+			ctrl.Log.WithName("datarootquota"),
+			opts.DataRoot,
+			opts.DataRootQuotaBytes,
+		)
+		go func(stopCh chan struct{}) {
+			dataRootQuota.Run(stopCh)
+		}(stopCh)
+
+		artifactReaper := garbagecollection.NewArtifactReaper(
+			context.Background(),
+			ctrl.Log.WithName("artifactreaper"),
+			mgr.GetCache(),
+			opts.DataRoot,
+			opts.DataRootArtifactTTL,
+		)
+		go func(stopCh chan struct{}) {
+			artifactReaper.Run(stopCh)
+		}(stopCh)
+
+		// Mutual tls configuration for the agent http endpoints.
+		mtlsConfig := tlsutil.Config{
+			CertFile: opts.MutualTLSCertFile,
+			KeyFile:  opts.MutualTLSKeyFile,
+			CAFile:   opts.MutualTLSCAFile,
+		}
+
 		router := mux.NewRouter()
 		router.HandleFunc("/healthz", HealthCheckHandler)
+
+		// Only accept requests relayed through the master in restricted network mode. Requests
+		// which do not present the master client certificate are rejected, so node ports of
+		// agents can be blocked with network policies.
+		if opts.RestrictedNetworkMode {
+			if !mtlsConfig.Enabled() {
+				err := fmt.Errorf("restricted network mode requires mutual tls certificate files")
+				setupLog.Error(err, "unable to enable restricted network mode")
+				return err
+			}
+			router.Use(tlsutil.RequirePeerIdentity(opts.MasterIdentity, "/healthz"))
+		}
+
+		// Authorize requests to processor endpoints before they reach a processor.
+		var clientset kubernetes.Interface
+		if opts.AgentAuthorizationMode == authorizer.AuthorizationModeBearerToken {
+			clientset, err = kubernetes.NewForConfig(mgr.GetConfig())
+			if err != nil {
+				setupLog.Error(err, "unable to create clientset")
+				return fmt.Errorf("unable to create clientset: %v", err)
+			}
+		}
+		agentAuthorizer, err := authorizer.NewAuthorizer(
+			context.Background(),
+			ctrl.Log.WithName("authorizer"),
+			clientset,
+			opts.AgentAuthorizationMode,
+			opts.AgentSharedSecretFile,
+		)
+		if err != nil {
+			setupLog.Error(err, "unable to create authorizer")
+			return fmt.Errorf("unable to create authorizer: %v", err)
+		}
+		router.Use(agentAuthorizer.Middleware)
+
+		// Setup artifact registration and index endpoints for processors.
+		artifactManager := artifacts.NewArtifactManager(
+			context.Background(),
+			ctrl.Log.WithName("artifactmanager"),
+			opts.DataRoot,
+			artifactEncryptor,
+		)
+		router.HandleFunc("/api/v1/artifacts", artifactManager.Handler)
+		router.HandleFunc("/api/v1/artifacts/download", artifactManager.DownloadHandler)
+
+		// Relay progress reports of operation processors into diagnosis statuses.
+		progressManager := progress.NewProgressManager(
+			context.Background(),
+			ctrl.Log.WithName("progressmanager"),
+			mgr.GetClient(),
+		)
+		router.HandleFunc("/api/v1/progress/{namespace}/{name}", progressManager.Handler)
 		// Start pprof server.
 		router.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
 
-		// Setup operation processors.
-		registryOpt := &register.RegistryOption{
-			NodeName:       opts.NodeName,
-			DockerEndpoint: opts.DockerEndpoint,
-			DataRoot:       opts.DataRoot,
-			BindAddress:    opts.BindAddress,
+		// Setup operation processors. Processors are registered on a dedicated router mounted
+		// under /processor/, so the configuration reloader can rebuild and swap it at runtime
+		// without restarting the agent.
+		buildProcessorRouter := func(dockerEndpoint string) (http.Handler, error) {
+			registryOpt := &register.RegistryOption{
+				NodeName:    opts.NodeName,
+				Runtime:     containerruntime.Detect(ctrl.Log.WithName("containerruntime"), dockerEndpoint),
+				DataRoot:    opts.DataRoot,
+				BindAddress: opts.BindAddress,
+			}
+			processorRouter := mux.NewRouter()
+			if err := register.RegisterProcessors(mgr, registryOpt, featureGate, processorRouter, setupLog); err != nil {
+				return nil, err
+			}
+			return processorRouter, nil
 		}
-		err = register.RegisterProcessors(mgr, registryOpt, featureGate, router, setupLog)
+		processorHandler, err := buildProcessorRouter(opts.DockerEndpoint)
 		if err != nil {
 			setupLog.Error(err, "unable to register processors")
 			return fmt.Errorf("unable to register processors for Diagnosis: %v", err)
 		}
+		initialProcessorRouter := processorHandler
+		currentProcessorRouter := func() http.Handler { return initialProcessorRouter }
+		if opts.AgentConfigFile != "" {
+			configReloader := configreloader.NewConfigReloader(
+				context.Background(),
+				ctrl.Log.WithName("configreloader"),
+				opts.AgentConfigFile,
+				opts.DockerEndpoint,
+				featureGate,
+				buildProcessorRouter,
+				processorHandler,
+			)
+			go configReloader.Run(stopCh)
+			processorHandler = configReloader
+			currentProcessorRouter = configReloader.Handler
+		}
+		router.PathPrefix("/processor/").Handler(processorHandler)
+
+		// Serve deep self diagnosis health checks of the agent.
+		deepHealthChecker := healthchecker.NewDeepHealthChecker(
+			context.Background(),
+			ctrl.Log.WithName("deephealthchecker"),
+			mgr.GetCache(),
+			containerruntime.Detect(ctrl.Log.WithName("containerruntime"), opts.DockerEndpoint),
+			opts.DataRoot,
+			currentProcessorRouter,
+		)
+		router.HandleFunc("/healthz/deep", deepHealthChecker.Handler)
+		go deepHealthChecker.Run(stopCh)
 
 		// Start http server.
 		go func(stopCh chan struct{}) {
-			if err := http.ListenAndServe(fmt.Sprintf("%s:%d", opts.BindAddress, opts.Port), router); err != nil {
+			if err := listenAndServe(fmt.Sprintf("%s:%d", opts.BindAddress, opts.Port), router, mtlsConfig, stopCh); err != nil {
 				setupLog.Error(err, "unable to start http server")
 				close(stopCh)
 			}
@@ -509,8 +1471,11 @@ func (opts *KubeDiagOptions) Run() error {
 			mgr.GetClient(),
 			ctrl.Log.WithName("controllers").WithName("Task"),
 			mgr.GetScheme(),
+			mgr.GetEventRecorderFor("kubediag/taskreconciler"),
+			opts.Mode,
 			opts.NodeName,
-			taskCh,
+			taskQueue,
+			opts.TaskMaxConcurrentReconciles,
 		)).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "Task")
 			os.Exit(1)
@@ -547,7 +1512,7 @@ func (opts *KubeDiagOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&opts.NodeName, "node-name", opts.NodeName, "The node name.")
 	fs.IntVar(&opts.MetricsPort, "metrics-port", opts.MetricsPort, "The port the metric endpoint to serve on.")
 	fs.BoolVar(&opts.EnableLeaderElection, "enable-leader-election", opts.EnableLeaderElection, "Enables leader election for kubediag master.")
-	fs.StringVar(&opts.DockerEndpoint, "docker-endpoint", "unix:///var/run/docker.sock", "The docker endpoint.")
+	fs.StringVar(&opts.DockerEndpoint, "docker-endpoint", "unix:///var/run/docker.sock", "The docker endpoint. The container runtime is auto detected by probing well known runtime sockets if the endpoint is not reachable.")
 	fs.IntVar(&opts.WebhookPort, "webhook-port", opts.WebhookPort, "The port that the webhook server serves at.")
 	fs.StringVar(&opts.Host, "host", opts.Host, "The hostname that the webhook server binds to.")
 	fs.StringVar(&opts.CertDir, "cert-dir", opts.CertDir, "The directory that contains the server key and certificate.")
@@ -558,12 +1523,87 @@ func (opts *KubeDiagOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.DurationVar(&opts.MinimumTaskTTLDuration, "minimum-task-ttl-duration", opts.MinimumTaskTTLDuration, "Minimum age for a finished task before it is garbage collected.")
 	fs.DurationVar(&opts.CommonEventTTL, "common-event-ttl", opts.CommonEventTTL, "Amount of time to retain common events.")
 	fs.Int32Var(&opts.MaximumTasksPerNode, "maximum-tasks-per-node", opts.MaximumTasksPerNode, "Maximum number of finished diagnoses to retain per node.")
+	fs.IntVar(&opts.MaxConcurrentReconciles, "max-concurrent-reconciles", opts.MaxConcurrentReconciles, "Maximum number of diagnoses or tasks reconciled concurrently by the Diagnosis and Task controllers.")
+	fs.IntVar(&opts.DiagnosisMaxConcurrentReconciles, "diagnosis-max-concurrent-reconciles", opts.DiagnosisMaxConcurrentReconciles, "Maximum number of diagnoses reconciled concurrently by the Diagnosis controller. The value of max-concurrent-reconciles is used if not positive.")
+	fs.IntVar(&opts.TaskMaxConcurrentReconciles, "task-max-concurrent-reconciles", opts.TaskMaxConcurrentReconciles, "Maximum number of tasks reconciled concurrently by the Task controller. The value of max-concurrent-reconciles is used if not positive.")
+	fs.IntVar(&opts.OperationSetMaxConcurrentReconciles, "operationset-max-concurrent-reconciles", opts.OperationSetMaxConcurrentReconciles, "Maximum number of operation sets reconciled concurrently by the OperationSet controller. The value of max-concurrent-reconciles is used if not positive.")
+	fs.IntVar(&opts.TaskWorkers, "task-workers", opts.TaskWorkers, "Number of workers processing tasks from the executor work queue on the agent.")
+	fs.IntVar(&opts.MaxConcurrentDiagnoses, "max-concurrent-diagnoses", opts.MaxConcurrentDiagnoses, "Maximum number of diagnoses with operations in flight on the agent node. Operations of further diagnoses are queued until a slot is free. Throttling is disabled if not positive.")
+	fs.StringVar(&opts.AgentAuthorizationMode, "agent-authorization-mode", opts.AgentAuthorizationMode, "Authorization mode of agent processor endpoints. Options are None, BearerToken and SharedSecret.")
+	fs.StringVar(&opts.AgentSharedSecretFile, "agent-shared-secret-file", opts.AgentSharedSecretFile, "Path of the shared secret file used to authorize requests to agent processor endpoints in SharedSecret mode.")
+	fs.StringVar(&opts.AgentConfigFile, "agent-config-file", opts.AgentConfigFile, "Path of a watched configuration file which reloads feature gates and the docker endpoint of the agent at runtime. Configuration reloading is disabled if empty.")
+	fs.BoolVar(&opts.EnableAudit, "enable-audit", opts.EnableAudit, "Enables recording of executed operations to a tamper evident audit log under data root.")
+	fs.StringVar(&opts.AuditSinkURL, "audit-sink-url", opts.AuditSinkURL, "URL of an external audit sink to ship audit entries to. Shipping is disabled if empty.")
+	fs.StringVar(&opts.OperationPolicyFile, "operation-policy-file", opts.OperationPolicyFile, "Path of the policy file which allowlists mutating operations. All mutating operations are permitted if empty.")
+	fs.BoolVar(&opts.DisableResultRedaction, "disable-result-redaction", opts.DisableResultRedaction, "Disables redaction of secrets from operation results.")
+	fs.StringSliceVar(&opts.RedactionPatterns, "redaction-patterns", opts.RedactionPatterns, "Additional regular expressions matching secret material to redact from operation results.")
+	fs.StringVar(&opts.HelperSocket, "helper-socket", opts.HelperSocket, "Path of the unix socket of the privileged helper. Host commands are delegated to the helper if specified.")
+	fs.StringVar(&opts.SignatureVerificationMode, "signature-verification-mode", opts.SignatureVerificationMode, "Verification mode of Operation and OperationSet signatures. Options are None and Enforce.")
+	fs.StringVar(&opts.SignaturePublicKeyFile, "signature-public-key-file", opts.SignaturePublicKeyFile, "Path of the PEM encoded public key used to verify Operation and OperationSet signatures.")
+	fs.StringVar(&opts.MutualTLSCertFile, "mtls-cert-file", opts.MutualTLSCertFile, "Path of the certificate presented to peers for mutual TLS between master and agent http endpoints. Mutual TLS is enabled if the certificate, key and CA bundle are all specified.")
+	fs.StringVar(&opts.MutualTLSKeyFile, "mtls-key-file", opts.MutualTLSKeyFile, "Path of the private key of the mutual TLS certificate.")
+	fs.StringVar(&opts.MutualTLSCAFile, "mtls-ca-file", opts.MutualTLSCAFile, "Path of the certificate authority bundle used to verify peers for mutual TLS.")
+	fs.BoolVar(&opts.RestrictedNetworkMode, "restricted-network-mode", opts.RestrictedNetworkMode, "Only accept requests relayed through the master on the agent. Requires mutual TLS to be enabled.")
+	fs.StringVar(&opts.MasterIdentity, "master-identity", opts.MasterIdentity, "Common name of the master client certificate accepted by agents in restricted network mode.")
+	fs.StringVar(&opts.ArtifactEncryptionKeyFile, "artifact-encryption-key-file", opts.ArtifactEncryptionKeyFile, "Path of the AES key file used to encrypt artifact files under data root at rest. Encryption at rest is disabled if empty.")
+	fs.BoolVar(&opts.EnableDashboard, "enable-dashboard", opts.EnableDashboard, "Enables the web dashboard on the master for browsing diagnoses, executed graphs and artifacts.")
+	fs.BoolVar(&opts.EnableCuratedLibrary, "enable-curated-library", opts.EnableCuratedLibrary, "Installs and keeps up to date the curated operation set library shipped with the release.")
 	fs.Var(flag.NewMapStringBool(&opts.FeatureGates), "feature-gates", "A map of feature names to bools that enable or disable features. Options are:\n"+strings.Join(features.NewFeatureGate().KnownFeatures(), "\n"))
 	fs.StringVar(&opts.DataRoot, "data-root", opts.DataRoot, "Root directory of persistent kubediag data.")
+	fs.Int64Var(&opts.DataRootQuotaBytes, "data-root-quota-bytes", opts.DataRootQuotaBytes, "Maximum size of persistent kubediag data in bytes. The oldest diagnosis artifacts are evicted once the quota is exceeded. Enforcement is disabled if not positive.")
+	fs.DurationVar(&opts.DataRootArtifactTTL, "data-root-artifact-ttl", opts.DataRootArtifactTTL, "Amount of time to retain artifacts under data root. Retention by age is disabled if not positive.")
 	fs.BoolVar(&opts.SinkEventToKafka, "sink-event-to-kafka", opts.SinkEventToKafka, "Enables the pagerduty handler to write message to kafka cluster.")
 	fs.StringVar(&opts.KafkaAddress, "kafka-address", opts.KafkaAddress, "The addresses used to connect to the kafka cluster.")
 	fs.BoolVar(&opts.SinkEventToWebhookReceiver, "sink-event-to-webhook-receiver", opts.SinkEventToWebhookReceiver, "Enables the pagerduty handler to write message to a webhook receiver.")
 	fs.StringVar(&opts.WebhookAddress, "webhook-address", opts.WebhookAddress, "The addresses used to connect to the webhook receiver.")
+	fs.StringVar(&opts.ChatOpsProvider, "chatops-provider", opts.ChatOpsProvider, "The type of the chatops webhook. Options are slack, dingtalk and wecom.")
+	fs.StringVar(&opts.ChatOpsWebhookURL, "chatops-webhook-url", opts.ChatOpsWebhookURL, "The url of the chatops webhook to post diagnosis summaries to.")
+	fs.StringVar(&opts.EmailSMTPServer, "email-smtp-server", opts.EmailSMTPServer, "The address of the smtp server in host:port format.")
+	fs.StringVar(&opts.EmailUsername, "email-username", opts.EmailUsername, "The username for smtp authentication.")
+	fs.StringVar(&opts.EmailPassword, "email-password", opts.EmailPassword, "The password for smtp authentication.")
+	fs.StringVar(&opts.EmailFrom, "email-from", opts.EmailFrom, "The sender address of diagnosis reports.")
+	fs.StringSliceVar(&opts.EmailRecipients, "email-recipients", opts.EmailRecipients, "The recipient addresses of diagnosis reports.")
+	fs.StringSliceVar(&opts.EmailNamespaces, "email-namespaces", opts.EmailNamespaces, "The namespaces of diagnoses to report by email. All namespaces are reported if empty.")
+	fs.StringVar(&opts.NotificationWebhookURL, "notification-webhook-url", opts.NotificationWebhookURL, "The url of a generic http receiver to post diagnosis summaries to.")
+	fs.StringVar(&opts.NotificationWebhookTemplateFile, "notification-webhook-template-file", opts.NotificationWebhookTemplateFile, "The path of a template file for rendering the webhook payload.")
+	fs.StringVar(&opts.S3Endpoint, "s3-endpoint", opts.S3Endpoint, "The url of an s3 compatible endpoint to upload artifacts to.")
+	fs.StringVar(&opts.S3Bucket, "s3-bucket", opts.S3Bucket, "The name of the bucket to upload artifacts to.")
+	fs.StringVar(&opts.S3Region, "s3-region", opts.S3Region, "The region of the bucket.")
+	fs.StringVar(&opts.S3AccessKey, "s3-access-key", opts.S3AccessKey, "The access key id for s3 authentication.")
+	fs.StringVar(&opts.S3SecretKey, "s3-secret-key", opts.S3SecretKey, "The secret access key for s3 authentication.")
+	fs.StringVar(&opts.S3KeyPrefix, "s3-key-prefix", opts.S3KeyPrefix, "The prefix of all uploaded object keys.")
+	fs.StringSliceVar(&opts.ElasticsearchAddresses, "elasticsearch-addresses", opts.ElasticsearchAddresses, "The addresses of the elasticsearch cluster to index completed diagnosis documents into.")
+	fs.StringVar(&opts.ElasticsearchUsername, "elasticsearch-username", opts.ElasticsearchUsername, "The username for elasticsearch authentication.")
+	fs.StringVar(&opts.ElasticsearchPassword, "elasticsearch-password", opts.ElasticsearchPassword, "The password for elasticsearch authentication.")
+	fs.StringVar(&opts.ElasticsearchIndex, "elasticsearch-index", opts.ElasticsearchIndex, "The name of the index to write diagnosis documents to.")
+	fs.StringSliceVar(&opts.KafkaResultBrokers, "kafka-result-brokers", opts.KafkaResultBrokers, "The addresses of the kafka brokers to publish completed diagnosis summaries to.")
+	fs.StringVar(&opts.KafkaResultTopic, "kafka-result-topic", opts.KafkaResultTopic, "The name of the kafka topic to publish diagnosis summaries to.")
+	fs.StringVar(&opts.KafkaResultFormat, "kafka-result-format", opts.KafkaResultFormat, "The serialization format of published diagnosis summaries. Options are json and cloudevents.")
+	fs.StringVar(&opts.GrafanaAPIURL, "grafana-api-url", opts.GrafanaAPIURL, "The base url of the grafana server to create diagnosis annotations on.")
+	fs.StringVar(&opts.GrafanaAPIKey, "grafana-api-key", opts.GrafanaAPIKey, "The api key for grafana authentication.")
+	fs.StringVar(&opts.ITSMProvider, "itsm-provider", opts.ITSMProvider, "The type of the itsm system to open tickets for failed diagnoses in. Options are jira and servicenow.")
+	fs.StringVar(&opts.ITSMAPIURL, "itsm-api-url", opts.ITSMAPIURL, "The base url of the itsm system to open tickets for failed diagnoses in.")
+	fs.StringVar(&opts.ITSMUsername, "itsm-username", opts.ITSMUsername, "The username for itsm authentication.")
+	fs.StringVar(&opts.ITSMPassword, "itsm-password", opts.ITSMPassword, "The password or api token for itsm authentication.")
+	fs.StringVar(&opts.ITSMProject, "itsm-project", opts.ITSMProject, "The project key of opened jira issues.")
+	fs.StringSliceVar(&opts.ITSMNamespaces, "itsm-namespaces", opts.ITSMNamespaces, "The namespaces of diagnoses to open tickets for. Failed diagnoses in all namespaces result in tickets if empty.")
+	fs.StringVar(&opts.PagerDutyEventsAPIURL, "pagerduty-events-api-url", opts.PagerDutyEventsAPIURL, "The url of the pagerduty events api to send resolve events to.")
+	fs.StringVar(&opts.PagerDutyRoutingKey, "pagerduty-routing-key", opts.PagerDutyRoutingKey, "The integration key of the pagerduty service to resolve incidents in.")
+	fs.StringVar(&opts.AlertmanagerSilenceURL, "alertmanager-silence-url", opts.AlertmanagerSilenceURL, "The base url of the alertmanager to create silences on while diagnoses are running.")
+	fs.DurationVar(&opts.AlertmanagerSilenceDuration, "alertmanager-silence-duration", opts.AlertmanagerSilenceDuration, "The duration of created silences. It should cover the expected runtime of a recovery and its verification.")
+	fs.IntVar(&opts.AgentPort, "agent-port", opts.AgentPort, "The port kubediag agents serve on. It is used by the master to proxy artifact requests to agents.")
+	fs.StringVar(&opts.LokiPushURL, "loki-push-url", opts.LokiPushURL, "The url of the loki push endpoint to ship operation results to.")
+	fs.StringVar(&opts.LokiTenant, "loki-tenant", opts.LokiTenant, "The tenant id sent with loki push requests.")
+	fs.IntVar(&opts.ShardIndex, "shard-index", opts.ShardIndex, "The index of the shard this master replica processes. Diagnoses are partitioned across replicas by hashing their namespaced names.")
+	fs.IntVar(&opts.TotalShards, "total-shards", opts.TotalShards, "The total number of master shards. Sharding is disabled if less than two.")
+	fs.StringSliceVar(&opts.AgentWatchNamespaces, "agent-watch-namespaces", opts.AgentWatchNamespaces, "The namespaces the agent cache is restricted to. Pods are always restricted to the local node. All namespaces are watched if empty.")
+	fs.StringToStringVar(&opts.ClusterHealthSchedules, "cluster-health-schedules", opts.ClusterHealthSchedules, "Cron expressions per cluster health check, such as workload=@every 10m,node=*/5 * * * *. Cluster health evaluation is disabled if empty.")
+	fs.StringVar(&opts.ClusterHealthTimezone, "cluster-health-timezone", opts.ClusterHealthTimezone, "The timezone cluster health schedules are evaluated in.")
+	fs.DurationVar(&opts.DiagnosisReportWindow, "diagnosis-report-window", opts.DiagnosisReportWindow, "The length of the rolling window diagnosis report statistics cover. The diagnosis report is disabled if zero.")
+	fs.DurationVar(&opts.CorrelationWindow, "correlation-window", opts.CorrelationWindow, "The length of the time window within which related diagnoses are correlated into one incident. Correlation is disabled if zero.")
+	fs.DurationVar(&opts.DeduplicationWindow, "deduplication-window", opts.DeduplicationWindow, "The length of the time window within which identical pending diagnoses are merged into the earliest of them. Deduplication is disabled if zero.")
+	fs.StringVar(&opts.ProfilerProxySecretFile, "profiler-proxy-secret-file", opts.ProfilerProxySecretFile, "The path of the shared secret file used to authorize creation of proxied profiler sessions. The profiler proxy is disabled if empty.")
+	fs.DurationVar(&opts.ProfilerProxySessionTTL, "profiler-proxy-session-ttl", opts.ProfilerProxySessionTTL, "The duration after which a proxied profiler session expires.")
 }
 
 // SetupSignalHandler registers for SIGTERM and SIGINT. A stop channel is returned
@@ -586,6 +1626,40 @@ func SetupSignalHandler() chan struct{} {
 	return stopCh
 }
 
+// listenAndServe starts an http server on the address with the handler. The server requires and
+// verifies client certificates if mutual tls is enabled. The server stops accepting new
+// connections and releases its port once the stop channel is closed.
+func listenAndServe(address string, handler http.Handler, mtlsConfig tlsutil.Config, stopCh <-chan struct{}) error {
+	server := &http.Server{
+		Addr:    address,
+		Handler: handler,
+	}
+	go func() {
+		<-stopCh
+		// Drain in-flight requests for at most ten seconds before releasing the port.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	var err error
+	if mtlsConfig.Enabled() {
+		tlsConfig, tlsErr := mtlsConfig.ServerTLSConfig()
+		if tlsErr != nil {
+			return fmt.Errorf("unable to create server tls config: %v", tlsErr)
+		}
+		server.TLSConfig = tlsConfig
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
 // HealthCheckHandler handles health check requests.
 func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -46,6 +46,12 @@ var (
 	PrometheusAlertGeneratedDiagnosisPrefix = "prometheus-alert"
 	// PrometheusAlertAnnotation is the annotation used to store the prometheus alert that triggers a diagnosis.
 	PrometheusAlertAnnotation = util.KubeDiagPrefix + PrometheusAlertGeneratedDiagnosisPrefix
+	// PrometheusAlertFingerprintAnnotation is the annotation used to store the fingerprint of the
+	// prometheus alert that triggers a diagnosis.
+	PrometheusAlertFingerprintAnnotation = util.KubeDiagPrefix + PrometheusAlertGeneratedDiagnosisPrefix + "-fingerprint"
+	// PrometheusAlertNameAnnotation is the annotation used to store the name of the prometheus
+	// alert that triggers a diagnosis.
+	PrometheusAlertNameAnnotation = util.KubeDiagPrefix + PrometheusAlertGeneratedDiagnosisPrefix + "-name"
 
 	invalidDNS1123Characters = regexp.MustCompile("[^-a-z0-9]+")
 )
@@ -95,6 +101,9 @@ type alertmanager struct {
 	firingAlertSet map[uint64]time.Time
 	// alertmanagerEnabled indicates whether alertmanager is enabled.
 	alertmanagerEnabled bool
+	// elected is closed once this replica has been elected leader. Alerts are only processed
+	// by the leader, so an HA deployment does not generate duplicate diagnoses.
+	elected <-chan struct{}
 }
 
 // NewAlertmanager creates a new Alertmanager.
@@ -105,6 +114,7 @@ func NewAlertmanager(
 	cache cache.Cache,
 	repeatInterval time.Duration,
 	alertmanagerEnabled bool,
+	elected <-chan struct{},
 ) Alertmanager {
 	metrics.Registry.MustRegister(
 		prometheusAlertReceivedCount,
@@ -122,6 +132,7 @@ func NewAlertmanager(
 		repeatInterval:      repeatInterval,
 		firingAlertSet:      firingAlertSet,
 		alertmanagerEnabled: alertmanagerEnabled,
+		elected:             elected,
 	}
 }
 
@@ -132,6 +143,15 @@ func (am *alertmanager) Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only the elected leader generates diagnoses from alerts, so an HA deployment does not
+	// create duplicates.
+	select {
+	case <-am.elected:
+	default:
+		http.Error(w, "kubediag master is not the leader", http.StatusServiceUnavailable)
+		return
+	}
+
 	switch r.Method {
 	case "POST":
 		prometheusAlertReceivedCount.Inc()
@@ -237,6 +257,8 @@ func (am *alertmanager) createDiagnosisFromPrometheusAlert(triggers []diagnosisv
 				namespace := util.DefautlNamespace
 				annotations := make(map[string]string)
 				annotations[PrometheusAlertAnnotation] = string(alert.String())
+				annotations[PrometheusAlertFingerprintAnnotation] = alert.Fingerprint().String()
+				annotations[PrometheusAlertNameAnnotation] = alert.Name()
 				diagnosis := diagnosisv1.Diagnosis{
 					ObjectMeta: metav1.ObjectMeta{
 						Name:        name,
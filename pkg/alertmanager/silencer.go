@@ -0,0 +1,141 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alertmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+)
+
+const (
+	// silenceCreatedBy is the author recorded on silences created by kubediag.
+	silenceCreatedBy = "kubediag"
+	// silenceTimeout is the timeout of a silence creation request.
+	silenceTimeout = 10 * time.Second
+)
+
+// silence is the payload of the alertmanager v2 silence API.
+type silence struct {
+	// Matchers contains label matchers the silence applies to.
+	Matchers []silenceMatcher `json:"matchers"`
+	// StartsAt is the start time of the silence.
+	StartsAt time.Time `json:"startsAt"`
+	// EndsAt is the end time of the silence.
+	EndsAt time.Time `json:"endsAt"`
+	// CreatedBy is the author of the silence.
+	CreatedBy string `json:"createdBy"`
+	// Comment is the description of the silence.
+	Comment string `json:"comment"`
+}
+
+// silenceMatcher is a label matcher of a silence.
+type silenceMatcher struct {
+	// Name is the label name to match.
+	Name string `json:"name"`
+	// Value is the label value to match.
+	Value string `json:"value"`
+	// IsRegex indicates whether the value is a regular expression.
+	IsRegex bool `json:"isRegex"`
+}
+
+// Silencer creates scoped alertmanager silences for alerts which triggered a running diagnosis,
+// so alertmanager stops paging while kubediag is already acting. Silences expire automatically
+// after the configured duration.
+type Silencer struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// apiURL is the base url of the alertmanager to create silences on.
+	apiURL string
+	// duration is the duration of created silences. It should cover the expected runtime of a
+	// recovery and its verification.
+	duration time.Duration
+	// client is the http client for creating silences.
+	client *http.Client
+}
+
+// NewSilencer creates a new Silencer.
+func NewSilencer(
+	ctx context.Context,
+	logger logr.Logger,
+	apiURL string,
+	duration time.Duration,
+) (*Silencer, error) {
+	if apiURL == "" {
+		return nil, fmt.Errorf("alertmanager url is not specified")
+	}
+
+	return &Silencer{
+		Context:  ctx,
+		Logger:   logger,
+		apiURL:   strings.TrimSuffix(apiURL, "/"),
+		duration: duration,
+		client: &http.Client{
+			Timeout: silenceTimeout,
+		},
+	}, nil
+}
+
+// SilenceAlert creates a silence scoped to the alert which triggered the diagnosis. It is a no
+// op if the diagnosis was not generated from a prometheus alert.
+func (sl *Silencer) SilenceAlert(diagnosis *diagnosisv1.Diagnosis) error {
+	alertName := diagnosis.Annotations[PrometheusAlertNameAnnotation]
+	if alertName == "" {
+		return nil
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(silence{
+		Matchers: []silenceMatcher{
+			{
+				Name:  "alertname",
+				Value: alertName,
+			},
+		},
+		StartsAt:  now,
+		EndsAt:    now.Add(sl.duration),
+		CreatedBy: silenceCreatedBy,
+		Comment:   fmt.Sprintf("silenced while diagnosis %s/%s is running", diagnosis.Namespace, diagnosis.Name),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal silence: %v", err)
+	}
+
+	res, err := sl.client.Post(sl.apiURL+"/api/v2/silences", "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("alertmanager responded with erroneous status: %s", res.Status)
+	}
+
+	sl.Info("alertmanager silence created", "diagnosis", diagnosis.Namespace+"/"+diagnosis.Name, "alertname", alertName, "duration", sl.duration)
+	return nil
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/kubediag/kubediag/pkg/encryption"
+)
+
+const (
+	// DefaultExpirationSeconds is the default number of seconds after which a registered artifact
+	// is removed from the index.
+	DefaultExpirationSeconds = 86400
+)
+
+// Artifact contains metadata of a file under data root registered by a processor.
+type Artifact struct {
+	// Path is the artifact file path. It must be under data root of kubediag agent.
+	Path string `json:"path"`
+	// DiagnosisNamespace is the namespace of the diagnosis which produced the artifact.
+	DiagnosisNamespace string `json:"diagnosisNamespace"`
+	// DiagnosisName is the name of the diagnosis which produced the artifact.
+	DiagnosisName string `json:"diagnosisName"`
+	// Operation is the name of the operation which produced the artifact.
+	// +optional
+	Operation string `json:"operation,omitempty"`
+	// Description is a human readable description of the artifact.
+	// +optional
+	Description string `json:"description,omitempty"`
+	// ExpirationSeconds is the number of seconds after which the artifact is removed from the
+	// index. Defaults to DefaultExpirationSeconds if not specified.
+	// +optional
+	ExpirationSeconds int64 `json:"expirationSeconds,omitempty"`
+	// CreationTime is the time at which the artifact was registered.
+	CreationTime time.Time `json:"creationTime"`
+	// Size is the size of the artifact file in bytes.
+	Size int64 `json:"size"`
+}
+
+// ArtifactManager manages artifacts registered by processors and serves a browsable index per
+// diagnosis. Registered artifacts expire from the index after their expiration seconds.
+type ArtifactManager struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// dataRoot is root directory of persistent kubediag data.
+	dataRoot string
+	// encryptor encrypts registered artifact files at rest and decrypts them on download.
+	// Encryption at rest is disabled if nil.
+	encryptor *encryption.Encryptor
+	// lock guards writes to artifacts.
+	lock sync.Mutex
+	// artifacts contains registered artifacts keyed by namespaced name of the diagnosis.
+	artifacts map[string][]Artifact
+}
+
+// NewArtifactManager creates a new ArtifactManager.
+func NewArtifactManager(
+	ctx context.Context,
+	logger logr.Logger,
+	dataRoot string,
+	encryptor *encryption.Encryptor,
+) *ArtifactManager {
+	return &ArtifactManager{
+		Context:   ctx,
+		Logger:    logger,
+		dataRoot:  dataRoot,
+		encryptor: encryptor,
+		artifacts: make(map[string][]Artifact),
+	}
+}
+
+// Handler handles http requests for registering and listing artifacts. A POST request registers
+// an artifact with metadata. A GET request lists artifacts of the diagnosis specified by the
+// diagnosisNamespace and diagnosisName query parameters, or all artifacts if unspecified.
+func (am *ArtifactManager) Handler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		var artifact Artifact
+		if err := json.Unmarshal(body, &artifact); err != nil {
+			http.Error(w, fmt.Sprintf("failed to unmarshal request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if err := am.register(artifact); err != nil {
+			http.Error(w, fmt.Sprintf("failed to register artifact: %v", err), http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	case "GET":
+		artifacts := am.list(r.URL.Query().Get("diagnosisNamespace"), r.URL.Query().Get("diagnosisName"))
+		data, err := json.Marshal(artifacts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to marshal artifacts: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// DownloadHandler handles http requests for downloading a registered artifact file. A GET
+// request streams the content of the artifact specified by the path query parameter. Only files
+// registered in the index can be downloaded.
+func (am *ArtifactManager) DownloadHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			http.Error(w, "artifact path is not specified", http.StatusBadRequest)
+			return
+		}
+		if !am.registered(path) {
+			http.Error(w, fmt.Sprintf("artifact %s is not registered", path), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+
+		// Decrypt the artifact transparently if encryption at rest is enabled.
+		if am.encryptor != nil {
+			if err := am.encryptor.DecryptTo(w, path); err != nil {
+				am.Error(err, "failed to stream artifact file", "path", path)
+			}
+			return
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to open artifact file: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(w, file); err != nil {
+			am.Error(err, "failed to stream artifact file", "path", path)
+		}
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// registered indicates whether the path refers to an unexpired artifact in the index.
+func (am *ArtifactManager) registered(path string) bool {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	now := time.Now()
+	for _, artifacts := range am.artifacts {
+		for _, artifact := range artifacts {
+			if artifact.Path == path && now.Sub(artifact.CreationTime) <= time.Duration(artifact.ExpirationSeconds)*time.Second {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// register validates and records an artifact in the index.
+func (am *ArtifactManager) register(artifact Artifact) error {
+	if artifact.DiagnosisNamespace == "" || artifact.DiagnosisName == "" {
+		return fmt.Errorf("diagnosis namespace and name must be specified")
+	}
+
+	// Only files under data root can be registered as artifacts.
+	absolutePath, err := filepath.Abs(artifact.Path)
+	if err != nil {
+		return fmt.Errorf("invalid artifact path %s: %v", artifact.Path, err)
+	}
+	if !strings.HasPrefix(absolutePath, filepath.Clean(am.dataRoot)+string(os.PathSeparator)) {
+		return fmt.Errorf("artifact path %s is not under data root %s", artifact.Path, am.dataRoot)
+	}
+	// Encrypt the artifact file at rest before it enters the index.
+	if am.encryptor != nil {
+		if err := am.encryptor.EncryptFile(absolutePath); err != nil {
+			return fmt.Errorf("unable to encrypt artifact file %s: %v", absolutePath, err)
+		}
+	}
+	fileInfo, err := os.Stat(absolutePath)
+	if err != nil {
+		return fmt.Errorf("unable to stat artifact file %s: %v", absolutePath, err)
+	}
+
+	artifact.Path = absolutePath
+	artifact.Size = fileInfo.Size()
+	artifact.CreationTime = time.Now()
+	if artifact.ExpirationSeconds <= 0 {
+		artifact.ExpirationSeconds = DefaultExpirationSeconds
+	}
+
+	key := artifact.DiagnosisNamespace + "/" + artifact.DiagnosisName
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	am.artifacts[key] = append(am.artifacts[key], artifact)
+
+	am.Info("artifact registered", "path", artifact.Path, "diagnosis", key)
+	return nil
+}
+
+// list returns unexpired artifacts of the specified diagnosis, or of all diagnoses if namespace
+// and name are empty. Expired artifacts are dropped from the index.
+func (am *ArtifactManager) list(diagnosisNamespace string, diagnosisName string) []Artifact {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	now := time.Now()
+	result := make([]Artifact, 0)
+	for key, artifacts := range am.artifacts {
+		unexpired := make([]Artifact, 0, len(artifacts))
+		for _, artifact := range artifacts {
+			if now.Sub(artifact.CreationTime) > time.Duration(artifact.ExpirationSeconds)*time.Second {
+				continue
+			}
+			unexpired = append(unexpired, artifact)
+		}
+		if len(unexpired) == 0 {
+			delete(am.artifacts, key)
+			continue
+		}
+		am.artifacts[key] = unexpired
+
+		if diagnosisNamespace != "" && diagnosisName != "" && key != diagnosisNamespace+"/"+diagnosisName {
+			continue
+		}
+		result = append(result, unexpired...)
+	}
+
+	return result
+}
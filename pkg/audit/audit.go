@@ -0,0 +1,304 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit records executed operations to a tamper evident append only log. Every entry
+// carries the hash of the previous entry, so any modification or removal of a recorded entry
+// breaks the hash chain and is detected by verification. Entries can optionally be shipped to an
+// external audit sink over http.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// auditSubDirectory is the subdirectory of data root to store the audit log.
+	auditSubDirectory = "audit"
+	// auditLogFileName is the file name of the audit log.
+	auditLogFileName = "audit.log"
+	// sinkTimeout is the timeout of a request to the audit sink.
+	sinkTimeout = 30 * time.Second
+)
+
+var (
+	auditEntryCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "audit_entry_count",
+			Help: "Counter of recorded audit entries",
+		},
+	)
+	auditEntryErrorCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "audit_entry_error_count",
+			Help: "Counter of audit entries which could not be recorded",
+		},
+	)
+	auditSinkErrorCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "audit_sink_error_count",
+			Help: "Counter of audit entries which could not be shipped to the audit sink",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		auditEntryCount,
+		auditEntryErrorCount,
+		auditSinkErrorCount,
+	)
+}
+
+// Entry describes one executed operation in the audit log.
+type Entry struct {
+	// Time is the time the operation finished.
+	Time time.Time `json:"time"`
+	// NodeName is the name of the node the operation was executed on.
+	NodeName string `json:"nodeName"`
+	// DiagnosisNamespace is the namespace of the diagnosis which requested the operation.
+	DiagnosisNamespace string `json:"diagnosisNamespace,omitempty"`
+	// DiagnosisName is the name of the diagnosis which requested the operation.
+	DiagnosisName string `json:"diagnosisName,omitempty"`
+	// TaskNamespace is the namespace of the task the operation was executed for.
+	TaskNamespace string `json:"taskNamespace"`
+	// TaskName is the name of the task the operation was executed for.
+	TaskName string `json:"taskName"`
+	// Operation is the name of the executed operation.
+	Operation string `json:"operation"`
+	// PodNamespace is the namespace of the target pod if the task targets a pod.
+	PodNamespace string `json:"podNamespace,omitempty"`
+	// PodName is the name of the target pod if the task targets a pod.
+	PodName string `json:"podName,omitempty"`
+	// Parameters contains the parameters passed to the operation.
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Outcome is the outcome of the execution. It is one of Succeeded, Failed and Error.
+	Outcome string `json:"outcome"`
+	// Reason is a human readable message describing a failed or erroneous execution.
+	Reason string `json:"reason,omitempty"`
+	// PreviousHash is the hash of the previous entry in the audit log.
+	PreviousHash string `json:"previousHash"`
+	// Hash is the hash of this entry chained with the previous entry.
+	Hash string `json:"hash"`
+}
+
+// Auditor records executed operations to the audit log and ships them to the audit sink.
+type Auditor struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// file is the append only audit log file.
+	file *os.File
+	// lastHash is the hash of the last recorded entry.
+	lastHash string
+	// sinkURL is the url of an external audit sink. Shipping is disabled if empty.
+	sinkURL string
+	// client is the http client for shipping entries to the audit sink.
+	client *http.Client
+	// lock guards writes to the audit log.
+	lock sync.Mutex
+}
+
+// NewAuditor creates a new Auditor. The audit log is stored in the audit subdirectory of data
+// root and the hash chain is recovered from the existing log on startup.
+func NewAuditor(
+	ctx context.Context,
+	logger logr.Logger,
+	dataRoot string,
+	sinkURL string,
+) (*Auditor, error) {
+	path := filepath.Join(dataRoot, auditSubDirectory)
+	if err := os.MkdirAll(path, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("unable to create audit directory %s: %v", path, err)
+	}
+
+	filename := filepath.Join(path, auditLogFileName)
+	lastHash, err := lastHashInLog(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to recover audit log hash chain: %v", err)
+	}
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit log %s: %v", filename, err)
+	}
+
+	return &Auditor{
+		Context:  ctx,
+		Logger:   logger,
+		file:     file,
+		lastHash: lastHash,
+		sinkURL:  sinkURL,
+		client: &http.Client{
+			Timeout: sinkTimeout,
+		},
+	}, nil
+}
+
+// Record appends the entry to the audit log and ships it to the audit sink if one is configured.
+func (auditor *Auditor) Record(entry Entry) error {
+	auditor.lock.Lock()
+	defer auditor.lock.Unlock()
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.PreviousHash = auditor.lastHash
+	hash, err := entryHash(entry)
+	if err != nil {
+		auditEntryErrorCount.Inc()
+		return fmt.Errorf("unable to hash audit entry: %v", err)
+	}
+	entry.Hash = hash
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		auditEntryErrorCount.Inc()
+		return fmt.Errorf("unable to marshal audit entry: %v", err)
+	}
+	if _, err := auditor.file.Write(append(data, '\n')); err != nil {
+		auditEntryErrorCount.Inc()
+		return fmt.Errorf("unable to write audit entry: %v", err)
+	}
+	auditor.lastHash = entry.Hash
+	auditEntryCount.Inc()
+
+	if auditor.sinkURL != "" {
+		go auditor.ship(data)
+	}
+
+	return nil
+}
+
+// VerifyLog verifies the hash chain of the audit log in data root. It returns an error if an
+// entry has been modified or removed.
+func VerifyLog(dataRoot string) error {
+	filename := filepath.Join(dataRoot, auditSubDirectory, auditLogFileName)
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	lastHash := ""
+	line := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("audit entry at line %d is not valid: %v", line, err)
+		}
+		if entry.PreviousHash != lastHash {
+			return fmt.Errorf("audit entry at line %d does not chain to the previous entry", line)
+		}
+		expected := entry.Hash
+		hash, err := entryHash(entry)
+		if err != nil {
+			return fmt.Errorf("unable to hash audit entry at line %d: %v", line, err)
+		}
+		if hash != expected {
+			return fmt.Errorf("audit entry at line %d has been modified", line)
+		}
+		lastHash = expected
+	}
+
+	return scanner.Err()
+}
+
+// ship sends the marshaled entry to the audit sink.
+func (auditor *Auditor) ship(data []byte) {
+	res, err := auditor.client.Post(auditor.sinkURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		auditSinkErrorCount.Inc()
+		auditor.Error(err, "unable to ship audit entry to audit sink")
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusAccepted {
+		auditSinkErrorCount.Inc()
+		auditor.Error(fmt.Errorf("audit sink responded with status %d", res.StatusCode), "unable to ship audit entry to audit sink")
+	}
+}
+
+// entryHash computes the hash of the entry chained with the previous entry. The hash field itself
+// is excluded from the hash.
+func entryHash(entry Entry) (string, error) {
+	entry.Hash = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lastHashInLog returns the hash of the last entry in the audit log, or an empty string if the
+// log does not exist or is empty.
+func lastHashInLog(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer file.Close()
+
+	lastHash := ""
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return "", fmt.Errorf("audit log contains an invalid entry: %v", err)
+		}
+		lastHash = entry.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return lastHash, nil
+}
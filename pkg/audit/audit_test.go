@@ -0,0 +1,174 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// newTestAuditor creates an auditor logging to a temporary data root. The data root is removed
+// when the test finishes.
+func newTestAuditor(t *testing.T) (*Auditor, string) {
+	dataRoot, err := ioutil.TempDir("", "audit-test")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dataRoot)
+	})
+
+	auditor, err := NewAuditor(context.Background(), ctrl.Log.WithName("audit"), dataRoot, "")
+	if err != nil {
+		t.Fatalf("unable to create auditor: %v", err)
+	}
+	return auditor, dataRoot
+}
+
+// logEntries reads all entries of the audit log in data root.
+func logEntries(t *testing.T, dataRoot string) []Entry {
+	data, err := ioutil.ReadFile(filepath.Join(dataRoot, auditSubDirectory, auditLogFileName))
+	if err != nil {
+		t.Fatalf("unable to read audit log: %v", err)
+	}
+
+	var entries []Entry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry Entry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("unable to unmarshal audit entry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// writeLogLines replaces the audit log in data root with the provided lines.
+func writeLogLines(t *testing.T, dataRoot string, lines []string) {
+	filename := filepath.Join(dataRoot, auditSubDirectory, auditLogFileName)
+	if err := ioutil.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("unable to write audit log: %v", err)
+	}
+}
+
+// logLines reads the raw lines of the audit log in data root.
+func logLines(t *testing.T, dataRoot string) []string {
+	data, err := ioutil.ReadFile(filepath.Join(dataRoot, auditSubDirectory, auditLogFileName))
+	if err != nil {
+		t.Fatalf("unable to read audit log: %v", err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestHashChain(t *testing.T) {
+	auditor, dataRoot := newTestAuditor(t)
+
+	for _, operation := range []string{"first", "second", "third"} {
+		err := auditor.Record(Entry{
+			NodeName:      "node-1",
+			TaskNamespace: "default",
+			TaskName:      "task",
+			Operation:     operation,
+			Outcome:       "Succeeded",
+		})
+		assert.NoError(t, err)
+	}
+
+	entries := logEntries(t, dataRoot)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "", entries[0].PreviousHash, "the first entry chains to the empty hash")
+	assert.Equal(t, entries[0].Hash, entries[1].PreviousHash, "every entry chains to its predecessor")
+	assert.Equal(t, entries[1].Hash, entries[2].PreviousHash, "every entry chains to its predecessor")
+	assert.NoError(t, VerifyLog(dataRoot), "an untouched log verifies")
+}
+
+func TestHashChainRecovery(t *testing.T) {
+	auditor, dataRoot := newTestAuditor(t)
+	err := auditor.Record(Entry{TaskNamespace: "default", TaskName: "task", Operation: "before-restart", Outcome: "Succeeded"})
+	assert.NoError(t, err)
+
+	// A new auditor over the same data root must chain its first entry to the last recorded
+	// entry instead of restarting the chain.
+	restarted, err := NewAuditor(context.Background(), ctrl.Log.WithName("audit"), dataRoot, "")
+	assert.NoError(t, err)
+	err = restarted.Record(Entry{TaskNamespace: "default", TaskName: "task", Operation: "after-restart", Outcome: "Succeeded"})
+	assert.NoError(t, err)
+
+	entries := logEntries(t, dataRoot)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, entries[0].Hash, entries[1].PreviousHash, "the recovered chain continues from the last entry")
+	assert.NoError(t, VerifyLog(dataRoot))
+}
+
+func TestVerifyLogDetectsTampering(t *testing.T) {
+	tests := []struct {
+		tamper func(lines []string) []string
+		desc   string
+	}{
+		{
+			tamper: func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], `"outcome":"Succeeded"`, `"outcome":"Failed"`, 1)
+				return lines
+			},
+			desc: "a modified entry is detected",
+		},
+		{
+			tamper: func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+			desc: "a removed entry is detected",
+		},
+		{
+			tamper: func(lines []string) []string {
+				lines[0], lines[1] = lines[1], lines[0]
+				return lines
+			},
+			desc: "reordered entries are detected",
+		},
+	}
+
+	for _, test := range tests {
+		auditor, dataRoot := newTestAuditor(t)
+		for _, operation := range []string{"first", "second", "third"} {
+			err := auditor.Record(Entry{TaskNamespace: "default", TaskName: "task", Operation: operation, Outcome: "Succeeded"})
+			assert.NoError(t, err, test.desc)
+		}
+
+		writeLogLines(t, dataRoot, test.tamper(logLines(t, dataRoot)))
+		assert.Error(t, VerifyLog(dataRoot), test.desc)
+	}
+}
+
+func TestVerifyLogWithoutLog(t *testing.T) {
+	dataRoot, err := ioutil.TempDir("", "audit-test")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dataRoot)
+	})
+
+	assert.NoError(t, VerifyLog(dataRoot), "a missing log verifies")
+}
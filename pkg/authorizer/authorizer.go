@@ -0,0 +1,215 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package authorizer protects the agent processor endpoints. Processor endpoints can trigger
+// intrusive operations such as packet captures and node cordons, so requests must be authorized
+// before they reach a processor when an authorization mode is configured.
+package authorizer
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// AuthorizationModeNone disables authorization on processor endpoints.
+	AuthorizationModeNone = "None"
+	// AuthorizationModeBearerToken authenticates requests with the token review api and authorizes
+	// them with the subject access review api.
+	AuthorizationModeBearerToken = "BearerToken"
+	// AuthorizationModeSharedSecret authorizes requests by comparing the bearer token against a
+	// shared secret read from a file.
+	AuthorizationModeSharedSecret = "SharedSecret"
+
+	// processorPathPrefix is the path prefix of processor endpoints on the agent.
+	processorPathPrefix = "/processor/"
+)
+
+var (
+	processorRequestAuthorizedCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "processor_request_authorized_count",
+			Help: "Counter of authorized processor requests",
+		},
+	)
+	processorRequestDeniedCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "processor_request_denied_count",
+			Help: "Counter of denied processor requests",
+		},
+	)
+)
+
+// Authorizer authorizes http requests to processor endpoints on the agent.
+type Authorizer struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// mode is the authorization mode of processor endpoints.
+	mode string
+	// clientset knows how to create token reviews and subject access reviews.
+	clientset kubernetes.Interface
+	// sharedSecret is the expected bearer token in shared secret mode.
+	sharedSecret string
+}
+
+// NewAuthorizer creates a new Authorizer.
+func NewAuthorizer(
+	ctx context.Context,
+	logger logr.Logger,
+	clientset kubernetes.Interface,
+	mode string,
+	sharedSecretFile string,
+) (*Authorizer, error) {
+	authorizer := &Authorizer{
+		Context:   ctx,
+		Logger:    logger,
+		mode:      mode,
+		clientset: clientset,
+	}
+
+	switch mode {
+	case AuthorizationModeNone:
+	case AuthorizationModeBearerToken:
+		if clientset == nil {
+			return nil, fmt.Errorf("a clientset is required in %s mode", AuthorizationModeBearerToken)
+		}
+	case AuthorizationModeSharedSecret:
+		if sharedSecretFile == "" {
+			return nil, fmt.Errorf("a shared secret file is required in %s mode", AuthorizationModeSharedSecret)
+		}
+		data, err := ioutil.ReadFile(sharedSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read shared secret file %s: %v", sharedSecretFile, err)
+		}
+		authorizer.sharedSecret = strings.TrimSpace(string(data))
+		if authorizer.sharedSecret == "" {
+			return nil, fmt.Errorf("shared secret file %s is empty", sharedSecretFile)
+		}
+	default:
+		return nil, fmt.Errorf("invalid authorization mode %s, must be one of %s, %s and %s", mode, AuthorizationModeNone, AuthorizationModeBearerToken, AuthorizationModeSharedSecret)
+	}
+
+	metrics.Registry.MustRegister(
+		processorRequestAuthorizedCount,
+		processorRequestDeniedCount,
+	)
+
+	return authorizer, nil
+}
+
+// Middleware authorizes requests to processor endpoints before passing them to the next handler.
+// Requests to other endpoints are passed through unchanged.
+func (auth *Authorizer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.mode == AuthorizationModeNone || !strings.HasPrefix(r.URL.Path, processorPathPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, err := bearerToken(r)
+		if err != nil {
+			processorRequestDeniedCount.Inc()
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		switch auth.mode {
+		case AuthorizationModeBearerToken:
+			if err := auth.reviewToken(token, r); err != nil {
+				processorRequestDeniedCount.Inc()
+				auth.Error(err, "unable to authorize processor request", "path", r.URL.Path)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		case AuthorizationModeSharedSecret:
+			if subtle.ConstantTimeCompare([]byte(token), []byte(auth.sharedSecret)) != 1 {
+				processorRequestDeniedCount.Inc()
+				http.Error(w, "invalid shared secret", http.StatusForbidden)
+				return
+			}
+		}
+
+		processorRequestAuthorizedCount.Inc()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reviewToken authenticates the bearer token with a token review and authorizes the authenticated
+// user against the request path with a subject access review.
+func (auth *Authorizer) reviewToken(token string, r *http.Request) error {
+	tokenReview, err := auth.clientset.AuthenticationV1().TokenReviews().Create(&authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token: token,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create token review: %v", err)
+	}
+	if !tokenReview.Status.Authenticated {
+		return fmt.Errorf("token is not authenticated")
+	}
+
+	groups := make([]string, 0, len(tokenReview.Status.User.Groups))
+	for _, group := range tokenReview.Status.User.Groups {
+		groups = append(groups, group)
+	}
+	subjectAccessReview, err := auth.clientset.AuthorizationV1().SubjectAccessReviews().Create(&authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   tokenReview.Status.User.Username,
+			UID:    tokenReview.Status.User.UID,
+			Groups: groups,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+				Path: r.URL.Path,
+				Verb: strings.ToLower(r.Method),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create subject access review: %v", err)
+	}
+	if !subjectAccessReview.Status.Allowed {
+		return fmt.Errorf("user %s is not allowed to %s %s: %s", tokenReview.Status.User.Username, strings.ToLower(r.Method), r.URL.Path, subjectAccessReview.Status.Reason)
+	}
+
+	return nil
+}
+
+// bearerToken extracts the bearer token from the authorization header of the request.
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", fmt.Errorf("an authorization header is required")
+	}
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", fmt.Errorf("the authorization header must contain a bearer token")
+	}
+
+	return strings.TrimPrefix(header, "Bearer "), nil
+}
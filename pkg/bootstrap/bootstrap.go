@@ -0,0 +1,294 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bootstrap installs and keeps up to date a library of curated operations and operation
+// sets shipped with the release, so common diagnoses such as out of memory, crash loops, disk
+// pressure and dns failures do not have to be written from scratch by every user. Curated
+// objects are labeled as managed by the bootstrap and carry a library version annotation, so
+// they are upgraded in place on new releases while user defined objects are never touched.
+package bootstrap
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const (
+	// LibraryVersion is the version of the curated operation set library shipped with this
+	// release. Bump it when curated objects change, so the bootstrap upgrades them in place.
+	LibraryVersion = "v1"
+	// ManagedByLabel marks objects installed by the bootstrap.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// ManagedByValue is the value of the managed by label on curated objects.
+	ManagedByValue = "kubediag-bootstrap"
+	// LibraryVersionAnnotation carries the library version a curated object was installed from.
+	LibraryVersionAnnotation = util.KubeDiagPrefix + "library-version"
+
+	// resyncInterval is the interval between bootstrap synchronizations.
+	resyncInterval = 10 * time.Minute
+)
+
+var (
+	bootstrapSyncSuccessCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "bootstrap_sync_success_count",
+			Help: "Counter of successful curated library synchronizations.",
+		},
+	)
+	bootstrapSyncErrorCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "bootstrap_sync_error_count",
+			Help: "Counter of erroneous curated library synchronizations.",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		bootstrapSyncSuccessCount,
+		bootstrapSyncErrorCount,
+	)
+}
+
+// Bootstrap installs the curated operation set library and upgrades it on new releases.
+type Bootstrap struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// client knows how to perform CRUD operations on Kubernetes objects.
+	client client.Client
+}
+
+// NewBootstrap creates a new Bootstrap.
+func NewBootstrap(
+	ctx context.Context,
+	logger logr.Logger,
+	cli client.Client,
+) *Bootstrap {
+	return &Bootstrap{
+		Context: ctx,
+		Logger:  logger,
+		client:  cli,
+	}
+}
+
+// Run installs the curated library and periodically reconciles it until the stop channel is
+// closed, so manual edits and deletions of curated objects converge back to the shipped
+// library.
+func (b *Bootstrap) Run(stopCh <-chan struct{}) {
+	b.sync()
+
+	ticker := time.NewTicker(resyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.sync()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// sync installs or upgrades all curated operations and operation sets.
+func (b *Bootstrap) sync() {
+	failed := false
+	for _, operation := range curatedOperations() {
+		if err := b.ensureOperation(operation); err != nil {
+			b.Error(err, "unable to install curated operation", "operation", operation.Name)
+			failed = true
+		}
+	}
+	for _, operationSet := range curatedOperationSets() {
+		if err := b.ensureOperationSet(operationSet); err != nil {
+			b.Error(err, "unable to install curated operation set", "operationset", operationSet.Name)
+			failed = true
+		}
+	}
+
+	if failed {
+		bootstrapSyncErrorCount.Inc()
+		return
+	}
+	bootstrapSyncSuccessCount.Inc()
+}
+
+// ensureOperation creates the curated operation or upgrades it if it was installed from an
+// older library version. Operations not managed by the bootstrap are never touched.
+func (b *Bootstrap) ensureOperation(operation diagnosisv1.Operation) error {
+	var existing diagnosisv1.Operation
+	err := b.client.Get(b, client.ObjectKey{Name: operation.Name}, &existing)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			b.Info("installing curated operation", "operation", operation.Name, "version", LibraryVersion)
+			return b.client.Create(b, &operation)
+		}
+		return err
+	}
+
+	if !managedByBootstrap(existing.ObjectMeta) || existing.Annotations[LibraryVersionAnnotation] == LibraryVersion {
+		return nil
+	}
+
+	b.Info("upgrading curated operation", "operation", operation.Name, "version", LibraryVersion)
+	existing.Labels = operation.Labels
+	existing.Annotations = operation.Annotations
+	existing.Spec = operation.Spec
+	return b.client.Update(b, &existing)
+}
+
+// ensureOperationSet creates the curated operation set or upgrades it if it was installed from
+// an older library version. Operation sets not managed by the bootstrap are never touched.
+func (b *Bootstrap) ensureOperationSet(operationSet diagnosisv1.OperationSet) error {
+	var existing diagnosisv1.OperationSet
+	err := b.client.Get(b, client.ObjectKey{Name: operationSet.Name}, &existing)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			b.Info("installing curated operation set", "operationset", operationSet.Name, "version", LibraryVersion)
+			return b.client.Create(b, &operationSet)
+		}
+		return err
+	}
+
+	if !managedByBootstrap(existing.ObjectMeta) || existing.Annotations[LibraryVersionAnnotation] == LibraryVersion {
+		return nil
+	}
+
+	b.Info("upgrading curated operation set", "operationset", operationSet.Name, "version", LibraryVersion)
+	existing.Labels = operationSet.Labels
+	existing.Annotations = operationSet.Annotations
+	existing.Spec = operationSet.Spec
+	return b.client.Update(b, &existing)
+}
+
+// managedByBootstrap indicates whether the object was installed by the bootstrap.
+func managedByBootstrap(meta metav1.ObjectMeta) bool {
+	return meta.Labels[ManagedByLabel] == ManagedByValue
+}
+
+// curatedMeta returns the object meta of a curated object with the managed by label and the
+// library version annotation.
+func curatedMeta(name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name: name,
+		Labels: map[string]string{
+			ManagedByLabel: ManagedByValue,
+		},
+		Annotations: map[string]string{
+			LibraryVersionAnnotation: LibraryVersion,
+		},
+	}
+}
+
+// httpServerOperation returns a curated operation backed by a processor served by the kubediag
+// agent on the node.
+func httpServerOperation(name string, path string) diagnosisv1.Operation {
+	return diagnosisv1.Operation{
+		ObjectMeta: curatedMeta(name),
+		Spec: diagnosisv1.OperationSpec{
+			Processor: diagnosisv1.Processor{
+				HTTPServer: &diagnosisv1.HTTPServer{
+					Path: &path,
+				},
+			},
+		},
+	}
+}
+
+// curatedOperations returns the curated operations of the library.
+func curatedOperations() []diagnosisv1.Operation {
+	dnsResultKey := "dns.resolution"
+	return []diagnosisv1.Operation{
+		httpServerOperation("kubediag-pod-list-collector", "/processor/podListCollector"),
+		httpServerOperation("kubediag-pod-detail-collector", "/processor/podDetailCollector"),
+		httpServerOperation("kubediag-container-collector", "/processor/containerCollector"),
+		httpServerOperation("kubediag-process-collector", "/processor/processCollector"),
+		httpServerOperation("kubediag-mount-info-collector", "/processor/mountInfoCollector"),
+		httpServerOperation("kubediag-docker-info-collector", "/processor/dockerInfoCollector"),
+		{
+			ObjectMeta: curatedMeta("kubediag-dns-resolution-check"),
+			Spec: diagnosisv1.OperationSpec{
+				Processor: diagnosisv1.Processor{
+					ScriptRunner: &diagnosisv1.ScriptRunner{
+						Script:             "nslookup kubernetes.default.svc.cluster.local && nslookup kubernetes.io",
+						OperationResultKey: &dnsResultKey,
+					},
+				},
+			},
+		},
+	}
+}
+
+// linearOperationSet returns a curated operation set running the operations one after another.
+func linearOperationSet(name string, operations ...string) diagnosisv1.OperationSet {
+	adjacencyList := []diagnosisv1.Node{
+		{
+			To: diagnosisv1.NodeSet{1},
+		},
+	}
+	for index, operation := range operations {
+		node := diagnosisv1.Node{
+			Operation: operation,
+		}
+		if index != len(operations)-1 {
+			node.To = diagnosisv1.NodeSet{index + 2}
+		}
+		adjacencyList = append(adjacencyList, node)
+	}
+
+	return diagnosisv1.OperationSet{
+		ObjectMeta: curatedMeta(name),
+		Spec: diagnosisv1.OperationSetSpec{
+			AdjacencyList: adjacencyList,
+		},
+	}
+}
+
+// curatedOperationSets returns the curated operation sets of the library.
+func curatedOperationSets() []diagnosisv1.OperationSet {
+	return []diagnosisv1.OperationSet{
+		linearOperationSet("kubediag-oom-diagnosis",
+			"kubediag-pod-detail-collector",
+			"kubediag-container-collector",
+			"kubediag-process-collector",
+		),
+		linearOperationSet("kubediag-crashloop-diagnosis",
+			"kubediag-pod-detail-collector",
+			"kubediag-container-collector",
+		),
+		linearOperationSet("kubediag-disk-pressure-diagnosis",
+			"kubediag-mount-info-collector",
+			"kubediag-docker-info-collector",
+			"kubediag-pod-list-collector",
+		),
+		linearOperationSet("kubediag-dns-diagnosis",
+			"kubediag-dns-resolution-check",
+			"kubediag-pod-list-collector",
+		),
+	}
+}
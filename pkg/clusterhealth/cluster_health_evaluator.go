@@ -0,0 +1,523 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package clusterhealth evaluates the health of the kubernetes cluster on cron style schedules.
+// Each check runs on its own schedule in a configurable timezone and the summary of the last
+// evaluation is emitted to a config map, so cluster health is observable without waiting for a
+// fixed loop interval.
+package clusterhealth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/kubediag/kubediag/pkg/types"
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const (
+	// WorkloadCheck evaluates the health of pods, deployments, statefulsets and daemonsets.
+	WorkloadCheck = "workload"
+	// NodeCheck evaluates the health of nodes.
+	NodeCheck = "node"
+
+	// SummaryConfigMapName is the name of the config map holding the cluster health summary of
+	// the last evaluation.
+	SummaryConfigMapName = "kubediag-cluster-health"
+
+	// housekeepingInterval specifies how long to wait between checks of due schedules.
+	clusterHealthHousekeepingInterval = 30 * time.Second
+)
+
+var (
+	clusterHealthEvaluationCycleCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cluster_health_evaluation_cycle_count",
+			Help: "Counter of cluster health evaluation cycles by check.",
+		},
+		[]string{"check"},
+	)
+	clusterHealthEvaluationErrorCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cluster_health_evaluation_error_count",
+			Help: "Counter of erroneous cluster health evaluations.",
+		},
+	)
+	clusterHealthScore = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cluster_health_score",
+			Help: "Weighted cluster health scores by component.",
+		},
+		[]string{"component"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		clusterHealthEvaluationCycleCount,
+		clusterHealthEvaluationErrorCount,
+		clusterHealthScore,
+	)
+}
+
+// ClusterHealthEvaluator evaluates the health of the kubernetes cluster. Every check runs on its
+// own cron style schedule and updates its part of the cluster health summary.
+type ClusterHealthEvaluator struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// client knows how to perform CRUD operations on Kubernetes objects.
+	client client.Client
+	// cache knows how to load Kubernetes objects.
+	cache cache.Cache
+	// schedules contains the parsed cron schedule per check.
+	schedules map[string]cron.Schedule
+	// location is the timezone schedules are evaluated in.
+	location *time.Location
+	// lastRuns contains the time each check last ran.
+	lastRuns map[string]time.Time
+	// health is the cluster health assembled from the last run of every check.
+	health types.ClusterHealth
+}
+
+// NewClusterHealthEvaluator creates a new ClusterHealthEvaluator. The schedules map check names
+// to cron expressions and the timezone names a location such as "UTC" or "Asia/Shanghai".
+func NewClusterHealthEvaluator(
+	ctx context.Context,
+	logger logr.Logger,
+	cli client.Client,
+	cache cache.Cache,
+	schedules map[string]string,
+	timezone string,
+) (*ClusterHealthEvaluator, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster health timezone %s: %v", timezone, err)
+	}
+
+	parsed := make(map[string]cron.Schedule)
+	for check, schedule := range schedules {
+		if check != WorkloadCheck && check != NodeCheck {
+			return nil, fmt.Errorf("unknown cluster health check %s, must be one of %s and %s", check, WorkloadCheck, NodeCheck)
+		}
+		sched, err := cron.ParseStandard(schedule)
+		if err != nil {
+			return nil, fmt.Errorf("unparseable schedule of cluster health check %s: %s: %v", check, schedule, err)
+		}
+		parsed[check] = sched
+	}
+
+	return &ClusterHealthEvaluator{
+		Context:   ctx,
+		Logger:    logger,
+		client:    cli,
+		cache:     cache,
+		schedules: parsed,
+		location:  location,
+		lastRuns:  make(map[string]time.Time),
+	}, nil
+}
+
+// Run evaluates due checks periodically until the stop channel is closed.
+func (ce *ClusterHealthEvaluator) Run(stopCh <-chan struct{}) {
+	// Wait for all caches to sync before processing.
+	if !ce.cache.WaitForCacheSync(stopCh) {
+		return
+	}
+
+	wait.Until(ce.sync, clusterHealthHousekeepingInterval, stopCh)
+}
+
+// sync runs all checks whose schedule has elapsed since their last run and emits the summary if
+// any check ran.
+func (ce *ClusterHealthEvaluator) sync() {
+	now := time.Now().In(ce.location)
+	ran := false
+	for check, schedule := range ce.schedules {
+		lastRun, ok := ce.lastRuns[check]
+		if ok && schedule.Next(lastRun.In(ce.location)).After(now) {
+			continue
+		}
+
+		ce.Info("running cluster health check", "check", check)
+		clusterHealthEvaluationCycleCount.WithLabelValues(check).Inc()
+		var err error
+		switch check {
+		case WorkloadCheck:
+			err = ce.evaluateWorkloadHealth()
+		case NodeCheck:
+			err = ce.evaluateNodeHealth()
+		}
+		if err != nil {
+			ce.Error(err, "cluster health check failed", "check", check)
+			clusterHealthEvaluationErrorCount.Inc()
+			continue
+		}
+		ce.lastRuns[check] = now
+		ran = true
+	}
+
+	if !ran {
+		return
+	}
+
+	ce.updateScore()
+	if err := ce.emitSummary(); err != nil {
+		ce.Error(err, "unable to emit cluster health summary")
+		clusterHealthEvaluationErrorCount.Inc()
+	}
+}
+
+// evaluateWorkloadHealth evaluates the health of pods, deployments, statefulsets and daemonsets.
+func (ce *ClusterHealthEvaluator) evaluateWorkloadHealth() error {
+	podHealth, err := ce.evaluatePodHealth()
+	if err != nil {
+		return err
+	}
+	deploymentHealth, err := ce.evaluateDeploymentHealth()
+	if err != nil {
+		return err
+	}
+	statefulSetHealth, err := ce.evaluateStatefulSetHealth()
+	if err != nil {
+		return err
+	}
+	daemonSetHealth, err := ce.evaluateDaemonSetHealth()
+	if err != nil {
+		return err
+	}
+
+	ce.health.WorkloadHealth = types.WorkloadHealth{
+		Score:             (podHealth.Score + deploymentHealth.Score + statefulSetHealth.Score + daemonSetHealth.Score) / 4,
+		PodHealth:         podHealth,
+		DeploymentHealth:  deploymentHealth,
+		StatefulSetHealth: statefulSetHealth,
+		DaemonSetHealth:   daemonSetHealth,
+	}
+
+	return nil
+}
+
+// evaluatePodHealth evaluates the health of pods in the cluster.
+func (ce *ClusterHealthEvaluator) evaluatePodHealth() (types.PodHealth, error) {
+	var podList corev1.PodList
+	if err := ce.cache.List(ce, &podList); err != nil {
+		return types.PodHealth{}, err
+	}
+
+	statistics := types.PodStatistics{
+		Total: len(podList.Items),
+		Unhealthy: types.UnhealthyPodStatistics{
+			ContainerStateReasons: make(map[string]int),
+		},
+	}
+	for _, pod := range podList.Items {
+		switch pod.Status.Phase {
+		case corev1.PodSucceeded:
+			statistics.Healthy.Succeeded++
+		case corev1.PodRunning:
+			if pod.DeletionTimestamp != nil {
+				statistics.Unhealthy.Terminating++
+			} else if podReady(pod) {
+				statistics.Healthy.Ready++
+			} else {
+				statistics.Unhealthy.Unready++
+				if reason := unhealthyContainerReason(pod); reason != "" {
+					statistics.Unhealthy.ContainerStateReasons[reason]++
+				}
+			}
+		case corev1.PodPending:
+			statistics.Unhealthy.Pending++
+		case corev1.PodFailed:
+			statistics.Unhealthy.Failed++
+		default:
+			statistics.Unhealthy.Unknown++
+		}
+	}
+
+	healthy := statistics.Healthy.Ready + statistics.Healthy.Succeeded
+	return types.PodHealth{
+		Score:      score(healthy, statistics.Total),
+		Statistics: statistics,
+	}, nil
+}
+
+// evaluateDeploymentHealth evaluates the health of deployments in the cluster.
+func (ce *ClusterHealthEvaluator) evaluateDeploymentHealth() (types.DeploymentHealth, error) {
+	var deploymentList appsv1.DeploymentList
+	if err := ce.cache.List(ce, &deploymentList); err != nil {
+		return types.DeploymentHealth{}, err
+	}
+
+	statistics := types.DeploymentStatistics{
+		Total: len(deploymentList.Items),
+	}
+	for _, deployment := range deploymentList.Items {
+		desired := int32(1)
+		if deployment.Spec.Replicas != nil {
+			desired = *deployment.Spec.Replicas
+		}
+		switch quarter(deployment.Status.AvailableReplicas, desired) {
+		case types.FourQuarters:
+			statistics.Healthy++
+		case types.ThreeQuarters:
+			statistics.Unhealthy.FourQuartersAvailable++
+		case types.TwoQuarters:
+			statistics.Unhealthy.ThreeQuartersAvailable++
+		case types.OneQuarter:
+			statistics.Unhealthy.TwoQuartersAvailable++
+		default:
+			statistics.Unhealthy.OneQuarterAvailable++
+		}
+	}
+
+	return types.DeploymentHealth{
+		Score:      score(statistics.Healthy, statistics.Total),
+		Statistics: statistics,
+	}, nil
+}
+
+// evaluateStatefulSetHealth evaluates the health of statefulsets in the cluster.
+func (ce *ClusterHealthEvaluator) evaluateStatefulSetHealth() (types.StatefulSetHealth, error) {
+	var statefulSetList appsv1.StatefulSetList
+	if err := ce.cache.List(ce, &statefulSetList); err != nil {
+		return types.StatefulSetHealth{}, err
+	}
+
+	statistics := types.StatefulSetStatistics{
+		Total: len(statefulSetList.Items),
+	}
+	for _, statefulSet := range statefulSetList.Items {
+		desired := int32(1)
+		if statefulSet.Spec.Replicas != nil {
+			desired = *statefulSet.Spec.Replicas
+		}
+		switch quarter(statefulSet.Status.ReadyReplicas, desired) {
+		case types.FourQuarters:
+			statistics.Healthy++
+		case types.ThreeQuarters:
+			statistics.Unhealthy.FourQuartersReady++
+		case types.TwoQuarters:
+			statistics.Unhealthy.ThreeQuartersReady++
+		case types.OneQuarter:
+			statistics.Unhealthy.TwoQuartersReady++
+		default:
+			statistics.Unhealthy.OneQuarterReady++
+		}
+	}
+
+	return types.StatefulSetHealth{
+		Score:      score(statistics.Healthy, statistics.Total),
+		Statistics: statistics,
+	}, nil
+}
+
+// evaluateDaemonSetHealth evaluates the health of daemonsets in the cluster.
+func (ce *ClusterHealthEvaluator) evaluateDaemonSetHealth() (types.DaemonSetHealth, error) {
+	var daemonSetList appsv1.DaemonSetList
+	if err := ce.cache.List(ce, &daemonSetList); err != nil {
+		return types.DaemonSetHealth{}, err
+	}
+
+	statistics := types.DaemonSetStatistics{
+		Total: len(daemonSetList.Items),
+	}
+	for _, daemonSet := range daemonSetList.Items {
+		available := daemonSet.Status.NumberAvailable
+		if daemonSet.Status.CurrentNumberScheduled < available {
+			available = daemonSet.Status.CurrentNumberScheduled
+		}
+		switch quarter(available, daemonSet.Status.DesiredNumberScheduled) {
+		case types.FourQuarters:
+			statistics.Healthy++
+		case types.ThreeQuarters:
+			statistics.Unhealthy.FourQuartersAvailableAndScheduled++
+		case types.TwoQuarters:
+			statistics.Unhealthy.ThreeQuartersAvailableAndScheduled++
+		case types.OneQuarter:
+			statistics.Unhealthy.TwoQuartersAvailableAndScheduled++
+		default:
+			statistics.Unhealthy.OneQuarterAvailableAndScheduled++
+		}
+	}
+
+	return types.DaemonSetHealth{
+		Score:      score(statistics.Healthy, statistics.Total),
+		Statistics: statistics,
+	}, nil
+}
+
+// evaluateNodeHealth evaluates the health of nodes in the cluster.
+func (ce *ClusterHealthEvaluator) evaluateNodeHealth() error {
+	var nodeList corev1.NodeList
+	if err := ce.cache.List(ce, &nodeList); err != nil {
+		return err
+	}
+
+	statistics := types.NodeStatistics{
+		Total:     len(nodeList.Items),
+		Unhealthy: make(map[corev1.NodeConditionType]int),
+	}
+	for _, node := range nodeList.Items {
+		if nodeReady(node) {
+			statistics.Healthy++
+			continue
+		}
+		statistics.Unhealthy[unhealthyNodeCondition(node)]++
+	}
+
+	ce.health.NodeHealth = types.NodeHealth{
+		Score:      score(statistics.Healthy, statistics.Total),
+		Statistics: statistics,
+	}
+
+	return nil
+}
+
+// updateScore assembles the overall cluster health score from the component scores and updates
+// the cluster health score metrics.
+func (ce *ClusterHealthEvaluator) updateScore() {
+	ce.health.Score = (ce.health.WorkloadHealth.Score + ce.health.NodeHealth.Score) / 2
+
+	clusterHealthScore.WithLabelValues("cluster").Set(float64(ce.health.Score))
+	clusterHealthScore.WithLabelValues("workload").Set(float64(ce.health.WorkloadHealth.Score))
+	clusterHealthScore.WithLabelValues("node").Set(float64(ce.health.NodeHealth.Score))
+}
+
+// emitSummary writes the cluster health summary of the last evaluation to the summary config
+// map in the kubediag namespace.
+func (ce *ClusterHealthEvaluator) emitSummary() error {
+	data, err := json.Marshal(ce.health)
+	if err != nil {
+		return fmt.Errorf("unable to marshal cluster health: %v", err)
+	}
+
+	configMap := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      SummaryConfigMapName,
+			Namespace: util.DefautlNamespace,
+		},
+		Data: map[string]string{
+			"clusterHealth": string(data),
+			"updatedAt":     time.Now().In(ce.location).Format(time.RFC3339),
+		},
+	}
+
+	var existing corev1.ConfigMap
+	err = ce.client.Get(ce, client.ObjectKey{Namespace: configMap.Namespace, Name: configMap.Name}, &existing)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return ce.client.Create(ce, &configMap)
+		}
+		return err
+	}
+	existing.Data = configMap.Data
+
+	return ce.client.Update(ce, &existing)
+}
+
+// score returns the weighted health score of healthy objects out of total objects.
+func score(healthy int, total int) int {
+	if total == 0 {
+		return types.MaxHealthScore
+	}
+
+	return types.MaxHealthScore * healthy / total
+}
+
+// quarter returns the quarter fraction bucket of actual objects out of desired objects.
+func quarter(actual int32, desired int32) float64 {
+	if desired == 0 || actual >= desired {
+		return types.FourQuarters
+	}
+
+	fraction := float64(actual) / float64(desired)
+	switch {
+	case fraction >= types.ThreeQuarters:
+		return types.ThreeQuarters
+	case fraction >= types.TwoQuarters:
+		return types.TwoQuarters
+	case fraction >= types.OneQuarter:
+		return types.OneQuarter
+	default:
+		return 0
+	}
+}
+
+// podReady indicates whether all containers of the pod are ready.
+func podReady(pod corev1.Pod) bool {
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if !containerStatus.Ready {
+			return false
+		}
+	}
+
+	return true
+}
+
+// unhealthyContainerReason returns the reason of the first waiting or terminated container of
+// the pod.
+func unhealthyContainerReason(pod corev1.Pod) string {
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if containerStatus.State.Waiting != nil {
+			return containerStatus.State.Waiting.Reason
+		}
+		if containerStatus.State.Terminated != nil {
+			return containerStatus.State.Terminated.Reason
+		}
+	}
+
+	return ""
+}
+
+// nodeReady indicates whether the node is in Ready condition.
+func nodeReady(node corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// unhealthyNodeCondition returns the first unhealthy condition type of the node, or Unknown if
+// the node does not report any condition.
+func unhealthyNodeCondition(node corev1.Node) corev1.NodeConditionType {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type != corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+			return condition.Type
+		}
+	}
+
+	return corev1.NodeConditionType("Unknown")
+}
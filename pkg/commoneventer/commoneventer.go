@@ -68,6 +68,9 @@ type commonEventer struct {
 	cache cache.Cache
 	// commonEventEnabled indicates whether common event is enabled.
 	commonEventEnabled bool
+	// elected is closed once this replica has been elected leader. Common events are only
+	// processed by the leader, so an HA deployment does not generate duplicate diagnoses.
+	elected <-chan struct{}
 }
 
 // NewCommonEventer creates a new CommonEvent.
@@ -77,6 +80,7 @@ func NewCommonEventer(
 	cli client.Client,
 	cache cache.Cache,
 	commonEventEnabled bool,
+	elected <-chan struct{},
 ) CommonEventer {
 	metrics.Registry.MustRegister(
 		commonEventReceivedCount,
@@ -90,6 +94,7 @@ func NewCommonEventer(
 		client:             cli,
 		cache:              cache,
 		commonEventEnabled: commonEventEnabled,
+		elected:            elected,
 	}
 }
 
@@ -100,6 +105,15 @@ func (ce *commonEventer) Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only the elected leader generates diagnoses from common events, so an HA deployment
+	// does not create duplicates.
+	select {
+	case <-ce.elected:
+	default:
+		http.Error(w, "kubediag master is not the leader", http.StatusServiceUnavailable)
+		return
+	}
+
 	switch r.Method {
 	case "POST":
 		commonEventReceivedCount.Inc()
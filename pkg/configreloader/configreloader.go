@@ -0,0 +1,199 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package configreloader reloads feature gates and agent configuration from a watched file,
+// typically mounted from a config map. Feature gates are applied to the live feature gate and
+// the processor router is rebuilt and swapped in place, so enabling a collector or changing the
+// docker endpoint takes effect without restarting every agent daemon set pod.
+package configreloader
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kubediag/kubediag/pkg/features"
+)
+
+// reloadInterval is the interval between checks of the watched configuration file.
+const reloadInterval = 10 * time.Second
+
+var (
+	configReloadSuccessCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "config_reload_success_count",
+			Help: "Counter of successful configuration reloads.",
+		},
+	)
+	configReloadErrorCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "config_reload_error_count",
+			Help: "Counter of erroneous configuration reloads.",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		configReloadSuccessCount,
+		configReloadErrorCount,
+	)
+}
+
+// Config is the reloadable agent configuration read from the watched file.
+type Config struct {
+	// FeatureGates is a map of feature names to enablement.
+	// +optional
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+	// DockerEndpoint overrides the docker endpoint processors are built with.
+	// +optional
+	DockerEndpoint string `json:"dockerEndpoint,omitempty"`
+}
+
+// RebuildFunc builds a new processor router with the docker endpoint and the current feature
+// gate state.
+type RebuildFunc func(dockerEndpoint string) (http.Handler, error)
+
+// ConfigReloader watches the configuration file and swaps the processor router on changes. It
+// serves processor requests by delegating to the current router.
+type ConfigReloader struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// configFile is the path of the watched configuration file.
+	configFile string
+	// defaultDockerEndpoint is the docker endpoint used if the configuration does not override it.
+	defaultDockerEndpoint string
+	// featureGate manages features of kubediag.
+	featureGate features.KubeDiagFeatureGate
+	// rebuild builds a new processor router after a configuration change.
+	rebuild RebuildFunc
+	// handler holds the current processor router as an http.Handler.
+	handler atomic.Value
+	// lastApplied is the configuration file content which was last applied.
+	lastApplied []byte
+}
+
+// NewConfigReloader creates a new ConfigReloader serving the initial processor router.
+func NewConfigReloader(
+	ctx context.Context,
+	logger logr.Logger,
+	configFile string,
+	defaultDockerEndpoint string,
+	featureGate features.KubeDiagFeatureGate,
+	rebuild RebuildFunc,
+	initial http.Handler,
+) *ConfigReloader {
+	reloader := &ConfigReloader{
+		Context:               ctx,
+		Logger:                logger,
+		configFile:            configFile,
+		defaultDockerEndpoint: defaultDockerEndpoint,
+		featureGate:           featureGate,
+		rebuild:               rebuild,
+	}
+	reloader.handler.Store(initial)
+
+	return reloader
+}
+
+// Handler returns the current processor router.
+func (cr *ConfigReloader) Handler() http.Handler {
+	return cr.handler.Load().(http.Handler)
+}
+
+// ServeHTTP delegates to the current processor router.
+func (cr *ConfigReloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cr.handler.Load().(http.Handler).ServeHTTP(w, r)
+}
+
+// Run applies the configuration file once and then reapplies it whenever its content changes,
+// until the stop channel is closed.
+func (cr *ConfigReloader) Run(stopCh <-chan struct{}) {
+	cr.reload()
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cr.reload()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// reload reads the configuration file and applies it if its content has changed. Feature gates
+// are set on the live feature gate and the processor router is rebuilt and swapped, so removed
+// processors stop serving and newly enabled processors start serving.
+func (cr *ConfigReloader) reload() {
+	content, err := ioutil.ReadFile(cr.configFile)
+	if err != nil {
+		// A missing file keeps the current configuration, so the file may be removed to revert
+		// to the flag provided configuration on the next restart.
+		if !os.IsNotExist(err) {
+			cr.Error(err, "unable to read configuration file", "file", cr.configFile)
+			configReloadErrorCount.Inc()
+		}
+		return
+	}
+	if bytes.Equal(content, cr.lastApplied) {
+		return
+	}
+
+	var config Config
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		cr.Error(err, "unable to unmarshal configuration file", "file", cr.configFile)
+		configReloadErrorCount.Inc()
+		return
+	}
+
+	if len(config.FeatureGates) != 0 {
+		if err := cr.featureGate.SetFromMap(config.FeatureGates); err != nil {
+			cr.Error(err, "unable to set feature gates", "file", cr.configFile)
+			configReloadErrorCount.Inc()
+			return
+		}
+	}
+
+	dockerEndpoint := cr.defaultDockerEndpoint
+	if config.DockerEndpoint != "" {
+		dockerEndpoint = config.DockerEndpoint
+	}
+	handler, err := cr.rebuild(dockerEndpoint)
+	if err != nil {
+		cr.Error(err, "unable to rebuild processor router", "file", cr.configFile)
+		configReloadErrorCount.Inc()
+		return
+	}
+	cr.handler.Store(handler)
+	cr.lastApplied = content
+
+	cr.Info("agent configuration reloaded", "file", cr.configFile, "featureGates", config.FeatureGates, "dockerEndpoint", dockerEndpoint)
+	configReloadSuccessCount.Inc()
+}
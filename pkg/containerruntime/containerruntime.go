@@ -0,0 +1,115 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package containerruntime detects the container runtime running on the node by probing well
+// known runtime sockets, so container related processors are routed to the detected runtime
+// instead of assuming docker on every node.
+package containerruntime
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Names of well known container runtimes.
+const (
+	// Docker is the name of the docker runtime.
+	Docker = "docker"
+	// Containerd is the name of the containerd runtime.
+	Containerd = "containerd"
+	// CRIO is the name of the cri-o runtime.
+	CRIO = "cri-o"
+)
+
+// probeTimeout is the timeout of a single runtime socket probe.
+const probeTimeout = 2 * time.Second
+
+// Runtime describes a container runtime on the node.
+type Runtime struct {
+	// Name is the name of the runtime.
+	Name string
+	// Endpoint is the endpoint of the runtime socket.
+	Endpoint string
+}
+
+// wellKnownRuntimes are probed in order during detection. Docker is preferred since most
+// container related processors speak the docker api.
+var wellKnownRuntimes = []Runtime{
+	{Name: Docker, Endpoint: "unix:///var/run/docker.sock"},
+	{Name: Containerd, Endpoint: "unix:///run/containerd/containerd.sock"},
+	{Name: CRIO, Endpoint: "unix:///var/run/crio/crio.sock"},
+}
+
+// Detect probes well known container runtime sockets and returns the detected runtime. The
+// preferred endpoint is probed first, so an explicitly configured endpoint keeps working. If no
+// socket is reachable the preferred endpoint is returned as a docker runtime, preserving the
+// previous behavior on nodes where the runtime starts after the agent.
+func Detect(logger logr.Logger, preferredEndpoint string) Runtime {
+	candidates := make([]Runtime, 0, len(wellKnownRuntimes)+1)
+	if preferredEndpoint != "" {
+		candidates = append(candidates, Runtime{Name: nameForEndpoint(preferredEndpoint), Endpoint: preferredEndpoint})
+	}
+	candidates = append(candidates, wellKnownRuntimes...)
+
+	for _, candidate := range candidates {
+		if Reachable(candidate.Endpoint) {
+			logger.Info("detected container runtime", "runtime", candidate.Name, "endpoint", candidate.Endpoint)
+			return candidate
+		}
+	}
+
+	fallback := Runtime{Name: Docker, Endpoint: preferredEndpoint}
+	logger.Info("no container runtime socket is reachable, falling back to the configured endpoint", "endpoint", preferredEndpoint)
+	return fallback
+}
+
+// Reachable reports whether the runtime socket of the endpoint is reachable.
+func Reachable(endpoint string) bool {
+	network := "unix"
+	address := strings.TrimPrefix(endpoint, "unix://")
+	if address == endpoint {
+		parsed, err := url.Parse(endpoint)
+		if err != nil {
+			return false
+		}
+		network = "tcp"
+		address = parsed.Host
+	}
+
+	conn, err := net.DialTimeout(network, address, probeTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
+
+// nameForEndpoint infers the runtime name from the endpoint.
+func nameForEndpoint(endpoint string) string {
+	switch {
+	case strings.Contains(endpoint, "crio"):
+		return CRIO
+	case strings.Contains(endpoint, "containerd"):
+		return Containerd
+	default:
+		return Docker
+	}
+}
@@ -0,0 +1,342 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/robfig/cron"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+)
+
+var (
+	// defaultSuccessfulDiagnosesHistoryLimit is the number of succeeded diagnoses retained if
+	// the limit is not specified on the cron diagnosis.
+	defaultSuccessfulDiagnosesHistoryLimit = int32(3)
+	// defaultFailedDiagnosesHistoryLimit is the number of failed diagnoses retained if the
+	// limit is not specified on the cron diagnosis.
+	defaultFailedDiagnosesHistoryLimit = int32(1)
+)
+
+// CronDiagnosisReconciler reconciles a CronDiagnosis object.
+type CronDiagnosisReconciler struct {
+	client.Client
+	Log           logr.Logger
+	Scheme        *runtime.Scheme
+	eventRecorder record.EventRecorder
+}
+
+// NewCronDiagnosisReconciler creates a new CronDiagnosisReconciler.
+func NewCronDiagnosisReconciler(
+	cli client.Client,
+	log logr.Logger,
+	scheme *runtime.Scheme,
+	eventRecorder record.EventRecorder,
+) *CronDiagnosisReconciler {
+	return &CronDiagnosisReconciler{
+		Client:        cli,
+		Log:           log,
+		Scheme:        scheme,
+		eventRecorder: eventRecorder,
+	}
+}
+
+// +kubebuilder:rbac:groups=diagnosis.kubediag.org,resources=crondiagnoses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=diagnosis.kubediag.org,resources=crondiagnoses/status,verbs=get;update;patch
+
+// Reconcile creates diagnoses from the template of a CronDiagnosis on its cron schedule and
+// garbage collects completed diagnoses beyond the history limits.
+func (r *CronDiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("crondiagnosis", req.NamespacedName)
+
+	var cronDiagnosis diagnosisv1.CronDiagnosis
+	if err := r.Get(ctx, req.NamespacedName, &cronDiagnosis); err != nil {
+		log.Error(err, "unable to fetch CronDiagnosis")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	sched, err := cron.ParseStandard(cronDiagnosis.Spec.Schedule)
+	if err != nil {
+		log.Error(err, "unparseable schedule", "schedule", cronDiagnosis.Spec.Schedule)
+		r.eventRecorder.Eventf(&cronDiagnosis, corev1.EventTypeWarning, "UnparseableSchedule", "Unparseable schedule %s: %v", cronDiagnosis.Spec.Schedule, err)
+		return ctrl.Result{}, nil
+	}
+
+	diagnoses, err := r.listChildDiagnoses(ctx, &cronDiagnosis)
+	if err != nil {
+		log.Error(err, "unable to list diagnoses of CronDiagnosis")
+		return ctrl.Result{}, err
+	}
+
+	active := make([]diagnosisv1.Diagnosis, 0)
+	for _, diagnosis := range diagnoses {
+		if diagnosis.Status.Phase != diagnosisv1.DiagnosisSucceeded && diagnosis.Status.Phase != diagnosisv1.DiagnosisFailed {
+			active = append(active, diagnosis)
+		}
+	}
+
+	if err := r.cleanupHistory(ctx, log, &cronDiagnosis, diagnoses); err != nil {
+		log.Error(err, "unable to clean up diagnosis history of CronDiagnosis")
+		return ctrl.Result{}, err
+	}
+
+	if cronDiagnosis.Spec.Suspend != nil && *cronDiagnosis.Spec.Suspend {
+		if err := r.updateStatus(ctx, &cronDiagnosis, active, nil); err != nil {
+			log.Error(err, "unable to update CronDiagnosis")
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	now := time.Now()
+	scheduledTime, err := r.lastUnmetScheduleTime(&cronDiagnosis, sched, now)
+	if err != nil {
+		log.Error(err, "cannot determine if CronDiagnosis needs to be started")
+		r.eventRecorder.Eventf(&cronDiagnosis, corev1.EventTypeWarning, "ScheduleError", "Cannot determine if cron diagnosis needs to be started: %v", err)
+		return ctrl.Result{}, nil
+	}
+
+	if scheduledTime == nil {
+		// No unmet start times found. Requeue at the next schedule time.
+		if err := r.updateStatus(ctx, &cronDiagnosis, active, nil); err != nil {
+			log.Error(err, "unable to update CronDiagnosis")
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		return ctrl.Result{RequeueAfter: sched.Next(now).Sub(now)}, nil
+	}
+
+	// Skip the run if the deadline for starting the diagnosis has been missed.
+	if cronDiagnosis.Spec.StartingDeadlineSeconds != nil && now.After(scheduledTime.Add(time.Duration(*cronDiagnosis.Spec.StartingDeadlineSeconds)*time.Second)) {
+		log.Info("missed starting deadline of CronDiagnosis", "scheduledTime", scheduledTime)
+		r.eventRecorder.Eventf(&cronDiagnosis, corev1.EventTypeWarning, "MissedSchedule", "Missed scheduled time to start a diagnosis: %s", scheduledTime.Format(time.RFC1123Z))
+		if err := r.updateStatus(ctx, &cronDiagnosis, active, scheduledTime); err != nil {
+			log.Error(err, "unable to update CronDiagnosis")
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		return ctrl.Result{RequeueAfter: sched.Next(now).Sub(now)}, nil
+	}
+
+	// Apply the concurrency policy if diagnoses of the previous runs are still running.
+	if len(active) != 0 {
+		switch cronDiagnosis.Spec.ConcurrencyPolicy {
+		case diagnosisv1.ForbidConcurrent:
+			log.Info("skipping run of CronDiagnosis since previous diagnoses are still running", "active", len(active))
+			r.eventRecorder.Eventf(&cronDiagnosis, corev1.EventTypeNormal, "DiagnosisSkipped", "Skipped scheduled run since %d diagnoses are still running", len(active))
+			if err := r.updateStatus(ctx, &cronDiagnosis, active, scheduledTime); err != nil {
+				log.Error(err, "unable to update CronDiagnosis")
+				return ctrl.Result{}, client.IgnoreNotFound(err)
+			}
+			return ctrl.Result{RequeueAfter: sched.Next(now).Sub(now)}, nil
+		case diagnosisv1.ReplaceConcurrent:
+			for _, diagnosis := range active {
+				log.Info("deleting running diagnosis to replace it with a new run", "diagnosis", client.ObjectKey{
+					Name:      diagnosis.Name,
+					Namespace: diagnosis.Namespace,
+				})
+				if err := r.Delete(ctx, &diagnosis); err != nil && !apierrors.IsNotFound(err) {
+					log.Error(err, "unable to delete Diagnosis")
+					return ctrl.Result{}, err
+				}
+			}
+			active = nil
+		}
+	}
+
+	diagnosis := diagnosisFromCronDiagnosis(&cronDiagnosis, *scheduledTime)
+	if err := r.Create(ctx, diagnosis); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			log.Error(err, "unable to create Diagnosis")
+			return ctrl.Result{}, err
+		}
+	} else {
+		log.Info("created Diagnosis from CronDiagnosis", "diagnosis", client.ObjectKey{
+			Name:      diagnosis.Name,
+			Namespace: diagnosis.Namespace,
+		})
+		r.eventRecorder.Eventf(&cronDiagnosis, corev1.EventTypeNormal, "DiagnosisCreated", "Created diagnosis %s", diagnosis.Name)
+	}
+
+	active = append(active, *diagnosis)
+	if err := r.updateStatus(ctx, &cronDiagnosis, active, scheduledTime); err != nil {
+		log.Error(err, "unable to update CronDiagnosis")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	return ctrl.Result{RequeueAfter: sched.Next(now).Sub(now)}, nil
+}
+
+// listChildDiagnoses lists all diagnoses created by the cron diagnosis.
+func (r *CronDiagnosisReconciler) listChildDiagnoses(ctx context.Context, cronDiagnosis *diagnosisv1.CronDiagnosis) ([]diagnosisv1.Diagnosis, error) {
+	var diagnosisList diagnosisv1.DiagnosisList
+	if err := r.List(ctx, &diagnosisList, client.InNamespace(cronDiagnosis.Namespace), client.MatchingLabels{
+		"crondiagnosis-name": cronDiagnosis.Name,
+	}); err != nil {
+		return nil, err
+	}
+
+	return diagnosisList.Items, nil
+}
+
+// cleanupHistory deletes the oldest completed diagnoses of the cron diagnosis beyond the
+// history limits.
+func (r *CronDiagnosisReconciler) cleanupHistory(ctx context.Context, log logr.Logger, cronDiagnosis *diagnosisv1.CronDiagnosis, diagnoses []diagnosisv1.Diagnosis) error {
+	successfulLimit := defaultSuccessfulDiagnosesHistoryLimit
+	if cronDiagnosis.Spec.SuccessfulDiagnosesHistoryLimit != nil {
+		successfulLimit = *cronDiagnosis.Spec.SuccessfulDiagnosesHistoryLimit
+	}
+	failedLimit := defaultFailedDiagnosesHistoryLimit
+	if cronDiagnosis.Spec.FailedDiagnosesHistoryLimit != nil {
+		failedLimit = *cronDiagnosis.Spec.FailedDiagnosesHistoryLimit
+	}
+
+	succeeded := make([]diagnosisv1.Diagnosis, 0)
+	failed := make([]diagnosisv1.Diagnosis, 0)
+	for _, diagnosis := range diagnoses {
+		switch diagnosis.Status.Phase {
+		case diagnosisv1.DiagnosisSucceeded:
+			succeeded = append(succeeded, diagnosis)
+		case diagnosisv1.DiagnosisFailed:
+			failed = append(failed, diagnosis)
+		}
+	}
+
+	if err := r.deleteOldestDiagnoses(ctx, log, succeeded, successfulLimit); err != nil {
+		return err
+	}
+	if err := r.deleteOldestDiagnoses(ctx, log, failed, failedLimit); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// deleteOldestDiagnoses deletes the oldest diagnoses until no more than limit diagnoses remain.
+func (r *CronDiagnosisReconciler) deleteOldestDiagnoses(ctx context.Context, log logr.Logger, diagnoses []diagnosisv1.Diagnosis, limit int32) error {
+	if len(diagnoses) <= int(limit) {
+		return nil
+	}
+
+	sort.Slice(diagnoses, func(i, j int) bool {
+		return diagnoses[i].CreationTimestamp.Before(&diagnoses[j].CreationTimestamp)
+	})
+	for _, diagnosis := range diagnoses[:len(diagnoses)-int(limit)] {
+		log.Info("deleting diagnosis beyond history limit", "diagnosis", client.ObjectKey{
+			Name:      diagnosis.Name,
+			Namespace: diagnosis.Namespace,
+		})
+		if err := r.Delete(ctx, &diagnosis); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateStatus updates the active diagnoses and last schedule time of the cron diagnosis.
+func (r *CronDiagnosisReconciler) updateStatus(ctx context.Context, cronDiagnosis *diagnosisv1.CronDiagnosis, active []diagnosisv1.Diagnosis, scheduledTime *time.Time) error {
+	names := make([]string, 0, len(active))
+	for _, diagnosis := range active {
+		names = append(names, diagnosis.Name)
+	}
+	sort.Strings(names)
+
+	cronDiagnosis.Status.Active = names
+	if scheduledTime != nil {
+		cronDiagnosis.Status.LastScheduleTime = &metav1.Time{Time: *scheduledTime}
+	}
+
+	return r.Status().Update(ctx, cronDiagnosis)
+}
+
+// lastUnmetScheduleTime returns the latest time the cron diagnosis should have been started
+// but was not, or nil if the cron diagnosis is not due yet.
+//
+// If there are too many (>100) unstarted times, an error is returned since an incorrect clock
+// or a decades-old last schedule time could otherwise eat up all the CPU and memory of the
+// controller.
+func (r *CronDiagnosisReconciler) lastUnmetScheduleTime(cronDiagnosis *diagnosisv1.CronDiagnosis, sched cron.Schedule, now time.Time) (*time.Time, error) {
+	var earliestTime time.Time
+	if cronDiagnosis.Status.LastScheduleTime != nil {
+		earliestTime = cronDiagnosis.Status.LastScheduleTime.Time
+	} else {
+		earliestTime = cronDiagnosis.CreationTimestamp.Time
+	}
+	if earliestTime.After(now) {
+		return nil, nil
+	}
+
+	var scheduledTime *time.Time
+	count := 0
+	for t := sched.Next(earliestTime); !t.After(now); t = sched.Next(t) {
+		t := t
+		scheduledTime = &t
+		count++
+		if count > 100 {
+			return nil, fmt.Errorf("too many missed start times (> 100)")
+		}
+	}
+
+	return scheduledTime, nil
+}
+
+// diagnosisFromCronDiagnosis constructs the diagnosis created for the scheduled time from the
+// template of the cron diagnosis.
+func diagnosisFromCronDiagnosis(cronDiagnosis *diagnosisv1.CronDiagnosis, scheduledTime time.Time) *diagnosisv1.Diagnosis {
+	labels := make(map[string]string)
+	for key, value := range cronDiagnosis.Spec.DiagnosisTemplate.Labels {
+		labels[key] = value
+	}
+	labels["crondiagnosis-name"] = cronDiagnosis.Name
+	annotations := make(map[string]string)
+	for key, value := range cronDiagnosis.Spec.DiagnosisTemplate.Annotations {
+		annotations[key] = value
+	}
+
+	return &diagnosisv1.Diagnosis{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s.%d", cronDiagnosis.Name, scheduledTime.Unix()),
+			Namespace:   cronDiagnosis.Namespace,
+			Labels:      labels,
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(cronDiagnosis, diagnosisv1.GroupVersion.WithKind("CronDiagnosis")),
+			},
+		},
+		Spec: cronDiagnosis.Spec.DiagnosisTemplate.Spec,
+	}
+}
+
+// SetupWithManager setups CronDiagnosisReconciler with the provided manager.
+func (r *CronDiagnosisReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&diagnosisv1.CronDiagnosis{}).
+		Owns(&diagnosisv1.Diagnosis{}).
+		Complete(r)
+}
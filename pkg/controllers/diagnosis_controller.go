@@ -19,7 +19,10 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/prometheus/client_golang/prometheus"
@@ -30,12 +33,21 @@ import (
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/alertmanager"
+	"github.com/kubediag/kubediag/pkg/grafana"
+	"github.com/kubediag/kubediag/pkg/notifier"
+	"github.com/kubediag/kubediag/pkg/signing"
 	"github.com/kubediag/kubediag/pkg/util"
 )
 
+// NotifiedAnnotation is the annotation set on a diagnosis after its completion summary has been
+// delivered to all configured notifiers.
+const NotifiedAnnotation = "diagnosis.kubediag.org/notified"
+
 // Kubediag master metrics
 var (
 	diagnosisMasterSkipCount = prometheus.NewCounter(
@@ -94,8 +106,20 @@ type DiagnosisReconciler struct {
 	Scheme        *runtime.Scheme
 	eventRecorder record.EventRecorder
 
-	nodeName   string
-	executorCh chan diagnosisv1.Diagnosis
+	nodeName  string
+	notifiers []notifier.Notifier
+	annotator *grafana.Annotator
+	silencer  *alertmanager.Silencer
+
+	// shardIndex and totalShards partition diagnoses across master replicas. A replica only
+	// processes diagnoses whose key hashes to its shard.
+	shardIndex  int
+	totalShards int
+	// maxConcurrentReconciles is the maximum number of diagnoses reconciled concurrently.
+	maxConcurrentReconciles int
+	// signatureVerifier verifies operation set signatures before a diagnosis runs. Unsigned
+	// operation sets are run without verification if nil.
+	signatureVerifier *signing.Verifier
 }
 
 // NewDiagnosisReconciler creates a new DiagnosisReconciler.
@@ -105,7 +129,13 @@ func NewDiagnosisReconciler(
 	scheme *runtime.Scheme,
 	eventRecorder record.EventRecorder,
 	nodeName string,
-	executorCh chan diagnosisv1.Diagnosis,
+	notifiers []notifier.Notifier,
+	annotator *grafana.Annotator,
+	silencer *alertmanager.Silencer,
+	shardIndex int,
+	totalShards int,
+	maxConcurrentReconciles int,
+	signatureVerifier *signing.Verifier,
 ) *DiagnosisReconciler {
 	metrics.Registry.MustRegister(
 		diagnosisMasterSkipCount,
@@ -118,12 +148,18 @@ func NewDiagnosisReconciler(
 	)
 
 	return &DiagnosisReconciler{
-		Client:        cli,
-		Log:           log,
-		Scheme:        scheme,
-		eventRecorder: eventRecorder,
-		nodeName:      nodeName,
-		executorCh:    executorCh,
+		Client:                  cli,
+		Log:                     log,
+		Scheme:                  scheme,
+		eventRecorder:           eventRecorder,
+		nodeName:                nodeName,
+		notifiers:               notifiers,
+		annotator:               annotator,
+		silencer:                silencer,
+		shardIndex:              shardIndex,
+		totalShards:             totalShards,
+		maxConcurrentReconciles: maxConcurrentReconciles,
+		signatureVerifier:       signatureVerifier,
 	}
 }
 
@@ -138,6 +174,7 @@ func NewDiagnosisReconciler(
 // +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch
 // +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
 
 // Reconcile synchronizes a Diagnosis object according to the phase.
 func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
@@ -146,6 +183,12 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 	log.Info("reconciling Diagnosis")
 
+	// Skip diagnoses belonging to other shards so concurrent master replicas partition the work.
+	if r.totalShards > 1 && util.ShardForKey(req.NamespacedName.String(), r.totalShards) != r.shardIndex {
+		diagnosisMasterSkipCount.Inc()
+		return ctrl.Result{}, nil
+	}
+
 	// Classify and calculate diagnosis according to the phase.
 	r.collectDiagnosisMetricsWithPhase(ctx, log)
 
@@ -172,7 +215,7 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 			diagnosis.Status.StartTime = metav1.Now()
 			diagnosis.Status.Phase = diagnosisv1.DiagnosisFailed
-			if err := r.Status().Update(ctx, &diagnosis); err != nil {
+			if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
 				log.Error(err, "target selector not found")
 				return ctrl.Result{}, client.IgnoreNotFound(err)
 			}
@@ -182,13 +225,37 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 		diagnosis.Status.StartTime = metav1.Now()
 		diagnosis.Status.Phase = diagnosisv1.DiagnosisPending
-		if err := r.Status().Update(ctx, &diagnosis); err != nil {
+		if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
 			log.Error(err, "unable to update Diagnosis")
 			return ctrl.Result{}, client.IgnoreNotFound(err)
 		}
+		r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeNormal, "DiagnosisAccepted", "Diagnosis %s/%s accepted by kubediag master", diagnosis.Namespace, diagnosis.Name)
 
 		return ctrl.Result{}, nil
 	case diagnosisv1.DiagnosisPending:
+		// Validate the diagnosis and report the would-be execution plan without running any
+		// operation in dry run mode.
+		if diagnosis.Spec.DryRun {
+			return r.syncDryRun(ctx, log, &diagnosis)
+		}
+
+		// Hold the diagnosis in Pending phase until all diagnoses it depends on have
+		// completed, so staged investigations run in order.
+		dependencyParameters := make(map[string]string)
+		if len(diagnosis.Spec.DependsOn) != 0 {
+			dependencies, failedDependency, err := r.resolveDependencies(ctx, log, &diagnosis)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if failedDependency != nil {
+				return r.failDependencyFailed(ctx, &diagnosis, failedDependency)
+			}
+			if dependencies == nil {
+				return ctrl.Result{RequeueAfter: 15 * time.Second}, nil
+			}
+			dependencyParameters = buildDependencyParameters(log, dependencies)
+		}
+
 		// Set node names from node selector, pod selector and pod names.
 		nodeNames := make([]string, 0)
 		if diagnosis.Spec.TargetSelector.NodeSelector != nil {
@@ -210,37 +277,47 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		} else if len(diagnosis.Spec.TargetSelector.NodeNames) != 0 {
 			nodeNames = append(nodeNames, diagnosis.Spec.TargetSelector.NodeNames...)
 		} else if diagnosis.Spec.TargetSelector.PodSelector != nil {
-			labelSelector, err := metav1.LabelSelectorAsSelector(diagnosis.Spec.TargetSelector.NodeSelector)
+			labelSelector, err := metav1.LabelSelectorAsSelector(diagnosis.Spec.TargetSelector.PodSelector)
 			if err != nil {
 				log.Error(err, "unable to get pod label selector")
 				return ctrl.Result{}, err
 			}
 
+			listOptions := &client.ListOptions{
+				LabelSelector: labelSelector,
+				Namespace:     diagnosis.Spec.TargetSelector.PodNamespace,
+			}
 			var podList corev1.PodList
-			if err := r.List(ctx, &podList, &client.ListOptions{LabelSelector: labelSelector}); err != nil {
+			if err := r.List(ctx, &podList, listOptions); err != nil {
 				log.Error(err, "unable to list Pods")
 				return ctrl.Result{}, err
 			}
 
+			// Record all matched pods, so one task is created for every pod and operation
+			// results are aggregated by pod name.
+			podReferences := make([]diagnosisv1.PodReference, 0, len(podList.Items))
 			for _, pod := range podList.Items {
+				podReferences = append(podReferences, diagnosisv1.PodReference{
+					NamespacedName: diagnosisv1.NamespacedName{
+						Name:      pod.Name,
+						Namespace: pod.Namespace,
+					},
+				})
 				if pod.Spec.NodeName != "" {
 					nodeNames = append(nodeNames, pod.Spec.NodeName)
 				}
 			}
+			diagnosis.Status.PodReferences = podReferences
 		} else if len(diagnosis.Spec.TargetSelector.PodReferences) != 0 {
-			for _, podReference := range diagnosis.Spec.TargetSelector.PodReferences {
-				var pod corev1.Pod
-				if err := r.Get(ctx, client.ObjectKey{
-					Name:      podReference.Name,
-					Namespace: podReference.Namespace,
-				}, &pod); err != nil {
-					log.Error(err, "unable to fetch Pod")
-					return ctrl.Result{}, client.IgnoreNotFound(err)
-				}
-				if pod.Spec.NodeName != "" {
-					nodeNames = append(nodeNames, pod.Spec.NodeName)
-				}
+			resolved, notFound, err := r.resolvePodReferenceNodeNames(ctx, &diagnosis)
+			if err != nil {
+				log.Error(err, "unable to fetch Pod")
+				return ctrl.Result{}, err
+			}
+			if notFound != nil {
+				return r.failTargetPodNotFound(ctx, log, &diagnosis, notFound)
 			}
+			nodeNames = append(nodeNames, resolved...)
 		}
 		// Deduplicate node names.
 		nodeNames = util.RemoveDuplicateStrings(nodeNames)
@@ -250,22 +327,111 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			Namespace: diagnosis.Namespace,
 		})
 
+		// Seed the context with the recorded context of the replayed diagnosis, so operations
+		// run against the recorded inputs of a historical incident.
+		if diagnosis.Spec.ReplayOf != nil {
+			var recorded diagnosisv1.Diagnosis
+			if err := r.Get(ctx, client.ObjectKey{
+				Namespace: diagnosis.Spec.ReplayOf.Namespace,
+				Name:      diagnosis.Spec.ReplayOf.Name,
+			}, &recorded); err != nil {
+				if apierrors.IsNotFound(err) {
+					return r.failReplay(ctx, &diagnosis, fmt.Sprintf("Diagnosis %s/%s to replay is not found", diagnosis.Spec.ReplayOf.Namespace, diagnosis.Spec.ReplayOf.Name))
+				}
+				return ctrl.Result{}, err
+			}
+			if recorded.Status.Phase != diagnosisv1.DiagnosisSucceeded && recorded.Status.Phase != diagnosisv1.DiagnosisFailed {
+				return r.failReplay(ctx, &diagnosis, fmt.Sprintf("Diagnosis %s/%s to replay has not completed", recorded.Namespace, recorded.Name))
+			}
+			if recorded.Status.Context == nil {
+				return r.failReplay(ctx, &diagnosis, fmt.Sprintf("Diagnosis %s/%s to replay has no recorded context", recorded.Namespace, recorded.Name))
+			}
+			if err := util.DecompressDiagnosisResults(&recorded); err != nil {
+				log.Error(err, "unable to decompress operation results of replayed diagnosis")
+			}
+			diagnosis.Status.Context = recorded.Status.Context.DeepCopy()
+		}
+
 		diagnosis.Status.Phase = diagnosisv1.DiagnosisRunning
 		diagnosis.Status.NodeNames = nodeNames
-		if diagnosis.Spec.Parameters != nil {
-			diagnosis.Status.Context.Parameters = diagnosis.Spec.Parameters
+		// Merge the parameters of the spec with the results injected from the diagnoses this
+		// diagnosis depends on, so operations of a later stage can consume the output of an
+		// earlier stage.
+		if diagnosis.Spec.Parameters != nil || len(dependencyParameters) != 0 {
+			if diagnosis.Status.Context == nil {
+				diagnosis.Status.Context = new(diagnosisv1.DiagnosisContext)
+			}
+			parameters := make(map[string]string)
+			for key, value := range diagnosis.Spec.Parameters {
+				parameters[key] = value
+			}
+			for key, value := range dependencyParameters {
+				parameters[key] = value
+			}
+			diagnosis.Status.Context.Parameters = parameters
 		}
-		if err := r.Status().Update(ctx, &diagnosis); err != nil {
+		if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
 			log.Error(err, "unable to update Diagnosis")
 			return ctrl.Result{}, client.IgnoreNotFound(err)
 		}
 		diagnosisTotalCount.Inc()
+		r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeNormal, "DiagnosisStarted", "Started diagnosis %s/%s with operation set %s on %d nodes", diagnosis.Namespace, diagnosis.Name, diagnosis.Spec.OperationSet, len(nodeNames))
+
+		if r.annotator != nil {
+			if err := r.annotator.AnnotateDiagnosisStarted(&diagnosis); err != nil {
+				log.Error(err, "failed to create grafana annotation")
+			}
+		}
+		// Silence the originating alert while kubediag is acting on it.
+		if r.silencer != nil {
+			if err := r.silencer.SilenceAlert(&diagnosis); err != nil {
+				log.Error(err, "failed to create alertmanager silence")
+			}
+		}
 	case diagnosisv1.DiagnosisRunning:
 		log.Info("starting to run Diagnosis", "diagnosis", client.ObjectKey{
 			Name:      diagnosis.Name,
 			Namespace: diagnosis.Namespace,
 		})
 
+		// Park the diagnosis while it is paused. No task is created and execution resumes
+		// from the stored checkpoint once the flag is cleared.
+		if diagnosis.Spec.Paused {
+			if _, condition := util.GetDiagnosisCondition(&diagnosis.Status, diagnosisv1.DiagnosisPaused); condition == nil || condition.Status != corev1.ConditionTrue {
+				r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeNormal, "DiagnosisPaused", "Execution of diagnosis %s/%s is paused", diagnosis.Namespace, diagnosis.Name)
+				util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
+					Type:    diagnosisv1.DiagnosisPaused,
+					Status:  corev1.ConditionTrue,
+					Reason:  "DiagnosisPaused",
+					Message: "Execution of the diagnosis is paused",
+				})
+				if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
+					return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+				}
+			}
+			return ctrl.Result{}, nil
+		}
+		if _, condition := util.GetDiagnosisCondition(&diagnosis.Status, diagnosisv1.DiagnosisPaused); condition != nil && condition.Status == corev1.ConditionTrue {
+			r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeNormal, "DiagnosisResumed", "Execution of diagnosis %s/%s is resumed", diagnosis.Namespace, diagnosis.Name)
+			util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
+				Type:    diagnosisv1.DiagnosisPaused,
+				Status:  corev1.ConditionFalse,
+				Reason:  "DiagnosisResumed",
+				Message: "Execution of the diagnosis is resumed from the stored checkpoint",
+			})
+			if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
+				return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+			}
+		}
+
+		// Terminate the diagnosis if it has been active longer than its active deadline.
+		if diagnosis.Spec.ActiveDeadlineSeconds != nil && !diagnosis.Status.StartTime.IsZero() {
+			deadline := diagnosis.Status.StartTime.Add(time.Duration(*diagnosis.Spec.ActiveDeadlineSeconds) * time.Second)
+			if time.Now().After(deadline) {
+				return r.failDeadlineExceeded(ctx, log, &diagnosis)
+			}
+		}
+
 		// Fetch operationSet according to diagnosis.
 		var operationset diagnosisv1.OperationSet
 		err := r.Get(ctx, client.ObjectKey{
@@ -286,7 +452,7 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 					Reason:  "OperationSetNotFound",
 					Message: fmt.Sprintf("OperationSet %s is not found", diagnosis.Spec.OperationSet),
 				})
-				if err := r.Status().Update(ctx, &diagnosis); err != nil {
+				if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
 					return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
 				}
 				diagnosisTotalFailCount.Inc()
@@ -308,13 +474,35 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 				Reason:  "OperationSetNotReady",
 				Message: fmt.Sprintf("OperationSet %s is not ready because the graph has not been updated according to the latest specification", operationset.Name),
 			})
-			if err := r.Status().Update(ctx, &diagnosis); err != nil {
+			if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
 				return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
 			}
 			diagnosisTotalFailCount.Inc()
 			return ctrl.Result{}, nil
 		}
 
+		// Verify the operation set signature before running the diagnosis, so unsigned or
+		// tampered pipelines are refused in environments which enforce signature verification.
+		if r.signatureVerifier != nil {
+			if err := r.signatureVerifier.Verify(operationset.Annotations, operationset.Spec); err != nil {
+				log.Info("operation set signature verification failed", "operationset", operationset.Name, "reason", err)
+
+				r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeWarning, "DiagnosisFailed", "Failed to run diagnosis %s/%s since signature verification of operation set failed: %v", diagnosis.Namespace, diagnosis.Name, err)
+				diagnosis.Status.Phase = diagnosisv1.DiagnosisFailed
+				util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
+					Type:    diagnosisv1.OperationSetNotVerified,
+					Status:  corev1.ConditionTrue,
+					Reason:  "OperationSetNotVerified",
+					Message: fmt.Sprintf("Signature verification of OperationSet %s failed: %v", operationset.Name, err),
+				})
+				if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
+					return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+				}
+				diagnosisTotalFailCount.Inc()
+				return ctrl.Result{}, nil
+			}
+		}
+
 		// Update hash value calculated from adjacency list of operation set.
 		diagnosisLabels := diagnosis.GetLabels()
 		if diagnosisLabels == nil {
@@ -322,9 +510,15 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		}
 		diagnosisAdjacencyListHash, ok := diagnosisLabels[util.OperationSetUniqueLabelKey]
 		if !ok {
-			diagnosisLabels[util.OperationSetUniqueLabelKey] = util.ComputeHash(operationset.Spec.AdjacencyList)
-			diagnosis.SetLabels(diagnosisLabels)
-			if err := r.Update(ctx, &diagnosis); err != nil {
+			if err := util.UpdateWithRetry(ctx, r.Client, req.NamespacedName, &diagnosis, func() error {
+				labels := diagnosis.GetLabels()
+				if labels == nil {
+					labels = make(map[string]string)
+				}
+				labels[util.OperationSetUniqueLabelKey] = util.ComputeHash(operationset.Spec.AdjacencyList)
+				diagnosis.SetLabels(labels)
+				return nil
+			}); err != nil {
 				return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
 			}
 
@@ -332,28 +526,43 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			return ctrl.Result{}, nil
 		}
 
-		// Validate the graph defined by operation set is not changed.
+		// Validate the graph defined by operation set is not changed. A diagnosis which
+		// started against an earlier specification of the operation set is pinned to the
+		// revision it started with as long as the revision is in the revision history.
 		operationSetLabels := operationset.GetLabels()
 		if operationSetLabels == nil {
 			operationSetLabels = make(map[string]string)
 		}
 		operationSetAdjacencyListHash := operationSetLabels[util.OperationSetUniqueLabelKey]
+		pinnedPaths := operationset.Status.Paths
 		if operationSetAdjacencyListHash != diagnosisAdjacencyListHash {
-			log.Info("hash value caculated from adjacency list has been changed", "new", operationSetAdjacencyListHash, "old", diagnosisAdjacencyListHash)
+			var pinnedRevision *diagnosisv1.OperationSetRevision
+			for i := range operationset.Status.Revisions {
+				if operationset.Status.Revisions[i].Hash == diagnosisAdjacencyListHash {
+					pinnedRevision = &operationset.Status.Revisions[i]
+					break
+				}
+			}
+			if pinnedRevision == nil {
+				log.Info("hash value caculated from adjacency list has been changed", "new", operationSetAdjacencyListHash, "old", diagnosisAdjacencyListHash)
 
-			r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeWarning, "DiagnosisFailed", "Failed to run diagnosis %s/%s since operation set has been changed during execution", diagnosis.Namespace, diagnosis.Name)
-			diagnosis.Status.Phase = diagnosisv1.DiagnosisFailed
-			util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
-				Type:    diagnosisv1.OperationSetChanged,
-				Status:  corev1.ConditionTrue,
-				Reason:  "OperationSetChanged",
-				Message: fmt.Sprintf("OperationSet %s specification has been changed during diagnosis execution", operationset.Name),
-			})
-			if err := r.Status().Update(ctx, &diagnosis); err != nil {
-				return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+				r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeWarning, "DiagnosisFailed", "Failed to run diagnosis %s/%s since operation set has been changed during execution", diagnosis.Namespace, diagnosis.Name)
+				diagnosis.Status.Phase = diagnosisv1.DiagnosisFailed
+				util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
+					Type:    diagnosisv1.OperationSetChanged,
+					Status:  corev1.ConditionTrue,
+					Reason:  "OperationSetChanged",
+					Message: fmt.Sprintf("OperationSet %s specification has been changed during diagnosis execution and the revision the diagnosis started with is not in the revision history", operationset.Name),
+				})
+				if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
+					return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+				}
+				diagnosisTotalFailCount.Inc()
+				return ctrl.Result{}, nil
 			}
-			diagnosisTotalFailCount.Inc()
-			return ctrl.Result{}, nil
+
+			log.Info("diagnosis pinned to operation set revision", "operationset", operationset.Name, "revision", pinnedRevision.Revision)
+			pinnedPaths = pinnedRevision.Paths
 		}
 
 		// Set initial checkpoint before operation execution.
@@ -367,7 +576,7 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 				Failed:            0,
 				SynchronizedTasks: []string{},
 			}
-			if err := r.Status().Update(ctx, &diagnosis); err != nil {
+			if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
 				return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
 			}
 			diagnosisTotalFailCount.Inc()
@@ -376,7 +585,7 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 		// Retrieve operation node information.
 		checkpoint := diagnosis.Status.Checkpoint
-		paths := operationset.Status.Paths
+		paths := pinnedPaths
 		if checkpoint.PathIndex >= len(paths) {
 			return ctrl.Result{}, fmt.Errorf("invalid path index %d of length %d", checkpoint.PathIndex, len(paths))
 		}
@@ -386,14 +595,82 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		}
 		node := path[checkpoint.NodeIndex]
 
+		// Surface the position of the pipeline in the status, so users can see how far a
+		// long diagnosis has progressed without decoding the checkpoint. The fields are
+		// persisted by the status patch of the current reconciliation.
+		diagnosis.Status.CurrentPathIndex = checkpoint.PathIndex
+		diagnosis.Status.CurrentOperation = node.Operation
+		diagnosis.Status.CompletedNodes = checkpoint.NodeIndex
+		diagnosis.Status.TotalNodes = len(path)
+
+		// Only traverse into the node if the results of the parent operation match the
+		// edge condition of the node. A path with an unmatched edge condition is skipped
+		// without being marked as failed, so one operation set can encode a decision tree
+		// branching on operation results.
+		if checkpoint.NodeIndex > 0 && len(node.When) != 0 {
+			matched, err := matchEdgeCondition(&diagnosis, node, path[checkpoint.NodeIndex-1], checkpoint.PathIndex, checkpoint.NodeIndex)
+			if err != nil {
+				r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeWarning, "InvalidEdgeCondition", "Edge condition of operation %s is invalid: %v", node.Operation, err)
+			}
+			if !matched {
+				log.Info("skipping path since edge condition is not matched", "path", checkpoint.PathIndex, "operation", node.Operation)
+				r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeNormal, "PathSkipped", "Skipped path %d since results of operation %s did not match the edge condition of operation %s", checkpoint.PathIndex, path[checkpoint.NodeIndex-1].Operation, node.Operation)
+
+				// Set phase to failed if the skipped path is the last path.
+				if checkpoint.PathIndex == len(paths)-1 {
+					r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeWarning, "DiagnosisFailed", "Failed to run diagnosis %s/%s since no remaining path matched its edge conditions", diagnosis.Namespace, diagnosis.Name)
+					diagnosis.Status.Phase = diagnosisv1.DiagnosisFailed
+					if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
+						return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+					}
+					diagnosisTotalFailCount.Inc()
+					return ctrl.Result{}, nil
+				}
+
+				checkpoint.PathIndex++
+				checkpoint.NodeIndex = 0
+				checkpoint.Active = 0
+				checkpoint.Desired = 0
+				checkpoint.Succeeded = 0
+				checkpoint.Failed = 0
+				checkpoint.SynchronizedTasks = []string{}
+				checkpoint.Siblings = nil
+				if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
+					return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+				}
+				return ctrl.Result{}, nil
+			}
+		}
+
 		// Set desired number of tasks.
 		desired := diagnosis.Status.Checkpoint.Desired
 		active := diagnosis.Status.Checkpoint.Active
 		succeeded := diagnosis.Status.Checkpoint.Succeeded
 		failed := diagnosis.Status.Checkpoint.Failed
 		if diagnosis.Status.Checkpoint.Desired == 0 {
-			diagnosis.Status.Checkpoint.Desired = len(diagnosis.Status.NodeNames)
-			if err := r.Status().Update(ctx, &diagnosis); err != nil {
+			// Re-resolve the nodes of referenced pods before tasks are created in case a pod
+			// moved to another node after the diagnosis was accepted.
+			if len(diagnosis.Spec.TargetSelector.PodReferences) != 0 {
+				resolved, notFound, err := r.resolvePodReferenceNodeNames(ctx, &diagnosis)
+				if err != nil {
+					log.Error(err, "unable to fetch Pod")
+					return ctrl.Result{}, err
+				}
+				if notFound != nil {
+					return r.failTargetPodNotFound(ctx, log, &diagnosis, notFound)
+				}
+				resolved = util.RemoveDuplicateStrings(resolved)
+				if len(resolved) != 0 {
+					diagnosis.Status.NodeNames = resolved
+				}
+			}
+
+			if len(diagnosis.Status.PodReferences) != 0 {
+				diagnosis.Status.Checkpoint.Desired = len(diagnosis.Status.PodReferences)
+			} else {
+				diagnosis.Status.Checkpoint.Desired = len(diagnosis.Status.NodeNames)
+			}
+			if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
 				return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
 			}
 			return ctrl.Result{}, nil
@@ -401,68 +678,61 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 		// Create tasks for current checkpoint.
 		if active+succeeded+failed != desired {
-			for _, nodeName := range diagnosis.Status.NodeNames {
-				log.Info("creating task", "task", client.ObjectKey{
-					Name:      diagnosis.Name,
-					Namespace: diagnosis.Namespace,
-				}, "diagnosis", client.ObjectKey{
-					Name:      diagnosis.Name,
-					Namespace: diagnosis.Namespace,
-				}, "operationset", operationset.Name, "node", node, "path", path)
-
-				owner := []metav1.OwnerReference{
-					{
-						APIVersion: diagnosis.APIVersion,
-						Kind:       diagnosis.Kind,
-						Name:       diagnosis.Name,
-						UID:        diagnosis.UID,
-					},
-				}
-
-				task := diagnosisv1.Task{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:            diagnosis.Name + "." + string(diagnosis.UID)[0:8] + "." + nodeName + "." + strconv.Itoa(diagnosis.Status.Checkpoint.PathIndex) + "." + strconv.Itoa(diagnosis.Status.Checkpoint.NodeIndex) + "." + node.Operation,
-						Namespace:       diagnosis.Namespace,
-						OwnerReferences: owner,
-					},
-					Spec: diagnosisv1.TaskSpec{
-						Operation: node.Operation,
-						NodeName:  nodeName,
-					},
-				}
+			tasks := r.buildNodeTasks(&diagnosis, node, checkpoint.PathIndex, checkpoint.NodeIndex)
+			created, err := r.createNodeTasks(ctx, log, &diagnosis, &operationset, node, path, checkpoint.PathIndex, tasks)
+			active += created
+			if err != nil {
+				return ctrl.Result{}, err
+			}
 
-				taskLabels := make(map[string]string)
-				taskLabels["diagnosis-namespace"] = diagnosis.Namespace
-				taskLabels["diagnosis-name"] = diagnosis.Name
-				task.SetLabels(taskLabels)
-
-				if err := r.Create(ctx, &task); err != nil {
-					if apierrors.IsAlreadyExists(err) {
-						if task.Status.Phase == "" {
-							task.Status.StartTime = metav1.Now()
-							task.Status.Phase = diagnosisv1.TaskPending
-							if err := r.Status().Update(ctx, &task); err != nil {
-								log.Error(err, "1 unable to update Task")
-								return ctrl.Result{}, client.IgnoreNotFound(err)
-							}
+			// Speculatively execute sibling nodes of alternative paths in parallel with the
+			// current node. A sibling node is the node at the current node index of a later
+			// path sharing the executed prefix of the current path. Results of sibling nodes
+			// are merged into the operation context keyed by path and node index, so a
+			// fallback after a failed operation can adopt results which are already computed.
+			if diagnosis.Spec.Parallelism != nil && *diagnosis.Spec.Parallelism > 1 {
+				budget := int(*diagnosis.Spec.Parallelism) - 1 - len(checkpoint.Siblings)
+				for _, siblingIndex := range siblingPathIndexes(paths, checkpoint.PathIndex, checkpoint.NodeIndex) {
+					if budget <= 0 {
+						break
+					}
+					tracked := false
+					for i := range checkpoint.Siblings {
+						if checkpoint.Siblings[i].PathIndex == siblingIndex {
+							tracked = true
+							break
 						}
+					}
+					if tracked {
 						continue
-					} else {
-						log.Error(err, "unable to create Task")
+					}
+
+					siblingPath := paths[siblingIndex]
+					siblingNode := siblingPath[checkpoint.NodeIndex]
+					if checkpoint.NodeIndex > 0 && len(siblingNode.When) != 0 {
+						matched, err := matchEdgeCondition(&diagnosis, siblingNode, path[checkpoint.NodeIndex-1], checkpoint.PathIndex, checkpoint.NodeIndex)
+						if err != nil || !matched {
+							continue
+						}
+					}
+
+					siblingTasks := r.buildNodeTasks(&diagnosis, siblingNode, siblingIndex, checkpoint.NodeIndex)
+					created, err := r.createNodeTasks(ctx, log, &diagnosis, &operationset, siblingNode, siblingPath, siblingIndex, siblingTasks)
+					if err != nil {
 						return ctrl.Result{}, err
 					}
+					checkpoint.Siblings = append(checkpoint.Siblings, diagnosisv1.SiblingCheckpoint{
+						PathIndex:         siblingIndex,
+						Desired:           desired,
+						Active:            created,
+						SynchronizedTasks: []string{},
+					})
+					budget--
 				}
-				task.Status.StartTime = metav1.Now()
-				task.Status.Phase = diagnosisv1.TaskPending
-				if err := r.Status().Update(ctx, &task); err != nil {
-					log.Error(err, "2 unable to update Task")
-					return ctrl.Result{}, client.IgnoreNotFound(err)
-				}
-				active += 1
 			}
 
 			diagnosis.Status.Checkpoint.Active = active
-			if err := r.Status().Update(ctx, &diagnosis); err != nil {
+			if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
 				return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
 			}
 
@@ -482,32 +752,142 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 				})
 				r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeNormal, "DiagnosisSucceeded", "Running %s/%s diagnosis successfully", diagnosis.Namespace, diagnosis.Name)
 
+				// Set SymptomVerified condition if the succeeded path contains a verification node
+				// which has confirmed that the original symptom has been resolved.
+				for _, succeededNode := range diagnosis.Status.SucceededPath {
+					if succeededNode.Verification {
+						r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeNormal, "SymptomVerified", "Verification operation %s confirmed the symptom has been resolved", succeededNode.Operation)
+						util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
+							Type:    diagnosisv1.SymptomVerified,
+							Status:  corev1.ConditionTrue,
+							Reason:  "SymptomResolved",
+							Message: fmt.Sprintf("Verification operation %s confirmed the symptom has been resolved", succeededNode.Operation),
+						})
+						break
+					}
+				}
+
+				// The overall phase of a fan-out diagnosis is computed from partial results.
+				// The diagnosis succeeds if the operation succeeded on at least one target,
+				// and the condition message records how many targets succeeded.
+				completeMessage := "Diagnosis is completed"
+				if failed != 0 {
+					completeMessage = fmt.Sprintf("Diagnosis is completed. The last operation succeeded on %d of %d targets", succeeded, desired)
+				}
 				util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
 					Type:    diagnosisv1.DiagnosisComplete,
 					Status:  corev1.ConditionTrue,
 					Reason:  "DiagnosisComplete",
-					Message: fmt.Sprintf("Diagnosis is completed"),
+					Message: completeMessage,
 				})
 				diagnosis.Status.Phase = diagnosisv1.DiagnosisSucceeded
-				if err := r.Status().Update(ctx, &diagnosis); err != nil {
+				diagnosis.Status.CompletedNodes = len(path)
+				if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
 					return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
 				}
 				return ctrl.Result{}, nil
 			}
 
 			// Increment node index if path has remaining operations to executed.
+			// Speculatively executed sibling nodes are abandoned once the current node has
+			// succeeded.
 			checkpoint.NodeIndex++
 			checkpoint.Active = 0
 			checkpoint.Desired = 0
 			checkpoint.Succeeded = 0
 			checkpoint.Failed = 0
 			checkpoint.SynchronizedTasks = []string{}
+			checkpoint.Siblings = nil
 		} else if failed == desired {
 			log.Info("failed to execute operation", "diagnosis", client.ObjectKey{
 				Name:      diagnosis.Name,
 				Namespace: diagnosis.Namespace,
 			}, "operationset", operationset.Name, "node", node, "path", path)
-			r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeWarning, "OperationFailed", "Failed to execute operation %s", node.Operation)
+			if node.Verification {
+				// A failed verification node means the recovery operations on the path did not
+				// resolve the symptom. Fall back to an alternative path like any other failure.
+				r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeWarning, "VerificationFailed", "Verification operation %s found the symptom unresolved", node.Operation)
+				util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
+					Type:    diagnosisv1.SymptomVerified,
+					Status:  corev1.ConditionFalse,
+					Reason:  "SymptomUnresolved",
+					Message: fmt.Sprintf("Verification operation %s found the symptom unresolved", node.Operation),
+				})
+			} else {
+				r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeWarning, "OperationFailed", "Failed to execute operation %s", node.Operation)
+			}
+
+			// Resolve the failure policy of the operation set. A failed verification node
+			// always falls back to an alternative path, since ignoring an unresolved symptom
+			// would wrongly complete the diagnosis.
+			failurePolicy := operationset.Spec.FailurePolicy
+			if failurePolicy == "" {
+				failurePolicy = diagnosisv1.FailurePolicyContinueSiblingPaths
+			}
+
+			// Record the ignored failure and continue with the next operation on the same
+			// path. The failed node is not appended to the succeeded path.
+			if failurePolicy == diagnosisv1.FailurePolicyIgnoreAndContinue && !node.Verification {
+				r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeNormal, "OperationFailureIgnored", "Ignored failure of operation %s on path %d", node.Operation, checkpoint.PathIndex)
+
+				// Complete the diagnosis if the ignored failure was on the last operation of
+				// the path.
+				if checkpoint.NodeIndex == len(path)-1 {
+					log.Info("running diagnosis successfully with ignored operation failure", "diagnosis", client.ObjectKey{
+						Name:      diagnosis.Name,
+						Namespace: diagnosis.Namespace,
+					})
+					r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeNormal, "DiagnosisSucceeded", "Running %s/%s diagnosis successfully", diagnosis.Namespace, diagnosis.Name)
+					util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
+						Type:    diagnosisv1.DiagnosisComplete,
+						Status:  corev1.ConditionTrue,
+						Reason:  "DiagnosisComplete",
+						Message: fmt.Sprintf("Diagnosis is completed. The failure of operation %s was ignored", node.Operation),
+					})
+					diagnosis.Status.Phase = diagnosisv1.DiagnosisSucceeded
+					diagnosis.Status.CompletedNodes = len(path)
+					if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
+						return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+					}
+					return ctrl.Result{}, nil
+				}
+
+				checkpoint.NodeIndex++
+				checkpoint.Active = 0
+				checkpoint.Desired = 0
+				checkpoint.Succeeded = 0
+				checkpoint.Failed = 0
+				checkpoint.SynchronizedTasks = []string{}
+				checkpoint.Siblings = nil
+				if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
+					return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+				}
+				if diagnosis.Spec.ActiveDeadlineSeconds != nil && !diagnosis.Status.StartTime.IsZero() {
+					deadline := diagnosis.Status.StartTime.Add(time.Duration(*diagnosis.Spec.ActiveDeadlineSeconds) * time.Second)
+					return ctrl.Result{RequeueAfter: time.Until(deadline)}, nil
+				}
+				return ctrl.Result{}, nil
+			}
+
+			// Fail the diagnosis without falling back to an alternative path, so operations
+			// on other paths never run after the failure.
+			if failurePolicy == diagnosisv1.FailurePolicyAbort {
+				log.Info("aborting diagnosis on operation failure", "diagnosis", client.ObjectKey{
+					Name:      diagnosis.Name,
+					Namespace: diagnosis.Namespace,
+				}, "operation", node.Operation)
+				if diagnosis.Status.FailedPaths == nil {
+					diagnosis.Status.FailedPaths = make([]diagnosisv1.Path, 0, len(paths))
+				}
+				diagnosis.Status.FailedPaths = append(diagnosis.Status.FailedPaths, path)
+				diagnosis.Status.SucceededPath = nil
+				r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeWarning, "DiagnosisAborted", "Aborted diagnosis %s/%s on failure of operation %s", diagnosis.Namespace, diagnosis.Name, node.Operation)
+				diagnosis.Status.Phase = diagnosisv1.DiagnosisFailed
+				if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
+					return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+				}
+				return ctrl.Result{}, nil
+			}
 
 			// Set current path as failed path and clear succeeded path if current operation is failed.
 			if diagnosis.Status.FailedPaths == nil {
@@ -524,40 +904,619 @@ func (r *DiagnosisReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 				})
 				r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeWarning, "DiagnosisFailed", "Failed to run diagnosis %s/%s", diagnosis.Namespace, diagnosis.Name)
 				diagnosis.Status.Phase = diagnosisv1.DiagnosisFailed
-				if err := r.Status().Update(ctx, &diagnosis); err != nil {
+				if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
 					return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
 				}
 				return ctrl.Result{}, nil
 			}
 
+			// Adopt the speculatively executed sibling node of the next path if it shares
+			// the executed prefix of the failed path, so results which are already computed
+			// are reused instead of re-running the path from its start.
+			adopted := false
+			for i := range checkpoint.Siblings {
+				if checkpoint.Siblings[i].PathIndex != checkpoint.PathIndex+1 {
+					continue
+				}
+				sibling := checkpoint.Siblings[i]
+
+				// Make the results of the executed prefix addressable under the adopted
+				// path index, so edge conditions and operation results of the adopted
+				// path resolve deterministically.
+				if diagnosis.Status.Context != nil {
+					for index := 0; index < checkpoint.NodeIndex; index++ {
+						oldKey := strconv.Itoa(checkpoint.PathIndex) + "." + strconv.Itoa(index) + "." + path[index].Operation
+						if value, ok := diagnosis.Status.Context.Operations[oldKey]; ok {
+							newKey := strconv.Itoa(sibling.PathIndex) + "." + strconv.Itoa(index) + "." + path[index].Operation
+							diagnosis.Status.Context.Operations[newKey] = value
+						}
+					}
+				}
+
+				// The executed prefix of the adopted path has already succeeded.
+				diagnosis.Status.SucceededPath = append(diagnosisv1.Path{}, path[0:checkpoint.NodeIndex]...)
+				checkpoint.PathIndex = sibling.PathIndex
+				checkpoint.Desired = sibling.Desired
+				checkpoint.Active = sibling.Active
+				checkpoint.Succeeded = sibling.Succeeded
+				checkpoint.Failed = sibling.Failed
+				checkpoint.SynchronizedTasks = sibling.SynchronizedTasks
+				checkpoint.Siblings = append(checkpoint.Siblings[0:i], checkpoint.Siblings[i+1:]...)
+				adopted = true
+				break
+			}
+
 			// Increment path index if paths has remaining paths to executed.
-			checkpoint.PathIndex++
-			checkpoint.NodeIndex = 0
-			checkpoint.Active = 0
-			checkpoint.Desired = 0
-			checkpoint.Succeeded = 0
-			checkpoint.Failed = 0
-			checkpoint.SynchronizedTasks = []string{}
+			if !adopted {
+				checkpoint.PathIndex++
+				checkpoint.NodeIndex = 0
+				checkpoint.Active = 0
+				checkpoint.Desired = 0
+				checkpoint.Succeeded = 0
+				checkpoint.Failed = 0
+				checkpoint.SynchronizedTasks = []string{}
+				checkpoint.Siblings = nil
+			}
 		}
 
-		if err := r.Status().Update(ctx, &diagnosis); err != nil {
+		if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
 			return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
 		}
 
+		// Requeue at the active deadline so the diagnosis is terminated in time even if no
+		// task update triggers a reconcilement.
+		if diagnosis.Spec.ActiveDeadlineSeconds != nil && !diagnosis.Status.StartTime.IsZero() {
+			deadline := diagnosis.Status.StartTime.Add(time.Duration(*diagnosis.Spec.ActiveDeadlineSeconds) * time.Second)
+			return ctrl.Result{RequeueAfter: time.Until(deadline)}, nil
+		}
+
 		return ctrl.Result{}, nil
 	case diagnosisv1.DiagnosisFailed:
 		diagnosisTotalFailCount.Inc()
+		r.notifyCompletedDiagnosis(ctx, log, &diagnosis)
 	case diagnosisv1.DiagnosisSucceeded:
 		diagnosisTotalSuccessCount.Inc()
+		r.notifyCompletedDiagnosis(ctx, log, &diagnosis)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// syncDryRun resolves the operation set of the diagnosis, validates all referenced operations
+// and reports the would-be execution plan in the DryRunCompleted condition. No task is created.
+func (r *DiagnosisReconciler) syncDryRun(ctx context.Context, log logr.Logger, diagnosis *diagnosisv1.Diagnosis) (ctrl.Result, error) {
+	log.Info("validating Diagnosis in dry run mode", "diagnosis", client.ObjectKey{
+		Name:      diagnosis.Name,
+		Namespace: diagnosis.Namespace,
+	})
+
+	var operationset diagnosisv1.OperationSet
+	if err := r.Get(ctx, client.ObjectKey{
+		Name: diagnosis.Spec.OperationSet,
+	}, &operationset); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.failDryRun(ctx, diagnosis, diagnosisv1.OperationSetNotFound, "OperationSetNotFound", fmt.Sprintf("OperationSet %s is not found", diagnosis.Spec.OperationSet))
+		}
+		return ctrl.Result{}, err
+	}
+
+	if !operationset.Status.Ready {
+		return r.failDryRun(ctx, diagnosis, diagnosisv1.OperationSetNotReady, "OperationSetNotReady", fmt.Sprintf("OperationSet %s is not ready because the graph has not been updated according to the latest specification", operationset.Name))
+	}
+	if r.signatureVerifier != nil {
+		if err := r.signatureVerifier.Verify(operationset.Annotations, operationset.Spec); err != nil {
+			return r.failDryRun(ctx, diagnosis, diagnosisv1.OperationSetNotVerified, "OperationSetNotVerified", fmt.Sprintf("Signature verification of OperationSet %s failed: %v", operationset.Name, err))
+		}
+	}
+
+	// Validate every operation referenced by the graph exists and declares a processor.
+	for _, node := range operationset.Spec.AdjacencyList {
+		if node.Operation == "" {
+			continue
+		}
+		var operation diagnosisv1.Operation
+		if err := r.Get(ctx, client.ObjectKey{
+			Name: node.Operation,
+		}, &operation); err != nil {
+			if apierrors.IsNotFound(err) {
+				return r.failDryRun(ctx, diagnosis, diagnosisv1.DryRunCompleted, "OperationNotFound", fmt.Sprintf("Operation %s referenced by OperationSet %s is not found", node.Operation, operationset.Name))
+			}
+			return ctrl.Result{}, err
+		}
+		if operation.Spec.Processor.HTTPServer == nil && operation.Spec.Processor.GRPCServer == nil && operation.Spec.Processor.ScriptRunner == nil && operation.Spec.Processor.Function == nil {
+			return r.failDryRun(ctx, diagnosis, diagnosisv1.DryRunCompleted, "InvalidProcessor", fmt.Sprintf("Operation %s does not declare a processor", operation.Name))
+		}
+	}
+
+	// Report the would-be execution plan.
+	plans := make([]string, 0, len(operationset.Status.Paths))
+	for index, path := range operationset.Status.Paths {
+		operations := make([]string, 0, len(path))
+		for _, node := range path {
+			if node.Operation != "" {
+				operations = append(operations, node.Operation)
+			}
+		}
+		plans = append(plans, fmt.Sprintf("path %d: %s", index, strings.Join(operations, " -> ")))
+	}
+
+	r.eventRecorder.Eventf(diagnosis, corev1.EventTypeNormal, "DiagnosisDryRunSucceeded", "Dry run of diagnosis %s/%s succeeded", diagnosis.Namespace, diagnosis.Name)
+	diagnosis.Status.Phase = diagnosisv1.DiagnosisSucceeded
+	util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
+		Type:    diagnosisv1.DryRunCompleted,
+		Status:  corev1.ConditionTrue,
+		Reason:  "DryRunSucceeded",
+		Message: fmt.Sprintf("Diagnosis is valid and would execute %s", strings.Join(plans, "; ")),
+	})
+	if err := util.PatchDiagnosisStatus(ctx, r.Client, diagnosis); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// resolvePodReferenceNodeNames resolves the nodes the pods referenced by the target selector
+// are scheduled on. The returned pod reference is the first referenced pod which does not
+// exist, or nil if all referenced pods exist.
+func (r *DiagnosisReconciler) resolvePodReferenceNodeNames(ctx context.Context, diagnosis *diagnosisv1.Diagnosis) ([]string, *diagnosisv1.PodReference, error) {
+	nodeNames := make([]string, 0, len(diagnosis.Spec.TargetSelector.PodReferences))
+	for _, podReference := range diagnosis.Spec.TargetSelector.PodReferences {
+		var pod corev1.Pod
+		if err := r.Get(ctx, client.ObjectKey{
+			Name:      podReference.Name,
+			Namespace: podReference.Namespace,
+		}, &pod); err != nil {
+			if apierrors.IsNotFound(err) {
+				podReference := podReference
+				return nil, &podReference, nil
+			}
+			return nil, nil, err
+		}
+		if pod.Spec.NodeName != "" {
+			nodeNames = append(nodeNames, pod.Spec.NodeName)
+		}
+	}
+
+	return nodeNames, nil, nil
+}
+
+// failTargetPodNotFound fails the diagnosis because a pod referenced by the target selector is
+// not found.
+func (r *DiagnosisReconciler) failTargetPodNotFound(ctx context.Context, log logr.Logger, diagnosis *diagnosisv1.Diagnosis, podReference *diagnosisv1.PodReference) (ctrl.Result, error) {
+	log.Info("pod referenced by target selector is not found", "pod", client.ObjectKey{
+		Name:      podReference.Name,
+		Namespace: podReference.Namespace,
+	})
+
+	r.eventRecorder.Eventf(diagnosis, corev1.EventTypeWarning, "DiagnosisFailed", "Failed to run diagnosis %s/%s since pod %s/%s in target selector is not found", diagnosis.Namespace, diagnosis.Name, podReference.Namespace, podReference.Name)
+	diagnosis.Status.Phase = diagnosisv1.DiagnosisFailed
+	util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
+		Type:    diagnosisv1.TargetPodNotFound,
+		Status:  corev1.ConditionTrue,
+		Reason:  "TargetPodNotFound",
+		Message: fmt.Sprintf("Pod %s/%s in target selector is not found", podReference.Namespace, podReference.Name),
+	})
+	if err := util.PatchDiagnosisStatus(ctx, r.Client, diagnosis); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
 	}
+	diagnosisTotalFailCount.Inc()
 
 	return ctrl.Result{}, nil
 }
 
+// failDeadlineExceeded fails the diagnosis because it has been active longer than its active
+// deadline. Unfinished tasks of the diagnosis are deleted so in-flight operations are cancelled.
+// buildNodeTasks builds the tasks to run the operation of the node on every target of the
+// diagnosis. One task is built per pod matched by the target selector if the diagnosis has
+// recorded pod references, and one task per node name otherwise.
+func (r *DiagnosisReconciler) buildNodeTasks(diagnosis *diagnosisv1.Diagnosis, node diagnosisv1.Node, pathIndex int, nodeIndex int) []diagnosisv1.Task {
+	owner := []metav1.OwnerReference{
+		{
+			APIVersion: diagnosis.APIVersion,
+			Kind:       diagnosis.Kind,
+			Name:       diagnosis.Name,
+			UID:        diagnosis.UID,
+		},
+	}
+
+	tasks := make([]diagnosisv1.Task, 0, len(diagnosis.Status.PodReferences)+len(diagnosis.Status.NodeNames))
+	if len(diagnosis.Status.PodReferences) != 0 {
+		// Fan out one task per pod matched by the pod selector. The node name of the
+		// task is backfilled from the pod by the master, so the task is picked up by
+		// the agent on the node the pod is on.
+		for _, podReference := range diagnosis.Status.PodReferences {
+			podReference := podReference
+			tasks = append(tasks, diagnosisv1.Task{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            diagnosis.Name + "." + string(diagnosis.UID)[0:8] + "." + podReference.Name + "." + strconv.Itoa(pathIndex) + "." + strconv.Itoa(nodeIndex) + "." + node.Operation,
+					Namespace:       diagnosis.Namespace,
+					OwnerReferences: owner,
+				},
+				Spec: diagnosisv1.TaskSpec{
+					Operation:      node.Operation,
+					PodReference:   &podReference,
+					ParameterFrom:  diagnosis.Spec.ParameterFrom,
+					Priority:       diagnosis.Spec.Priority,
+					TimeoutSeconds: node.TimeoutSeconds,
+					Retries:        node.Retries,
+					BackoffSeconds: node.BackoffSeconds,
+				},
+			})
+		}
+	} else {
+		for _, nodeName := range diagnosis.Status.NodeNames {
+			tasks = append(tasks, diagnosisv1.Task{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            diagnosis.Name + "." + string(diagnosis.UID)[0:8] + "." + nodeName + "." + strconv.Itoa(pathIndex) + "." + strconv.Itoa(nodeIndex) + "." + node.Operation,
+					Namespace:       diagnosis.Namespace,
+					OwnerReferences: owner,
+				},
+				Spec: diagnosisv1.TaskSpec{
+					Operation:      node.Operation,
+					NodeName:       nodeName,
+					ParameterFrom:  diagnosis.Spec.ParameterFrom,
+					Priority:       diagnosis.Spec.Priority,
+					TimeoutSeconds: node.TimeoutSeconds,
+					Retries:        node.Retries,
+					BackoffSeconds: node.BackoffSeconds,
+				},
+			})
+		}
+	}
+
+	return tasks
+}
+
+// createNodeTasks creates the built tasks of a node and marks them pending. Every task is
+// labeled with the index of the path its node belongs to, so completed tasks are credited
+// to the checkpoint of the path they were created for. It returns the number of tasks which
+// were created.
+func (r *DiagnosisReconciler) createNodeTasks(ctx context.Context, log logr.Logger, diagnosis *diagnosisv1.Diagnosis, operationset *diagnosisv1.OperationSet, node diagnosisv1.Node, path diagnosisv1.Path, pathIndex int, tasks []diagnosisv1.Task) (int, error) {
+	created := 0
+	for i := range tasks {
+		task := tasks[i]
+
+		log.Info("creating task", "task", client.ObjectKey{
+			Name:      task.Name,
+			Namespace: task.Namespace,
+		}, "diagnosis", client.ObjectKey{
+			Name:      diagnosis.Name,
+			Namespace: diagnosis.Namespace,
+		}, "operationset", operationset.Name, "node", node, "path", path)
+
+		taskLabels := make(map[string]string)
+		taskLabels["diagnosis-namespace"] = diagnosis.Namespace
+		taskLabels["diagnosis-name"] = diagnosis.Name
+		taskLabels["path-index"] = strconv.Itoa(pathIndex)
+		task.SetLabels(taskLabels)
+
+		// Mark tasks of a replayed diagnosis, so agents skip node mutating operations.
+		if diagnosis.Spec.ReplayOf != nil {
+			task.SetAnnotations(map[string]string{util.ReplayAnnotation: "true"})
+		}
+
+		if err := r.Create(ctx, &task); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				if task.Status.Phase == "" {
+					task.Status.StartTime = metav1.Now()
+					task.Status.Phase = diagnosisv1.TaskPending
+					if err := util.PatchTaskStatus(ctx, r.Client, &task); err != nil {
+						log.Error(err, "1 unable to update Task")
+						return created, client.IgnoreNotFound(err)
+					}
+				}
+				continue
+			} else {
+				log.Error(err, "unable to create Task")
+				return created, err
+			}
+		}
+		task.Status.StartTime = metav1.Now()
+		task.Status.Phase = diagnosisv1.TaskPending
+		if err := util.PatchTaskStatus(ctx, r.Client, &task); err != nil {
+			log.Error(err, "2 unable to update Task")
+			return created, client.IgnoreNotFound(err)
+		}
+		created += 1
+	}
+
+	if created > 0 {
+		r.eventRecorder.Eventf(diagnosis, corev1.EventTypeNormal, "OperationStarted", "Started operation %s of path %d on %d targets", node.Operation, pathIndex, created)
+	}
+
+	return created, nil
+}
+
+// siblingPathIndexes returns the indexes of later paths which share the executed prefix of
+// the current path and branch into a different node at the current node index.
+func siblingPathIndexes(paths []diagnosisv1.Path, pathIndex int, nodeIndex int) []int {
+	indexes := make([]int, 0)
+	path := paths[pathIndex]
+	for index := pathIndex + 1; index < len(paths); index++ {
+		candidate := paths[index]
+		if len(candidate) <= nodeIndex {
+			continue
+		}
+		if candidate[nodeIndex].ID == path[nodeIndex].ID {
+			continue
+		}
+		shared := true
+		for i := 0; i < nodeIndex; i++ {
+			if candidate[i].ID != path[i].ID {
+				shared = false
+				break
+			}
+		}
+		if shared {
+			indexes = append(indexes, index)
+		}
+	}
+
+	return indexes
+}
+
+// matchEdgeCondition reports whether the results of the parent operation match all
+// expressions of the edge condition of the node. The results of the parent operation are
+// looked up under the provided path and node index, and the condition is satisfied if the
+// results of any task of the parent operation match every expression.
+func matchEdgeCondition(diagnosis *diagnosisv1.Diagnosis, node diagnosisv1.Node, parent diagnosisv1.Node, pathIndex int, nodeIndex int) (bool, error) {
+	if diagnosis.Status.Context == nil {
+		return false, nil
+	}
+	operationValue, ok := diagnosis.Status.Context.Operations[strconv.Itoa(pathIndex)+"."+strconv.Itoa(nodeIndex-1)+"."+parent.Operation]
+	if !ok {
+		return false, nil
+	}
+
+	for _, results := range operationValue {
+		matched := true
+		for key, expression := range node.When {
+			regex, err := regexp.Compile(expression)
+			if err != nil {
+				return false, fmt.Errorf("invalid expression %s of result key %s: %s", expression, key, err)
+			}
+			value, ok := results[key]
+			if !ok || !regex.MatchString(value) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *DiagnosisReconciler) failDeadlineExceeded(ctx context.Context, log logr.Logger, diagnosis *diagnosisv1.Diagnosis) (ctrl.Result, error) {
+	log.Info("diagnosis was active longer than specified deadline", "diagnosis", client.ObjectKey{
+		Name:      diagnosis.Name,
+		Namespace: diagnosis.Namespace,
+	}, "activeDeadlineSeconds", *diagnosis.Spec.ActiveDeadlineSeconds)
+
+	// Delete unfinished tasks of the diagnosis so agents stop processing cancelled operations.
+	var taskList diagnosisv1.TaskList
+	if err := r.List(ctx, &taskList, client.InNamespace(diagnosis.Namespace), client.MatchingLabels{
+		"diagnosis-namespace": diagnosis.Namespace,
+		"diagnosis-name":      diagnosis.Name,
+	}); err != nil {
+		log.Error(err, "unable to list Tasks")
+		return ctrl.Result{}, err
+	}
+	for _, task := range taskList.Items {
+		if task.Status.Phase == diagnosisv1.TaskSucceeded || task.Status.Phase == diagnosisv1.TaskFailed {
+			continue
+		}
+		if err := r.Delete(ctx, &task); err != nil && !apierrors.IsNotFound(err) {
+			log.Error(err, "unable to delete Task")
+			return ctrl.Result{}, err
+		}
+	}
+
+	r.eventRecorder.Eventf(diagnosis, corev1.EventTypeWarning, "DeadlineExceeded", "Diagnosis %s/%s was active longer than specified deadline of %d seconds", diagnosis.Namespace, diagnosis.Name, *diagnosis.Spec.ActiveDeadlineSeconds)
+	diagnosis.Status.Phase = diagnosisv1.DiagnosisFailed
+	util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
+		Type:    diagnosisv1.DeadlineExceeded,
+		Status:  corev1.ConditionTrue,
+		Reason:  "DeadlineExceeded",
+		Message: fmt.Sprintf("Diagnosis was active longer than specified deadline of %d seconds", *diagnosis.Spec.ActiveDeadlineSeconds),
+	})
+	if err := util.PatchDiagnosisStatus(ctx, r.Client, diagnosis); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+	}
+	diagnosisTotalFailCount.Inc()
+
+	return ctrl.Result{}, nil
+}
+
+// resolveDependencies fetches the diagnoses this diagnosis depends on. It returns the
+// dependencies once all of them have succeeded, a reference to the first failed dependency
+// if any dependency has failed, and nil dependencies while a dependency has not completed.
+// The DependenciesReady condition is updated to reflect the dependency the diagnosis is
+// waiting for.
+func (r *DiagnosisReconciler) resolveDependencies(ctx context.Context, log logr.Logger, diagnosis *diagnosisv1.Diagnosis) ([]diagnosisv1.Diagnosis, *diagnosisv1.NamespacedName, error) {
+	dependencies := make([]diagnosisv1.Diagnosis, 0, len(diagnosis.Spec.DependsOn))
+	for _, reference := range diagnosis.Spec.DependsOn {
+		namespace := reference.Namespace
+		if namespace == "" {
+			namespace = diagnosis.Namespace
+		}
+
+		var dependency diagnosisv1.Diagnosis
+		if err := r.Get(ctx, client.ObjectKey{
+			Namespace: namespace,
+			Name:      reference.Name,
+		}, &dependency); err != nil {
+			if !apierrors.IsNotFound(err) {
+				log.Error(err, "unable to fetch Diagnosis")
+				return nil, nil, err
+			}
+
+			// The dependency may not have been created yet. Wait for it instead of failing,
+			// so diagnoses of a staged investigation can be created in any order.
+			return nil, nil, r.markDependenciesNotReady(ctx, diagnosis, fmt.Sprintf("Waiting for diagnosis %s/%s which is not found", namespace, reference.Name))
+		}
+
+		if dependency.Status.Phase == diagnosisv1.DiagnosisFailed {
+			return nil, &diagnosisv1.NamespacedName{Namespace: namespace, Name: reference.Name}, nil
+		}
+		if dependency.Status.Phase != diagnosisv1.DiagnosisSucceeded {
+			return nil, nil, r.markDependenciesNotReady(ctx, diagnosis, fmt.Sprintf("Waiting for diagnosis %s/%s in %s phase", namespace, reference.Name, dependency.Status.Phase))
+		}
+
+		dependencies = append(dependencies, dependency)
+	}
+
+	return dependencies, nil, nil
+}
+
+// markDependenciesNotReady records the dependency the diagnosis is waiting for in the
+// DependenciesReady condition. The status is only patched if the condition has changed, so
+// waiting does not generate a write on every requeue.
+func (r *DiagnosisReconciler) markDependenciesNotReady(ctx context.Context, diagnosis *diagnosisv1.Diagnosis, message string) error {
+	if util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
+		Type:    diagnosisv1.DependenciesReady,
+		Status:  corev1.ConditionFalse,
+		Reason:  "WaitingForDependencies",
+		Message: message,
+	}) {
+		if err := util.PatchDiagnosisStatus(ctx, r.Client, diagnosis); err != nil {
+			return fmt.Errorf("unable to update Diagnosis: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// failDependencyFailed fails the diagnosis because a diagnosis it depends on has failed.
+func (r *DiagnosisReconciler) failDependencyFailed(ctx context.Context, diagnosis *diagnosisv1.Diagnosis, reference *diagnosisv1.NamespacedName) (ctrl.Result, error) {
+	r.eventRecorder.Eventf(diagnosis, corev1.EventTypeWarning, "DependencyFailed", "Diagnosis %s/%s which diagnosis %s/%s depends on has failed", reference.Namespace, reference.Name, diagnosis.Namespace, diagnosis.Name)
+	diagnosis.Status.Phase = diagnosisv1.DiagnosisFailed
+	util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
+		Type:    diagnosisv1.DependencyFailed,
+		Status:  corev1.ConditionTrue,
+		Reason:  "DependencyFailed",
+		Message: fmt.Sprintf("Diagnosis %s/%s which the diagnosis depends on has failed", reference.Namespace, reference.Name),
+	})
+	if err := util.PatchDiagnosisStatus(ctx, r.Client, diagnosis); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+	}
+	diagnosisTotalFailCount.Inc()
+
+	return ctrl.Result{}, nil
+}
+
+// buildDependencyParameters flattens the operation results of the dependencies into parameters
+// for the diagnosis context. Every result value is keyed by the dependency name, the operation
+// key of the result and the result key, so results of different dependencies and operations do
+// not collide.
+func buildDependencyParameters(log logr.Logger, dependencies []diagnosisv1.Diagnosis) map[string]string {
+	parameters := make(map[string]string)
+	for _, dependency := range dependencies {
+		if dependency.Status.Context == nil {
+			continue
+		}
+		if err := util.DecompressDiagnosisResults(&dependency); err != nil {
+			log.Error(err, "unable to decompress operation results of dependency", "diagnosis", client.ObjectKey{
+				Name:      dependency.Name,
+				Namespace: dependency.Namespace,
+			})
+		}
+		for operationKey, operationValue := range dependency.Status.Context.Operations {
+			for resultKey, taskContext := range operationValue {
+				for key, value := range taskContext {
+					parameters["dependency."+dependency.Name+"."+operationKey+"."+resultKey+"."+key] = value
+				}
+			}
+		}
+	}
+
+	return parameters
+}
+
+// failReplay fails the diagnosis because the diagnosis referenced for replay is invalid.
+func (r *DiagnosisReconciler) failReplay(ctx context.Context, diagnosis *diagnosisv1.Diagnosis, message string) (ctrl.Result, error) {
+	r.eventRecorder.Eventf(diagnosis, corev1.EventTypeWarning, "DiagnosisFailed", "Failed to replay diagnosis %s/%s: %s", diagnosis.Namespace, diagnosis.Name, message)
+	diagnosis.Status.Phase = diagnosisv1.DiagnosisFailed
+	util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
+		Type:    diagnosisv1.ReplaySourceInvalid,
+		Status:  corev1.ConditionTrue,
+		Reason:  "ReplaySourceInvalid",
+		Message: message,
+	})
+	if err := util.PatchDiagnosisStatus(ctx, r.Client, diagnosis); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+	}
+	diagnosisTotalFailCount.Inc()
+
+	return ctrl.Result{}, nil
+}
+
+// failDryRun fails the diagnosis with the condition and records a warning event.
+func (r *DiagnosisReconciler) failDryRun(ctx context.Context, diagnosis *diagnosisv1.Diagnosis, conditionType diagnosisv1.DiagnosisConditionType, reason string, message string) (ctrl.Result, error) {
+	r.eventRecorder.Eventf(diagnosis, corev1.EventTypeWarning, "DiagnosisDryRunFailed", "Dry run of diagnosis %s/%s failed: %s", diagnosis.Namespace, diagnosis.Name, message)
+	diagnosis.Status.Phase = diagnosisv1.DiagnosisFailed
+	status := corev1.ConditionTrue
+	if conditionType == diagnosisv1.DryRunCompleted {
+		status = corev1.ConditionFalse
+	}
+	util.UpdateDiagnosisCondition(&diagnosis.Status, &diagnosisv1.DiagnosisCondition{
+		Type:    conditionType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+	if err := util.PatchDiagnosisStatus(ctx, r.Client, diagnosis); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+	}
+	diagnosisTotalFailCount.Inc()
+
+	return ctrl.Result{}, nil
+}
+
+// notifyCompletedDiagnosis delivers a summary of the completed diagnosis to all configured
+// notifiers. The diagnosis is annotated after delivery so the summary is sent only once.
+func (r *DiagnosisReconciler) notifyCompletedDiagnosis(ctx context.Context, log logr.Logger, diagnosis *diagnosisv1.Diagnosis) {
+	if len(r.notifiers) == 0 && r.annotator == nil {
+		return
+	}
+
+	annotations := diagnosis.GetAnnotations()
+	if annotations[NotifiedAnnotation] == "true" {
+		return
+	}
+
+	summary := notifier.NewDiagnosisSummary(*diagnosis)
+	for _, diagnosisNotifier := range r.notifiers {
+		if err := diagnosisNotifier.Notify(summary); err != nil {
+			log.Error(err, "failed to deliver diagnosis summary")
+		}
+	}
+	if r.annotator != nil {
+		if err := r.annotator.AnnotateDiagnosisCompleted(diagnosis); err != nil {
+			log.Error(err, "failed to create grafana annotation")
+		}
+	}
+
+	if err := util.UpdateWithRetry(ctx, r.Client, client.ObjectKey{Namespace: diagnosis.Namespace, Name: diagnosis.Name}, diagnosis, func() error {
+		annotations := diagnosis.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[NotifiedAnnotation] = "true"
+		diagnosis.SetAnnotations(annotations)
+		return nil
+	}); err != nil {
+		log.Error(err, "unable to update Diagnosis")
+	}
+}
+
 // SetupWithManager setups DiagnosisReconciler with the provided manager.
 func (r *DiagnosisReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&diagnosisv1.Diagnosis{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.maxConcurrentReconciles}).
 		Complete(r)
 }
 
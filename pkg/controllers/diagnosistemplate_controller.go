@@ -0,0 +1,95 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+)
+
+var (
+	diagnosisTemplateInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "diagnosis_template_info",
+			Help: "Information about diagnosis template",
+		},
+		[]string{"name", "operationset"},
+	)
+)
+
+// DiagnosisTemplateReconciler reconciles a DiagnosisTemplate object.
+type DiagnosisTemplateReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// NewDiagnosisTemplateReconciler creates a new DiagnosisTemplateReconciler.
+func NewDiagnosisTemplateReconciler(
+	cli client.Client,
+	log logr.Logger,
+	scheme *runtime.Scheme,
+) *DiagnosisTemplateReconciler {
+	metrics.Registry.MustRegister(diagnosisTemplateInfo)
+	return &DiagnosisTemplateReconciler{
+		Client: cli,
+		Log:    log,
+		Scheme: scheme,
+	}
+}
+
+// +kubebuilder:rbac:groups=diagnosis.kubediag.org,resources=diagnosistemplates,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile synchronizes a DiagnosisTemplate object. Diagnoses are instantiated from templates
+// by the admission webhook, so the reconciler only maintains template metrics.
+func (r *DiagnosisTemplateReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("diagnosistemplate", req.NamespacedName)
+	r.collectDiagnosisTemplateMetrics(ctx, log)
+
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager setups DiagnosisTemplateReconciler with the provided manager.
+func (r *DiagnosisTemplateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&diagnosisv1.DiagnosisTemplate{}).
+		Complete(r)
+}
+
+func (r *DiagnosisTemplateReconciler) collectDiagnosisTemplateMetrics(ctx context.Context, log logr.Logger) {
+	var diagnosisTemplateList diagnosisv1.DiagnosisTemplateList
+	err := r.Client.List(ctx, &diagnosisTemplateList)
+	if err != nil {
+		log.Error(err, "error in collect diagnosis template metrics")
+		return
+	}
+
+	diagnosisTemplateInfo.Reset()
+	for _, template := range diagnosisTemplateList.Items {
+		diagnosisTemplateInfo.WithLabelValues(template.Name, template.Spec.OperationSet).Set(1)
+	}
+	log.Info("collected diagnosis template metrics.")
+}
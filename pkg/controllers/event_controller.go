@@ -25,7 +25,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	"github.com/kubediag/kubediag/pkg/util"
+	"github.com/kubediag/kubediag/pkg/queue"
 )
 
 // EventReconciler reconciles an Event object.
@@ -34,7 +34,7 @@ type EventReconciler struct {
 	Log    logr.Logger
 	Scheme *runtime.Scheme
 
-	eventChainCh chan corev1.Event
+	eventQueue *queue.WorkQueue
 }
 
 // NewEventReconciler creates a new EventReconciler.
@@ -42,13 +42,13 @@ func NewEventReconciler(
 	cli client.Client,
 	log logr.Logger,
 	scheme *runtime.Scheme,
-	eventChainCh chan corev1.Event,
+	eventQueue *queue.WorkQueue,
 ) *EventReconciler {
 	return &EventReconciler{
-		Client:       cli,
-		Log:          log,
-		Scheme:       scheme,
-		eventChainCh: eventChainCh,
+		Client:     cli,
+		Log:        log,
+		Scheme:     scheme,
+		eventQueue: eventQueue,
 	}
 }
 
@@ -66,10 +66,7 @@ func (r *EventReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	err := util.QueueEvent(ctx, r.eventChainCh, event)
-	if err != nil {
-		log.Error(err, "failed to send event to event queue")
-	}
+	r.eventQueue.Add(req.NamespacedName.String())
 
 	return ctrl.Result{}, nil
 }
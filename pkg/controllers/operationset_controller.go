@@ -25,12 +25,18 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/queue"
 	"github.com/kubediag/kubediag/pkg/util"
 )
 
+// defaultRevisionHistoryLimit is the number of adjacency list revisions retained in the
+// status of an operation set if RevisionHistoryLimit is not specified.
+const defaultRevisionHistoryLimit = 10
+
 var (
 	operationsetInfo = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -44,9 +50,14 @@ var (
 // OperationSetReconciler reconciles a OperationSet object.
 type OperationSetReconciler struct {
 	client.Client
-	Log            logr.Logger
-	Scheme         *runtime.Scheme
-	graphBuilderCh chan diagnosisv1.OperationSet
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// operationSetQueue is the rate limited work queue of operation sets to be processed by
+	// the graph builder. Unlike the channel it replaces, pending operation sets are
+	// deduplicated and never dropped under load.
+	operationSetQueue *queue.WorkQueue
+	// maxConcurrentReconciles is the maximum number of operation sets reconciled concurrently.
+	maxConcurrentReconciles int
 }
 
 // NewOperationSetReconciler creates a new OperationSetReconciler.
@@ -54,16 +65,18 @@ func NewOperationSetReconciler(
 	cli client.Client,
 	log logr.Logger,
 	scheme *runtime.Scheme,
-	graphBuilderCh chan diagnosisv1.OperationSet,
+	operationSetQueue *queue.WorkQueue,
+	maxConcurrentReconciles int,
 ) *OperationSetReconciler {
 	metrics.Registry.MustRegister(
 		operationsetInfo,
 	)
 	return &OperationSetReconciler{
-		Client:         cli,
-		Log:            log,
-		Scheme:         scheme,
-		graphBuilderCh: graphBuilderCh,
+		Client:                  cli,
+		Log:                     log,
+		Scheme:                  scheme,
+		operationSetQueue:       operationSetQueue,
+		maxConcurrentReconciles: maxConcurrentReconciles,
 	}
 }
 
@@ -84,6 +97,37 @@ func (r *OperationSetReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// Roll the adjacency list back to a recorded revision if a rollback is requested. The
+	// rollback field is cleared after the rollback is performed and the changed adjacency
+	// list is processed like any other specification change.
+	if operationSet.Spec.RollbackTo != nil {
+		var revision *diagnosisv1.OperationSetRevision
+		for i := range operationSet.Status.Revisions {
+			if operationSet.Status.Revisions[i].Revision == *operationSet.Spec.RollbackTo {
+				revision = &operationSet.Status.Revisions[i]
+				break
+			}
+		}
+		if revision == nil {
+			log.Info("rollback revision is not in the revision history", "revision", *operationSet.Spec.RollbackTo)
+		} else {
+			log.Info("rolling back adjacency list to revision", "revision", revision.Revision)
+		}
+
+		if err := util.UpdateWithRetry(ctx, r.Client, req.NamespacedName, &operationSet, func() error {
+			if revision != nil {
+				operationSet.Spec.AdjacencyList = revision.DeepCopy().AdjacencyList
+			}
+			operationSet.Spec.RollbackTo = nil
+			return nil
+		}); err != nil {
+			log.Error(err, "unable to update OperationSet")
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{}, nil
+	}
+
 	// Update ready status and hash value calculated from adjacency list on specification change.
 	labels := operationSet.GetLabels()
 	if labels == nil {
@@ -103,18 +147,49 @@ func (r *OperationSetReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error
 			}
 		}
 
-		labels[util.OperationSetUniqueLabelKey] = newAdjacencyListHash
-		operationSet.SetLabels(labels)
-		if err := r.Update(ctx, &operationSet); err != nil {
+		if err := util.UpdateWithRetry(ctx, r.Client, req.NamespacedName, &operationSet, func() error {
+			labels := operationSet.GetLabels()
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[util.OperationSetUniqueLabelKey] = newAdjacencyListHash
+			operationSet.SetLabels(labels)
+			return nil
+		}); err != nil {
 			log.Error(err, "unable to update OperationSet")
 			return ctrl.Result{}, err
 		}
 	}
 
 	if !operationSet.Status.Ready {
-		err := util.QueueOperationSet(ctx, r.graphBuilderCh, operationSet)
-		if err != nil {
-			log.Error(err, "failed to send operationSet to graph builder queue")
+		r.operationSetQueue.Add(operationSet.Name)
+
+		return ctrl.Result{}, nil
+	}
+
+	// Record a revision of the adjacency list once the graph has been built, so running
+	// diagnoses can be pinned to the revision they started with and the operation set can
+	// be rolled back to an earlier revision. Old revisions are pruned according to the
+	// revision history limit.
+	if len(operationSet.Status.Revisions) == 0 || operationSet.Status.Revisions[len(operationSet.Status.Revisions)-1].Hash != newAdjacencyListHash {
+		operationSet.Status.Revision++
+		operationSet.Status.Revisions = append(operationSet.Status.Revisions, diagnosisv1.OperationSetRevision{
+			Revision:      operationSet.Status.Revision,
+			Hash:          newAdjacencyListHash,
+			AdjacencyList: operationSet.Spec.AdjacencyList,
+			Paths:         operationSet.Status.Paths,
+		})
+		limit := defaultRevisionHistoryLimit
+		if operationSet.Spec.RevisionHistoryLimit != nil {
+			limit = int(*operationSet.Spec.RevisionHistoryLimit)
+		}
+		if len(operationSet.Status.Revisions) > limit {
+			operationSet.Status.Revisions = operationSet.Status.Revisions[len(operationSet.Status.Revisions)-limit:]
+		}
+		log.Info("recorded adjacency list revision", "revision", operationSet.Status.Revision)
+		if err := r.Status().Update(ctx, &operationSet); err != nil {
+			log.Error(err, "unable to update OperationSet")
+			return ctrl.Result{}, err
 		}
 	}
 
@@ -125,6 +200,7 @@ func (r *OperationSetReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error
 func (r *OperationSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&diagnosisv1.OperationSet{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.maxConcurrentReconciles}).
 		Complete(r)
 }
 
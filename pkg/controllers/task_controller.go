@@ -18,18 +18,25 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 
 	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/queue"
 	"github.com/kubediag/kubediag/pkg/util"
 )
 
@@ -39,8 +46,14 @@ type TaskReconciler struct {
 	Log    logr.Logger
 	Scheme *runtime.Scheme
 
-	nodeName   string
-	executorCh chan diagnosisv1.Task
+	// eventRecorder knows how to record events on behalf of a diagnosis, so the outcome of
+	// every operation is visible on the diagnosis object.
+	eventRecorder record.EventRecorder
+	mode          string
+	nodeName      string
+	taskQueue     *queue.PriorityQueue
+	// maxConcurrentReconciles is the maximum number of tasks reconciled concurrently.
+	maxConcurrentReconciles int
 }
 
 // NewTaskReconciler creates a new TaskReconciler.
@@ -48,15 +61,21 @@ func NewTaskReconciler(
 	cli client.Client,
 	log logr.Logger,
 	scheme *runtime.Scheme,
+	eventRecorder record.EventRecorder,
+	mode string,
 	nodeName string,
-	executorCh chan diagnosisv1.Task,
+	taskQueue *queue.PriorityQueue,
+	maxConcurrentReconciles int,
 ) *TaskReconciler {
 	return &TaskReconciler{
-		Client:     cli,
-		Log:        log,
-		Scheme:     scheme,
-		nodeName:   nodeName,
-		executorCh: executorCh,
+		Client:                  cli,
+		Log:                     log,
+		Scheme:                  scheme,
+		eventRecorder:           eventRecorder,
+		mode:                    mode,
+		nodeName:                nodeName,
+		taskQueue:               taskQueue,
+		maxConcurrentReconciles: maxConcurrentReconciles,
 	}
 }
 
@@ -84,6 +103,12 @@ func (r *TaskReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// The master backfills the node name of tasks which only reference a pod, so agents can
+	// match tasks against their node.
+	if r.mode == "master" {
+		return r.syncPodReference(ctx, log, &task)
+	}
+
 	// The master will process a task which is not found or completed, or has not been accept yet, while the agent will process
 	// a task in Pending and Running phases.
 	if !util.IsTaskNodeNameMatched(task, r.nodeName) {
@@ -104,17 +129,47 @@ func (r *TaskReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			Reason:  "TaskAccepted",
 			Message: fmt.Sprintf("Task is accepted by agent on node %s", task.Spec.NodeName),
 		})
-		if err := r.Status().Update(ctx, &task); err != nil {
+		if err := util.PatchTaskStatus(ctx, r.Client, &task); err != nil {
 			log.Error(err, "unable to update Task")
 			return ctrl.Result{}, client.IgnoreNotFound(err)
 		}
 	case diagnosisv1.TaskRunning:
-		err := util.QueueTask(ctx, r.executorCh, task)
-		if err != nil {
-			log.Error(err, "failed to send diagnosis to executor queue")
+		// Terminate the task if it has been active longer than its active deadline, so a
+		// hanging operation does not keep the task running forever.
+		if task.Spec.ActiveDeadlineSeconds != nil && !task.Status.StartTime.IsZero() {
+			deadline := task.Status.StartTime.Add(time.Duration(*task.Spec.ActiveDeadlineSeconds) * time.Second)
+			if time.Now().After(deadline) {
+				return r.failTaskDeadlineExceeded(ctx, log, &task)
+			}
+		}
+
+		// Do not dispatch operations of a paused diagnosis. The task is requeued and picked
+		// up again once the diagnosis is resumed.
+		if task.Labels["diagnosis-name"] != "" {
+			var diagnosis diagnosisv1.Diagnosis
+			if err := r.Get(ctx, types.NamespacedName{
+				Namespace: task.Namespace,
+				Name:      task.Labels["diagnosis-name"],
+			}, &diagnosis); err == nil && diagnosis.Spec.Paused {
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+			}
 		}
+
+		r.taskQueue.AddWithPriority(req.NamespacedName.String(), task.Spec.Priority)
 		diagnosisAgentQueuedCount.Inc()
+
+		// Requeue the task at its active deadline, so the deadline is enforced even if the
+		// operation hangs and the task is never updated again.
+		if task.Spec.ActiveDeadlineSeconds != nil && !task.Status.StartTime.IsZero() {
+			deadline := task.Status.StartTime.Add(time.Duration(*task.Spec.ActiveDeadlineSeconds) * time.Second)
+			return ctrl.Result{RequeueAfter: time.Until(deadline)}, nil
+		}
 	case diagnosisv1.TaskSucceeded:
+		// Tasks of a task set are not tracked by a diagnosis checkpoint.
+		if task.Labels["taskset-name"] != "" {
+			return ctrl.Result{}, nil
+		}
+
 		diagnosisName := strings.Split(req.Name, ".")[0]
 		var diagnosis diagnosisv1.Diagnosis
 		if err := r.Get(ctx, types.NamespacedName{
@@ -125,6 +180,13 @@ func (r *TaskReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			return ctrl.Result{}, client.IgnoreNotFound(err)
 		}
 
+		// Credit the task to the sibling checkpoint of the path it was created for if it
+		// does not belong to the current path, so speculatively executed sibling nodes are
+		// tracked separately from the current node.
+		if pathIndexValue, ok := task.Labels["path-index"]; ok && pathIndexValue != strconv.Itoa(diagnosis.Status.Checkpoint.PathIndex) {
+			return r.syncSiblingTask(ctx, &diagnosis, &task, req.Name, diagnosisv1.TaskSucceeded)
+		}
+
 		if util.Contains(diagnosis.Status.Checkpoint.SynchronizedTasks, req.Name) {
 			return ctrl.Result{}, nil
 		}
@@ -133,25 +195,82 @@ func (r *TaskReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		diagnosis.Status.Checkpoint.Succeeded += 1
 		diagnosis.Status.Checkpoint.SynchronizedTasks = append(diagnosis.Status.Checkpoint.SynchronizedTasks, req.Name)
 
+		// Track per node status of the fan-out, so partial results are visible while tasks
+		// on other nodes are still running.
+		if task.Spec.NodeName != "" {
+			if diagnosis.Status.NodeStatuses == nil {
+				diagnosis.Status.NodeStatuses = make(map[string]diagnosisv1.TaskPhase)
+			}
+			diagnosis.Status.NodeStatuses[task.Spec.NodeName] = diagnosisv1.TaskSucceeded
+		}
+
 		if diagnosis.Status.Context == nil {
 			diagnosis.Status.Context = new(diagnosisv1.DiagnosisContext)
 			diagnosis.Status.Context.Operations = make(map[string]diagnosisv1.OperationContext, 0)
 		}
 		operationKey := strconv.Itoa(diagnosis.Status.Checkpoint.PathIndex) + "." + strconv.Itoa(diagnosis.Status.Checkpoint.NodeIndex) + "." + task.Spec.Operation
+		// Key results by pod name for tasks fanned out from a pod selector, so results of the
+		// operation on all pods are aggregated side by side.
+		resultKey := req.Name
+		if task.Spec.PodReference != nil {
+			resultKey = task.Spec.PodReference.Name
+		}
 		operationValue, ok := diagnosis.Status.Context.Operations[operationKey]
 		if ok {
-			operationValue[task.Name] = task.Status.Results
+			operationValue[resultKey] = task.Status.Results
 		} else {
 			operationValue = make(map[string]diagnosisv1.TaskContext, 0)
-			operationValue[task.Name] = task.Status.Results
+			operationValue[resultKey] = task.Status.Results
 		}
 		diagnosis.Status.Context.Operations[operationKey] = operationValue
 
-		if err := r.Status().Update(ctx, &diagnosis); err != nil {
+		// Parse the structured result envelope of the task if the processor returned one, so
+		// downstream tooling can consume the result programmatically.
+		if encoded, ok := task.Status.Results[diagnosisv1.ResultEnvelopeKey]; ok {
+			value, err := util.DecompressValue(encoded)
+			if err != nil {
+				log.Error(err, "unable to decompress result envelope")
+			} else {
+				var envelope diagnosisv1.ResultEnvelope
+				if err := json.Unmarshal([]byte(value), &envelope); err != nil {
+					log.Error(err, "unable to parse result envelope")
+				} else {
+					diagnosis.Status.OperationResults = append(diagnosis.Status.OperationResults, diagnosisv1.OperationResult{
+						Operation: task.Spec.Operation,
+						Task:      req.Name,
+						NodeName:  task.Spec.NodeName,
+						Status:    envelope.Status,
+						Summary:   envelope.Summary,
+						Findings:  envelope.Findings,
+						Artifacts: envelope.Artifacts,
+					})
+				}
+			}
+		}
+
+		// Drop the relayed progress of the operation once the task has completed. The entry is
+		// set to nil so the status merge patch removes it from the map.
+		if diagnosis.Status.Progress != nil {
+			diagnosis.Status.Progress[task.Spec.Operation] = nil
+		}
+
+		if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
 			return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
 		}
+		r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeNormal, "OperationSucceeded", "Operation %s completed successfully on node %s", task.Spec.Operation, task.Spec.NodeName)
 		return ctrl.Result{}, nil
 	case diagnosisv1.TaskFailed:
+		// Restart the task if its restart policy allows another run, so a transient failure
+		// is retried with backoff like a job instead of failing the diagnosis permanently.
+		if r.shouldRestartTask(task) {
+			return r.restartTask(ctx, log, &task)
+		}
+
+		// Tasks of a task set are not tracked by a diagnosis checkpoint.
+		if task.Labels["taskset-name"] != "" {
+			return ctrl.Result{}, nil
+		}
+
 		diagnosisName := strings.Split(req.Name, ".")[0]
 		var diagnosis diagnosisv1.Diagnosis
 		if err := r.Get(ctx, types.NamespacedName{
@@ -162,6 +281,13 @@ func (r *TaskReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			return ctrl.Result{}, client.IgnoreNotFound(err)
 		}
 
+		// Credit the task to the sibling checkpoint of the path it was created for if it
+		// does not belong to the current path, so speculatively executed sibling nodes are
+		// tracked separately from the current node.
+		if pathIndexValue, ok := task.Labels["path-index"]; ok && pathIndexValue != strconv.Itoa(diagnosis.Status.Checkpoint.PathIndex) {
+			return r.syncSiblingTask(ctx, &diagnosis, &task, req.Name, diagnosisv1.TaskFailed)
+		}
+
 		if util.Contains(diagnosis.Status.Checkpoint.SynchronizedTasks, req.Name) {
 			return ctrl.Result{}, nil
 		}
@@ -170,9 +296,25 @@ func (r *TaskReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		diagnosis.Status.Checkpoint.Failed += 1
 		diagnosis.Status.Checkpoint.SynchronizedTasks = append(diagnosis.Status.Checkpoint.SynchronizedTasks, req.Name)
 
-		if err := r.Status().Update(ctx, &diagnosis); err != nil {
+		// Track per node status of the fan-out, so partial results are visible while tasks
+		// on other nodes are still running.
+		if task.Spec.NodeName != "" {
+			if diagnosis.Status.NodeStatuses == nil {
+				diagnosis.Status.NodeStatuses = make(map[string]diagnosisv1.TaskPhase)
+			}
+			diagnosis.Status.NodeStatuses[task.Spec.NodeName] = diagnosisv1.TaskFailed
+		}
+
+		// Drop the relayed progress of the operation once the task has completed. The entry is
+		// set to nil so the status merge patch removes it from the map.
+		if diagnosis.Status.Progress != nil {
+			diagnosis.Status.Progress[task.Spec.Operation] = nil
+		}
+
+		if err := util.PatchDiagnosisStatus(ctx, r.Client, &diagnosis); err != nil {
 			return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
 		}
+		r.eventRecorder.Eventf(&diagnosis, corev1.EventTypeWarning, "OperationFailed", "Operation %s failed on node %s: %s", task.Spec.Operation, task.Spec.NodeName, taskFailureMessage(task))
 		return ctrl.Result{}, nil
 	case diagnosisv1.TaskUnknown:
 		log.Info("ignoring Task in phase Unknown")
@@ -181,9 +323,246 @@ func (r *TaskReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	return ctrl.Result{}, nil
 }
 
+// taskFailureMessage returns the message of the most recently transitioned condition of a
+// failed task, so diagnosis events carry the error reported by the operation processor. A
+// generic message is returned if no condition carries a message.
+func taskFailureMessage(task diagnosisv1.Task) string {
+	message := fmt.Sprintf("operation %s reported a failure", task.Spec.Operation)
+	var latest metav1.Time
+	for _, condition := range task.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue || condition.Message == "" {
+			continue
+		}
+		if latest.IsZero() || condition.LastTransitionTime.After(latest.Time) {
+			latest = condition.LastTransitionTime
+			message = condition.Message
+		}
+	}
+
+	return message
+}
+
+// shouldRestartTask reports whether a failed task is restarted according to its restart policy.
+// A task is restarted if its restart policy is OnFailure, its backoff limit has not been
+// reached and its active deadline has not been exceeded.
+func (r *TaskReconciler) shouldRestartTask(task diagnosisv1.Task) bool {
+	if task.Spec.RestartPolicy != diagnosisv1.TaskRestartPolicyOnFailure {
+		return false
+	}
+	if task.Status.RestartCount >= taskBackoffLimit(task) {
+		return false
+	}
+	if task.Spec.ActiveDeadlineSeconds != nil && !task.Status.StartTime.IsZero() {
+		deadline := task.Status.StartTime.Add(time.Duration(*task.Spec.ActiveDeadlineSeconds) * time.Second)
+		if time.Now().After(deadline) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// restartTask restarts a failed task with exponential backoff. The first reconciliation of the
+// failure schedules the restart by recording a Restarted condition, and a later reconciliation
+// moves the task back to Running phase once the backoff delay has elapsed, so a crash looping
+// operation does not hot loop on the node.
+func (r *TaskReconciler) restartTask(ctx context.Context, log logr.Logger, task *diagnosisv1.Task) (ctrl.Result, error) {
+	_, condition := util.GetTaskCondition(&task.Status, diagnosisv1.TaskRestarted)
+	if condition == nil || condition.Status != corev1.ConditionTrue {
+		delay := taskRestartDelay(task.Status.RestartCount)
+		task.Status.RestartCount += 1
+		util.UpdateTaskCondition(&task.Status, &diagnosisv1.TaskCondition{
+			Type:    diagnosisv1.TaskRestarted,
+			Status:  corev1.ConditionTrue,
+			Reason:  "BackoffRestart",
+			Message: fmt.Sprintf("Restart %d of %d scheduled after %s backoff", task.Status.RestartCount, taskBackoffLimit(*task), delay),
+		})
+		if err := util.PatchTaskStatus(ctx, r.Client, task); err != nil {
+			log.Error(err, "unable to update Task")
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+
+	restartTime := condition.LastTransitionTime.Add(taskRestartDelay(task.Status.RestartCount - 1))
+	if time.Now().Before(restartTime) {
+		return ctrl.Result{RequeueAfter: time.Until(restartTime)}, nil
+	}
+
+	log.Info("restarting failed task", "restartCount", task.Status.RestartCount)
+	task.Status.Phase = diagnosisv1.TaskRunning
+	util.UpdateTaskCondition(&task.Status, &diagnosisv1.TaskCondition{
+		Type:    diagnosisv1.TaskRestarted,
+		Status:  corev1.ConditionFalse,
+		Reason:  "RestartPerformed",
+		Message: fmt.Sprintf("Task has been restarted %d of %d times", task.Status.RestartCount, taskBackoffLimit(*task)),
+	})
+	if err := util.PatchTaskStatus(ctx, r.Client, task); err != nil {
+		log.Error(err, "unable to update Task")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// failTaskDeadlineExceeded fails the task because it has been active longer than its active
+// deadline.
+func (r *TaskReconciler) failTaskDeadlineExceeded(ctx context.Context, log logr.Logger, task *diagnosisv1.Task) (ctrl.Result, error) {
+	log.Info("task was active longer than specified deadline", "activeDeadlineSeconds", *task.Spec.ActiveDeadlineSeconds)
+
+	task.Status.Phase = diagnosisv1.TaskFailed
+	util.UpdateTaskCondition(&task.Status, &diagnosisv1.TaskCondition{
+		Type:    diagnosisv1.TaskDeadlineExceeded,
+		Status:  corev1.ConditionTrue,
+		Reason:  "DeadlineExceeded",
+		Message: fmt.Sprintf("Task was active longer than specified deadline of %d seconds", *task.Spec.ActiveDeadlineSeconds),
+	})
+	if err := util.PatchTaskStatus(ctx, r.Client, task); err != nil {
+		log.Error(err, "unable to update Task")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// taskBackoffLimit returns the number of restarts of a failed task before the task is marked
+// as failed permanently.
+func taskBackoffLimit(task diagnosisv1.Task) int32 {
+	if task.Spec.BackoffLimit != nil {
+		return *task.Spec.BackoffLimit
+	}
+
+	return 6
+}
+
+// taskRestartDelay returns the backoff delay before a failed task is restarted. The delay is
+// doubled on every restart and capped at six minutes.
+func taskRestartDelay(restartCount int32) time.Duration {
+	delay := 10 * time.Second
+	for i := int32(0); i < restartCount; i++ {
+		delay = delay * 2
+		if delay >= 6*time.Minute {
+			return 6 * time.Minute
+		}
+	}
+
+	return delay
+}
+
+// syncSiblingTask credits a completed task to the sibling checkpoint of the path the task
+// was created for. Results of a succeeded task are merged into the operation context keyed
+// by the path index of the sibling node, so the merge is deterministic. The task is ignored
+// if the sibling node is no longer tracked by the checkpoint.
+func (r *TaskReconciler) syncSiblingTask(ctx context.Context, diagnosis *diagnosisv1.Diagnosis, task *diagnosisv1.Task, taskName string, phase diagnosisv1.TaskPhase) (ctrl.Result, error) {
+	pathIndexValue := task.Labels["path-index"]
+	var sibling *diagnosisv1.SiblingCheckpoint
+	for i := range diagnosis.Status.Checkpoint.Siblings {
+		if strconv.Itoa(diagnosis.Status.Checkpoint.Siblings[i].PathIndex) == pathIndexValue {
+			sibling = &diagnosis.Status.Checkpoint.Siblings[i]
+			break
+		}
+	}
+	if sibling == nil {
+		return ctrl.Result{}, nil
+	}
+
+	if util.Contains(sibling.SynchronizedTasks, taskName) {
+		return ctrl.Result{}, nil
+	}
+
+	sibling.Active -= 1
+	if phase == diagnosisv1.TaskSucceeded {
+		sibling.Succeeded += 1
+	} else {
+		sibling.Failed += 1
+	}
+	sibling.SynchronizedTasks = append(sibling.SynchronizedTasks, taskName)
+
+	if phase == diagnosisv1.TaskSucceeded {
+		if diagnosis.Status.Context == nil {
+			diagnosis.Status.Context = new(diagnosisv1.DiagnosisContext)
+			diagnosis.Status.Context.Operations = make(map[string]diagnosisv1.OperationContext, 0)
+		}
+		operationKey := pathIndexValue + "." + strconv.Itoa(diagnosis.Status.Checkpoint.NodeIndex) + "." + task.Spec.Operation
+		resultKey := taskName
+		if task.Spec.PodReference != nil {
+			resultKey = task.Spec.PodReference.Name
+		}
+		operationValue, ok := diagnosis.Status.Context.Operations[operationKey]
+		if !ok {
+			operationValue = make(map[string]diagnosisv1.TaskContext, 0)
+		}
+		operationValue[resultKey] = task.Status.Results
+		diagnosis.Status.Context.Operations[operationKey] = operationValue
+	}
+
+	if err := util.PatchDiagnosisStatus(ctx, r.Client, diagnosis); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to update Diagnosis: %s", err)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// syncPodReference resolves the node the referenced pod is scheduled on and backfills the task
+// node name, so agents can match the task against their node. The node name is re-resolved
+// while the task has not been accepted in case the pod moved to another node, and the task
+// fails with the PodNotFound condition if the referenced pod does not exist.
+func (r *TaskReconciler) syncPodReference(ctx context.Context, log logr.Logger, task *diagnosisv1.Task) (ctrl.Result, error) {
+	if task.Spec.PodReference == nil {
+		return ctrl.Result{}, nil
+	}
+	if task.Status.Phase != "" && task.Status.Phase != diagnosisv1.TaskPending {
+		return ctrl.Result{}, nil
+	}
+
+	var pod corev1.Pod
+	if err := r.Get(ctx, client.ObjectKey{
+		Name:      task.Spec.PodReference.Name,
+		Namespace: task.Spec.PodReference.Namespace,
+	}, &pod); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Error(err, "unable to fetch Pod")
+			return ctrl.Result{}, err
+		}
+
+		log.Info("pod referenced by task is not found", "pod", client.ObjectKey{
+			Name:      task.Spec.PodReference.Name,
+			Namespace: task.Spec.PodReference.Namespace,
+		})
+		task.Status.Phase = diagnosisv1.TaskFailed
+		util.UpdateTaskCondition(&task.Status, &diagnosisv1.TaskCondition{
+			Type:    diagnosisv1.PodNotFound,
+			Status:  corev1.ConditionTrue,
+			Reason:  "PodNotFound",
+			Message: fmt.Sprintf("Pod %s/%s referenced by task is not found", task.Spec.PodReference.Namespace, task.Spec.PodReference.Name),
+		})
+		if err := util.PatchTaskStatus(ctx, r.Client, task); err != nil {
+			log.Error(err, "unable to update Task")
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if pod.Spec.NodeName == "" || pod.Spec.NodeName == task.Spec.NodeName {
+		return ctrl.Result{}, nil
+	}
+
+	if err := util.UpdateWithRetry(ctx, r.Client, types.NamespacedName{Namespace: task.Namespace, Name: task.Name}, task, func() error {
+		task.Spec.NodeName = pod.Spec.NodeName
+		return nil
+	}); err != nil {
+		log.Error(err, "unable to update Task")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	log.Info("node name of task backfilled from referenced pod", "node", pod.Spec.NodeName)
+	return ctrl.Result{}, nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *TaskReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&diagnosisv1.Task{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.maxConcurrentReconciles}).
 		Complete(r)
 }
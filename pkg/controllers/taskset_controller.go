@@ -0,0 +1,276 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+// TaskSetReconciler reconciles a TaskSet object.
+type TaskSetReconciler struct {
+	client.Client
+	Log           logr.Logger
+	Scheme        *runtime.Scheme
+	eventRecorder record.EventRecorder
+
+	// maxConcurrentReconciles is the maximum number of task sets reconciled concurrently.
+	maxConcurrentReconciles int
+}
+
+// NewTaskSetReconciler creates a new TaskSetReconciler.
+func NewTaskSetReconciler(
+	cli client.Client,
+	log logr.Logger,
+	scheme *runtime.Scheme,
+	eventRecorder record.EventRecorder,
+	maxConcurrentReconciles int,
+) *TaskSetReconciler {
+	return &TaskSetReconciler{
+		Client:                  cli,
+		Log:                     log,
+		Scheme:                  scheme,
+		eventRecorder:           eventRecorder,
+		maxConcurrentReconciles: maxConcurrentReconciles,
+	}
+}
+
+// +kubebuilder:rbac:groups=diagnosis.kubediag.org,resources=tasksets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=diagnosis.kubediag.org,resources=tasksets/status,verbs=get;update;patch
+
+// Reconcile synchronizes a TaskSet object according to the phase. Tasks without dependences
+// are created first and a task is created once all tasks it depends on have succeeded.
+func (r *TaskSetReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("taskset", req.NamespacedName)
+
+	log.Info("reconciling TaskSet")
+
+	var taskSet diagnosisv1.TaskSet
+	if err := r.Get(ctx, req.NamespacedName, &taskSet); err != nil {
+		log.Error(err, "unable to fetch TaskSet")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	switch taskSet.Status.Phase {
+	case "":
+		if err := validateTaskSet(&taskSet); err != nil {
+			log.Error(err, "ignoring invalid TaskSet")
+
+			r.eventRecorder.Eventf(&taskSet, corev1.EventTypeWarning, "TaskSetFailed", "Failed to run task set %s/%s: %v", taskSet.Namespace, taskSet.Name, err)
+			taskSet.Status.StartTime = metav1.Now()
+			taskSet.Status.Phase = diagnosisv1.TaskFailed
+			if err := util.PatchTaskSetStatus(ctx, r.Client, &taskSet); err != nil {
+				log.Error(err, "unable to update TaskSet")
+				return ctrl.Result{}, client.IgnoreNotFound(err)
+			}
+
+			return ctrl.Result{}, nil
+		}
+
+		taskSet.Status.StartTime = metav1.Now()
+		taskSet.Status.Phase = diagnosisv1.TaskPending
+		if err := util.PatchTaskSetStatus(ctx, r.Client, &taskSet); err != nil {
+			log.Error(err, "unable to update TaskSet")
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+	case diagnosisv1.TaskPending, diagnosisv1.TaskRunning:
+		return r.syncTasks(ctx, log, &taskSet)
+	case diagnosisv1.TaskSucceeded, diagnosisv1.TaskFailed:
+		// The task set has completed its execution.
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// syncTasks creates tasks whose dependences have succeeded and updates the aggregate phase of
+// the task set from the phases of its tasks.
+func (r *TaskSetReconciler) syncTasks(ctx context.Context, log logr.Logger, taskSet *diagnosisv1.TaskSet) (ctrl.Result, error) {
+	taskPhases := make(map[string]diagnosisv1.TaskPhase)
+	succeeded := 0
+	failed := 0
+	for _, definition := range taskSet.Spec.Tasks {
+		var task diagnosisv1.Task
+		err := r.Get(ctx, client.ObjectKey{
+			Name:      taskName(taskSet, definition),
+			Namespace: taskSet.Namespace,
+		}, &task)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			log.Error(err, "unable to fetch Task")
+			return ctrl.Result{}, err
+		}
+
+		taskPhases[definition.Name] = task.Status.Phase
+		switch task.Status.Phase {
+		case diagnosisv1.TaskSucceeded:
+			succeeded++
+		case diagnosisv1.TaskFailed:
+			failed++
+		}
+	}
+
+	// Create tasks whose dependences have all succeeded.
+	for _, definition := range taskSet.Spec.Tasks {
+		if _, ok := taskPhases[definition.Name]; ok {
+			continue
+		}
+		runnable := true
+		for _, dependence := range definition.DependsOn {
+			if taskPhases[dependence] != diagnosisv1.TaskSucceeded {
+				runnable = false
+				break
+			}
+		}
+		if !runnable {
+			continue
+		}
+
+		log.Info("creating task of task set", "task", definition.Name)
+		task := diagnosisv1.Task{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      taskName(taskSet, definition),
+				Namespace: taskSet.Namespace,
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(taskSet, diagnosisv1.GroupVersion.WithKind("TaskSet")),
+				},
+				Labels: map[string]string{
+					"taskset-namespace": taskSet.Namespace,
+					"taskset-name":      taskSet.Name,
+				},
+			},
+			Spec: definition.TaskSpec,
+		}
+		if err := r.Create(ctx, &task); err != nil {
+			if !apierrors.IsAlreadyExists(err) {
+				log.Error(err, "unable to create Task")
+				return ctrl.Result{}, err
+			}
+		}
+		task.Status.StartTime = metav1.Now()
+		task.Status.Phase = diagnosisv1.TaskPending
+		if err := util.PatchTaskStatus(ctx, r.Client, &task); err != nil {
+			log.Error(err, "unable to update Task")
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		taskPhases[definition.Name] = diagnosisv1.TaskPending
+	}
+
+	taskSet.Status.TaskPhases = taskPhases
+	taskSet.Status.Succeeded = succeeded
+	taskSet.Status.Failed = failed
+	if failed != 0 {
+		log.Info("failed to run task set", "succeeded", succeeded, "failed", failed)
+		r.eventRecorder.Eventf(taskSet, corev1.EventTypeWarning, "TaskSetFailed", "Failed to run task set %s/%s", taskSet.Namespace, taskSet.Name)
+		taskSet.Status.Phase = diagnosisv1.TaskFailed
+	} else if succeeded == len(taskSet.Spec.Tasks) {
+		log.Info("running task set successfully")
+		r.eventRecorder.Eventf(taskSet, corev1.EventTypeNormal, "TaskSetSucceeded", "Running task set %s/%s successfully", taskSet.Namespace, taskSet.Name)
+		taskSet.Status.Phase = diagnosisv1.TaskSucceeded
+	} else {
+		taskSet.Status.Phase = diagnosisv1.TaskRunning
+	}
+	if err := util.PatchTaskSetStatus(ctx, r.Client, taskSet); err != nil {
+		log.Error(err, "unable to update TaskSet")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// validateTaskSet validates that task names are unique, all dependences reference tasks in the
+// task set and the dependences do not form a cycle.
+func validateTaskSet(taskSet *diagnosisv1.TaskSet) error {
+	if len(taskSet.Spec.Tasks) == 0 {
+		return fmt.Errorf("task set does not contain any task")
+	}
+
+	definitions := make(map[string]diagnosisv1.TaskDefinition)
+	for _, definition := range taskSet.Spec.Tasks {
+		if definition.Name == "" {
+			return fmt.Errorf("task name must not be empty")
+		}
+		if _, ok := definitions[definition.Name]; ok {
+			return fmt.Errorf("duplicated task name %s", definition.Name)
+		}
+		definitions[definition.Name] = definition
+	}
+	for _, definition := range taskSet.Spec.Tasks {
+		for _, dependence := range definition.DependsOn {
+			if _, ok := definitions[dependence]; !ok {
+				return fmt.Errorf("task %s depends on unknown task %s", definition.Name, dependence)
+			}
+		}
+	}
+
+	// Validate the dependences form a directed acyclic graph by iteratively removing tasks
+	// whose dependences have all been removed.
+	removed := make(map[string]bool)
+	for len(removed) != len(definitions) {
+		progressed := false
+		for name, definition := range definitions {
+			if removed[name] {
+				continue
+			}
+			removable := true
+			for _, dependence := range definition.DependsOn {
+				if !removed[dependence] {
+					removable = false
+					break
+				}
+			}
+			if removable {
+				removed[name] = true
+				progressed = true
+			}
+		}
+		if !progressed {
+			return fmt.Errorf("task dependences form a cycle")
+		}
+	}
+
+	return nil
+}
+
+// taskName returns the name of the task created for the task definition.
+func taskName(taskSet *diagnosisv1.TaskSet, definition diagnosisv1.TaskDefinition) string {
+	return taskSet.Name + "." + string(taskSet.UID)[0:8] + "." + definition.Name
+}
+
+// SetupWithManager setups TaskSetReconciler with the provided manager.
+func (r *TaskSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&diagnosisv1.TaskSet{}).
+		Owns(&diagnosisv1.Task{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.maxConcurrentReconciles}).
+		Complete(r)
+}
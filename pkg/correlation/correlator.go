@@ -0,0 +1,284 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package correlation groups related diagnoses into incidents. Diagnoses created from the same
+// alert fingerprint or started within the same time window receive a shared correlation id
+// label and are summarized in an Incident resource, so an operator can see that diagnoses
+// across several nodes belong to one outage.
+package correlation
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/alertmanager"
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const (
+	// CorrelationIDLabel is the label holding the correlation id shared by all diagnoses of
+	// an incident. The correlation id is also the name of the Incident resource summarizing
+	// the diagnoses.
+	CorrelationIDLabel = util.KubeDiagPrefix + "correlation-id"
+
+	// alertCorrelationIDPrefix is the prefix of correlation ids derived from an alert
+	// fingerprint.
+	alertCorrelationIDPrefix = "alert-"
+	// windowCorrelationIDPrefix is the prefix of correlation ids derived from the earliest
+	// diagnosis of an incident window.
+	windowCorrelationIDPrefix = "incident-"
+	// correlationSyncInterval specifies how long to wait between correlation syncs.
+	correlationSyncInterval = 30 * time.Second
+)
+
+var (
+	correlationSyncSuccessCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "correlation_sync_success_count",
+			Help: "Counter of successful correlation syncs.",
+		},
+	)
+	correlationSyncErrorCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "correlation_sync_error_count",
+			Help: "Counter of erroneous correlation syncs.",
+		},
+	)
+	correlatedDiagnosisCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "correlated_diagnosis_count",
+			Help: "Counter of diagnoses labeled with a correlation id.",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		correlationSyncSuccessCount,
+		correlationSyncErrorCount,
+		correlatedDiagnosisCount,
+	)
+}
+
+// Correlator labels related diagnoses with a shared correlation id and maintains an Incident
+// summary per correlation id.
+type Correlator struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// client knows how to perform CRUD operations on Kubernetes objects.
+	client client.Client
+	// cache knows how to load Kubernetes objects.
+	cache cache.Cache
+	// window is the length of the time window within which diagnoses are correlated.
+	window time.Duration
+}
+
+// NewCorrelator creates a new Correlator.
+func NewCorrelator(
+	ctx context.Context,
+	logger logr.Logger,
+	cli client.Client,
+	cache cache.Cache,
+	window time.Duration,
+) *Correlator {
+	return &Correlator{
+		Context: ctx,
+		Logger:  logger,
+		client:  cli,
+		cache:   cache,
+		window:  window,
+	}
+}
+
+// Run correlates diagnoses periodically until the stop channel is closed.
+func (co *Correlator) Run(stopCh <-chan struct{}) {
+	// Wait for all caches to sync before processing.
+	if !co.cache.WaitForCacheSync(stopCh) {
+		return
+	}
+
+	wait.Until(co.sync, correlationSyncInterval, stopCh)
+}
+
+// sync labels uncorrelated diagnoses which belong to an incident with a correlation id and
+// updates the incident summaries of all correlated diagnoses.
+func (co *Correlator) sync() {
+	var diagnosisList diagnosisv1.DiagnosisList
+	if err := co.cache.List(co, &diagnosisList); err != nil {
+		co.Error(err, "unable to list diagnoses")
+		correlationSyncErrorCount.Inc()
+		return
+	}
+	diagnoses := diagnosisList.Items
+
+	// Bucket correlated diagnoses by namespace and correlation id.
+	incidents := make(map[types.NamespacedName][]diagnosisv1.Diagnosis)
+	for _, diagnosis := range diagnoses {
+		if id := diagnosis.Labels[CorrelationIDLabel]; id != "" {
+			key := types.NamespacedName{Namespace: diagnosis.Namespace, Name: id}
+			incidents[key] = append(incidents[key], diagnosis)
+		}
+	}
+
+	// Label recent uncorrelated diagnoses which belong to an incident.
+	now := time.Now()
+	for i := range diagnoses {
+		diagnosis := diagnoses[i]
+		if diagnosis.Labels[CorrelationIDLabel] != "" {
+			continue
+		}
+		if diagnosis.Status.StartTime.IsZero() || now.Sub(diagnosis.Status.StartTime.Time) > co.window {
+			continue
+		}
+
+		id := co.correlationID(diagnosis, diagnoses)
+		if id == "" {
+			continue
+		}
+
+		if err := util.UpdateWithRetry(co, co.client, types.NamespacedName{Namespace: diagnosis.Namespace, Name: diagnosis.Name}, &diagnosis, func() error {
+			if diagnosis.Labels == nil {
+				diagnosis.Labels = make(map[string]string)
+			}
+			diagnosis.Labels[CorrelationIDLabel] = id
+			return nil
+		}); err != nil {
+			co.Error(err, "unable to label diagnosis with correlation id", "diagnosis", client.ObjectKey{
+				Name:      diagnosis.Name,
+				Namespace: diagnosis.Namespace,
+			}, "correlationid", id)
+			correlationSyncErrorCount.Inc()
+			continue
+		}
+		correlatedDiagnosisCount.Inc()
+
+		key := types.NamespacedName{Namespace: diagnosis.Namespace, Name: id}
+		incidents[key] = append(incidents[key], diagnosis)
+	}
+
+	for key, members := range incidents {
+		if err := co.updateIncident(key, members); err != nil {
+			co.Error(err, "unable to update incident", "incident", key)
+			correlationSyncErrorCount.Inc()
+			return
+		}
+	}
+	correlationSyncSuccessCount.Inc()
+}
+
+// correlationID returns the correlation id the diagnosis belongs to, or an empty string if the
+// diagnosis is not related to any other diagnosis. Diagnoses created from an alert share the
+// id derived from the alert fingerprint. Otherwise the diagnosis adopts the id of a correlated
+// diagnosis in the same namespace started within the correlation window, or forms a new
+// incident with other uncorrelated diagnoses of the window named after the earliest of them.
+func (co *Correlator) correlationID(diagnosis diagnosisv1.Diagnosis, diagnoses []diagnosisv1.Diagnosis) string {
+	if fingerprint := diagnosis.Annotations[alertmanager.PrometheusAlertFingerprintAnnotation]; fingerprint != "" {
+		return alertCorrelationIDPrefix + strings.ToLower(fingerprint)
+	}
+
+	earliest := diagnosis
+	related := false
+	for _, other := range diagnoses {
+		if other.Namespace != diagnosis.Namespace || other.UID == diagnosis.UID {
+			continue
+		}
+		if other.Status.StartTime.IsZero() {
+			continue
+		}
+		delta := other.Status.StartTime.Sub(diagnosis.Status.StartTime.Time)
+		if delta > co.window || -delta > co.window {
+			continue
+		}
+
+		// Adopt the correlation id of a diagnosis already correlated by its start time.
+		if id := other.Labels[CorrelationIDLabel]; strings.HasPrefix(id, windowCorrelationIDPrefix) {
+			return id
+		}
+
+		related = true
+		if other.Status.StartTime.Before(&earliest.Status.StartTime) {
+			earliest = other
+		}
+	}
+	if !related {
+		return ""
+	}
+
+	return windowCorrelationIDPrefix + string(earliest.UID)[0:8]
+}
+
+// updateIncident writes the summary of the member diagnoses into the incident, creating the
+// incident on first use.
+func (co *Correlator) updateIncident(key types.NamespacedName, members []diagnosisv1.Diagnosis) error {
+	status := diagnosisv1.IncidentStatus{
+		UpdateTime: metav1.Now(),
+		Total:      len(members),
+	}
+	nodes := make(map[string]bool)
+	for _, member := range members {
+		if status.StartTime.IsZero() || member.Status.StartTime.Before(&status.StartTime) {
+			status.StartTime = member.Status.StartTime
+		}
+		if fingerprint := member.Annotations[alertmanager.PrometheusAlertFingerprintAnnotation]; fingerprint != "" {
+			status.AlertFingerprint = fingerprint
+		}
+		status.Diagnoses = append(status.Diagnoses, member.Name)
+		for _, nodeName := range member.Status.NodeNames {
+			nodes[nodeName] = true
+		}
+	}
+	sort.Strings(status.Diagnoses)
+	for nodeName := range nodes {
+		status.Nodes = append(status.Nodes, nodeName)
+	}
+	sort.Strings(status.Nodes)
+
+	var incident diagnosisv1.Incident
+	err := co.client.Get(co, client.ObjectKey{Namespace: key.Namespace, Name: key.Name}, &incident)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		incident = diagnosisv1.Incident{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+			},
+		}
+		if err := co.client.Create(co, &incident); err != nil {
+			return err
+		}
+	}
+	incident.Status = status
+
+	return co.client.Status().Update(co, &incident)
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dashboard serves an optional web ui on the kubediag master for browsing diagnoses,
+// their executed graphs with per operation results and their artifacts. Artifact downloads and
+// graph renderings are served by the rest api of the master, so the dashboard itself only
+// renders html from the cache.
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"github.com/gorilla/mux"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const listTemplateText = `<!DOCTYPE html>
+<html>
+<head>
+<title>KubeDiag</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.4em 0.8em; text-align: left; }
+.Succeeded { color: green; }
+.Failed { color: red; }
+</style>
+</head>
+<body>
+<h1>Diagnoses</h1>
+<form method="get">
+<input type="text" name="namespace" placeholder="namespace" value="{{.Namespace}}">
+<select name="phase">
+<option value="">all phases</option>
+{{range .Phases}}<option value="{{.}}" {{if eq . $.Phase}}selected{{end}}>{{.}}</option>{{end}}
+</select>
+<button type="submit">Filter</button>
+</form>
+<table>
+<tr><th>Namespace</th><th>Name</th><th>OperationSet</th><th>Phase</th><th>Nodes</th></tr>
+{{range .Diagnoses}}
+<tr>
+<td>{{.Namespace}}</td>
+<td><a href="/dashboard/diagnoses/{{.Namespace}}/{{.Name}}">{{.Name}}</a></td>
+<td>{{.Spec.OperationSet}}</td>
+<td class="{{.Status.Phase}}">{{.Status.Phase}}</td>
+<td>{{range .Status.NodeNames}}{{.}} {{end}}</td>
+</tr>
+{{end}}
+</table>
+</body>
+</html>
+`
+
+const detailTemplateText = `<!DOCTYPE html>
+<html>
+<head>
+<title>{{.Diagnosis.Namespace}}/{{.Diagnosis.Name}} - KubeDiag</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.4em 0.8em; text-align: left; vertical-align: top; }
+pre { white-space: pre-wrap; max-width: 60em; margin: 0; }
+.Succeeded { color: green; }
+.Failed { color: red; }
+</style>
+</head>
+<body>
+<p><a href="/dashboard">&larr; all diagnoses</a></p>
+<h1>{{.Diagnosis.Namespace}}/{{.Diagnosis.Name}}</h1>
+<p>OperationSet: {{.Diagnosis.Spec.OperationSet}}, phase: <span class="{{.Diagnosis.Status.Phase}}">{{.Diagnosis.Status.Phase}}</span></p>
+<h2>Executed graph</h2>
+<img src="/api/v1/operationsets/{{.Diagnosis.Spec.OperationSet}}/graph?format=svg&diagnosisNamespace={{.Diagnosis.Namespace}}&diagnosisName={{.Diagnosis.Name}}" alt="executed graph">
+<h2>Operation results</h2>
+<table>
+<tr><th>Operation</th><th>Task</th><th>Result</th></tr>
+{{range .Results}}
+<tr><td>{{.Operation}}</td><td>{{.Task}}</td><td><pre>{{.Result}}</pre></td></tr>
+{{end}}
+</table>
+<h2>Conditions</h2>
+<table>
+<tr><th>Type</th><th>Status</th><th>Reason</th><th>Message</th></tr>
+{{range .Diagnosis.Status.Conditions}}
+<tr><td>{{.Type}}</td><td>{{.Status}}</td><td>{{.Reason}}</td><td>{{.Message}}</td></tr>
+{{end}}
+</table>
+<h2>Artifacts</h2>
+<p><a href="/api/v1/diagnoses/{{.Diagnosis.Namespace}}/{{.Diagnosis.Name}}/artifacts">artifact index</a></p>
+</body>
+</html>
+`
+
+// operationResult is a flattened operation result row rendered on the detail page.
+type operationResult struct {
+	// Operation is the name of the operation.
+	Operation string
+	// Task is the name of the task which produced the result.
+	Task string
+	// Result is the result rendered as key value lines.
+	Result string
+}
+
+// Dashboard renders the web ui pages of the kubediag master.
+type Dashboard struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// cache knows how to load Kubernetes objects.
+	cache cache.Cache
+	// listTemplate renders the diagnosis list page.
+	listTemplate *template.Template
+	// detailTemplate renders the diagnosis detail page.
+	detailTemplate *template.Template
+}
+
+// NewDashboard creates a new Dashboard.
+func NewDashboard(
+	ctx context.Context,
+	logger logr.Logger,
+	cache cache.Cache,
+) *Dashboard {
+	return &Dashboard{
+		Context:        ctx,
+		Logger:         logger,
+		cache:          cache,
+		listTemplate:   template.Must(template.New("list").Parse(listTemplateText)),
+		detailTemplate: template.Must(template.New("detail").Parse(detailTemplateText)),
+	}
+}
+
+// ListHandler handles http requests for the diagnosis list page. The list can be filtered with
+// the namespace and phase query parameters.
+func (d *Dashboard) ListHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		namespace := r.URL.Query().Get("namespace")
+		phase := r.URL.Query().Get("phase")
+
+		var diagnosisList diagnosisv1.DiagnosisList
+		listOptions := make([]client.ListOption, 0)
+		if namespace != "" {
+			listOptions = append(listOptions, client.InNamespace(namespace))
+		}
+		if phase != "" {
+			listOptions = append(listOptions, client.MatchingFields{util.DiagnosisPhaseIndexField: phase})
+		}
+		if err := d.cache.List(d, &diagnosisList, listOptions...); err != nil {
+			http.Error(w, fmt.Sprintf("unable to list diagnoses: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		diagnoses := append(make([]diagnosisv1.Diagnosis, 0, len(diagnosisList.Items)), diagnosisList.Items...)
+		sort.Slice(diagnoses, func(i, j int) bool {
+			return diagnoses[i].CreationTimestamp.After(diagnoses[j].CreationTimestamp.Time)
+		})
+
+		data := struct {
+			Namespace string
+			Phase     string
+			Phases    []diagnosisv1.DiagnosisPhase
+			Diagnoses []diagnosisv1.Diagnosis
+		}{
+			Namespace: namespace,
+			Phase:     phase,
+			Phases: []diagnosisv1.DiagnosisPhase{
+				diagnosisv1.DiagnosisPending,
+				diagnosisv1.DiagnosisRunning,
+				diagnosisv1.DiagnosisSucceeded,
+				diagnosisv1.DiagnosisFailed,
+				diagnosisv1.DiagnosisUnknown,
+			},
+			Diagnoses: diagnoses,
+		}
+		if err := d.listTemplate.Execute(w, data); err != nil {
+			d.Error(err, "failed to render diagnosis list page")
+		}
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// DetailHandler handles http requests for the diagnosis detail page.
+func (d *Dashboard) DetailHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		vars := mux.Vars(r)
+		var diagnosis diagnosisv1.Diagnosis
+		if err := d.cache.Get(d, client.ObjectKey{
+			Namespace: vars["namespace"],
+			Name:      vars["name"],
+		}, &diagnosis); err != nil {
+			http.Error(w, fmt.Sprintf("unable to fetch diagnosis: %v", err), http.StatusNotFound)
+			return
+		}
+
+		if err := util.DecompressDiagnosisResults(&diagnosis); err != nil {
+			d.Error(err, "unable to decompress operation results", "diagnosis", client.ObjectKey{
+				Namespace: vars["namespace"],
+				Name:      vars["name"],
+			})
+		}
+
+		data := struct {
+			Diagnosis diagnosisv1.Diagnosis
+			Results   []operationResult
+		}{
+			Diagnosis: diagnosis,
+			Results:   flattenResults(diagnosis),
+		}
+		if err := d.detailTemplate.Execute(w, data); err != nil {
+			d.Error(err, "failed to render diagnosis detail page")
+		}
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// flattenResults flattens operation results of a diagnosis into sorted rows for rendering.
+func flattenResults(diagnosis diagnosisv1.Diagnosis) []operationResult {
+	results := make([]operationResult, 0)
+	if diagnosis.Status.Context == nil {
+		return results
+	}
+
+	for operation, operationContext := range diagnosis.Status.Context.Operations {
+		for task, taskContext := range operationContext {
+			keys := make([]string, 0, len(taskContext))
+			for key := range taskContext {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			result := ""
+			for _, key := range keys {
+				result = result + fmt.Sprintf("%s: %s\n", key, taskContext[key])
+			}
+			results = append(results, operationResult{
+				Operation: operation,
+				Task:      task,
+				Result:    result,
+			})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Operation != results[j].Operation {
+			return results[i].Operation < results[j].Operation
+		}
+		return results[i].Task < results[j].Task
+	})
+
+	return results
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package deduplication coalesces identical pending diagnoses. An alert storm or event burst
+// can generate many diagnoses with the same operation set, target and parameters; all but the
+// earliest of them are deleted and the surviving diagnosis is annotated with the number of
+// diagnoses merged into it.
+package deduplication
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const (
+	// MergeCountAnnotation is the annotation holding the number of identical diagnoses merged
+	// into the surviving diagnosis.
+	MergeCountAnnotation = util.KubeDiagPrefix + "merge-count"
+
+	// deduplicationSyncInterval specifies how long to wait between deduplication syncs.
+	deduplicationSyncInterval = 10 * time.Second
+)
+
+var (
+	deduplicationSyncErrorCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "deduplication_sync_error_count",
+			Help: "Counter of erroneous deduplication syncs.",
+		},
+	)
+	deduplicatedDiagnosisCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "deduplicated_diagnosis_count",
+			Help: "Counter of pending diagnoses merged into an identical diagnosis.",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		deduplicationSyncErrorCount,
+		deduplicatedDiagnosisCount,
+	)
+}
+
+// Deduplicator deletes pending diagnoses which are identical to an earlier diagnosis created
+// within the deduplication window and records the merge count on the surviving diagnosis.
+type Deduplicator struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// client knows how to perform CRUD operations on Kubernetes objects.
+	client client.Client
+	// cache knows how to load Kubernetes objects.
+	cache cache.Cache
+	// window is the length of the time window within which identical diagnoses are merged.
+	window time.Duration
+}
+
+// NewDeduplicator creates a new Deduplicator.
+func NewDeduplicator(
+	ctx context.Context,
+	logger logr.Logger,
+	cli client.Client,
+	cache cache.Cache,
+	window time.Duration,
+) *Deduplicator {
+	return &Deduplicator{
+		Context: ctx,
+		Logger:  logger,
+		client:  cli,
+		cache:   cache,
+		window:  window,
+	}
+}
+
+// Run deduplicates diagnoses periodically until the stop channel is closed.
+func (dd *Deduplicator) Run(stopCh <-chan struct{}) {
+	// Wait for all caches to sync before processing.
+	if !dd.cache.WaitForCacheSync(stopCh) {
+		return
+	}
+
+	wait.Until(dd.sync, deduplicationSyncInterval, stopCh)
+}
+
+// sync merges pending diagnoses which are identical to an earlier diagnosis created within
+// the deduplication window.
+func (dd *Deduplicator) sync() {
+	var diagnosisList diagnosisv1.DiagnosisList
+	if err := dd.cache.List(dd, &diagnosisList); err != nil {
+		dd.Error(err, "unable to list diagnoses")
+		deduplicationSyncErrorCount.Inc()
+		return
+	}
+
+	// Bucket diagnoses by namespace and the hash of their operation set, target and
+	// parameters. Failed diagnoses and diagnoses being deleted do not participate, so a
+	// failed diagnosis does not absorb a retry of the same specification.
+	groups := make(map[types.NamespacedName][]diagnosisv1.Diagnosis)
+	for _, diagnosis := range diagnosisList.Items {
+		if diagnosis.DeletionTimestamp != nil {
+			continue
+		}
+		if diagnosis.Status.Phase == diagnosisv1.DiagnosisFailed {
+			continue
+		}
+
+		key := types.NamespacedName{Namespace: diagnosis.Namespace, Name: deduplicationKey(diagnosis)}
+		groups[key] = append(groups[key], diagnosis)
+	}
+
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		dd.mergeGroup(group)
+	}
+}
+
+// mergeGroup deletes the pending diagnoses of a group which were created within the
+// deduplication window of the earliest diagnosis and accumulates their count in the merge
+// count annotation of the survivor.
+func (dd *Deduplicator) mergeGroup(group []diagnosisv1.Diagnosis) {
+	sort.Slice(group, func(i, j int) bool {
+		return group[i].CreationTimestamp.Before(&group[j].CreationTimestamp)
+	})
+	survivor := group[0]
+
+	merged := 0
+	for _, duplicate := range group[1:] {
+		// Only diagnoses which have not started any operation are merged.
+		if duplicate.Status.Phase != "" && duplicate.Status.Phase != diagnosisv1.DiagnosisPending {
+			continue
+		}
+		if duplicate.CreationTimestamp.Sub(survivor.CreationTimestamp.Time) > dd.window {
+			continue
+		}
+
+		if err := dd.client.Delete(dd, &duplicate); err != nil {
+			if !apierrors.IsNotFound(err) {
+				dd.Error(err, "unable to delete duplicate diagnosis", "diagnosis", client.ObjectKey{
+					Name:      duplicate.Name,
+					Namespace: duplicate.Namespace,
+				})
+				deduplicationSyncErrorCount.Inc()
+			}
+			continue
+		}
+
+		dd.Info("duplicate diagnosis has been merged", "diagnosis", client.ObjectKey{
+			Name:      duplicate.Name,
+			Namespace: duplicate.Namespace,
+		}, "survivor", client.ObjectKey{
+			Name:      survivor.Name,
+			Namespace: survivor.Namespace,
+		})
+		deduplicatedDiagnosisCount.Inc()
+		merged++
+	}
+	if merged == 0 {
+		return
+	}
+
+	if err := util.UpdateWithRetry(dd, dd.client, types.NamespacedName{Namespace: survivor.Namespace, Name: survivor.Name}, &survivor, func() error {
+		count := merged
+		if value, ok := survivor.Annotations[MergeCountAnnotation]; ok {
+			if parsed, err := strconv.Atoi(value); err == nil {
+				count += parsed
+			}
+		}
+		if survivor.Annotations == nil {
+			survivor.Annotations = make(map[string]string)
+		}
+		survivor.Annotations[MergeCountAnnotation] = strconv.Itoa(count)
+		return nil
+	}); err != nil {
+		dd.Error(err, "unable to update merge count of diagnosis", "diagnosis", client.ObjectKey{
+			Name:      survivor.Name,
+			Namespace: survivor.Namespace,
+		})
+		deduplicationSyncErrorCount.Inc()
+	}
+}
+
+// deduplicationKey returns the hash identifying diagnoses which perform the same work. Two
+// diagnoses with the same operation set, target selector and parameters are considered
+// identical.
+func deduplicationKey(diagnosis diagnosisv1.Diagnosis) string {
+	return util.ComputeHash(struct {
+		OperationSet   string
+		TargetSelector *diagnosisv1.TargetSelector
+		Parameters     map[string]string
+	}{
+		OperationSet:   diagnosis.Spec.OperationSet,
+		TargetSelector: diagnosis.Spec.TargetSelector,
+		Parameters:     diagnosis.Spec.Parameters,
+	})
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deduplication
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+)
+
+// newDiagnosis creates a diagnosis with the provided specification fields relevant to
+// deduplication.
+func newDiagnosis(operationSet string, targetSelector *diagnosisv1.TargetSelector, parameters map[string]string) diagnosisv1.Diagnosis {
+	return diagnosisv1.Diagnosis{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "diagnosis",
+			Namespace: "default",
+		},
+		Spec: diagnosisv1.DiagnosisSpec{
+			OperationSet:   operationSet,
+			TargetSelector: targetSelector,
+			Parameters:     parameters,
+		},
+	}
+}
+
+func TestDeduplicationKey(t *testing.T) {
+	targetSelector := &diagnosisv1.TargetSelector{
+		NodeNames: []string{"node-1"},
+	}
+	base := newDiagnosis("memory-investigation", targetSelector, map[string]string{"duration": "30s"})
+
+	tests := []struct {
+		diagnosis diagnosisv1.Diagnosis
+		expected  bool
+		desc      string
+	}{
+		{
+			diagnosis: newDiagnosis("memory-investigation", &diagnosisv1.TargetSelector{
+				NodeNames: []string{"node-1"},
+			}, map[string]string{"duration": "30s"}),
+			expected: true,
+			desc:     "diagnoses with identical specifications share a key",
+		},
+		{
+			diagnosis: func() diagnosisv1.Diagnosis {
+				diagnosis := newDiagnosis("memory-investigation", targetSelector, map[string]string{"duration": "30s"})
+				diagnosis.Name = "another-diagnosis"
+				diagnosis.Status.Phase = diagnosisv1.DiagnosisRunning
+				return diagnosis
+			}(),
+			expected: true,
+			desc:     "the key does not depend on object metadata or status",
+		},
+		{
+			diagnosis: newDiagnosis("cpu-investigation", targetSelector, map[string]string{"duration": "30s"}),
+			expected:  false,
+			desc:      "a different operation set produces a different key",
+		},
+		{
+			diagnosis: newDiagnosis("memory-investigation", &diagnosisv1.TargetSelector{
+				NodeNames: []string{"node-2"},
+			}, map[string]string{"duration": "30s"}),
+			expected: false,
+			desc:     "a different target selector produces a different key",
+		},
+		{
+			diagnosis: newDiagnosis("memory-investigation", nil, map[string]string{"duration": "30s"}),
+			expected:  false,
+			desc:      "a nil target selector produces a different key",
+		},
+		{
+			diagnosis: newDiagnosis("memory-investigation", targetSelector, map[string]string{"duration": "60s"}),
+			expected:  false,
+			desc:      "different parameters produce a different key",
+		},
+		{
+			diagnosis: newDiagnosis("memory-investigation", targetSelector, nil),
+			expected:  false,
+			desc:      "missing parameters produce a different key",
+		},
+	}
+
+	baseKey := deduplicationKey(base)
+	for _, test := range tests {
+		if test.expected {
+			assert.Equal(t, baseKey, deduplicationKey(test.diagnosis), test.desc)
+		} else {
+			assert.NotEqual(t, baseKey, deduplicationKey(test.diagnosis), test.desc)
+		}
+	}
+}
+
+func TestDeduplicationKeyIsStable(t *testing.T) {
+	diagnosis := newDiagnosis("memory-investigation", &diagnosisv1.TargetSelector{
+		PodSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"app": "nginx"},
+		},
+	}, map[string]string{"duration": "30s", "interval": "1s"})
+
+	key := deduplicationKey(diagnosis)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, key, deduplicationKey(diagnosis), "the key of one specification never changes")
+	}
+}
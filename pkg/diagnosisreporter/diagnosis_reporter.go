@@ -0,0 +1,302 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package diagnosisreporter maintains aggregate statistics of completed diagnoses in a
+// DiagnosisReport resource. Success rates, mean durations and top failure reasons are computed
+// over a rolling window per node and per operation set, so the fleet can be surveyed with
+// kubectl instead of an external analytics stack.
+package diagnosisreporter
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+)
+
+const (
+	// ReportName is the name of the DiagnosisReport maintained by the reporter.
+	ReportName = "kubediag"
+
+	// diagnosisReportUpdateInterval specifies how long to wait between report updates.
+	diagnosisReportUpdateInterval = 60 * time.Second
+	// topFailureReasonCount is the number of failure reasons reported per statistics entry.
+	topFailureReasonCount = 5
+)
+
+var (
+	diagnosisReportUpdateSuccessCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "diagnosis_report_update_success_count",
+			Help: "Counter of successful diagnosis report updates.",
+		},
+	)
+	diagnosisReportUpdateErrorCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "diagnosis_report_update_error_count",
+			Help: "Counter of erroneous diagnosis report updates.",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		diagnosisReportUpdateSuccessCount,
+		diagnosisReportUpdateErrorCount,
+	)
+}
+
+// DiagnosisReporter maintains aggregate statistics of diagnoses completed in a rolling window
+// in a DiagnosisReport resource.
+type DiagnosisReporter struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// client knows how to perform CRUD operations on Kubernetes objects.
+	client client.Client
+	// cache knows how to load Kubernetes objects.
+	cache cache.Cache
+	// window is the length of the rolling window the statistics cover.
+	window time.Duration
+}
+
+// NewDiagnosisReporter creates a new DiagnosisReporter.
+func NewDiagnosisReporter(
+	ctx context.Context,
+	logger logr.Logger,
+	cli client.Client,
+	cache cache.Cache,
+	window time.Duration,
+) *DiagnosisReporter {
+	return &DiagnosisReporter{
+		Context: ctx,
+		Logger:  logger,
+		client:  cli,
+		cache:   cache,
+		window:  window,
+	}
+}
+
+// Run updates the diagnosis report periodically until the stop channel is closed.
+func (dr *DiagnosisReporter) Run(stopCh <-chan struct{}) {
+	// Wait for all caches to sync before processing.
+	if !dr.cache.WaitForCacheSync(stopCh) {
+		return
+	}
+
+	wait.Until(dr.sync, diagnosisReportUpdateInterval, stopCh)
+}
+
+// sync aggregates all diagnoses completed in the rolling window and updates the diagnosis
+// report.
+func (dr *DiagnosisReporter) sync() {
+	var diagnosisList diagnosisv1.DiagnosisList
+	if err := dr.cache.List(dr, &diagnosisList); err != nil {
+		dr.Error(err, "unable to list diagnoses")
+		diagnosisReportUpdateErrorCount.Inc()
+		return
+	}
+
+	now := time.Now()
+	cluster := newAccumulator()
+	nodes := make(map[string]*accumulator)
+	operationSets := make(map[string]*accumulator)
+	for _, diagnosis := range diagnosisList.Items {
+		if diagnosis.Status.Phase != diagnosisv1.DiagnosisSucceeded && diagnosis.Status.Phase != diagnosisv1.DiagnosisFailed {
+			continue
+		}
+		if diagnosis.Status.CompletionTime == nil || now.Sub(diagnosis.Status.CompletionTime.Time) > dr.window {
+			continue
+		}
+
+		cluster.observe(diagnosis)
+		for _, nodeName := range diagnosis.Status.NodeNames {
+			accumulator, ok := nodes[nodeName]
+			if !ok {
+				accumulator = newAccumulator()
+				nodes[nodeName] = accumulator
+			}
+			accumulator.observe(diagnosis)
+		}
+		accumulator, ok := operationSets[diagnosis.Spec.OperationSet]
+		if !ok {
+			accumulator = newAccumulator()
+			operationSets[diagnosis.Spec.OperationSet] = accumulator
+		}
+		accumulator.observe(diagnosis)
+	}
+
+	status := diagnosisv1.DiagnosisReportStatus{
+		UpdateTime: metav1.NewTime(now),
+		Window:     dr.window.String(),
+		Cluster:    cluster.statistics(),
+	}
+	for _, nodeName := range sortedKeys(nodes) {
+		status.Nodes = append(status.Nodes, diagnosisv1.NodeDiagnosisStatistics{
+			NodeName:            nodeName,
+			DiagnosisStatistics: nodes[nodeName].statistics(),
+		})
+	}
+	for _, operationSet := range sortedKeys(operationSets) {
+		status.OperationSets = append(status.OperationSets, diagnosisv1.OperationSetDiagnosisStatistics{
+			OperationSet:        operationSet,
+			DiagnosisStatistics: operationSets[operationSet].statistics(),
+		})
+	}
+
+	if err := dr.updateReport(status); err != nil {
+		dr.Error(err, "unable to update diagnosis report", "diagnosisreport", ReportName)
+		diagnosisReportUpdateErrorCount.Inc()
+		return
+	}
+	diagnosisReportUpdateSuccessCount.Inc()
+}
+
+// updateReport writes the status into the diagnosis report, creating the report on first use.
+func (dr *DiagnosisReporter) updateReport(status diagnosisv1.DiagnosisReportStatus) error {
+	var report diagnosisv1.DiagnosisReport
+	err := dr.client.Get(dr, client.ObjectKey{Name: ReportName}, &report)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		report = diagnosisv1.DiagnosisReport{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: ReportName,
+			},
+		}
+		if err := dr.client.Create(dr, &report); err != nil {
+			return err
+		}
+	}
+	report.Status = status
+
+	return dr.client.Status().Update(dr, &report)
+}
+
+// accumulator accumulates statistics of observed diagnoses.
+type accumulator struct {
+	// total is the number of observed diagnoses.
+	total int
+	// succeeded is the number of observed diagnoses which reached phase Succeeded.
+	succeeded int
+	// failed is the number of observed diagnoses which reached phase Failed.
+	failed int
+	// duration is the summed time taken from start to completion of observed diagnoses.
+	duration time.Duration
+	// durations is the number of observed diagnoses with both start and completion time set.
+	durations int
+	// failureReasons contains the number of failed diagnoses by reason.
+	failureReasons map[string]int
+}
+
+// newAccumulator creates a new accumulator.
+func newAccumulator() *accumulator {
+	return &accumulator{
+		failureReasons: make(map[string]int),
+	}
+}
+
+// observe accumulates a completed diagnosis.
+func (a *accumulator) observe(diagnosis diagnosisv1.Diagnosis) {
+	a.total++
+	switch diagnosis.Status.Phase {
+	case diagnosisv1.DiagnosisSucceeded:
+		a.succeeded++
+	case diagnosisv1.DiagnosisFailed:
+		a.failed++
+		a.failureReasons[failureReason(diagnosis)]++
+	}
+	if !diagnosis.Status.StartTime.IsZero() && diagnosis.Status.CompletionTime != nil {
+		a.duration += diagnosis.Status.CompletionTime.Sub(diagnosis.Status.StartTime.Time)
+		a.durations++
+	}
+}
+
+// statistics returns the accumulated statistics.
+func (a *accumulator) statistics() diagnosisv1.DiagnosisStatistics {
+	statistics := diagnosisv1.DiagnosisStatistics{
+		Total:     a.total,
+		Succeeded: a.succeeded,
+		Failed:    a.failed,
+	}
+	if a.total != 0 {
+		statistics.SuccessRate = 100 * a.succeeded / a.total
+	}
+	if a.durations != 0 {
+		statistics.MeanDuration = (a.duration / time.Duration(a.durations)).Truncate(time.Second).String()
+	}
+
+	reasons := make([]diagnosisv1.FailureReason, 0, len(a.failureReasons))
+	for reason, count := range a.failureReasons {
+		reasons = append(reasons, diagnosisv1.FailureReason{
+			Reason: reason,
+			Count:  count,
+		})
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if reasons[i].Count != reasons[j].Count {
+			return reasons[i].Count > reasons[j].Count
+		}
+		return reasons[i].Reason < reasons[j].Reason
+	})
+	if len(reasons) > topFailureReasonCount {
+		reasons = reasons[:topFailureReasonCount]
+	}
+	statistics.TopFailureReasons = reasons
+
+	return statistics
+}
+
+// failureReason returns the reason of the most recent true condition of a failed diagnosis
+// other than the completion condition, or Unknown if no such condition carries a reason.
+func failureReason(diagnosis diagnosisv1.Diagnosis) string {
+	for i := len(diagnosis.Status.Conditions) - 1; i >= 0; i-- {
+		condition := diagnosis.Status.Conditions[i]
+		if condition.Type == diagnosisv1.DiagnosisComplete {
+			continue
+		}
+		if condition.Status == corev1.ConditionTrue && condition.Reason != "" {
+			return condition.Reason
+		}
+	}
+
+	return "Unknown"
+}
+
+// sortedKeys returns the keys of the accumulators in increasing order.
+func sortedKeys(accumulators map[string]*accumulator) []string {
+	keys := make([]string, 0, len(accumulators))
+	for key := range accumulators {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package encryption provides aes gcm encryption at rest for artifact files under data root.
+// Heap dumps and packet captures frequently contain customer data and node disks are not always
+// encrypted, so artifacts are encrypted on registration and decrypted transparently on download.
+// The key is read from a file, typically mounted from a kubernetes secret.
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// chunkSize is the plaintext size of an encryption chunk. Files are encrypted in chunks so
+	// large artifacts such as heap dumps do not need to be held in memory.
+	chunkSize = 64 * 1024
+	// nonceSize is the size of the random nonce prepended to every chunk.
+	nonceSize = 12
+
+	// chunkFlagFinal marks the last chunk of a file. The flag is bound into the additional
+	// authenticated data of the chunk, so a truncated file is detected on decryption.
+	chunkFlagFinal = 0x01
+)
+
+// magic marks a file as encrypted by kubediag. Files without the magic are served unchanged,
+// so artifacts written before encryption was enabled remain readable.
+var magic = []byte("KDIAGENC")
+
+var (
+	encryptionFileCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "encryption_file_count",
+			Help: "Number of artifact files encrypted at rest.",
+		},
+	)
+	encryptionErrorCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "encryption_error_count",
+			Help: "Number of errors in encrypting or decrypting artifact files.",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		encryptionFileCount,
+		encryptionErrorCount,
+	)
+}
+
+// Encryptor encrypts and decrypts artifact files with aes gcm.
+type Encryptor struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// aead is the authenticated cipher constructed from the key.
+	aead cipher.AEAD
+}
+
+// NewEncryptor creates a new Encryptor with the aes key read from the key file. The key must be
+// 16, 24 or 32 bytes long.
+func NewEncryptor(
+	ctx context.Context,
+	logger logr.Logger,
+	keyFile string,
+) (*Encryptor, error) {
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read encryption key file %s: %v", keyFile, err)
+	}
+	key = bytes.TrimSpace(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cipher from key file %s: %v", keyFile, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create aes gcm cipher: %v", err)
+	}
+
+	return &Encryptor{
+		Context: ctx,
+		Logger:  logger,
+		aead:    aead,
+	}, nil
+}
+
+// EncryptFile encrypts the file at path in place. The encrypted content is written to a
+// temporary file which atomically replaces the original. Files which are already encrypted are
+// left unchanged.
+func (e *Encryptor) EncryptFile(path string) error {
+	encrypted, err := e.encrypted(path)
+	if err != nil {
+		encryptionErrorCount.Inc()
+		return err
+	}
+	if encrypted {
+		return nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		encryptionErrorCount.Inc()
+		return fmt.Errorf("unable to open artifact file %s: %v", path, err)
+	}
+	defer in.Close()
+
+	out, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".enc")
+	if err != nil {
+		encryptionErrorCount.Inc()
+		return fmt.Errorf("unable to create temporary file for %s: %v", path, err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	if _, err := out.Write(magic); err != nil {
+		encryptionErrorCount.Inc()
+		return fmt.Errorf("unable to write encryption header: %v", err)
+	}
+
+	// Chunks are written with one chunk of read ahead, so the last chunk of the file can be
+	// sealed with the final flag. An empty file is written as a single empty final chunk.
+	var index uint64
+	current := make([]byte, chunkSize)
+	next := make([]byte, chunkSize)
+	n, readErr := io.ReadFull(in, current)
+	for {
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			if err := e.writeChunk(out, index, true, current[:n]); err != nil {
+				encryptionErrorCount.Inc()
+				return err
+			}
+			break
+		}
+		if readErr != nil {
+			encryptionErrorCount.Inc()
+			return fmt.Errorf("unable to read artifact file %s: %v", path, readErr)
+		}
+
+		m, nextErr := io.ReadFull(in, next)
+		if nextErr == io.EOF {
+			if err := e.writeChunk(out, index, true, current[:n]); err != nil {
+				encryptionErrorCount.Inc()
+				return err
+			}
+			break
+		}
+		if err := e.writeChunk(out, index, false, current[:n]); err != nil {
+			encryptionErrorCount.Inc()
+			return err
+		}
+		index++
+		current, next = next, current
+		n, readErr = m, nextErr
+	}
+
+	if err := out.Close(); err != nil {
+		encryptionErrorCount.Inc()
+		return fmt.Errorf("unable to close temporary file: %v", err)
+	}
+	if err := os.Rename(out.Name(), path); err != nil {
+		encryptionErrorCount.Inc()
+		return fmt.Errorf("unable to replace artifact file %s: %v", path, err)
+	}
+
+	encryptionFileCount.Inc()
+	e.Info("artifact file encrypted", "path", path)
+	return nil
+}
+
+// DecryptTo streams the decrypted content of the file at path to the writer. Files without the
+// encryption header are streamed unchanged.
+func (e *Encryptor) DecryptTo(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		encryptionErrorCount.Inc()
+		return fmt.Errorf("unable to open artifact file %s: %v", path, err)
+	}
+	defer file.Close()
+
+	header := make([]byte, len(magic))
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		encryptionErrorCount.Inc()
+		return fmt.Errorf("unable to read artifact file %s: %v", path, err)
+	}
+	if !bytes.Equal(header[:n], magic) {
+		if _, err := w.Write(header[:n]); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, file)
+		return err
+	}
+
+	var index uint64
+	finalSeen := false
+	for {
+		chunk, final, err := e.readChunk(file, index)
+		if err == io.EOF {
+			// A file cut off between chunks decrypts cleanly chunk by chunk, so the end of the
+			// file is only trusted after the final chunk has been seen.
+			if !finalSeen {
+				encryptionErrorCount.Inc()
+				return fmt.Errorf("unable to decrypt artifact file %s: file is truncated before the final chunk", path)
+			}
+			return nil
+		}
+		if err != nil {
+			encryptionErrorCount.Inc()
+			return fmt.Errorf("unable to decrypt artifact file %s: %v", path, err)
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		finalSeen = final
+		index++
+	}
+}
+
+// encrypted indicates whether the file at path carries the encryption header.
+func (e *Encryptor) encrypted(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("unable to open artifact file %s: %v", path, err)
+	}
+	defer file.Close()
+
+	header := make([]byte, len(magic))
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, fmt.Errorf("unable to read artifact file %s: %v", path, err)
+	}
+
+	return bytes.Equal(header[:n], magic), nil
+}
+
+// writeChunk seals a plaintext chunk and writes it framed with its length, flags and nonce. The
+// chunk index and the flags are bound into the additional authenticated data, so reordered,
+// duplicated or truncated chunks are detected on decryption.
+func (e *Encryptor) writeChunk(w io.Writer, index uint64, final bool, plaintext []byte) error {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("unable to generate nonce: %v", err)
+	}
+
+	var flags byte
+	if final {
+		flags = chunkFlagFinal
+	}
+	ciphertext := e.aead.Seal(nil, nonce, plaintext, chunkAdditionalData(index, flags))
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(ciphertext)))
+	if _, err := w.Write(length); err != nil {
+		return fmt.Errorf("unable to write chunk length: %v", err)
+	}
+	if _, err := w.Write([]byte{flags}); err != nil {
+		return fmt.Errorf("unable to write chunk flags: %v", err)
+	}
+	if _, err := w.Write(nonce); err != nil {
+		return fmt.Errorf("unable to write chunk nonce: %v", err)
+	}
+	if _, err := w.Write(ciphertext); err != nil {
+		return fmt.Errorf("unable to write chunk ciphertext: %v", err)
+	}
+
+	return nil
+}
+
+// readChunk reads the framed chunk with the given index and returns the opened plaintext and
+// whether the chunk is the final chunk of the file. Opening fails if the chunk was sealed at a
+// different position or with different flags. It returns io.EOF at the end of the file.
+func (e *Encryptor) readChunk(r io.Reader, index uint64) ([]byte, bool, error) {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(r, length); err != nil {
+		if err == io.EOF {
+			return nil, false, io.EOF
+		}
+		return nil, false, fmt.Errorf("unable to read chunk length: %v", err)
+	}
+	ciphertextLength := binary.BigEndian.Uint32(length)
+	if ciphertextLength > chunkSize+uint32(e.aead.Overhead()) {
+		return nil, false, fmt.Errorf("chunk length %d exceeds maximum", ciphertextLength)
+	}
+
+	flagsByte := make([]byte, 1)
+	if _, err := io.ReadFull(r, flagsByte); err != nil {
+		return nil, false, fmt.Errorf("unable to read chunk flags: %v", err)
+	}
+	flags := flagsByte[0]
+	if flags&^byte(chunkFlagFinal) != 0 {
+		return nil, false, fmt.Errorf("chunk flags %#x are not recognized", flags)
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, false, fmt.Errorf("unable to read chunk nonce: %v", err)
+	}
+	ciphertext := make([]byte, ciphertextLength)
+	if _, err := io.ReadFull(r, ciphertext); err != nil {
+		return nil, false, fmt.Errorf("unable to read chunk ciphertext: %v", err)
+	}
+
+	plaintext, err := e.aead.Open(nil, nonce, ciphertext, chunkAdditionalData(index, flags))
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to open chunk %d: %v", index, err)
+	}
+
+	return plaintext, flags&chunkFlagFinal != 0, nil
+}
+
+// chunkAdditionalData returns the additional authenticated data binding a chunk to its position
+// in the file.
+func chunkAdditionalData(index uint64, flags byte) []byte {
+	data := make([]byte, 9)
+	binary.BigEndian.PutUint64(data, index)
+	data[8] = flags
+
+	return data
+}
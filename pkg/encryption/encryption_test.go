@@ -0,0 +1,234 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// newTestEncryptor creates an encryptor with a fresh key file in a temporary directory. The
+// directory is removed when the test finishes.
+func newTestEncryptor(t *testing.T) (*Encryptor, string) {
+	dir, err := ioutil.TempDir("", "encryption-test")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	keyFile := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef"), 0600); err != nil {
+		t.Fatalf("unable to write key file: %v", err)
+	}
+	encryptor, err := NewEncryptor(context.Background(), ctrl.Log.WithName("encryptor"), keyFile)
+	if err != nil {
+		t.Fatalf("unable to create encryptor: %v", err)
+	}
+
+	return encryptor, dir
+}
+
+// encryptContent writes the content to a file, encrypts it in place and returns the file path.
+func encryptContent(t *testing.T, encryptor *Encryptor, dir string, content []byte) string {
+	path := filepath.Join(dir, "artifact")
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("unable to write artifact file: %v", err)
+	}
+	if err := encryptor.EncryptFile(path); err != nil {
+		t.Fatalf("unable to encrypt artifact file: %v", err)
+	}
+
+	return path
+}
+
+// chunkFrames splits an encrypted file into its header and framed chunks.
+func chunkFrames(t *testing.T, path string) ([]byte, [][]byte) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read encrypted file: %v", err)
+	}
+	assert.True(t, bytes.HasPrefix(data, magic), "encrypted file must carry the magic header")
+
+	frames := make([][]byte, 0)
+	rest := data[len(magic):]
+	for len(rest) > 0 {
+		length := binary.BigEndian.Uint32(rest[:4])
+		frameSize := 4 + 1 + nonceSize + int(length)
+		frames = append(frames, rest[:frameSize])
+		rest = rest[frameSize:]
+	}
+
+	return data[:len(magic)], frames
+}
+
+func TestEncryptDecryptRoundtrip(t *testing.T) {
+	encryptor, dir := newTestEncryptor(t)
+
+	tests := []struct {
+		size int
+		desc string
+	}{
+		{
+			size: 0,
+			desc: "empty file",
+		},
+		{
+			size: 1,
+			desc: "single byte",
+		},
+		{
+			size: chunkSize - 1,
+			desc: "one byte below the chunk size",
+		},
+		{
+			size: chunkSize,
+			desc: "exactly the chunk size",
+		},
+		{
+			size: chunkSize + 1,
+			desc: "one byte above the chunk size",
+		},
+		{
+			size: 3*chunkSize + 17,
+			desc: "multiple chunks with a partial final chunk",
+		},
+	}
+
+	for _, test := range tests {
+		content := make([]byte, test.size)
+		rand.Read(content)
+		path := encryptContent(t, encryptor, dir, content)
+
+		encrypted, err := ioutil.ReadFile(path)
+		assert.NoError(t, err, test.desc)
+		if test.size > 0 {
+			assert.False(t, bytes.Contains(encrypted, content[:minInt(test.size, 16)]), test.desc)
+		}
+
+		var decrypted bytes.Buffer
+		err = encryptor.DecryptTo(&decrypted, path)
+		assert.NoError(t, err, test.desc)
+		assert.True(t, bytes.Equal(content, decrypted.Bytes()), test.desc)
+	}
+}
+
+func TestEncryptFileIdempotent(t *testing.T) {
+	encryptor, dir := newTestEncryptor(t)
+
+	content := []byte("operation output")
+	path := encryptContent(t, encryptor, dir, content)
+	encrypted, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	// Encrypting an already encrypted file must leave it unchanged.
+	err = encryptor.EncryptFile(path)
+	assert.NoError(t, err)
+	unchanged, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, encrypted, unchanged)
+}
+
+func TestDecryptToPassesThroughUnencryptedFiles(t *testing.T) {
+	encryptor, dir := newTestEncryptor(t)
+
+	content := []byte("plaintext artifact written before encryption was enabled")
+	path := filepath.Join(dir, "plain")
+	err := ioutil.WriteFile(path, content, 0600)
+	assert.NoError(t, err)
+
+	var decrypted bytes.Buffer
+	err = encryptor.DecryptTo(&decrypted, path)
+	assert.NoError(t, err)
+	assert.Equal(t, content, decrypted.Bytes())
+}
+
+func TestDecryptToDetectsTampering(t *testing.T) {
+	encryptor, dir := newTestEncryptor(t)
+
+	content := make([]byte, 3*chunkSize)
+	rand.Read(content)
+	path := encryptContent(t, encryptor, dir, content)
+	header, frames := chunkFrames(t, path)
+	assert.Equal(t, 3, len(frames))
+
+	tests := []struct {
+		frames [][]byte
+		desc   string
+	}{
+		{
+			frames: [][]byte{frames[1], frames[0], frames[2]},
+			desc:   "reordered chunks",
+		},
+		{
+			frames: [][]byte{frames[0], frames[0], frames[1], frames[2]},
+			desc:   "duplicated chunk",
+		},
+		{
+			frames: [][]byte{frames[0], frames[1]},
+			desc:   "file truncated before the final chunk",
+		},
+		{
+			frames: [][]byte{frames[0], frames[1], frames[2], frames[2]},
+			desc:   "chunk appended after the final chunk",
+		},
+		{
+			frames: [][]byte{frames[0], frames[1]},
+			desc:   "final chunk missing",
+		},
+	}
+
+	for _, test := range tests {
+		tampered := filepath.Join(dir, "tampered")
+		err := ioutil.WriteFile(tampered, bytes.Join(append([][]byte{header}, test.frames...), nil), 0600)
+		assert.NoError(t, err, test.desc)
+
+		var decrypted bytes.Buffer
+		err = encryptor.DecryptTo(&decrypted, tampered)
+		assert.Error(t, err, test.desc)
+	}
+
+	// A flipped ciphertext byte must fail authentication.
+	corrupted, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	corrupted[len(corrupted)-1] ^= 0xff
+	corruptedPath := filepath.Join(dir, "corrupted")
+	err = ioutil.WriteFile(corruptedPath, corrupted, 0600)
+	assert.NoError(t, err)
+	var decrypted bytes.Buffer
+	err = encryptor.DecryptTo(&decrypted, corruptedPath)
+	assert.Error(t, err)
+}
+
+// minInt returns the smaller of two ints.
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
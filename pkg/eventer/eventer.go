@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -27,11 +28,13 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgocache "k8s.io/client-go/tools/cache"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/queue"
 	"github.com/kubediag/kubediag/pkg/util"
 )
 
@@ -42,6 +45,15 @@ var (
 	KubernetesEventAnnotation = util.KubeDiagPrefix + KubernetesEventGeneratedDiagnosisPrefix
 )
 
+const (
+	// saturationQueueDepth is the event queue depth above which the eventer is considered
+	// saturated and normal events are sampled out.
+	saturationQueueDepth = 256
+	// saturationWriteLatency is the api write latency above which the eventer is considered
+	// saturated and normal events are sampled out.
+	saturationWriteLatency = time.Second
+)
+
 var (
 	eventReceivedCount = prometheus.NewCounter(
 		prometheus.CounterOpts{
@@ -61,6 +73,18 @@ var (
 			Help: "Counter of erroneous diagnosis generations by eventer",
 		},
 	)
+	eventerEventProcessedCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "eventer_event_processed_count",
+			Help: "Counter of events processed by eventer",
+		},
+	)
+	eventerEventSampledCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "eventer_event_sampled_count",
+			Help: "Counter of normal events dropped by eventer adaptive sampling under saturation",
+		},
+	)
 )
 
 // Eventer generates diagnoses from kubernetes events.
@@ -80,10 +104,13 @@ type eventer struct {
 	client client.Client
 	// cache knows how to load Kubernetes objects.
 	cache cache.Cache
-	// eventChainCh is a channel for queuing Events to be processed by eventer.
-	eventChainCh chan corev1.Event
+	// eventQueue is a rate limited work queue of Events to be processed by eventer.
+	eventQueue *queue.WorkQueue
 	// eventerEnabled indicates whether eventer is enabled.
 	eventerEnabled bool
+	// lastWriteLatencyNanos is the api write latency of the last diagnosis generation in
+	// nanoseconds. It is accessed atomically.
+	lastWriteLatencyNanos int64
 }
 
 // NewEventer creates a new Eventer.
@@ -92,19 +119,23 @@ func NewEventer(
 	logger logr.Logger,
 	cli client.Client,
 	cache cache.Cache,
-	eventChainCh chan corev1.Event,
+	eventQueue *queue.WorkQueue,
 	eventerEnabled bool,
 ) Eventer {
 	metrics.Registry.MustRegister(
 		eventReceivedCount,
 		eventerDiagnosisGenerationSuccessCount,
 		eventerDiagnosisGenerationErrorCount,
+		eventerEventProcessedCount,
+		eventerEventSampledCount,
 	)
 
 	return &eventer{
 		Context:        ctx,
 		Logger:         logger,
-		eventChainCh:   eventChainCh,
+		client:         cli,
+		cache:          cache,
+		eventQueue:     eventQueue,
 		eventerEnabled: eventerEnabled,
 	}
 }
@@ -120,37 +151,76 @@ func (ev *eventer) Run(stopCh <-chan struct{}) {
 		return
 	}
 
-	for {
-		select {
-		// Process events queuing in event channel.
-		case event := <-ev.eventChainCh:
-			eventReceivedCount.Inc()
+	// Process events queuing in event work queue. Failed events are retried with rate limited
+	// backoff by the work queue.
+	ev.eventQueue.Run(1, ev.handleEvent, stopCh)
+}
 
-			triggers, err := ev.listTriggers()
-			if err != nil {
-				ev.Error(err, "failed to list Triggers")
-				return
-			}
+// handleEvent processes the event specified by the key in namespace/name format. It returns an
+// error if the event should be requeued.
+func (ev *eventer) handleEvent(key string) error {
+	namespace, name, err := clientgocache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		ev.Error(err, "invalid event key", "key", key)
+		return nil
+	}
 
-			diagnosis, err := ev.createDiagnosisFromKubernetesEvent(triggers, event)
-			if err != nil {
-				// Increment counter of erroneous diagnosis generations by eventer.
-				eventerDiagnosisGenerationErrorCount.Inc()
-				continue
-			}
+	var event corev1.Event
+	if err := ev.cache.Get(ev, client.ObjectKey{
+		Name:      name,
+		Namespace: namespace,
+	}, &event); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
 
-			ev.Info("creating Diagnosis from kubernetes event successfully", "diagnosis", client.ObjectKey{
-				Name:      diagnosis.Name,
-				Namespace: diagnosis.Namespace,
-			})
+	eventReceivedCount.Inc()
 
-			// Increment counter of successful diagnosis generations by eventer.
-			eventerDiagnosisGenerationSuccessCount.Inc()
-		// Stop source manager on stop signal.
-		case <-stopCh:
-			return
-		}
+	// Sample out normal events while the eventer is saturated so warnings are still processed
+	// under load.
+	if event.Type == corev1.EventTypeNormal && ev.saturated() {
+		eventerEventSampledCount.Inc()
+		return nil
+	}
+	eventerEventProcessedCount.Inc()
+
+	triggers, err := ev.listTriggers()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	diagnosis, err := ev.createDiagnosisFromKubernetesEvent(triggers, event)
+	atomic.StoreInt64(&ev.lastWriteLatencyNanos, int64(time.Since(start)))
+	if err != nil {
+		// Increment counter of erroneous diagnosis generations by eventer.
+		eventerDiagnosisGenerationErrorCount.Inc()
+		return nil
+	}
+	if diagnosis == nil {
+		return nil
+	}
+
+	ev.Info("creating Diagnosis from kubernetes event successfully", "diagnosis", client.ObjectKey{
+		Name:      diagnosis.Name,
+		Namespace: diagnosis.Namespace,
+	})
+
+	// Increment counter of successful diagnosis generations by eventer.
+	eventerDiagnosisGenerationSuccessCount.Inc()
+	return nil
+}
+
+// saturated reports whether the eventer is saturated according to the event queue depth and the
+// api write latency of the last diagnosis generation.
+func (ev *eventer) saturated() bool {
+	if ev.eventQueue.Len() > saturationQueueDepth {
+		return true
 	}
+
+	return time.Duration(atomic.LoadInt64(&ev.lastWriteLatencyNanos)) > saturationWriteLatency
 }
 
 // listTriggers lists Triggers from cache.
@@ -0,0 +1,105 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+)
+
+const (
+	// AsyncOperationTokenKey is the key of the operation token returned by a processor which
+	// accepted a long running operation with a response code of 202. The agent polls the processor
+	// with the token until the operation finishes.
+	AsyncOperationTokenKey = "operation.async.token"
+	// AsyncOperationProgressKey is the key of the optional progress message reported by a
+	// processor while a long running operation is still in progress.
+	AsyncOperationProgressKey = "operation.async.progress"
+
+	// asyncPollInterval specifies how long to wait between polls of a long running operation.
+	asyncPollInterval = 30 * time.Second
+	// asyncOperationDeadline is the maximum amount of time the agent polls a long running
+	// operation before giving up.
+	asyncOperationDeadline = 60 * time.Minute
+)
+
+// pollAsyncOperation polls a processor which accepted a long running operation until the operation
+// finishes or the deadline elapses. Each poll sends the original request data with the operation
+// token. The processor responds with 202 while the operation is still in progress and with 200
+// carrying the final result once it finishes.
+func (ex *executor) pollAsyncOperation(operation diagnosisv1.Operation, cli *http.Client, url string, data map[string]string, token string) (bool, map[string]string, error) {
+	pollData := make(map[string]string)
+	for key, value := range data {
+		pollData[key] = value
+	}
+	pollData[AsyncOperationTokenKey] = token
+
+	body, err := json.Marshal(pollData)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to marshal poll request body: %s", err)
+	}
+
+	deadline := time.Now().Add(asyncOperationDeadline)
+	for time.Now().Before(deadline) {
+		time.Sleep(asyncPollInterval)
+
+		req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+		if err != nil {
+			return false, nil, err
+		}
+		res, err := cli.Do(req)
+		if err != nil {
+			return false, nil, err
+		}
+		resBody, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return false, nil, err
+		}
+
+		if len(resBody) > MaxDataSize {
+			return false, nil, fmt.Errorf("response body size %d exceeds max data size %d", len(resBody), MaxDataSize)
+		}
+
+		switch res.StatusCode {
+		case http.StatusAccepted:
+			// The operation is still in progress. Log the reported progress if any.
+			var progress map[string]string
+			if err := json.Unmarshal(resBody, &progress); err == nil && progress[AsyncOperationProgressKey] != "" {
+				ex.Info("long running operation in progress", "operation", operation.Name, "token", token, "progress", progress[AsyncOperationProgressKey])
+			}
+		case http.StatusOK:
+			var result map[string]string
+			if err := json.Unmarshal(resBody, &result); err != nil {
+				ex.Error(err, "failed to unmarshal response body", "response", string(resBody))
+				return false, nil, nil
+			}
+			return true, result, nil
+		default:
+			ex.Info("http response with erroneous status", "status", res.Status, "response", string(resBody))
+			return false, nil, nil
+		}
+	}
+
+	return false, nil, fmt.Errorf("long running operation %s with token %s timed out after %s", operation.Name, token, asyncOperationDeadline)
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// defaultProcessorQPS is the rate limit of requests sent to a single processor endpoint.
+	defaultProcessorQPS = 10
+	// defaultProcessorBurst is the burst of requests sent to a single processor endpoint.
+	defaultProcessorBurst = 20
+	// breakerFailureThreshold is the number of consecutive failures after which the circuit trips.
+	breakerFailureThreshold = 5
+	// breakerOpenDuration specifies how long the circuit stays open before a trial request is allowed.
+	breakerOpenDuration = 60 * time.Second
+)
+
+var (
+	executorRateLimitedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "executor_processor_rate_limited_count",
+			Help: "Counter of operation executions rejected by per processor rate limits",
+		},
+		[]string{"operation"},
+	)
+	executorCircuitBreakerOpenCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "executor_processor_circuit_breaker_open_count",
+			Help: "Counter of operation executions rejected by an open circuit breaker",
+		},
+		[]string{"operation"},
+	)
+	executorCircuitBreakerTripCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "executor_processor_circuit_breaker_trip_count",
+			Help: "Counter of circuit breaker trips per processor",
+		},
+		[]string{"operation"},
+	)
+)
+
+// processorGuard protects executor workers from a misbehaving processor. It combines a token
+// bucket rate limiter with a circuit breaker which trips after consecutive failures and allows a
+// trial request once the open duration has elapsed.
+type processorGuard struct {
+	// lock guards failures and openedAt.
+	lock sync.Mutex
+	// limiter limits the rate of requests sent to the processor endpoint.
+	limiter flowcontrol.RateLimiter
+	// failures is the count of consecutive failed executions.
+	failures int
+	// openedAt is the time the circuit tripped. It is zero if the circuit is closed.
+	openedAt time.Time
+}
+
+// newProcessorGuard creates a new processorGuard.
+func newProcessorGuard() *processorGuard {
+	return &processorGuard{
+		limiter: flowcontrol.NewTokenBucketRateLimiter(defaultProcessorQPS, defaultProcessorBurst),
+	}
+}
+
+// Allow reports whether a request to the processor may proceed. It returns false with a reason if
+// the request is rejected by the rate limiter or an open circuit.
+func (pg *processorGuard) Allow() (bool, string) {
+	pg.lock.Lock()
+	defer pg.lock.Unlock()
+
+	if !pg.openedAt.IsZero() {
+		// Allow a trial request in half open state once the open duration has elapsed.
+		if time.Since(pg.openedAt) < breakerOpenDuration {
+			return false, "circuit breaker is open"
+		}
+	}
+
+	if !pg.limiter.TryAccept() {
+		return false, "rate limit exceeded"
+	}
+
+	return true, ""
+}
+
+// Succeed records a successful execution and closes the circuit.
+func (pg *processorGuard) Succeed() {
+	pg.lock.Lock()
+	defer pg.lock.Unlock()
+
+	pg.failures = 0
+	pg.openedAt = time.Time{}
+}
+
+// Fail records a failed execution. It returns true if the failure trips the circuit.
+func (pg *processorGuard) Fail() bool {
+	pg.lock.Lock()
+	defer pg.lock.Unlock()
+
+	pg.failures++
+	if pg.failures >= breakerFailureThreshold && pg.openedAt.IsZero() {
+		pg.openedAt = time.Now()
+		return true
+	}
+	// Reset the open time on a failed trial request to keep the circuit open.
+	if !pg.openedAt.IsZero() {
+		pg.openedAt = time.Now()
+	}
+
+	return false
+}
+
+// guardFor returns the processor guard of the operation, creating one if absent.
+func (ex *executor) guardFor(operation string) *processorGuard {
+	ex.guardLock.Lock()
+	defer ex.guardLock.Unlock()
+
+	guard, ok := ex.guards[operation]
+	if !ok {
+		guard = newProcessorGuard()
+		ex.guards[operation] = guard
+	}
+
+	return guard
+}
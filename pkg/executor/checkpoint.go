@@ -0,0 +1,200 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/uuid"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+)
+
+// checkpointSubDirectory is the subdirectory of data root which holds per task execution
+// checkpoints.
+const checkpointSubDirectory = "checkpoints"
+
+// taskCheckpoint records the execution state of a task persisted before its operation is
+// invoked, so a restart of the agent in the middle of an operation can be detected.
+type taskCheckpoint struct {
+	// Namespace is the namespace of the task.
+	Namespace string `json:"namespace"`
+	// Name is the name of the task.
+	Name string `json:"name"`
+	// Operation is the name of the operation being executed.
+	Operation string `json:"operation"`
+	// NodeName is the node the operation is executed on.
+	NodeName string `json:"nodeName"`
+	// Token is the idempotency token of the execution. The token is stable across retries
+	// and agent restarts, so side effecting processors can deduplicate work.
+	Token string `json:"token"`
+	// BootID identifies the agent process which created the checkpoint. A checkpoint with a
+	// foreign boot id was created by an agent process which terminated before the operation
+	// completed.
+	BootID string `json:"bootID"`
+	// StartedAt is the time the operation was first invoked.
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// checkpointStore persists per task execution checkpoints under the data root, so execution
+// state survives agent restarts.
+type checkpointStore struct {
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// directory is the directory checkpoints are persisted under.
+	directory string
+	// bootID identifies the current agent process.
+	bootID string
+	// lock guards checkpoints.
+	lock sync.Mutex
+	// checkpoints contains loaded and created checkpoints keyed by namespaced task name.
+	checkpoints map[string]taskCheckpoint
+}
+
+// newCheckpointStore creates a new checkpointStore and loads checkpoints persisted by
+// previous agent processes.
+func newCheckpointStore(logger logr.Logger, dataRoot string) *checkpointStore {
+	store := &checkpointStore{
+		Logger:      logger,
+		directory:   filepath.Join(dataRoot, checkpointSubDirectory),
+		bootID:      string(uuid.NewUUID()),
+		checkpoints: make(map[string]taskCheckpoint),
+	}
+
+	entries, err := ioutil.ReadDir(store.directory)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error(err, "unable to load task checkpoints", "directory", store.directory)
+		}
+		return store
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(store.directory, entry.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			logger.Error(err, "unable to read task checkpoint", "path", path)
+			continue
+		}
+		var checkpoint taskCheckpoint
+		if err := json.Unmarshal(data, &checkpoint); err != nil {
+			logger.Error(err, "unable to unmarshal task checkpoint", "path", path)
+			continue
+		}
+
+		store.checkpoints[checkpoint.Namespace+"/"+checkpoint.Name] = checkpoint
+	}
+
+	return store
+}
+
+// begin returns the checkpoint of the task, creating and persisting one before the operation
+// is invoked if none exists. It reports whether the checkpoint was created by a previous
+// agent process, which means the agent was restarted while the operation was in flight.
+func (cs *checkpointStore) begin(task diagnosisv1.Task, operation diagnosisv1.Operation, nodeName string) (taskCheckpoint, bool) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	key := task.Namespace + "/" + task.Name
+	if checkpoint, ok := cs.checkpoints[key]; ok {
+		return checkpoint, checkpoint.BootID != cs.bootID
+	}
+
+	checkpoint := taskCheckpoint{
+		Namespace: task.Namespace,
+		Name:      task.Name,
+		Operation: operation.Name,
+		NodeName:  nodeName,
+		Token:     string(uuid.NewUUID()),
+		BootID:    cs.bootID,
+		StartedAt: time.Now(),
+	}
+	cs.checkpoints[key] = checkpoint
+	cs.persist(checkpoint)
+
+	return checkpoint, false
+}
+
+// complete removes the checkpoint of a task once its terminal phase has been persisted.
+func (cs *checkpointStore) complete(namespace string, name string) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	key := namespace + "/" + name
+	if _, ok := cs.checkpoints[key]; !ok {
+		return
+	}
+	delete(cs.checkpoints, key)
+
+	if err := os.Remove(cs.path(namespace, name)); err != nil && !os.IsNotExist(err) {
+		cs.Error(err, "unable to remove task checkpoint", "task", key)
+	}
+}
+
+// list returns all checkpoints in the store.
+func (cs *checkpointStore) list() []taskCheckpoint {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	checkpoints := make([]taskCheckpoint, 0, len(cs.checkpoints))
+	for _, checkpoint := range cs.checkpoints {
+		checkpoints = append(checkpoints, checkpoint)
+	}
+
+	return checkpoints
+}
+
+// persist writes the checkpoint to disk. The file is written to a temporary path and renamed,
+// so a crash cannot leave a torn checkpoint behind.
+func (cs *checkpointStore) persist(checkpoint taskCheckpoint) {
+	if err := os.MkdirAll(cs.directory, os.ModePerm); err != nil {
+		cs.Error(err, "unable to create checkpoint directory", "directory", cs.directory)
+		return
+	}
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		cs.Error(err, "unable to marshal task checkpoint", "task", checkpoint.Namespace+"/"+checkpoint.Name)
+		return
+	}
+
+	path := cs.path(checkpoint.Namespace, checkpoint.Name)
+	temporaryPath := path + ".tmp"
+	if err := ioutil.WriteFile(temporaryPath, data, 0644); err != nil {
+		cs.Error(err, "unable to write task checkpoint", "path", temporaryPath)
+		return
+	}
+	if err := os.Rename(temporaryPath, path); err != nil {
+		cs.Error(err, "unable to rename task checkpoint", "path", path)
+	}
+}
+
+// path returns the file path of the checkpoint of a task.
+func (cs *checkpointStore) path(namespace string, name string) string {
+	return filepath.Join(cs.directory, namespace+"."+name+".json")
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"sync"
+)
+
+// diagnosisConcurrencyLimiter caps the number of diagnoses with operations in flight on the
+// node, so dozens of heavy profilers cannot run simultaneously and OOM the node. Tasks of a
+// diagnosis which already holds a slot are always admitted, so a diagnosis cannot block its
+// own remaining operations.
+type diagnosisConcurrencyLimiter struct {
+	// limit is the maximum number of diagnoses with operations in flight. A non positive
+	// limit disables throttling.
+	limit int
+	// lock guards inflight.
+	lock sync.Mutex
+	// inflight contains the number of in flight operations per diagnosis.
+	inflight map[string]int
+}
+
+// newDiagnosisConcurrencyLimiter creates a new diagnosisConcurrencyLimiter.
+func newDiagnosisConcurrencyLimiter(limit int) *diagnosisConcurrencyLimiter {
+	return &diagnosisConcurrencyLimiter{
+		limit:    limit,
+		inflight: make(map[string]int),
+	}
+}
+
+// acquire reserves a slot for a task of the diagnosis. It returns false if the node is
+// already running the maximum number of diagnoses and none of them is the diagnosis of the
+// task.
+func (dl *diagnosisConcurrencyLimiter) acquire(key string) bool {
+	if dl.limit <= 0 {
+		return true
+	}
+
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	if count, ok := dl.inflight[key]; ok {
+		dl.inflight[key] = count + 1
+		return true
+	}
+	if len(dl.inflight) >= dl.limit {
+		return false
+	}
+	dl.inflight[key] = 1
+
+	return true
+}
+
+// release frees the slot held by a task of the diagnosis.
+func (dl *diagnosisConcurrencyLimiter) release(key string) {
+	if dl.limit <= 0 {
+		return
+	}
+
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	count := dl.inflight[key]
+	if count <= 1 {
+		delete(dl.inflight, key)
+		return
+	}
+	dl.inflight[key] = count - 1
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const (
+	// ContextEnvelopeVersion is the current version of the operation context envelope.
+	ContextEnvelopeVersion = "v1"
+	// ContextEnvelopeTelemetryKey is the telemetry key of the operation context envelope.
+	ContextEnvelopeTelemetryKey = "context.envelope"
+)
+
+// ContextEnvelope is a versioned envelope containing all information generated before an operation
+// is executed. It is injected into the request data sent to operation processors so that a processor
+// does not have to make redundant api or cache lookups for the diagnosis, the target pod or node and
+// results of prior operations.
+type ContextEnvelope struct {
+	// APIVersion is the version of the envelope format.
+	APIVersion string `json:"apiVersion"`
+	// Task is the task which is being executed.
+	Task diagnosisv1.Task `json:"task"`
+	// Diagnosis is the diagnosis which owns the task. It contains parameters and results of all
+	// prior operations in its status context.
+	// +optional
+	Diagnosis *diagnosisv1.Diagnosis `json:"diagnosis,omitempty"`
+	// Pod is the snapshot of the target pod.
+	// +optional
+	Pod *corev1.Pod `json:"pod,omitempty"`
+	// Node is the snapshot of the target node.
+	// +optional
+	Node *corev1.Node `json:"node,omitempty"`
+}
+
+// buildContextEnvelope constructs a context envelope for the task. Lookup failures of optional
+// fields are tolerated since an envelope with partial information is still useful to processors.
+func (ex *executor) buildContextEnvelope(task diagnosisv1.Task) *ContextEnvelope {
+	envelope := &ContextEnvelope{
+		APIVersion: ContextEnvelopeVersion,
+		Task:       task,
+	}
+
+	// Fetch the diagnosis which owns the task according to task labels.
+	taskLabels := task.GetLabels()
+	if taskLabels["diagnosis-name"] != "" && taskLabels["diagnosis-namespace"] != "" {
+		var diagnosis diagnosisv1.Diagnosis
+		err := ex.client.Get(ex, client.ObjectKey{
+			Name:      taskLabels["diagnosis-name"],
+			Namespace: taskLabels["diagnosis-namespace"],
+		}, &diagnosis)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				ex.Error(err, "unable to fetch Diagnosis for context envelope", "task", client.ObjectKey{
+					Name:      task.Name,
+					Namespace: task.Namespace,
+				})
+			}
+		} else {
+			// Decompress compressed results of prior operations so that processors always see
+			// the original values.
+			if err := util.DecompressDiagnosisResults(&diagnosis); err != nil {
+				ex.Error(err, "unable to decompress operation results for context envelope", "diagnosis", client.ObjectKey{
+					Name:      diagnosis.Name,
+					Namespace: diagnosis.Namespace,
+				})
+			}
+			envelope.Diagnosis = &diagnosis
+		}
+	}
+
+	// Fetch the snapshot of the target pod.
+	if task.Spec.PodReference != nil {
+		var pod corev1.Pod
+		err := ex.cache.Get(ex, client.ObjectKey{
+			Name:      task.Spec.PodReference.Name,
+			Namespace: task.Spec.PodReference.Namespace,
+		}, &pod)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				ex.Error(err, "unable to fetch Pod for context envelope", "pod", client.ObjectKey{
+					Name:      task.Spec.PodReference.Name,
+					Namespace: task.Spec.PodReference.Namespace,
+				})
+			}
+		} else {
+			envelope.Pod = &pod
+		}
+	}
+
+	// Fetch the snapshot of the target node.
+	if task.Spec.NodeName != "" {
+		var node corev1.Node
+		err := ex.cache.Get(ex, client.ObjectKey{
+			Name: task.Spec.NodeName,
+		}, &node)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				ex.Error(err, "unable to fetch Node for context envelope", "node", task.Spec.NodeName)
+			}
+		} else {
+			envelope.Node = &node
+		}
+	}
+
+	return envelope
+}
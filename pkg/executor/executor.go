@@ -19,15 +19,23 @@ package executor
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	dockerclient "github.com/docker/docker/client"
@@ -40,14 +48,23 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	utilnet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/apimachinery/pkg/util/wait"
+	clientgocache "k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/audit"
 	"github.com/kubediag/kubediag/pkg/controllers"
+	"github.com/kubediag/kubediag/pkg/encryption"
 	"github.com/kubediag/kubediag/pkg/function"
+	"github.com/kubediag/kubediag/pkg/loki"
+	"github.com/kubediag/kubediag/pkg/policy"
+	"github.com/kubediag/kubediag/pkg/queue"
+	"github.com/kubediag/kubediag/pkg/redactor"
+	"github.com/kubediag/kubediag/pkg/signing"
+	"github.com/kubediag/kubediag/pkg/storage"
 	"github.com/kubediag/kubediag/pkg/util"
 )
 
@@ -79,6 +96,48 @@ const (
 
 	// DefaultFunctionNamepace is the dafault namespace for k8s object created by function processor.
 	DefaultFunctionNamespace = "kubediag"
+
+	// StreamingResponseHeader is the http response header set by a processor to declare that its output
+	// is streamed. The response body is written to artifact storage chunk by chunk instead of being
+	// buffered in agent memory, so the output is not limited by max data size.
+	StreamingResponseHeader = "X-KubeDiag-Streaming"
+	// OperationResultArtifactKey is the key to store artifact file path of a streamed operation result.
+	OperationResultArtifactKey = "operation.result.artifact"
+	// OperationResultArtifactSizeKey is the key to store artifact file size of a streamed operation result.
+	OperationResultArtifactSizeKey = "operation.result.artifact.size"
+	// ArtifactSubDirectory is the subdirectory of data root to store operation result artifacts.
+	ArtifactSubDirectory = "artifacts"
+	// WorkspaceSubDirectory is the subdirectory of data root which holds per diagnosis
+	// ephemeral workspaces of operations.
+	WorkspaceSubDirectory = "workspaces"
+	// WorkspaceTelemetryKey is the telemetry key of the working directory an operation
+	// declared via its workspace.
+	WorkspaceTelemetryKey = "workspace"
+	// ProgressCallbackTelemetryKey is the telemetry key of the url a processor can post
+	// progress reports to while it is running.
+	ProgressCallbackTelemetryKey = "progress.callback"
+	// IdempotencyTokenTelemetryKey is the telemetry key of the idempotency token of an
+	// execution. The token is stable across retries and agent restarts, so side effecting
+	// processors can deduplicate work.
+	IdempotencyTokenTelemetryKey = "idempotency.token"
+
+	// defaultTaskWorkers is the number of workers processing tasks from the executor work
+	// queue if no worker count is configured.
+	defaultTaskWorkers = 8
+	// resultCompressionThresholdBytes is the size threshold above which an operation result value
+	// is gzip compressed before being stored in task status.
+	resultCompressionThresholdBytes = 16 << 10
+	// resultOffloadThresholdBytes is the size threshold above which a compressed operation result
+	// value is offloaded to artifact storage with only a reference stored in task status, so huge
+	// collector outputs do not hit the etcd object size limit.
+	resultOffloadThresholdBytes = 128 << 10
+	// NodeMutatingOperationAnnotation is the annotation set on an operation to declare that it
+	// mutates node state. Node mutating operations are serialized on each node.
+	NodeMutatingOperationAnnotation = util.KubeDiagPrefix + "node-mutating"
+	// ResourceIntensiveOperationAnnotation is the annotation set on an operation to declare
+	// that it is expensive to run, such as perf recordings, memory analyzer runs and core dump
+	// collection. Resource intensive operations are deferred while the node is under pressure.
+	ResourceIntensiveOperationAnnotation = util.KubeDiagPrefix + "resource-intensive"
 )
 
 var (
@@ -124,8 +183,30 @@ var (
 			Help: "Counter of fail diagnosis syncs by operation",
 		},
 	)
+	executorPermissionDeniedCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "executor_permission_denied_count",
+			Help: "Counter of operation executions denied by the operation policy",
+		},
+	)
+	executorOperationDeferredCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "executor_operation_deferred_count",
+			Help: "Counter of resource intensive operations deferred on a node under pressure",
+		},
+	)
+	executorDiagnosisThrottledCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "executor_diagnosis_throttled_count",
+			Help: "Counter of operations queued since the node concurrency limit was reached",
+		},
+	)
 )
 
+// errProcessorServerError indicates the processor responded with a server error which may be
+// transient.
+var errProcessorServerError = errors.New("processor responded with a server error")
+
 // TaskBackoff is the recommended backoff for a failure when syncing diagnosis.
 var TaskBackoff = wait.Backoff{
 	Steps:    4,
@@ -142,6 +223,8 @@ type Executor interface {
 	logr.Logger
 	// Run runs the Executor.
 	Run(<-chan struct{})
+	// Drain stops the Executor from starting new operations during shutdown.
+	Drain()
 }
 
 // executor runs the diagnosis pipeline by executing operations defined in diagnosis.
@@ -171,8 +254,48 @@ type executor struct {
 	port int
 	// dataRoot is root directory of persistent kubediag data.
 	dataRoot string
-	// taskCh is a channel for queuing Tasks to be processed by executor.
-	taskCh chan diagnosisv1.Task
+	// taskQueue is a priority work queue of Tasks to be processed by executor.
+	taskQueue *queue.PriorityQueue
+	// taskWorkers is the number of workers processing tasks from the work queue.
+	taskWorkers int
+	// checkpoints persists per task execution checkpoints under the data root, so an agent
+	// restart in the middle of an operation can be detected.
+	checkpoints *checkpointStore
+	// concurrencyLimiter caps the number of diagnoses with operations in flight on the node.
+	concurrencyLimiter *diagnosisConcurrencyLimiter
+	// guardLock guards writes to guards.
+	guardLock sync.Mutex
+	// guards contains rate limiters and circuit breakers per processor endpoint.
+	guards map[string]*processorGuard
+	// retryBudgets contains remaining retries per diagnosis.
+	retryBudgets map[string]int
+	// artifactUploader uploads artifacts to s3 compatible object storage. Artifacts are kept on
+	// local disk only if nil.
+	artifactUploader *storage.S3Uploader
+	// resultShipper ships operation results and textual artifact content to loki. Shipping is
+	// disabled if nil.
+	resultShipper *loki.Client
+	// auditor records executed operations to the audit log. Auditing is disabled if nil.
+	auditor *audit.Auditor
+	// operationPolicy decides whether mutating operations are permitted to run. All mutating
+	// operations are permitted if nil.
+	operationPolicy *policy.Policy
+	// resultRedactor redacts secrets from operation results before they are stored or exported.
+	// Redaction is disabled if nil.
+	resultRedactor *redactor.Redactor
+	// signatureVerifier verifies operation signatures before execution. Unsigned operations are
+	// executed without verification if nil.
+	signatureVerifier *signing.Verifier
+	// encryptor encrypts artifact files written by the executor at rest. Encryption at rest is
+	// disabled if nil.
+	encryptor *encryption.Encryptor
+	// nodeLock serializes node mutating operations on the local node. Operations which do not
+	// mutate node state are executed concurrently by the task workers.
+	nodeLock sync.Mutex
+	// resourceGuard checks node pressure before resource intensive operations are started.
+	resourceGuard *nodeResourceGuard
+	// draining indicates whether the executor is shutting down. It is accessed atomically.
+	draining int32
 }
 
 // NewExecutor creates a new executor.
@@ -188,7 +311,16 @@ func NewExecutor(
 	bindAddress string,
 	port int,
 	dataRoot string,
-	taskCh chan diagnosisv1.Task,
+	taskQueue *queue.PriorityQueue,
+	taskWorkers int,
+	maxConcurrentDiagnoses int,
+	artifactUploader *storage.S3Uploader,
+	resultShipper *loki.Client,
+	auditor *audit.Auditor,
+	operationPolicy *policy.Policy,
+	resultRedactor *redactor.Redactor,
+	signatureVerifier *signing.Verifier,
+	encryptor *encryption.Encryptor,
 ) Executor {
 	metrics.Registry.MustRegister(
 		executorSyncSuccessCount,
@@ -198,6 +330,12 @@ func NewExecutor(
 		executorOperationErrorCounter,
 		executorOperationSuccessCounter,
 		executorOperationFailCounter,
+		executorRateLimitedCount,
+		executorCircuitBreakerOpenCount,
+		executorCircuitBreakerTripCount,
+		executorPermissionDeniedCount,
+		executorOperationDeferredCount,
+		executorDiagnosisThrottledCount,
 	)
 
 	transport := utilnet.SetTransportDefaults(
@@ -208,19 +346,32 @@ func NewExecutor(
 		})
 
 	return &executor{
-		Context:       ctx,
-		Logger:        logger,
-		client:        cli,
-		dockerClient:  dockerClient,
-		eventRecorder: eventRecorder,
-		scheme:        scheme,
-		cache:         cache,
-		nodeName:      nodeName,
-		transport:     transport,
-		bindAddress:   bindAddress,
-		port:          port,
-		dataRoot:      dataRoot,
-		taskCh:        taskCh,
+		Context:            ctx,
+		Logger:             logger,
+		client:             cli,
+		dockerClient:       dockerClient,
+		eventRecorder:      eventRecorder,
+		scheme:             scheme,
+		cache:              cache,
+		nodeName:           nodeName,
+		transport:          transport,
+		bindAddress:        bindAddress,
+		port:               port,
+		dataRoot:           dataRoot,
+		taskQueue:          taskQueue,
+		taskWorkers:        taskWorkers,
+		checkpoints:        newCheckpointStore(logger, dataRoot),
+		concurrencyLimiter: newDiagnosisConcurrencyLimiter(maxConcurrentDiagnoses),
+		guards:             make(map[string]*processorGuard),
+		retryBudgets:       make(map[string]int),
+		artifactUploader:   artifactUploader,
+		resultShipper:      resultShipper,
+		auditor:            auditor,
+		operationPolicy:    operationPolicy,
+		resultRedactor:     resultRedactor,
+		signatureVerifier:  signatureVerifier,
+		encryptor:          encryptor,
+		resourceGuard:      &nodeResourceGuard{dataRoot: dataRoot},
 	}
 }
 
@@ -231,54 +382,136 @@ func (ex *executor) Run(stopCh <-chan struct{}) {
 		return
 	}
 
-	for {
-		select {
-		// Process diagnoses queuing in executor channel.
-		case task := <-ex.taskCh:
-			err := ex.client.Get(ex, client.ObjectKey{
-				Name:      task.Name,
-				Namespace: task.Namespace,
-			}, &task)
-			if err != nil {
-				if apierrors.IsNotFound(err) {
-					continue
-				}
-				ex.addTaskToExecutorQueue(task)
-				continue
-			}
+	// Reconcile checkpoints persisted by a previous agent process before resuming, so
+	// checkpoints of tasks which finished or were deleted while the agent was down do not
+	// linger on disk.
+	ex.reconcileCheckpoints()
 
-			// Only process task in DiagnosisRunning phase.
-			if task.Status.Phase != diagnosisv1.TaskRunning {
-				continue
-			}
+	// Evict retry budget entries of finished diagnoses periodically, so the budget map does
+	// not grow without bound.
+	go wait.Until(ex.evictRetryBudgets, retryBudgetEvictionInterval, stopCh)
 
-			// Only process diagnosis on designated node.
-			if util.IsTaskNodeNameMatched(task, ex.nodeName) {
-				go func() {
-					task, err := ex.SyncTaskWithRetry(TaskBackoff, task)
-					if err != nil {
-						ex.Error(err, "failed to sync Task", "task", client.ObjectKey{
-							Name:      task.Name,
-							Namespace: task.Namespace,
-						})
-						executorSyncErrorCount.Inc()
-						return
-					}
-
-					ex.Info("syncing Task successfully", "task", client.ObjectKey{
-						Name:      task.Name,
-						Namespace: task.Namespace,
-					})
-				}()
+	// Process tasks queuing in executor work queue. Failed tasks are retried with rate limited
+	// backoff by the work queue.
+	taskWorkers := ex.taskWorkers
+	if taskWorkers <= 0 {
+		taskWorkers = defaultTaskWorkers
+	}
+	ex.taskQueue.Run(taskWorkers, ex.handleTask, stopCh)
+}
+
+// Drain stops the executor from starting new operations. In-flight tasks stay in TaskRunning
+// phase and are resumed by the replacement agent pod after a rolling update.
+func (ex *executor) Drain() {
+	ex.Info("executor is draining, no new operations are started")
+	atomic.StoreInt32(&ex.draining, 1)
+}
+
+// handleTask processes the task specified by the key in namespace/name format. It returns an
+// error if the task should be requeued.
+func (ex *executor) handleTask(key string) error {
+	namespace, name, err := clientgocache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		ex.Error(err, "invalid task key", "key", key)
+		return nil
+	}
+
+	var task diagnosisv1.Task
+	err = ex.client.Get(ex, client.ObjectKey{
+		Name:      name,
+		Namespace: namespace,
+	}, &task)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	// Only process task in TaskRunning phase. Any persisted checkpoint is removed once the
+	// task has left the running phase.
+	if task.Status.Phase != diagnosisv1.TaskRunning {
+		ex.checkpoints.complete(task.Namespace, task.Name)
+		return nil
+	}
+
+	// Only process task on designated node.
+	if !util.IsTaskNodeNameMatched(task, ex.nodeName) {
+		return nil
+	}
+
+	// Do not start new operations while draining. The task stays in TaskRunning phase, so the
+	// replacement agent pod picks it up after the rolling update.
+	if atomic.LoadInt32(&ex.draining) == 1 {
+		ex.Info("executor is draining, task is left for the replacement agent", "task", client.ObjectKey{
+			Name:      task.Name,
+			Namespace: task.Namespace,
+		})
+		return nil
+	}
+
+	task, err = ex.SyncTaskWithRetry(taskBackoff(task), task)
+	if err != nil {
+		ex.Error(err, "failed to sync Task", "task", client.ObjectKey{
+			Name:      task.Name,
+			Namespace: task.Namespace,
+		})
+		executorSyncErrorCount.Inc()
+		return err
+	}
+
+	ex.Info("syncing Task successfully", "task", client.ObjectKey{
+		Name:      task.Name,
+		Namespace: task.Namespace,
+	})
+	return nil
+}
+
+// reconcileCheckpoints removes checkpoints of tasks which finished or were deleted while the
+// agent was down. Checkpoints of tasks still in running phase are kept, so the interruption
+// of their operations is detected when the tasks are re-enqueued.
+func (ex *executor) reconcileCheckpoints() {
+	for _, checkpoint := range ex.checkpoints.list() {
+		var task diagnosisv1.Task
+		err := ex.client.Get(ex, client.ObjectKey{
+			Name:      checkpoint.Name,
+			Namespace: checkpoint.Namespace,
+		}, &task)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				ex.checkpoints.complete(checkpoint.Namespace, checkpoint.Name)
+			} else {
+				ex.Error(err, "unable to get task of checkpoint", "task", client.ObjectKey{
+					Name:      checkpoint.Name,
+					Namespace: checkpoint.Namespace,
+				})
 			}
-		// Stop executor on stop signal.
-		case <-stopCh:
-			return
+			continue
+		}
+
+		if task.Status.Phase != diagnosisv1.TaskRunning {
+			ex.checkpoints.complete(checkpoint.Namespace, checkpoint.Name)
 		}
 	}
 }
 
-// SyncTaskWithRetry syncs diagnoses with backoff.
+// taskBackoff returns the backoff for retrying a failed task. The retry policy of the node the
+// task was created for takes precedence over the recommended TaskBackoff.
+func taskBackoff(task diagnosisv1.Task) wait.Backoff {
+	backoff := TaskBackoff
+	if task.Spec.Retries != nil {
+		backoff.Steps = int(*task.Spec.Retries) + 1
+		if task.Spec.BackoffSeconds != nil {
+			backoff.Duration = time.Duration(*task.Spec.BackoffSeconds) * time.Second
+		}
+	}
+
+	return backoff
+}
+
+// SyncTaskWithRetry syncs diagnoses with exponential backoff and jitter. Retries are bounded by
+// the retry budget of the diagnosis owning the task, so transient processor errors are retried
+// while a persistently failing diagnosis cannot retry without bound.
 func (ex *executor) SyncTaskWithRetry(backoff wait.Backoff, task diagnosisv1.Task) (diagnosisv1.Task, error) {
 	err := errors.New("timed out waiting for the condition")
 	for backoff.Steps > 0 {
@@ -288,6 +521,13 @@ func (ex *executor) SyncTaskWithRetry(backoff wait.Backoff, task diagnosisv1.Tas
 		if backoff.Steps == 1 {
 			break
 		}
+		if !ex.consumeRetryBudget(task) {
+			ex.Info("retry budget of diagnosis exhausted", "task", client.ObjectKey{
+				Name:      task.Name,
+				Namespace: task.Namespace,
+			})
+			break
+		}
 		time.Sleep(backoff.Step())
 	}
 
@@ -300,9 +540,10 @@ func (ex *executor) SyncTaskWithRetry(backoff wait.Backoff, task diagnosisv1.Tas
 		Reason:  "SyncTaskFailed",
 		Message: err.Error(),
 	})
-	if err := ex.client.Status().Update(ex, &task); err != nil {
+	if err := util.PatchTaskStatus(ex, ex.client, &task); err != nil {
 		return task, fmt.Errorf("unable to update Diagnosis: %s", err)
 	}
+	ex.checkpoints.complete(task.Namespace, task.Name)
 	executorSyncFailCount.Inc()
 	return task, err
 }
@@ -335,7 +576,7 @@ func (ex *executor) syncTask(task diagnosisv1.Task) (diagnosisv1.Task, error) {
 				Reason:  "OperationNotFound",
 				Message: fmt.Sprintf("Operation %s is not found", operation.Name),
 			})
-			if err := ex.client.Status().Update(ex, &task); err != nil {
+			if err := util.PatchTaskStatus(ex, ex.client, &task); err != nil {
 				return task, fmt.Errorf("unable to update Task: %s", err)
 			}
 			executorSyncFailCount.Inc()
@@ -345,42 +586,366 @@ func (ex *executor) syncTask(task diagnosisv1.Task) (diagnosisv1.Task, error) {
 		return task, err
 	}
 
+	// Validate the task parameters against the parameter schema declared by the operation, so
+	// a mismatch fails the task with a precise message instead of an opaque processor failure
+	// deep in execution.
+	if operation.Spec.ParameterSchema != nil {
+		if violations := operation.Spec.ParameterSchema.Validate(task.Spec.Parameters); len(violations) > 0 {
+			ex.Info("task parameters do not conform to the parameter schema of operation", "task", client.ObjectKey{
+				Name:      task.Name,
+				Namespace: task.Namespace,
+			}, "operation", operation.Name, "violations", violations)
+
+			ex.eventRecorder.Eventf(&task, corev1.EventTypeWarning, "InvalidParameters", "Parameters of operation %s are invalid: %s", operation.Name, strings.Join(violations, "; "))
+			task.Status.Phase = diagnosisv1.TaskFailed
+			util.UpdateTaskCondition(&task.Status, &diagnosisv1.TaskCondition{
+				Type:    diagnosisv1.InvalidParameters,
+				Status:  corev1.ConditionTrue,
+				Reason:  "InvalidParameters",
+				Message: fmt.Sprintf("Parameters of operation %s are invalid: %s", operation.Name, strings.Join(violations, "; ")),
+			})
+			if err := util.PatchTaskStatus(ex, ex.client, &task); err != nil {
+				return task, fmt.Errorf("unable to update Task: %s", err)
+			}
+			executorSyncFailCount.Inc()
+			return task, nil
+		}
+	}
+
 	// Construct request data for current operation by adding contexts and operation results.
 	// The request data is a map[string]string which contains key value pairs.
 	data := make(map[string]string)
 	for key, value := range task.Spec.Parameters {
 		data[key] = value
 	}
+
+	// Resolve parameters referenced from secrets and config maps at execution time, so
+	// credentials for processors never appear in the diagnosis or task object.
+	if len(task.Spec.ParameterFrom) != 0 {
+		if err := ex.resolveParameterSources(task, data); err != nil {
+			ex.Info("unable to resolve parameter sources of task", "task", client.ObjectKey{
+				Name:      task.Name,
+				Namespace: task.Namespace,
+			}, "operation", operation.Name, "reason", err)
+
+			ex.eventRecorder.Eventf(&task, corev1.EventTypeWarning, "ParameterNotResolved", "Failed to resolve parameters of task %s/%s: %s", task.Namespace, task.Name, err)
+			task.Status.Phase = diagnosisv1.TaskFailed
+			util.UpdateTaskCondition(&task.Status, &diagnosisv1.TaskCondition{
+				Type:    diagnosisv1.ParameterNotResolved,
+				Status:  corev1.ConditionTrue,
+				Reason:  "ParameterNotResolved",
+				Message: err.Error(),
+			})
+			if err := util.PatchTaskStatus(ex, ex.client, &task); err != nil {
+				return task, fmt.Errorf("unable to update Task: %s", err)
+			}
+			executorSyncFailCount.Inc()
+			return task, nil
+		}
+	}
+
 	updateTaskContext(data, task)
 
+	// Inject the versioned context envelope into request data so that processors do not have to
+	// make redundant api or cache lookups.
+	envelope := ex.buildContextEnvelope(task)
+
+	// Render go template expressions in parameter values against the task and its diagnosis,
+	// so an operation set can be generic across targets.
+	if err := renderParameterTemplates(task, envelope.Diagnosis, data); err != nil {
+		ex.Info("unable to render parameter templates of task", "task", client.ObjectKey{
+			Name:      task.Name,
+			Namespace: task.Namespace,
+		}, "operation", operation.Name, "reason", err)
+
+		ex.eventRecorder.Eventf(&task, corev1.EventTypeWarning, "InvalidParameterTemplate", "Failed to render parameter templates of task %s/%s: %s", task.Namespace, task.Name, err)
+		task.Status.Phase = diagnosisv1.TaskFailed
+		util.UpdateTaskCondition(&task.Status, &diagnosisv1.TaskCondition{
+			Type:    diagnosisv1.InvalidParameters,
+			Status:  corev1.ConditionTrue,
+			Reason:  "InvalidParameterTemplate",
+			Message: err.Error(),
+		})
+		if err := util.PatchTaskStatus(ex, ex.client, &task); err != nil {
+			return task, fmt.Errorf("unable to update Task: %s", err)
+		}
+		executorSyncFailCount.Inc()
+		return task, nil
+	}
+
+	raw, err := json.Marshal(envelope)
+	if err != nil {
+		return task, fmt.Errorf("failed to marshal context envelope: %s", err)
+	}
+	data[ContextEnvelopeTelemetryKey] = string(raw)
+
+	// Prepare the working directory declared by the operation and expose its path to the
+	// processor, so large captures can target a dedicated scratch disk instead of the shared
+	// data root.
+	workspace, err := ex.prepareWorkspace(operation, task)
+	if err != nil {
+		return task, fmt.Errorf("unable to prepare workspace of operation %s: %s", operation.Name, err)
+	}
+	if workspace != "" {
+		data[WorkspaceTelemetryKey] = workspace
+	}
+
+	// Inject the progress callback url so long running processors can report percentage and
+	// phase messages, which the agent relays into the diagnosis status.
+	data[ProgressCallbackTelemetryKey] = fmt.Sprintf("http://%s:%d/api/v1/progress/%s/%s", ex.bindAddress, ex.port, task.Namespace, task.Name)
+
 	ex.Info("running operation", "task", client.ObjectKey{
 		Name:      task.Name,
 		Namespace: task.Namespace,
 	}, "operation", operation.Name)
 
+	// Reject the execution if the processor is rate limited or its circuit breaker is open, so a
+	// misbehaving processor does not absorb all executor workers.
+	guard := ex.guardFor(operation.Name)
+	if allowed, reason := guard.Allow(); !allowed {
+		ex.Info("operation execution rejected", "task", client.ObjectKey{
+			Name:      task.Name,
+			Namespace: task.Namespace,
+		}, "operation", operation.Name, "reason", reason)
+		if reason == "rate limit exceeded" {
+			executorRateLimitedCount.WithLabelValues(operation.Name).Inc()
+		} else {
+			executorCircuitBreakerOpenCount.WithLabelValues(operation.Name).Inc()
+		}
+		return task, fmt.Errorf("operation %s rejected: %s", operation.Name, reason)
+	}
+
+	// Verify the operation signature before execution, so unsigned or tampered operations are
+	// refused in environments which enforce signature verification.
+	if ex.signatureVerifier != nil {
+		if err := ex.signatureVerifier.Verify(operation.Annotations, operation.Spec); err != nil {
+			ex.Info("operation signature verification failed", "task", client.ObjectKey{
+				Name:      task.Name,
+				Namespace: task.Namespace,
+			}, "operation", operation.Name, "reason", err)
+
+			ex.eventRecorder.Eventf(&task, corev1.EventTypeWarning, "OperationNotVerified", "Signature verification of operation %s failed: %v", operation.Name, err)
+			ex.auditTask(task, operation, "Denied", fmt.Sprintf("signature verification failed: %v", err))
+			task.Status.Phase = diagnosisv1.TaskFailed
+			util.UpdateTaskCondition(&task.Status, &diagnosisv1.TaskCondition{
+				Type:    diagnosisv1.OperationNotVerified,
+				Status:  corev1.ConditionTrue,
+				Reason:  "OperationNotVerified",
+				Message: fmt.Sprintf("Signature verification of operation %s failed: %v", operation.Name, err),
+			})
+			if err := util.PatchTaskStatus(ex, ex.client, &task); err != nil {
+				return task, fmt.Errorf("unable to update Task: %s", err)
+			}
+			executorSyncFailCount.Inc()
+			return task, nil
+		}
+	}
+
+	// Enforce the operation policy before invoking the processor. A mutating operation which is
+	// not permitted by the policy fails the task without being executed.
+	if ex.operationPolicy != nil {
+		if permitted, reason := ex.permitOperation(task, operation); !permitted {
+			ex.Info("operation is not permitted by the operation policy", "task", client.ObjectKey{
+				Name:      task.Name,
+				Namespace: task.Namespace,
+			}, "operation", operation.Name, "reason", reason)
+			executorPermissionDeniedCount.Inc()
+
+			ex.eventRecorder.Eventf(&task, corev1.EventTypeWarning, "OperationNotPermitted", "Operation %s is not permitted: %s", operation.Name, reason)
+			ex.auditTask(task, operation, "Denied", reason)
+			task.Status.Phase = diagnosisv1.TaskFailed
+			util.UpdateTaskCondition(&task.Status, &diagnosisv1.TaskCondition{
+				Type:    diagnosisv1.OperationNotPermitted,
+				Status:  corev1.ConditionTrue,
+				Reason:  "OperationNotPermitted",
+				Message: reason,
+			})
+			if err := util.PatchTaskStatus(ex, ex.client, &task); err != nil {
+				return task, fmt.Errorf("unable to update Task: %s", err)
+			}
+			executorSyncFailCount.Inc()
+			return task, nil
+		}
+	}
+
+	// Skip node mutating operations when replaying a recorded diagnosis, so a replay cannot
+	// change the state of the node.
+	if task.Annotations[util.ReplayAnnotation] == "true" && operation.Annotations[NodeMutatingOperationAnnotation] == "true" {
+		ex.Info("skipping node mutating operation on replay", "task", client.ObjectKey{
+			Name:      task.Name,
+			Namespace: task.Namespace,
+		}, "operation", operation.Name)
+
+		ex.eventRecorder.Eventf(&task, corev1.EventTypeNormal, "OperationSkipped", "Operation %s is node mutating and skipped on replay", operation.Name)
+		task.Status.Phase = diagnosisv1.TaskSucceeded
+		util.UpdateTaskCondition(&task.Status, &diagnosisv1.TaskCondition{
+			Type:    diagnosisv1.TaskComplete,
+			Status:  corev1.ConditionTrue,
+			Reason:  "SkippedOnReplay",
+			Message: fmt.Sprintf("Operation %s is node mutating and was skipped on replay", operation.Name),
+		})
+		if err := util.PatchTaskStatus(ex, ex.client, &task); err != nil {
+			return task, fmt.Errorf("unable to update Task: %s", err)
+		}
+		executorSyncSkipCount.Inc()
+		return task, nil
+	}
+
+	// Defer resource intensive operations while the node is under cpu, memory or disk pressure.
+	// The deferral is recorded as a condition and the task is retried with backoff, so the
+	// operation runs once the node has headroom again.
+	if operation.Annotations[ResourceIntensiveOperationAnnotation] == "true" {
+		if reason := ex.resourceGuard.pressure(); reason != "" {
+			ex.Info("deferring resource intensive operation on node under pressure", "task", client.ObjectKey{
+				Name:      task.Name,
+				Namespace: task.Namespace,
+			}, "operation", operation.Name, "reason", reason)
+
+			ex.eventRecorder.Eventf(&task, corev1.EventTypeWarning, "OperationDeferred", "Operation %s deferred since %s", operation.Name, reason)
+			util.UpdateTaskCondition(&task.Status, &diagnosisv1.TaskCondition{
+				Type:    diagnosisv1.NodeUnderPressure,
+				Status:  corev1.ConditionTrue,
+				Reason:  "NodeUnderPressure",
+				Message: fmt.Sprintf("Operation %s was deferred since %s", operation.Name, reason),
+			})
+			if err := util.PatchTaskStatus(ex, ex.client, &task); err != nil {
+				return task, fmt.Errorf("unable to update Task: %s", err)
+			}
+			executorOperationDeferredCount.Inc()
+			return task, fmt.Errorf("operation %s deferred since %s", operation.Name, reason)
+		}
+	}
+
+	// Queue the task while the node is already running the maximum number of concurrent
+	// diagnoses, so dozens of heavy profilers cannot run simultaneously and OOM the node.
+	// The deferral is recorded as a condition and the task is retried with backoff, so the
+	// operation runs once a slot is free.
+	diagnosisKey := retryBudgetKey(task)
+	if !ex.concurrencyLimiter.acquire(diagnosisKey) {
+		ex.Info("queuing operation since the node concurrency limit is reached", "task", client.ObjectKey{
+			Name:      task.Name,
+			Namespace: task.Namespace,
+		}, "operation", operation.Name, "limit", ex.concurrencyLimiter.limit)
+
+		ex.eventRecorder.Eventf(&task, corev1.EventTypeWarning, "DiagnosisThrottled", "Operation %s queued since the node is running the maximum of %d concurrent diagnoses", operation.Name, ex.concurrencyLimiter.limit)
+		util.UpdateTaskCondition(&task.Status, &diagnosisv1.TaskCondition{
+			Type:    diagnosisv1.ConcurrencyLimitReached,
+			Status:  corev1.ConditionTrue,
+			Reason:  "MaxConcurrentDiagnosesReached",
+			Message: fmt.Sprintf("Operation %s was queued since the node is running the maximum of %d concurrent diagnoses", operation.Name, ex.concurrencyLimiter.limit),
+		})
+		if err := util.PatchTaskStatus(ex, ex.client, &task); err != nil {
+			return task, fmt.Errorf("unable to update Task: %s", err)
+		}
+		executorDiagnosisThrottledCount.Inc()
+		return task, fmt.Errorf("operation %s queued since the node concurrency limit is reached", operation.Name)
+	}
+	defer ex.concurrencyLimiter.release(diagnosisKey)
+
+	// Serialize node mutating operations so concurrent tasks cannot apply conflicting changes to
+	// the node, while other operations are executed concurrently by the task workers.
+	if operation.Annotations[NodeMutatingOperationAnnotation] == "true" {
+		ex.nodeLock.Lock()
+		defer ex.nodeLock.Unlock()
+	}
+
+	// Persist a checkpoint before the operation is invoked, so an agent restart in the middle
+	// of the operation can be detected instead of blindly re-running it. The idempotency
+	// token is stable across retries and restarts and is exposed to the processor, so side
+	// effecting recoverers can deduplicate work.
+	checkpoint, interrupted := ex.checkpoints.begin(task, operation, ex.nodeName)
+	data[IdempotencyTokenTelemetryKey] = checkpoint.Token
+	if interrupted && operation.Annotations[NodeMutatingOperationAnnotation] == "true" {
+		ex.Info("node mutating operation was interrupted by an agent restart", "task", client.ObjectKey{
+			Name:      task.Name,
+			Namespace: task.Namespace,
+		}, "operation", operation.Name)
+
+		ex.eventRecorder.Eventf(&task, corev1.EventTypeWarning, "OperationInterrupted", "Operation %s was interrupted by an agent restart and may have partially applied its side effects", operation.Name)
+		task.Status.Phase = diagnosisv1.TaskFailed
+		util.UpdateTaskCondition(&task.Status, &diagnosisv1.TaskCondition{
+			Type:    diagnosisv1.OperationInterrupted,
+			Status:  corev1.ConditionTrue,
+			Reason:  "AgentRestarted",
+			Message: fmt.Sprintf("Operation %s was interrupted by an agent restart and may have partially applied its side effects", operation.Name),
+		})
+		if err := util.PatchTaskStatus(ex, ex.client, &task); err != nil {
+			return task, fmt.Errorf("unable to update Task: %s", err)
+		}
+		ex.checkpoints.complete(task.Namespace, task.Name)
+		executorSyncFailCount.Inc()
+		return task, nil
+	}
+
+	// Bound the execution with the node level timeout of the operation set if the task
+	// specifies one.
+	if task.Spec.TimeoutSeconds != nil {
+		operation.Spec.Processor.TimeoutSeconds = task.Spec.TimeoutSeconds
+	}
+
 	// Execute the operation by sending http request to the processor or running predefined script.
 	var succeeded bool
 	var result map[string]string
 	if operation.Spec.Processor.HTTPServer != nil {
 		succeeded, result, err = ex.doHTTPRequestWithContext(operation, data)
+		if err != nil && errors.Is(err, errProcessorServerError) && task.Spec.Retries == nil {
+			// Processors conventionally report a failed operation with a server error, so a
+			// 5xx only triggers the retry backoff if the node declares a retry policy.
+			succeeded = false
+			err = nil
+		}
 		if err != nil {
 			executorOperationErrorCounter.Inc()
+			ex.recordProcessorFailure(guard, operation, &task)
+			ex.auditTask(task, operation, "Error", err.Error())
+			return task, err
+		}
+	} else if operation.Spec.Processor.GRPCServer != nil {
+		succeeded, result, err = ex.doGRPCRequestWithContext(operation, data)
+		if err != nil && errors.Is(err, errProcessorServerError) && task.Spec.Retries == nil {
+			// A grpc server error is treated like a http 5xx and only triggers the retry
+			// backoff if the node declares a retry policy.
+			succeeded = false
+			err = nil
+		}
+		if err != nil {
+			executorOperationErrorCounter.Inc()
+			ex.recordProcessorFailure(guard, operation, &task)
+			ex.auditTask(task, operation, "Error", err.Error())
 			return task, err
 		}
 	} else if operation.Spec.Processor.ScriptRunner != nil {
 		succeeded, result, err = ex.runScriptWithContext(operation, data)
 		if err != nil {
 			executorOperationErrorCounter.Inc()
+			ex.recordProcessorFailure(guard, operation, &task)
+			ex.auditTask(task, operation, "Error", err.Error())
 			return task, err
 		}
 	} else if operation.Spec.Processor.Function != nil {
 		succeeded, result, err = ex.runFunctionWithContext(operation, data)
 		if err != nil {
 			executorOperationErrorCounter.Inc()
+			ex.recordProcessorFailure(guard, operation, &task)
+			ex.auditTask(task, operation, "Error", err.Error())
 			return task, err
 		}
 	}
 
+	// Enforce the size limit of an ephemeral workspace after the operation has run, so a
+	// runaway capture cannot fill the node disk. The workspace is removed and the task fails
+	// if the limit is exceeded.
+	if succeeded && workspace != "" {
+		if reason := ex.enforceWorkspaceSizeLimit(operation, workspace); reason != "" {
+			ex.Info("workspace size limit of operation exceeded", "task", client.ObjectKey{
+				Name:      task.Name,
+				Namespace: task.Namespace,
+			}, "operation", operation.Name, "reason", reason)
+			ex.eventRecorder.Eventf(&task, corev1.EventTypeWarning, "WorkspaceSizeLimitExceeded", "Operation %s failed since %s", operation.Name, reason)
+			succeeded = false
+		}
+	}
+
 	// Update the operation result into task status.
 	if succeeded {
 		ex.Info("operation executed successfully", "task", client.ObjectKey{
@@ -389,20 +954,53 @@ func (ex *executor) syncTask(task diagnosisv1.Task) (diagnosisv1.Task, error) {
 		}, "operation", operation.Name)
 		ex.eventRecorder.Eventf(&task, corev1.EventTypeNormal, "OperationSucceeded", "Operation %s executed successfully", operation.Name)
 		executorOperationSuccessCounter.Inc()
+		guard.Succeed()
+
+		// Redact secrets from the operation result before it is stored or exported, unless the
+		// operation has opted out of redaction.
+		if ex.resultRedactor != nil && operation.Annotations[redactor.RedactionDisabledAnnotation] != "true" {
+			ex.resultRedactor.RedactResults(result)
+		}
 
 		// Set operation result according to response from operaton processor.
 		if task.Status.Results == nil {
 			task.Status.Results = make(map[string]string)
 		}
 		for key, value := range result {
-			task.Status.Results[key] = value
+			compressed, err := util.CompressLargeValue(value, resultCompressionThresholdBytes)
+			if err != nil {
+				ex.Error(err, "failed to compress operation result", "task", client.ObjectKey{
+					Name:      task.Name,
+					Namespace: task.Namespace,
+				}, "key", key)
+				compressed = value
+			}
+
+			// Offload values which are still large after compression to artifact storage, so
+			// huge collector outputs do not bloat the diagnosis object. Only a reference with
+			// the location and checksum of the payload is stored in the result.
+			if len(compressed) > resultOffloadThresholdBytes {
+				reference, err := ex.offloadResultValue(operation, compressed)
+				if err != nil {
+					ex.Error(err, "failed to offload operation result", "task", client.ObjectKey{
+						Name:      task.Name,
+						Namespace: task.Namespace,
+					}, "key", key)
+				} else {
+					compressed = reference
+				}
+			}
+			task.Status.Results[key] = compressed
 		}
 
 		task.Status.Phase = diagnosisv1.TaskSucceeded
-		if err := ex.client.Status().Update(ex, &task); err != nil {
+		if err := util.PatchTaskStatus(ex, ex.client, &task); err != nil {
 			return task, fmt.Errorf("unable to update Task: %s", err)
 		}
+		ex.checkpoints.complete(task.Namespace, task.Name)
 		executorSyncSuccessCount.Inc()
+		ex.shipTaskResult(task, operation, result)
+		ex.auditTask(task, operation, "Succeeded", "")
 		return task, nil
 	} else {
 		ex.Info("failed to execute operation", "task", client.ObjectKey{
@@ -411,16 +1009,135 @@ func (ex *executor) syncTask(task diagnosisv1.Task) (diagnosisv1.Task, error) {
 		}, "operation", operation.Name)
 		ex.eventRecorder.Eventf(&task, corev1.EventTypeWarning, "OperationFailed", "Failed to execute operation %s", operation.Name)
 		executorOperationFailCounter.Inc()
+		ex.recordProcessorFailure(guard, operation, &task)
 
 		task.Status.Phase = diagnosisv1.TaskFailed
-		if err := ex.client.Status().Update(ex, &task); err != nil {
+		if err := util.PatchTaskStatus(ex, ex.client, &task); err != nil {
 			return task, fmt.Errorf("unable to update Diagnosis: %s", err)
 		}
+		ex.checkpoints.complete(task.Namespace, task.Name)
 		executorSyncFailCount.Inc()
+		ex.auditTask(task, operation, "Failed", fmt.Sprintf("operation %s reported a failure", operation.Name))
 		return task, nil
 	}
 }
 
+// permitOperation checks the operation against the operation policy. A mutating operation is
+// permitted if the policy allows it to mutate all of its declared resource kinds against the
+// target namespace and the local node. Operations which do not declare mutated resources are
+// considered read only and are always permitted.
+func (ex *executor) permitOperation(task diagnosisv1.Task, operation diagnosisv1.Operation) (bool, string) {
+	resources := policy.MutatedResources(operation.Annotations)
+	if len(resources) == 0 {
+		return true, ""
+	}
+
+	namespace := ""
+	if task.Spec.PodReference != nil {
+		namespace = task.Spec.PodReference.Namespace
+	}
+	for _, resource := range resources {
+		if !ex.operationPolicy.Allow(operation.Name, resource, namespace, ex.nodeName) {
+			return false, fmt.Sprintf("operation %s is not permitted to mutate %s in namespace %q on node %s", operation.Name, resource, namespace, ex.nodeName)
+		}
+	}
+
+	return true, ""
+}
+
+// prepareWorkspace creates the working directory declared by the operation and returns its
+// path. A host path workspace is shared by all tasks of the operation while an ephemeral
+// workspace is created per diagnosis under the workspaces subdirectory of the data root, so
+// it is removed once the diagnosis data is garbage collected. It returns an empty path if the
+// operation does not declare a workspace.
+func (ex *executor) prepareWorkspace(operation diagnosisv1.Operation, task diagnosisv1.Task) (string, error) {
+	if operation.Spec.Workspace == nil {
+		return "", nil
+	}
+
+	var path string
+	if operation.Spec.Workspace.HostPath != nil {
+		path = operation.Spec.Workspace.HostPath.Path
+		if path == "" {
+			path = ex.dataRoot
+		}
+	} else if operation.Spec.Workspace.EphemeralDir != nil {
+		namespace := task.Labels["diagnosis-namespace"]
+		name := task.Labels["diagnosis-name"]
+		if namespace == "" || name == "" {
+			namespace = task.Namespace
+			name = strings.Split(task.Name, ".")[0]
+		}
+		path = filepath.Join(ex.dataRoot, WorkspaceSubDirectory, namespace+"."+name)
+	} else {
+		return "", nil
+	}
+
+	if err := os.MkdirAll(path, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// enforceWorkspaceSizeLimit checks an ephemeral workspace against its declared size limit and
+// removes the workspace if the limit is exceeded. It returns an empty reason if the workspace
+// is within its limit or does not declare one.
+func (ex *executor) enforceWorkspaceSizeLimit(operation diagnosisv1.Operation, workspace string) string {
+	if operation.Spec.Workspace == nil || operation.Spec.Workspace.EphemeralDir == nil {
+		return ""
+	}
+	sizeLimitBytes := operation.Spec.Workspace.EphemeralDir.SizeLimitBytes
+	if sizeLimitBytes <= 0 {
+		return ""
+	}
+
+	usage, err := util.DiskUsage(workspace)
+	if err != nil {
+		ex.Error(err, "unable to measure workspace usage", "workspace", workspace)
+		return ""
+	}
+	if int64(usage) <= sizeLimitBytes {
+		return ""
+	}
+
+	if err := os.RemoveAll(workspace); err != nil {
+		ex.Error(err, "unable to remove workspace over size limit", "workspace", workspace)
+	}
+
+	return fmt.Sprintf("workspace %s holds %d bytes which exceeds the size limit of %d bytes", workspace, usage, sizeLimitBytes)
+}
+
+// auditTask records the outcome of an executed operation to the audit log. It is a no-op if
+// auditing is disabled.
+func (ex *executor) auditTask(task diagnosisv1.Task, operation diagnosisv1.Operation, outcome string, reason string) {
+	if ex.auditor == nil {
+		return
+	}
+
+	entry := audit.Entry{
+		NodeName:           ex.nodeName,
+		DiagnosisNamespace: task.Labels["diagnosis-namespace"],
+		DiagnosisName:      task.Labels["diagnosis-name"],
+		TaskNamespace:      task.Namespace,
+		TaskName:           task.Name,
+		Operation:          operation.Name,
+		Parameters:         task.Spec.Parameters,
+		Outcome:            outcome,
+		Reason:             reason,
+	}
+	if task.Spec.PodReference != nil {
+		entry.PodNamespace = task.Spec.PodReference.Namespace
+		entry.PodName = task.Spec.PodReference.Name
+	}
+	if err := ex.auditor.Record(entry); err != nil {
+		ex.Error(err, "unable to record audit entry", "task", client.ObjectKey{
+			Name:      task.Name,
+			Namespace: task.Namespace,
+		}, "operation", operation.Name)
+	}
+}
+
 // doHTTPRequestWithContext sends a http request to the operation processor with payload.
 // It returns a bool, a map and an error as results.
 func (ex *executor) doHTTPRequestWithContext(operation diagnosisv1.Operation, data map[string]string) (bool, map[string]string, error) {
@@ -467,6 +1184,56 @@ func (ex *executor) doHTTPRequestWithContext(operation diagnosisv1.Operation, da
 		return false, nil, err
 	}
 	defer res.Body.Close()
+
+	// Poll the processor if it accepted a long running operation with a response code of 202. The
+	// response body carries a token which identifies the operation in subsequent polls.
+	if res.StatusCode == http.StatusAccepted {
+		acceptedBody, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return false, nil, err
+		}
+		var accepted map[string]string
+		if err := json.Unmarshal(acceptedBody, &accepted); err != nil {
+			ex.Error(err, "failed to unmarshal response body", "response", string(acceptedBody))
+			return false, nil, nil
+		}
+		token, ok := accepted[AsyncOperationTokenKey]
+		if !ok {
+			ex.Info("processor accepted operation without token", "operation", operation.Name, "response", string(acceptedBody))
+			return false, nil, nil
+		}
+		ex.Info("processor accepted long running operation", "operation", operation.Name, "token", token)
+		return ex.pollAsyncOperation(operation, cli, url.String(), data, token)
+	}
+
+	// Stream the response body to artifact storage if the processor declares a streaming response.
+	// The result contains the artifact file path instead of the response payload, so a large output
+	// does not have to fit in a single response buffered in agent memory.
+	if res.StatusCode == http.StatusOK && res.Header.Get(StreamingResponseHeader) != "" {
+		artifactPath, size, err := ex.storeArtifact(operation, res.Body)
+		if err != nil {
+			return false, nil, fmt.Errorf("failed to store streamed operation result: %s", err)
+		}
+		ex.Info("operation result streamed to artifact storage", "operation", operation.Name, "artifact", artifactPath, "size", size)
+
+		// Upload the artifact to object storage and rewrite the artifact reference to the object
+		// url, so the result can be retrieved without access to the node.
+		if ex.artifactUploader != nil {
+			objectURL, err := ex.artifactUploader.UploadFile(operation.Name, artifactPath)
+			if err != nil {
+				ex.Error(err, "failed to upload artifact to object storage", "artifact", artifactPath)
+			} else {
+				artifactPath = objectURL
+			}
+		}
+
+		result := map[string]string{
+			OperationResultArtifactKey:     artifactPath,
+			OperationResultArtifactSizeKey: strconv.FormatInt(size, 10),
+		}
+		return true, result, nil
+	}
+
 	body, err = ioutil.ReadAll(res.Body)
 	if err != nil {
 		ex.Error(err, "failed to read http response body", "response", string(body))
@@ -480,6 +1247,9 @@ func (ex *executor) doHTTPRequestWithContext(operation diagnosisv1.Operation, da
 
 	if res.StatusCode != http.StatusOK {
 		ex.Info("http response with erroneous status", "status", res.Status, "response", string(body))
+		if res.StatusCode >= http.StatusInternalServerError {
+			return false, nil, fmt.Errorf("%w: %s", errProcessorServerError, res.Status)
+		}
 		return false, nil, nil
 	}
 
@@ -494,30 +1264,73 @@ func (ex *executor) doHTTPRequestWithContext(operation diagnosisv1.Operation, da
 	return true, result, nil
 }
 
-// runScriptWithContext runs a script with the arguments provided by context.
-// It returns a bool, a map and an error as results.
+// runScriptWithContext runs a script with the arguments provided by context. The script is
+// executed in a sandboxed working directory with its standard output and error captured, and
+// a nonzero exit status reports a failed operation. It returns a bool, a map and an error as
+// results.
 func (ex *executor) runScriptWithContext(operation diagnosisv1.Operation, data map[string]string) (bool, map[string]string, error) {
 	if operation.Spec.Processor.ScriptRunner == nil {
 		return false, nil, fmt.Errorf("script runner not specified")
 	}
 
-	// Generate all argument according to script runner definition and execute the script with timeout.
+	// Generate all argument according to script runner definition.
 	var args []string
 	for _, key := range operation.Spec.Processor.ScriptRunner.ArgKeys {
 		if value, ok := data[key]; ok {
 			args = append(args, value)
 		}
 	}
+
+	// Run the script in the workspace of the operation if one is declared, otherwise in a
+	// sandbox directory which is removed after the run.
+	workingDirectory, ok := data[WorkspaceTelemetryKey]
+	if !ok {
+		var err error
+		workingDirectory, err = ioutil.TempDir(ex.dataRoot, "sandbox")
+		if err != nil {
+			return false, nil, fmt.Errorf("unable to create sandbox directory for script of operation %s: %s", operation.Name, err)
+		}
+		defer os.RemoveAll(workingDirectory)
+	}
+
+	// Write the inline script into the working directory if it has not been synchronized to
+	// the scripts directory by the operation controller yet.
 	scriptFilePath := filepath.Join(ex.dataRoot, controllers.ScriptSubDirectory, operation.Name)
-	command := append([]string{"/bin/sh", scriptFilePath}, args...)
-	output, err := util.BlockingRunCommandWithTimeout(command, *operation.Spec.Processor.TimeoutSeconds)
+	if _, err := os.Stat(scriptFilePath); err != nil {
+		scriptFilePath = filepath.Join(workingDirectory, operation.Name)
+		if err := ioutil.WriteFile(scriptFilePath, []byte(operation.Spec.Processor.ScriptRunner.Script), 0700); err != nil {
+			return false, nil, fmt.Errorf("unable to write script of operation %s: %s", operation.Name, err)
+		}
+	}
 
-	// Update script execution result with output and error.
+	interpreter := "/bin/sh"
+	if operation.Spec.Processor.ScriptRunner.Interpreter != nil {
+		interpreter = *operation.Spec.Processor.ScriptRunner.Interpreter
+	}
+
+	// Execute the script with timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*operation.Spec.Processor.TimeoutSeconds)*time.Second)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	command := exec.CommandContext(ctx, interpreter, append([]string{scriptFilePath}, args...)...)
+	command.Dir = workingDirectory
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	err := command.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return false, nil, fmt.Errorf("script of operation %s timed out after %d seconds", operation.Name, *operation.Spec.Processor.TimeoutSeconds)
+	}
+
+	// Update script execution result with captured output and error.
 	result := make(map[string]string)
 	if operation.Spec.Processor.ScriptRunner.OperationResultKey != nil {
-		if output != nil {
+		if stdout.Len() != 0 {
 			key := strings.Join([]string{"operation", *operation.Spec.Processor.ScriptRunner.OperationResultKey, "output"}, ".")
-			result[key] = string(output)
+			result[key] = stdout.String()
+		}
+		if stderr.Len() != 0 {
+			key := strings.Join([]string{"operation", *operation.Spec.Processor.ScriptRunner.OperationResultKey, "stderr"}, ".")
+			result[key] = stderr.String()
 		}
 		if err != nil {
 			key := strings.Join([]string{"operation", *operation.Spec.Processor.ScriptRunner.OperationResultKey, "error"}, ".")
@@ -525,6 +1338,14 @@ func (ex *executor) runScriptWithContext(operation diagnosisv1.Operation, data m
 		}
 	}
 
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return false, nil, fmt.Errorf("unable to run script of operation %s: %s", operation.Name, err)
+		}
+		// A nonzero exit status reports a failed operation instead of an execution error.
+		return false, result, nil
+	}
+
 	return true, result, nil
 }
 
@@ -535,6 +1356,11 @@ func (ex *executor) runFunctionWithContext(operation diagnosisv1.Operation, data
 		return false, nil, fmt.Errorf("function not specified")
 	}
 
+	// A wasm function runs in process in the agent instead of a processor container.
+	if operation.Spec.Processor.Function.Runtime == diagnosisv1.WASMFunctionRuntime {
+		return ex.runWASMFunctionWithContext(operation, data)
+	}
+
 	imageName, tag := function.GetImageNameAndTag(&operation)
 	// Check if exist the image in local host.
 	if !function.ImageExists(ex.dockerClient, imageName, tag) {
@@ -602,6 +1428,9 @@ func (ex *executor) runFunctionWithContext(operation diagnosisv1.Operation, data
 
 	if res.StatusCode != http.StatusOK {
 		ex.Info("http response with erroneous status", "status", res.Status, "response", string(body))
+		if res.StatusCode >= http.StatusInternalServerError {
+			return false, nil, fmt.Errorf("%w: %s", errProcessorServerError, res.Status)
+		}
 		return false, nil, nil
 	}
 
@@ -616,6 +1445,95 @@ func (ex *executor) runFunctionWithContext(operation diagnosisv1.Operation, data
 	return true, result, nil
 }
 
+// recordProcessorFailure records a failed execution on the processor guard. A circuit breaker trip
+// is surfaced as a task condition, an event and a metric.
+func (ex *executor) recordProcessorFailure(guard *processorGuard, operation diagnosisv1.Operation, task *diagnosisv1.Task) {
+	if !guard.Fail() {
+		return
+	}
+
+	ex.Info("circuit breaker tripped for processor", "operation", operation.Name)
+	executorCircuitBreakerTripCount.WithLabelValues(operation.Name).Inc()
+	ex.eventRecorder.Eventf(task, corev1.EventTypeWarning, "ProcessorCircuitOpen", "Circuit breaker of operation %s tripped after consecutive failures", operation.Name)
+	util.UpdateTaskCondition(&task.Status, &diagnosisv1.TaskCondition{
+		Type:    diagnosisv1.ProcessorCircuitOpen,
+		Status:  corev1.ConditionTrue,
+		Reason:  "ConsecutiveProcessorFailures",
+		Message: fmt.Sprintf("Circuit breaker of operation %s tripped after consecutive failures", operation.Name),
+	})
+	if err := util.PatchTaskStatus(ex, ex.client, task); err != nil {
+		ex.Error(err, "unable to update Task", "task", client.ObjectKey{
+			Name:      task.Name,
+			Namespace: task.Namespace,
+		})
+	}
+}
+
+// storeArtifact streams the reader to a file under the artifact directory of data root and
+// encrypts the file at rest if encryption is enabled. It returns the artifact file path and the
+// number of plaintext bytes written.
+func (ex *executor) storeArtifact(operation diagnosisv1.Operation, reader io.Reader) (string, int64, error) {
+	artifactDir := filepath.Join(ex.dataRoot, ArtifactSubDirectory)
+	if err := os.MkdirAll(artifactDir, os.ModePerm); err != nil {
+		return "", 0, fmt.Errorf("unable to create artifact directory %s: %s", artifactDir, err)
+	}
+
+	artifactPath := filepath.Join(artifactDir, fmt.Sprintf("%s.%d", operation.Name, time.Now().UnixNano()))
+	file, err := os.Create(artifactPath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	size, err := io.Copy(file, reader)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := file.Close(); err != nil {
+		return "", 0, err
+	}
+
+	// Executor written artifacts hold the same operation output as registered artifacts, so
+	// they are encrypted at rest with the same encryptor.
+	if ex.encryptor != nil {
+		if err := ex.encryptor.EncryptFile(artifactPath); err != nil {
+			return "", 0, fmt.Errorf("unable to encrypt artifact file %s: %v", artifactPath, err)
+		}
+	}
+
+	return artifactPath, size, nil
+}
+
+// offloadResultValue writes a result value to artifact storage and returns a reference with
+// the location, checksum and size of the payload. The artifact is uploaded to object storage
+// if an uploader is configured, so the payload can be retrieved without access to the node.
+func (ex *executor) offloadResultValue(operation diagnosisv1.Operation, value string) (string, error) {
+	artifactPath, size, err := ex.storeArtifact(operation, strings.NewReader(value))
+	if err != nil {
+		return "", err
+	}
+
+	location := artifactPath
+	nodeName := ex.nodeName
+	if ex.artifactUploader != nil {
+		objectURL, err := ex.artifactUploader.UploadFile(operation.Name, artifactPath)
+		if err != nil {
+			ex.Error(err, "failed to upload artifact to object storage", "artifact", artifactPath)
+		} else {
+			location = objectURL
+			nodeName = ""
+		}
+	}
+
+	checksum := sha256.Sum256([]byte(value))
+	return util.EncodeOffloadedValue(util.OffloadedValue{
+		Location:  location,
+		NodeName:  nodeName,
+		Checksum:  hex.EncodeToString(checksum[:]),
+		SizeBytes: size,
+	})
+}
+
 // ensureK8sResource creates/updates k8s object (pod) for the operation.
 func (ex *executor) EnsureK8sResource(operation *diagnosisv1.Operation) (namespacedName types.NamespacedName, err error) {
 	namespacedName = types.NamespacedName{
@@ -701,15 +1619,84 @@ func hasDefaultLabel(labels map[string]string) bool {
 	return true
 }
 
-// addTaskToExecutorQueue adds Task to the queue processed by executor.
-func (ex *executor) addTaskToExecutorQueue(task diagnosisv1.Task) {
-	err := util.QueueTask(ex, ex.taskCh, task)
-	if err != nil {
-		ex.Error(err, "failed to send task to executor queue", "task", client.ObjectKey{
-			Name:      task.Name,
-			Namespace: task.Namespace,
-		})
+// renderParameterTemplates renders go template expressions in parameter values against the task
+// and the diagnosis which owns it. A template can reference the target of the task, such as
+// {{ .NodeName }} or {{ .PodReference.Namespace }}, and results of prior operations via
+// {{ .OperationResults }}. Values without template expressions are left untouched.
+func renderParameterTemplates(task diagnosisv1.Task, diagnosis *diagnosisv1.Diagnosis, data map[string]string) error {
+	templateContext := struct {
+		NodeName         string
+		PodReference     *diagnosisv1.PodReference
+		Parameters       map[string]string
+		OperationResults map[string]diagnosisv1.OperationContext
+	}{
+		NodeName:     task.Spec.NodeName,
+		PodReference: task.Spec.PodReference,
+		Parameters:   task.Spec.Parameters,
+	}
+	if diagnosis != nil && diagnosis.Status.Context != nil {
+		templateContext.OperationResults = diagnosis.Status.Context.Operations
+		if templateContext.Parameters == nil {
+			templateContext.Parameters = diagnosis.Status.Context.Parameters
+		}
+	}
+
+	for key, value := range data {
+		if !strings.Contains(value, "{{") {
+			continue
+		}
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return fmt.Errorf("invalid template in parameter %s: %s", key, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, templateContext); err != nil {
+			return fmt.Errorf("unable to render template in parameter %s: %s", key, err)
+		}
+		data[key] = buf.String()
+	}
+
+	return nil
+}
+
+// resolveParameterSources resolves parameter values referenced from secret and config map keys
+// in the namespace of the task and merges them into data. The resolved values are sent to the
+// operation processor only and are not stored in any api object.
+func (ex *executor) resolveParameterSources(task diagnosisv1.Task, data map[string]string) error {
+	for _, source := range task.Spec.ParameterFrom {
+		switch {
+		case source.SecretKeyRef != nil:
+			var secret corev1.Secret
+			if err := ex.client.Get(ex, client.ObjectKey{
+				Name:      source.SecretKeyRef.Name,
+				Namespace: task.Namespace,
+			}, &secret); err != nil {
+				return fmt.Errorf("unable to fetch Secret %s/%s: %s", task.Namespace, source.SecretKeyRef.Name, err)
+			}
+			value, ok := secret.Data[source.SecretKeyRef.Key]
+			if !ok {
+				return fmt.Errorf("key %s is not found in Secret %s/%s", source.SecretKeyRef.Key, task.Namespace, source.SecretKeyRef.Name)
+			}
+			data[source.Name] = string(value)
+		case source.ConfigMapKeyRef != nil:
+			var configMap corev1.ConfigMap
+			if err := ex.client.Get(ex, client.ObjectKey{
+				Name:      source.ConfigMapKeyRef.Name,
+				Namespace: task.Namespace,
+			}, &configMap); err != nil {
+				return fmt.Errorf("unable to fetch ConfigMap %s/%s: %s", task.Namespace, source.ConfigMapKeyRef.Name, err)
+			}
+			value, ok := configMap.Data[source.ConfigMapKeyRef.Key]
+			if !ok {
+				return fmt.Errorf("key %s is not found in ConfigMap %s/%s", source.ConfigMapKeyRef.Key, task.Namespace, source.ConfigMapKeyRef.Name)
+			}
+			data[source.Name] = value
+		default:
+			return fmt.Errorf("parameter %s specifies no secret or config map key reference", source.Name)
+		}
 	}
+
+	return nil
 }
 
 // updateTaskContext updates data with task contexts.
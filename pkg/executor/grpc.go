@@ -0,0 +1,100 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/processorproto"
+)
+
+// processorStreamDesc describes the server streaming Process method of the operation
+// processor service defined in api/proto/processor.proto.
+var processorStreamDesc = grpc.StreamDesc{
+	StreamName:    "Process",
+	ServerStreams: true,
+}
+
+// doGRPCRequestWithContext calls the grpc operation processor with the operation context.
+// Result chunks streamed by the processor are merged into one result and the succeeded flag
+// of the last chunk reports whether the operation succeeded.
+func (ex *executor) doGRPCRequestWithContext(operation diagnosisv1.Operation, data map[string]string) (bool, map[string]string, error) {
+	if operation.Spec.Processor.GRPCServer == nil {
+		return false, nil, fmt.Errorf("grpc server not specified")
+	}
+
+	host := ex.bindAddress
+	if operation.Spec.Processor.GRPCServer.Address != nil {
+		host = *operation.Spec.Processor.GRPCServer.Address
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(int(operation.Spec.Processor.GRPCServer.Port)))
+
+	timeout := time.Duration(*operation.Spec.Processor.TimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, address, grpc.WithInsecure())
+	if err != nil {
+		return false, nil, fmt.Errorf("unable to connect to operation processor %s: %s", address, err)
+	}
+	defer conn.Close()
+
+	stream, err := conn.NewStream(ctx, &processorStreamDesc, "/processor.OperationProcessor/Process", grpc.ForceCodec(processorproto.Codec{}))
+	if err != nil {
+		return false, nil, fmt.Errorf("unable to open stream to operation processor %s: %s", address, err)
+	}
+	if err := stream.SendMsg(&processorproto.ProcessRequest{Context: data}); err != nil {
+		return false, nil, fmt.Errorf("unable to send operation context to operation processor %s: %s", address, err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return false, nil, fmt.Errorf("unable to close send direction of stream to operation processor %s: %s", address, err)
+	}
+
+	succeeded := false
+	result := make(map[string]string)
+	for {
+		var response processorproto.ProcessResponse
+		if err := stream.RecvMsg(&response); err != nil {
+			if err == io.EOF {
+				break
+			}
+			// A server error may be transient and is mapped like a http 5xx, so the retry
+			// policy of the node applies.
+			if code := status.Code(err); code == codes.Internal || code == codes.Unknown || code == codes.Unavailable {
+				return false, nil, fmt.Errorf("%w: %s", errProcessorServerError, err)
+			}
+			return false, nil, fmt.Errorf("unable to receive result from operation processor %s: %s", address, err)
+		}
+
+		succeeded = response.Succeeded
+		for key, value := range response.Result {
+			result[key] = value
+		}
+	}
+
+	return succeeded, result, nil
+}
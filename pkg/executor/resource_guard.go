@@ -0,0 +1,68 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/mem"
+
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const (
+	// cpuPressureThreshold is the cpu usage percentage above which the node is considered under
+	// cpu pressure.
+	cpuPressureThreshold = 90
+	// memoryPressureThreshold is the memory usage percentage above which the node is considered
+	// under memory pressure.
+	memoryPressureThreshold = 90
+	// diskPressureThreshold is the used fraction of the data root filesystem above which the
+	// node is considered under disk pressure.
+	diskPressureThreshold = 0.9
+)
+
+// nodeResourceGuard checks node cpu, memory and disk pressure before resource intensive
+// operations such as perf recordings, memory analyzer runs and core dump collection are
+// started, so a heavyweight operation cannot make a degraded node worse.
+type nodeResourceGuard struct {
+	// dataRoot is root directory of persistent kubediag data.
+	dataRoot string
+}
+
+// pressure returns a human readable reason the node is considered under pressure, or an empty
+// string if the node has headroom for a resource intensive operation.
+func (g *nodeResourceGuard) pressure() string {
+	if percents, err := cpu.Percent(0, false); err == nil && len(percents) == 1 && percents[0] > cpuPressureThreshold {
+		return fmt.Sprintf("node cpu usage is %.0f%%", percents[0])
+	}
+
+	if virtualMemory, err := mem.VirtualMemory(); err == nil && virtualMemory.UsedPercent > memoryPressureThreshold {
+		return fmt.Sprintf("node memory usage is %.0f%%", virtualMemory.UsedPercent)
+	}
+
+	totalBytes := util.GetTotalBytes(g.dataRoot)
+	if totalBytes != 0 {
+		usage := float64(totalBytes-util.GetAvailableBytes(g.dataRoot)) / float64(totalBytes)
+		if usage > diskPressureThreshold {
+			return fmt.Sprintf("filesystem of data root %s is %.0f%% used", g.dataRoot, usage*100)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"strconv"
+	"time"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+)
+
+const (
+	// defaultRetryBudget is the number of retries shared by all tasks of one diagnosis. The budget
+	// bounds the total amount of retrying a diagnosis can cause, so a persistently failing
+	// processor does not keep executor workers busy with backoff loops.
+	defaultRetryBudget = 8
+
+	// RetryBudgetAnnotation is the annotation to override the retry budget of a diagnosis. The
+	// annotation is propagated from the diagnosis to its tasks.
+	RetryBudgetAnnotation = "diagnosis.kubediag.org/retry-budget"
+
+	// retryBudgetEvictionInterval specifies how long to wait between retry budget eviction
+	// sweeps.
+	retryBudgetEvictionInterval = 5 * time.Minute
+)
+
+// consumeRetryBudget consumes one retry from the budget of the diagnosis owning the task. It
+// returns false if the budget is exhausted. Tasks without an owning diagnosis get a budget of
+// their own keyed by task. Budget entries are evicted once the owning diagnosis finishes.
+func (ex *executor) consumeRetryBudget(task diagnosisv1.Task) bool {
+	key := retryBudgetKey(task)
+
+	ex.guardLock.Lock()
+	defer ex.guardLock.Unlock()
+
+	remaining, ok := ex.retryBudgets[key]
+	if !ok {
+		remaining = retryBudget(task)
+	}
+	if remaining <= 0 {
+		ex.retryBudgets[key] = 0
+		return false
+	}
+	ex.retryBudgets[key] = remaining - 1
+
+	return true
+}
+
+// evictRetryBudgets removes budget entries whose owning diagnosis has finished or no longer
+// exists, so the budget map does not grow without bound and a re-created diagnosis of the same
+// name starts with a fresh budget instead of inheriting the exhausted one.
+func (ex *executor) evictRetryBudgets() {
+	var diagnosisList diagnosisv1.DiagnosisList
+	if err := ex.cache.List(ex, &diagnosisList); err != nil {
+		ex.Error(err, "unable to list diagnoses for retry budget eviction")
+		return
+	}
+	var taskList diagnosisv1.TaskList
+	if err := ex.cache.List(ex, &taskList); err != nil {
+		ex.Error(err, "unable to list tasks for retry budget eviction")
+		return
+	}
+
+	active := make(map[string]bool)
+	for _, diagnosis := range diagnosisList.Items {
+		if diagnosis.Status.Phase == diagnosisv1.DiagnosisSucceeded || diagnosis.Status.Phase == diagnosisv1.DiagnosisFailed {
+			continue
+		}
+		active[diagnosis.Namespace+"/"+diagnosis.Name] = true
+	}
+	// Tasks without an owning diagnosis hold a budget of their own keyed by task.
+	for _, task := range taskList.Items {
+		if task.Status.Phase == diagnosisv1.TaskSucceeded || task.Status.Phase == diagnosisv1.TaskFailed {
+			continue
+		}
+		active[retryBudgetKey(task)] = true
+	}
+
+	ex.guardLock.Lock()
+	defer ex.guardLock.Unlock()
+
+	for key := range ex.retryBudgets {
+		if !active[key] {
+			delete(ex.retryBudgets, key)
+		}
+	}
+}
+
+// retryBudgetKey returns the key of the retry budget shared by all tasks of one diagnosis.
+func retryBudgetKey(task diagnosisv1.Task) string {
+	taskLabels := task.GetLabels()
+	if taskLabels["diagnosis-name"] != "" && taskLabels["diagnosis-namespace"] != "" {
+		return taskLabels["diagnosis-namespace"] + "/" + taskLabels["diagnosis-name"]
+	}
+
+	return task.Namespace + "/" + task.Name
+}
+
+// retryBudget returns the retry budget declared by the task annotation, or the default budget if
+// the annotation is absent or invalid.
+func retryBudget(task diagnosisv1.Task) int {
+	if value, ok := task.GetAnnotations()[RetryBudgetAnnotation]; ok {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			return parsed
+		}
+	}
+
+	return defaultRetryBudget
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"unicode/utf8"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+)
+
+const (
+	// maxShippedArtifactBytes is the maximum number of artifact bytes shipped to loki. Larger
+	// artifacts are truncated.
+	maxShippedArtifactBytes = 1 << 20
+)
+
+// shipTaskResult ships the operation result and the textual content of a produced artifact to
+// the configured loki endpoint, labeled with diagnosis and operation metadata. Shipping failures
+// are logged and do not affect task execution.
+func (ex *executor) shipTaskResult(task diagnosisv1.Task, operation diagnosisv1.Operation, result map[string]string) {
+	if ex.resultShipper == nil {
+		return
+	}
+
+	taskLabels := task.GetLabels()
+	labels := map[string]string{
+		"app":                 "kubediag",
+		"node":                ex.nodeName,
+		"operation":           operation.Name,
+		"diagnosis_namespace": taskLabels["diagnosis-namespace"],
+		"diagnosis_name":      taskLabels["diagnosis-name"],
+	}
+
+	lines := make([]string, 0, 2)
+	if len(result) != 0 {
+		line, err := json.Marshal(result)
+		if err != nil {
+			ex.Error(err, "failed to marshal operation result", "operation", operation.Name)
+		} else {
+			lines = append(lines, string(line))
+		}
+	}
+
+	// Ship the content of a produced artifact if it is valid text.
+	if artifactPath := result[OperationResultArtifactKey]; artifactPath != "" {
+		content, err := readTextualContent(artifactPath)
+		if err != nil {
+			ex.Error(err, "failed to read artifact content", "path", artifactPath)
+		} else if content != "" {
+			lines = append(lines, content)
+		}
+	}
+
+	if err := ex.resultShipper.Push(labels, lines); err != nil {
+		ex.Error(err, "failed to ship operation result", "operation", operation.Name)
+	}
+}
+
+// readTextualContent reads up to maxShippedArtifactBytes from the file and returns the content
+// if it is valid utf8 text, or an empty string otherwise.
+func readTextualContent(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(file, maxShippedArtifactBytes))
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(content) {
+		return "", nil
+	}
+
+	return string(content), nil
+}
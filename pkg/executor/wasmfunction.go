@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/wasm"
+)
+
+const (
+	// WASMModuleKey is the code source key holding the base64 encoded WebAssembly module of
+	// a wasm function.
+	WASMModuleKey = "module.wasm"
+	// wasmABIModule is the import module name of the kubediag host ABI.
+	wasmABIModule = "kubediag"
+	// wasmProcessExport is the name of the exported entry function of a wasm function.
+	wasmProcessExport = "process"
+)
+
+// runWASMFunctionWithContext runs a WebAssembly module in process in the agent. The module
+// may import the following functions from module "kubediag":
+//
+//	context_size() -> i32: returns the size in bytes of the JSON encoded operation context.
+//	context_read(ptr i32) -> i32: writes the JSON encoded operation context into memory at
+//	ptr and returns the number of bytes written.
+//	result_write(ptr i32, size i32): appends size bytes of memory at ptr to the result
+//	document.
+//	log(ptr i32, size i32): logs size bytes of memory at ptr through the agent log.
+//
+// The module must export a function process() -> i32 which returns zero if the operation
+// succeeded. The result document must be a JSON object with string keys and values and is
+// merged into the operation result. It returns a bool, a map and an error as results.
+func (ex *executor) runWASMFunctionWithContext(operation diagnosisv1.Operation, data map[string]string) (bool, map[string]string, error) {
+	encoded, ok := operation.Spec.Processor.Function.CodeSource[WASMModuleKey]
+	if !ok {
+		return false, nil, fmt.Errorf("code source of operation %s does not contain %s", operation.Name, WASMModuleKey)
+	}
+	moduleBytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false, nil, fmt.Errorf("unable to decode wasm module of operation %s: %s", operation.Name, err)
+	}
+	module, err := wasm.Decode(moduleBytes)
+	if err != nil {
+		return false, nil, fmt.Errorf("unable to decode wasm module of operation %s: %s", operation.Name, err)
+	}
+
+	operationContext, err := json.Marshal(data)
+	if err != nil {
+		return false, nil, fmt.Errorf("unable to marshal operation context: %s", err)
+	}
+
+	var resultDocument bytes.Buffer
+	hostFunctions := map[string]wasm.HostFunction{
+		wasmABIModule + ".context_size": func(instance *wasm.Instance, args []uint64) ([]uint64, error) {
+			return []uint64{uint64(uint32(len(operationContext)))}, nil
+		},
+		wasmABIModule + ".context_read": func(instance *wasm.Instance, args []uint64) ([]uint64, error) {
+			pointer := int(uint32(args[0]))
+			if pointer+len(operationContext) > len(instance.Memory()) {
+				return nil, fmt.Errorf("operation context does not fit into module memory at %d", pointer)
+			}
+			copy(instance.Memory()[pointer:], operationContext)
+			return []uint64{uint64(uint32(len(operationContext)))}, nil
+		},
+		wasmABIModule + ".result_write": func(instance *wasm.Instance, args []uint64) ([]uint64, error) {
+			pointer := int(uint32(args[0]))
+			size := int(uint32(args[1]))
+			if pointer+size > len(instance.Memory()) {
+				return nil, fmt.Errorf("result chunk at %d with size %d is out of bounds", pointer, size)
+			}
+			resultDocument.Write(instance.Memory()[pointer : pointer+size])
+			return nil, nil
+		},
+		wasmABIModule + ".log": func(instance *wasm.Instance, args []uint64) ([]uint64, error) {
+			pointer := int(uint32(args[0]))
+			size := int(uint32(args[1]))
+			if pointer+size > len(instance.Memory()) {
+				return nil, fmt.Errorf("log message at %d with size %d is out of bounds", pointer, size)
+			}
+			ex.Info("wasm function log", "operation", operation.Name, "message", string(instance.Memory()[pointer:pointer+size]))
+			return nil, nil
+		},
+	}
+
+	instance, err := wasm.Instantiate(module, hostFunctions)
+	if err != nil {
+		return false, nil, fmt.Errorf("unable to instantiate wasm module of operation %s: %s", operation.Name, err)
+	}
+	instance.SetDeadline(time.Now().Add(time.Duration(*operation.Spec.Processor.TimeoutSeconds) * time.Second))
+
+	exitCodes, err := instance.Call(wasmProcessExport)
+	if err != nil {
+		return false, nil, fmt.Errorf("unable to run wasm module of operation %s: %s", operation.Name, err)
+	}
+
+	result := make(map[string]string)
+	if resultDocument.Len() != 0 {
+		if err := json.Unmarshal(resultDocument.Bytes(), &result); err != nil {
+			return false, nil, fmt.Errorf("unable to unmarshal result of operation %s: %s", operation.Name, err)
+		}
+	}
+
+	succeeded := len(exitCodes) != 0 && uint32(exitCodes[0]) == 0
+	return succeeded, result, nil
+}
@@ -174,6 +174,12 @@ const (
 	// Owner: yanwenhao
 	// Alpha: 0.2.3
 	TcpdumpProfiler featuregate.Feature = "TcpdumpProfiler"
+	// SymptomVerifier verifies that the original symptom of a diagnosis has been resolved
+	// after recovery operations.
+	//
+	// Mode: agent
+	// Alpha: 0.2.3
+	SymptomVerifier featuregate.Feature = "SymptomVerifier"
 )
 
 var (
@@ -211,6 +217,7 @@ var defaultKubeDiagFeatureGates = map[featuregate.Feature]featuregate.FeatureSpe
 	StatefulSetDetailCollector:   {Default: true, PreRelease: featuregate.Alpha},
 	StatefulSetStuck:             {Default: true, PreRelease: featuregate.Alpha},
 	TcpdumpProfiler:              {Default: true, PreRelease: featuregate.Alpha},
+	SymptomVerifier:              {Default: true, PreRelease: featuregate.Alpha},
 }
 
 // KubeDiagFeatureGate indicates whether a given feature is enabled or not and stores flag gates for known features.
@@ -0,0 +1,219 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package garbagecollection
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+// artifactReaperHousekeepingInterval is the interval between two artifact garbage collection
+// cycles.
+const artifactReaperHousekeepingInterval = 10 * time.Minute
+
+var (
+	dataRootReclaimedBytes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "data_root_reclaimed_bytes",
+			Help: "Counter of bytes reclaimed under data root by garbage collection",
+		},
+	)
+	artifactGarbageCollectionCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "artifact_garbage_collection_count",
+			Help: "Counter of artifacts removed by artifact garbage collection",
+		},
+	)
+	artifactGarbageCollectionErrorCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "artifact_garbage_collection_error_count",
+			Help: "Counter of erroneous artifact removals during artifact garbage collection",
+		},
+	)
+)
+
+// ArtifactReaper manages garbage collections of operation artifacts under the data root. It
+// removes artifacts which outlive the configured retention age as well as workspaces whose
+// owning diagnoses have been deleted, so that artifacts of profiler and collector heavy usage
+// do not grow unbounded.
+type ArtifactReaper struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// cache knows how to load Kubernetes objects.
+	cache cache.Cache
+	// dataRoot is root directory of persistent kubediag data.
+	dataRoot string
+	// artifactTTL is the amount of time to retain artifacts under the data root. A non
+	// positive ttl disables retention by age.
+	artifactTTL time.Duration
+}
+
+// NewArtifactReaper creates a new ArtifactReaper.
+func NewArtifactReaper(
+	ctx context.Context,
+	logger logr.Logger,
+	cache cache.Cache,
+	dataRoot string,
+	artifactTTL time.Duration,
+) *ArtifactReaper {
+	metrics.Registry.MustRegister(
+		dataRootReclaimedBytes,
+		artifactGarbageCollectionCount,
+		artifactGarbageCollectionErrorCount,
+	)
+
+	return &ArtifactReaper{
+		Context:     ctx,
+		Logger:      logger,
+		cache:       cache,
+		dataRoot:    dataRoot,
+		artifactTTL: artifactTTL,
+	}
+}
+
+// Run runs the artifact reaper.
+func (ar *ArtifactReaper) Run(stopCh <-chan struct{}) {
+	// Wait for all caches to sync before processing.
+	if !ar.cache.WaitForCacheSync(stopCh) {
+		return
+	}
+
+	go wait.Until(ar.sync, artifactReaperHousekeepingInterval, stopCh)
+	<-stopCh
+	ar.Info("shutting down artifact reaper")
+}
+
+// sync removes expired artifacts and workspaces of deleted diagnoses under the data root.
+func (ar *ArtifactReaper) sync() {
+	if err := ar.reapOrphanedWorkspaces(); err != nil {
+		ar.Error(err, "failed to garbage collect workspaces of deleted diagnoses")
+	}
+
+	if ar.artifactTTL > 0 {
+		if err := ar.reapExpiredArtifacts(); err != nil {
+			ar.Error(err, "failed to garbage collect expired artifacts")
+		}
+	}
+}
+
+// reapOrphanedWorkspaces removes workspaces under the data root whose owning diagnoses no
+// longer exist. Workspaces of expired diagnoses are removed on deletion by the diagnosis
+// reaper, so this handles diagnoses deleted by hand or by other controllers.
+func (ar *ArtifactReaper) reapOrphanedWorkspaces() error {
+	workspacesPath := filepath.Join(ar.dataRoot, "workspaces")
+	_, err := os.Stat(workspacesPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(workspacesPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		// A workspace directory is named after the namespace and name of its diagnosis.
+		parts := strings.SplitN(entry.Name(), ".", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		var diagnosis diagnosisv1.Diagnosis
+		err := ar.cache.Get(ar, client.ObjectKey{
+			Namespace: parts[0],
+			Name:      parts[1],
+		}, &diagnosis)
+		if err == nil {
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			ar.Error(err, "unable to get diagnosis of workspace", "workspace", entry.Name())
+			continue
+		}
+
+		path := filepath.Join(workspacesPath, entry.Name())
+		ar.removeArtifact(path, entry)
+	}
+
+	return nil
+}
+
+// reapExpiredArtifacts removes artifacts under the data root which have not been modified
+// within the artifact ttl.
+func (ar *ArtifactReaper) reapExpiredArtifacts() error {
+	artifacts, err := listDataRootArtifacts(ar.Logger, ar.dataRoot)
+	if err != nil {
+		return err
+	}
+
+	for _, artifact := range artifacts {
+		if time.Now().Sub(artifact.modTime) <= ar.artifactTTL {
+			continue
+		}
+
+		if err := util.RemoveFile(artifact.path); err != nil {
+			artifactGarbageCollectionErrorCount.Inc()
+			ar.Error(err, "unable to remove expired artifact", "path", artifact.path)
+			continue
+		}
+		artifactGarbageCollectionCount.Inc()
+		dataRootReclaimedBytes.Add(float64(artifact.size))
+	}
+
+	return nil
+}
+
+// removeArtifact removes the file or directory at path and records the reclaimed bytes.
+func (ar *ArtifactReaper) removeArtifact(path string, info os.FileInfo) {
+	size := info.Size()
+	if info.IsDir() {
+		usage, err := util.DiskUsage(path)
+		if err != nil {
+			ar.Error(err, "unable to calculate artifact size", "path", path)
+			usage = 0
+		}
+		size = int64(usage)
+	}
+
+	if err := util.RemoveFile(path); err != nil {
+		artifactGarbageCollectionErrorCount.Inc()
+		ar.Error(err, "unable to remove artifact", "path", path)
+		return
+	}
+
+	artifactGarbageCollectionCount.Inc()
+	dataRootReclaimedBytes.Add(float64(size))
+	ar.Info("artifact of deleted diagnosis has been garbage collected", "path", path)
+}
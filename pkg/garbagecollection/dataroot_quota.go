@@ -0,0 +1,222 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package garbagecollection
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+// dataRootQuotaHousekeepingInterval is the interval between data root quota enforcements.
+const dataRootQuotaHousekeepingInterval = 10 * time.Minute
+
+// dataRootArtifactDirectories are the directories under data root which hold per diagnosis
+// artifacts and are subject to quota eviction.
+var dataRootArtifactDirectories = []string{
+	"artifacts",
+	"profilers/java/memory",
+	"profilers/go/pprof",
+	"tasks",
+	"workspaces",
+}
+
+var (
+	dataRootUsageBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "data_root_usage_bytes",
+			Help: "Size of persistent kubediag data in bytes",
+		},
+	)
+	dataRootQuotaBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "data_root_quota_bytes",
+			Help: "Size quota of persistent kubediag data in bytes",
+		},
+	)
+	dataRootQuotaEvictionCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "data_root_quota_eviction_count",
+			Help: "Counter of diagnosis artifacts evicted by data root quota enforcement",
+		},
+	)
+	dataRootQuotaEvictionErrorCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "data_root_quota_eviction_error_count",
+			Help: "Counter of erroneous diagnosis artifact evictions during data root quota enforcement",
+		},
+	)
+)
+
+// DataRootQuota enforces a size quota on the data root by evicting the oldest diagnosis
+// artifacts first, so that profiling heavy usage cannot fill the node disk.
+type DataRootQuota struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// dataRoot is root directory of persistent kubediag data.
+	dataRoot string
+	// quotaBytes is the maximum size of the data root in bytes. A non positive quota disables
+	// enforcement.
+	quotaBytes int64
+}
+
+// artifact is a file or directory under the data root which can be evicted.
+type artifact struct {
+	// path is the absolute path of the artifact.
+	path string
+	// size is the size of the artifact in bytes.
+	size int64
+	// modTime is the modification time of the artifact.
+	modTime time.Time
+}
+
+// NewDataRootQuota creates a new DataRootQuota.
+func NewDataRootQuota(
+	ctx context.Context,
+	logger logr.Logger,
+	dataRoot string,
+	quotaBytes int64,
+) *DataRootQuota {
+	metrics.Registry.MustRegister(
+		dataRootUsageBytes,
+		dataRootQuotaBytes,
+		dataRootQuotaEvictionCount,
+		dataRootQuotaEvictionErrorCount,
+	)
+	dataRootQuotaBytes.Set(float64(quotaBytes))
+
+	return &DataRootQuota{
+		Context:    ctx,
+		Logger:     logger,
+		dataRoot:   dataRoot,
+		quotaBytes: quotaBytes,
+	}
+}
+
+// Run runs the data root quota enforcement.
+func (dq *DataRootQuota) Run(stopCh <-chan struct{}) {
+	if dq.quotaBytes <= 0 {
+		return
+	}
+
+	go wait.Until(func() {
+		if err := dq.enforceQuota(); err != nil {
+			dq.Error(err, "failed to enforce data root quota")
+		}
+	}, dataRootQuotaHousekeepingInterval, stopCh)
+}
+
+// enforceQuota evicts the oldest diagnosis artifacts until the data root usage is within the
+// quota.
+func (dq *DataRootQuota) enforceQuota() error {
+	usage, err := util.DiskUsage(dq.dataRoot)
+	if err != nil {
+		return err
+	}
+	dataRootUsageBytes.Set(float64(usage))
+
+	if int64(usage) <= dq.quotaBytes {
+		return nil
+	}
+
+	artifacts, err := listDataRootArtifacts(dq.Logger, dq.dataRoot)
+	if err != nil {
+		return err
+	}
+
+	// Evict least recently modified artifacts first.
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].modTime.Before(artifacts[j].modTime)
+	})
+
+	remaining := int64(usage)
+	evicted := 0
+	for _, artifact := range artifacts {
+		if remaining <= dq.quotaBytes {
+			break
+		}
+
+		if err := util.RemoveFile(artifact.path); err != nil {
+			dataRootQuotaEvictionErrorCount.Inc()
+			dq.Error(err, "unable to evict diagnosis artifact", "path", artifact.path)
+			continue
+		}
+		dataRootQuotaEvictionCount.Inc()
+		dataRootReclaimedBytes.Add(float64(artifact.size))
+		remaining -= artifact.size
+		evicted++
+	}
+	dataRootUsageBytes.Set(float64(remaining))
+
+	if evicted > 0 {
+		dq.Info("diagnosis artifacts has been evicted by data root quota enforcement", "count", evicted, "usage", remaining, "quota", dq.quotaBytes)
+	}
+
+	return nil
+}
+
+// listDataRootArtifacts lists evictable diagnosis artifacts under the data root with their
+// sizes and modification times.
+func listDataRootArtifacts(logger logr.Logger, dataRoot string) ([]artifact, error) {
+	artifacts := make([]artifact, 0)
+	for _, directory := range dataRootArtifactDirectories {
+		absolutePath := filepath.Join(dataRoot, directory)
+		_, err := os.Stat(absolutePath)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(absolutePath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			path := filepath.Join(absolutePath, entry.Name())
+			size := entry.Size()
+			if entry.IsDir() {
+				usage, err := util.DiskUsage(path)
+				if err != nil {
+					logger.Error(err, "unable to calculate artifact size", "path", path)
+					continue
+				}
+				size = int64(usage)
+			}
+
+			artifacts = append(artifacts, artifact{
+				path:    path,
+				size:    size,
+				modTime: entry.ModTime(),
+			})
+		}
+	}
+
+	return artifacts, nil
+}
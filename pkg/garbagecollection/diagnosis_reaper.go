@@ -0,0 +1,193 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package garbagecollection
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+// diagnosisReaperHousekeepingInterval is the interval between two diagnosis ttl garbage
+// collection cycles.
+const diagnosisReaperHousekeepingInterval = 1 * time.Minute
+
+var (
+	diagnosisTTLGarbageCollectionCycleCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "diagnosis_ttl_garbage_collection_cycle_count",
+			Help: "Counter of diagnosis ttl garbage collection cycle",
+		},
+	)
+	diagnosisTTLGarbageCollectionSuccessCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "diagnosis_ttl_garbage_collection_success_count",
+			Help: "Counter of successful diagnosis ttl garbage collections",
+		},
+	)
+	diagnosisTTLGarbageCollectionErrorCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "diagnosis_ttl_garbage_collection_error_count",
+			Help: "Counter of erroneous diagnosis ttl garbage collections",
+		},
+	)
+)
+
+// DiagnosisReaper manages garbage collections of finished diagnoses which specify a ttl after
+// finished. A finished diagnosis is deleted along with its on-disk artifacts once its ttl
+// after completion expires.
+type DiagnosisReaper struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// client knows how to perform CRUD operations on Kubernetes objects.
+	client client.Client
+	// cache knows how to load Kubernetes objects.
+	cache cache.Cache
+	// nodeName specifies the node name.
+	nodeName string
+	// dataRoot is root directory of persistent kubediag data.
+	dataRoot string
+}
+
+// NewDiagnosisReaper creates a new DiagnosisReaper.
+func NewDiagnosisReaper(
+	ctx context.Context,
+	logger logr.Logger,
+	cli client.Client,
+	cache cache.Cache,
+	nodeName string,
+	dataRoot string,
+) *DiagnosisReaper {
+	metrics.Registry.MustRegister(
+		diagnosisTTLGarbageCollectionCycleCount,
+		diagnosisTTLGarbageCollectionSuccessCount,
+		diagnosisTTLGarbageCollectionErrorCount,
+	)
+
+	return &DiagnosisReaper{
+		Context:  ctx,
+		Logger:   logger,
+		client:   cli,
+		cache:    cache,
+		nodeName: nodeName,
+		dataRoot: dataRoot,
+	}
+}
+
+// Run runs the diagnosis reaper.
+func (dr *DiagnosisReaper) Run(stopCh <-chan struct{}) {
+	// Wait for all caches to sync before processing.
+	if !dr.cache.WaitForCacheSync(stopCh) {
+		return
+	}
+
+	go wait.Until(dr.sync, diagnosisReaperHousekeepingInterval, stopCh)
+	<-stopCh
+	dr.Info("shutting down diagnosis reaper")
+}
+
+// sync deletes finished diagnoses whose ttl after finished has expired.
+func (dr *DiagnosisReaper) sync() {
+	diagnosisTTLGarbageCollectionCycleCount.Inc()
+
+	var diagnosisList diagnosisv1.DiagnosisList
+	if err := dr.cache.List(dr, &diagnosisList); err != nil {
+		diagnosisTTLGarbageCollectionErrorCount.Inc()
+		dr.Error(err, "failed to list diagnoses")
+		return
+	}
+
+	for _, diagnosis := range diagnosisList.Items {
+		if diagnosis.Status.Phase != diagnosisv1.DiagnosisSucceeded && diagnosis.Status.Phase != diagnosisv1.DiagnosisFailed {
+			continue
+		}
+		if diagnosis.Spec.TTLSecondsAfterFinished == nil {
+			continue
+		}
+		if time.Now().Sub(completionTime(diagnosis).Time) <= time.Duration(*diagnosis.Spec.TTLSecondsAfterFinished)*time.Second {
+			continue
+		}
+
+		// Only agents the diagnosis ran on delete the diagnosis, so the on-disk artifacts are
+		// removed on the node holding them. Diagnoses which never scheduled any task are
+		// reaped by any agent.
+		if len(diagnosis.Status.NodeNames) != 0 && !contains(diagnosis.Status.NodeNames, dr.nodeName) {
+			continue
+		}
+
+		// Remove the workspace of the diagnosis under data root on node.
+		workspace := filepath.Join(dr.dataRoot, "workspaces", diagnosis.Namespace+"."+diagnosis.Name)
+		if err := util.RemoveFile(workspace); err != nil {
+			dr.Error(err, "unable to remove workspace of diagnosis", "workspace", workspace)
+		}
+
+		if err := dr.client.Delete(dr, &diagnosis); err != nil {
+			if !apierrors.IsNotFound(err) {
+				diagnosisTTLGarbageCollectionErrorCount.Inc()
+				dr.Error(err, "failed to delete diagnosis", "diagnosis", client.ObjectKey{
+					Name:      diagnosis.Name,
+					Namespace: diagnosis.Namespace,
+				})
+			}
+			continue
+		}
+
+		dr.Info("diagnosis has been garbage collected on expired ttl", "diagnosis", client.ObjectKey{
+			Name:      diagnosis.Name,
+			Namespace: diagnosis.Namespace,
+		})
+		diagnosisTTLGarbageCollectionSuccessCount.Inc()
+	}
+}
+
+// completionTime returns the time the diagnosis completed its execution. The last transition
+// time of the DiagnosisComplete condition is used if present and the start time otherwise.
+func completionTime(diagnosis diagnosisv1.Diagnosis) metav1.Time {
+	for _, condition := range diagnosis.Status.Conditions {
+		if condition.Type == diagnosisv1.DiagnosisComplete {
+			return condition.LastTransitionTime
+		}
+	}
+
+	return diagnosis.Status.StartTime
+}
+
+// contains reports whether the string is in the slice.
+func contains(slice []string, value string) bool {
+	for _, item := range slice {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
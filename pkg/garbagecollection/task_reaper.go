@@ -205,19 +205,24 @@ func (tr *TaskReaper) Run(stopCh <-chan struct{}) {
 			tr.Error(err, "failed to garbage collect task data")
 		}
 
+		// Garbage collect per diagnosis operation workspaces on node.
+		absoluteWorkspacePath := filepath.Join(tr.dataRoot, "workspaces")
+		err = DeleteExpiredProfilerData(absoluteWorkspacePath, tr.taskTTL, tr)
+		if err != nil {
+			tr.Error(err, "failed to garbage collect operation workspaces")
+		}
+
 	}, housekeepingInterval, stopCh)
 }
 
-// listTasks lists Diagnoses from cache.
+// listTasks lists Tasks on the node from cache with the node name index.
 func (tr *TaskReaper) listTasks() ([]diagnosisv1.Task, error) {
 	var taskList diagnosisv1.TaskList
-	if err := tr.cache.List(tr, &taskList); err != nil {
+	if err := tr.cache.List(tr, &taskList, client.MatchingFields{util.NodeNameIndexField: tr.nodeName}); err != nil {
 		return nil, err
 	}
 
-	tasksOnNode := util.RetrieveTasksOnNode(taskList.Items, tr.nodeName)
-
-	return tasksOnNode, nil
+	return taskList.Items, nil
 }
 
 // DeleteExpiredProfilerData deletes profiler data by removing files or directories if the file age is longer
@@ -0,0 +1,142 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package grafana creates grafana annotations for diagnosis lifecycle transitions so dashboards
+// show exactly when automated diagnostics and recoveries ran.
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+)
+
+const (
+	// annotationTimeout is the timeout of an annotation creation request.
+	annotationTimeout = 10 * time.Second
+)
+
+// annotation is the payload of the grafana annotation HTTP API.
+type annotation struct {
+	// Time is the start time of the annotation in epoch milliseconds.
+	Time int64 `json:"time"`
+	// Text is the description of the annotation.
+	Text string `json:"text"`
+	// Tags contains tags of the annotation.
+	Tags []string `json:"tags"`
+}
+
+// Annotator creates grafana annotations on diagnosis start and completion via the HTTP API.
+// Annotations are tagged with the node, namespace and operation set of the diagnosis.
+type Annotator struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// apiURL is the base url of the grafana server.
+	apiURL string
+	// apiKey is the api key for grafana authentication.
+	apiKey string
+	// client is the http client for creating annotations.
+	client *http.Client
+}
+
+// NewAnnotator creates a new Annotator.
+func NewAnnotator(
+	ctx context.Context,
+	logger logr.Logger,
+	apiURL string,
+	apiKey string,
+) (*Annotator, error) {
+	if apiURL == "" {
+		return nil, fmt.Errorf("grafana api url is not specified")
+	}
+
+	return &Annotator{
+		Context: ctx,
+		Logger:  logger,
+		apiURL:  strings.TrimSuffix(apiURL, "/"),
+		apiKey:  apiKey,
+		client: &http.Client{
+			Timeout: annotationTimeout,
+		},
+	}, nil
+}
+
+// AnnotateDiagnosisStarted creates an annotation recording that the diagnosis started running.
+func (an *Annotator) AnnotateDiagnosisStarted(diagnosis *diagnosisv1.Diagnosis) error {
+	text := fmt.Sprintf("Diagnosis %s/%s started (operation set %s)", diagnosis.Namespace, diagnosis.Name, diagnosis.Spec.OperationSet)
+	return an.createAnnotation(text, diagnosis)
+}
+
+// AnnotateDiagnosisCompleted creates an annotation recording the terminal phase of the diagnosis.
+func (an *Annotator) AnnotateDiagnosisCompleted(diagnosis *diagnosisv1.Diagnosis) error {
+	text := fmt.Sprintf("Diagnosis %s/%s %s (operation set %s)", diagnosis.Namespace, diagnosis.Name, strings.ToLower(string(diagnosis.Status.Phase)), diagnosis.Spec.OperationSet)
+	return an.createAnnotation(text, diagnosis)
+}
+
+// createAnnotation posts an annotation tagged with the node, namespace and operation set of the
+// diagnosis to the grafana annotation HTTP API.
+func (an *Annotator) createAnnotation(text string, diagnosis *diagnosisv1.Diagnosis) error {
+	tags := []string{
+		"kubediag",
+		"namespace:" + diagnosis.Namespace,
+		"operationset:" + diagnosis.Spec.OperationSet,
+	}
+	for _, nodeName := range diagnosis.Status.NodeNames {
+		tags = append(tags, "node:"+nodeName)
+	}
+
+	payload, err := json.Marshal(annotation{
+		Time: time.Now().UnixNano() / int64(time.Millisecond),
+		Text: text,
+		Tags: tags,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal annotation: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", an.apiURL+"/api/annotations", bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if an.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+an.apiKey)
+	}
+
+	res, err := an.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("grafana responded with erroneous status: %s", res.Status)
+	}
+
+	an.Info("grafana annotation created", "diagnosis", diagnosis.Namespace+"/"+diagnosis.Name, "text", text)
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graphbuilder
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+)
+
+// RenderDOT renders the adjacency list of an operation set in the dot graph description
+// language. Nodes and edges on the executed path are highlighted, so the path a completed
+// diagnosis took can be reviewed at a glance. The executed path may be empty.
+func RenderDOT(operationSet diagnosisv1.OperationSet, executedPath diagnosisv1.Path) string {
+	executedNodes := make(map[int]bool)
+	executedEdges := make(map[string]bool)
+	previous := -1
+	for _, node := range executedPath {
+		executedNodes[node.ID] = true
+		if previous != -1 {
+			executedEdges[fmt.Sprintf("%d-%d", previous, node.ID)] = true
+		}
+		previous = node.ID
+	}
+	// The start node is always part of an executed path.
+	if len(executedPath) != 0 && len(operationSet.Spec.AdjacencyList) != 0 {
+		executedNodes[0] = true
+		executedEdges[fmt.Sprintf("0-%d", executedPath[0].ID)] = true
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("digraph %q {\n", operationSet.Name))
+	builder.WriteString("  rankdir=LR;\n")
+	builder.WriteString("  node [shape=box];\n")
+	for _, node := range operationSet.Spec.AdjacencyList {
+		label := node.Operation
+		if label == "" {
+			label = "start"
+		}
+		attributes := fmt.Sprintf("label=%q", label)
+		if executedNodes[node.ID] {
+			attributes = attributes + ", color=green, penwidth=2"
+		}
+		builder.WriteString(fmt.Sprintf("  %d [%s];\n", node.ID, attributes))
+	}
+	for _, node := range operationSet.Spec.AdjacencyList {
+		for _, to := range node.To {
+			if executedEdges[fmt.Sprintf("%d-%d", node.ID, to)] {
+				builder.WriteString(fmt.Sprintf("  %d -> %d [color=green, penwidth=2];\n", node.ID, to))
+			} else {
+				builder.WriteString(fmt.Sprintf("  %d -> %d;\n", node.ID, to))
+			}
+		}
+	}
+	builder.WriteString("}\n")
+
+	return builder.String()
+}
+
+// RenderSVG renders a dot graph as svg with the dot executable of graphviz. An error is
+// returned if graphviz is not installed.
+func RenderSVG(dot string) ([]byte, error) {
+	command := exec.Command("dot", "-Tsvg")
+	command.Stdin = strings.NewReader(dot)
+	var out bytes.Buffer
+	var stderr bytes.Buffer
+	command.Stdout = &out
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		return nil, fmt.Errorf("unable to render svg with graphviz: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out.Bytes(), nil
+}
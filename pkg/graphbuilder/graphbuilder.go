@@ -32,7 +32,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
-	"github.com/kubediag/kubediag/pkg/util"
+	"github.com/kubediag/kubediag/pkg/queue"
 )
 
 var (
@@ -82,8 +82,10 @@ type graphBuilder struct {
 	scheme *runtime.Scheme
 	// cache knows how to load Kubernetes objects.
 	cache cache.Cache
-	// graphBuilderCh is a channel for queuing OperationSets to be processed by graph builder.
-	graphBuilderCh chan diagnosisv1.OperationSet
+	// operationSetQueue is the rate limited work queue of operation set names to be processed
+	// by the graph builder. Failed operation sets are retried with backoff and pending
+	// operation sets are never dropped under load.
+	operationSetQueue *queue.WorkQueue
 }
 
 // NewGraphBuilder creates a new graph builder.
@@ -94,7 +96,7 @@ func NewGraphBuilder(
 	eventRecorder record.EventRecorder,
 	scheme *runtime.Scheme,
 	cache cache.Cache,
-	graphBuilderCh chan diagnosisv1.OperationSet,
+	operationSetQueue *queue.WorkQueue,
 ) GraphBuilder {
 	metrics.Registry.MustRegister(
 		graphbuilderSyncSuccessCount,
@@ -102,58 +104,57 @@ func NewGraphBuilder(
 		graphbuilderSyncErrorCount,
 	)
 	return &graphBuilder{
-		Context:        ctx,
-		Logger:         logger,
-		client:         cli,
-		eventRecorder:  eventRecorder,
-		scheme:         scheme,
-		cache:          cache,
-		graphBuilderCh: graphBuilderCh,
+		Context:           ctx,
+		Logger:            logger,
+		client:            cli,
+		eventRecorder:     eventRecorder,
+		scheme:            scheme,
+		cache:             cache,
+		operationSetQueue: operationSetQueue,
 	}
 }
 
 // Run runs the graph builder.
-// TODO: Prometheus metrics.
 func (gb *graphBuilder) Run(stopCh <-chan struct{}) {
 	// Wait for all caches to sync before processing.
 	if !gb.cache.WaitForCacheSync(stopCh) {
 		return
 	}
 
-	for {
-		select {
-		// Process operation sets queuing in graph builder channel.
-		case operationSet := <-gb.graphBuilderCh:
-
-			err := gb.client.Get(gb, client.ObjectKey{
-				Name: operationSet.Name,
-			}, &operationSet)
-			if err != nil {
-				if apierrors.IsNotFound(err) {
-					continue
-				}
-				gb.addDiagnosisToGraphBuilderQueue(operationSet)
-				continue
-			}
-
-			// Only process unready operation set.
-			if operationSet.Status.Ready {
-				graphbuilderSyncSkipCount.Inc()
-				continue
-			}
+	// Process operation sets queuing in the work queue. Failed operation sets are retried
+	// with rate limited backoff by the work queue.
+	gb.operationSetQueue.Run(1, gb.processOperationSet, stopCh)
+}
 
-			operationSet, err = gb.syncOperationSet(operationSet)
-			if err != nil {
-				gb.Error(err, "failed to sync OperationSet", "operationSet", operationSet)
-				gb.addDiagnosisToGraphBuilderQueue(operationSet)
-				continue
-			}
-			graphbuilderSyncSuccessCount.Inc()
-		// Stop graph builder on stop signal.
-		case <-stopCh:
-			return
+// processOperationSet builds the graph of one operation set by name. An error is returned if
+// the operation set could not be fetched or its graph is invalid, so the work queue retries
+// the operation set with backoff.
+func (gb *graphBuilder) processOperationSet(name string) error {
+	var operationSet diagnosisv1.OperationSet
+	if err := gb.client.Get(gb, client.ObjectKey{
+		Name: name,
+	}, &operationSet); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
 		}
+		graphbuilderSyncErrorCount.Inc()
+		return err
+	}
+
+	// Only process unready operation set.
+	if operationSet.Status.Ready {
+		graphbuilderSyncSkipCount.Inc()
+		return nil
 	}
+
+	if _, err := gb.syncOperationSet(operationSet); err != nil {
+		gb.Error(err, "failed to sync OperationSet", "operationSet", operationSet)
+		graphbuilderSyncErrorCount.Inc()
+		return err
+	}
+	graphbuilderSyncSuccessCount.Inc()
+
+	return nil
 }
 
 // syncOperationSet syncs operation sets.
@@ -219,17 +220,6 @@ func (gb *graphBuilder) syncOperationSet(operationSet diagnosisv1.OperationSet)
 	return operationSet, nil
 }
 
-// addDiagnosisToGraphBuilderQueue adds OperationSets to the queue processed by graph builder.
-func (gb *graphBuilder) addDiagnosisToGraphBuilderQueue(operationSet diagnosisv1.OperationSet) {
-	graphbuilderSyncErrorCount.Inc()
-	err := util.QueueOperationSet(gb, gb.graphBuilderCh, operationSet)
-	if err != nil {
-		gb.Error(err, "failed to send operation set to graph builder queue", "operationset", client.ObjectKey{
-			Name: operationSet.Name,
-		})
-	}
-}
-
 // newGraphFromAdjacencyList builds a directed graph from a adjacency list.
 // TODO: Panic recovery.
 func newGraphFromAdjacencyList(adjacencyList []diagnosisv1.Node) (*simple.DirectedGraph, error) {
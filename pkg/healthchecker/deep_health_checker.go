@@ -0,0 +1,305 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthchecker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	dockerclient "github.com/docker/docker/client"
+	"github.com/go-logr/logr"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/kubediag/kubediag/pkg/containerruntime"
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const (
+	// runtimePingTimeout is the timeout of a container runtime connectivity check.
+	runtimePingTimeout = 5 * time.Second
+	// dataRootUsageThreshold is the fraction of used data root capacity above which the data
+	// root check reports unhealthy.
+	dataRootUsageThreshold = 0.9
+)
+
+var (
+	deepHealthCheckCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "deep_health_check_count",
+			Help: "Counter of deep health checks served by the agent.",
+		},
+	)
+	deepHealthCheckStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "deep_health_check_status",
+			Help: "Status of deep health checks of the agent by check name. 1 is healthy and 0 is unhealthy.",
+		},
+		[]string{"check"},
+	)
+	deepHealthProcessorRegistered = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "deep_health_processor_registered",
+			Help: "Registration state of agent processors by path. 1 is registered.",
+		},
+		[]string{"processor"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		deepHealthCheckCount,
+		deepHealthCheckStatus,
+		deepHealthProcessorRegistered,
+	)
+}
+
+// checkResult is the result of a single deep health check.
+type checkResult struct {
+	// Name is the name of the check.
+	Name string `json:"name"`
+	// Healthy indicates whether the check passed.
+	Healthy bool `json:"healthy"`
+	// Message describes why the check failed. It is empty on a healthy check.
+	Message string `json:"message,omitempty"`
+}
+
+// deepHealthReport is the body of a deep health check response.
+type deepHealthReport struct {
+	// Healthy indicates whether all checks passed.
+	Healthy bool `json:"healthy"`
+	// Checks contains the results of all checks.
+	Checks []checkResult `json:"checks"`
+	// Processors contains the paths of all registered processors.
+	Processors []string `json:"processors"`
+}
+
+// DeepHealthChecker verifies the health of the kubediag agent itself on demand: container
+// runtime connectivity, data root writability and usage, cache synchronization and processor
+// registration state. It serves the /healthz/deep endpoint, so fleet health of kubediag is
+// observable with the regular liveness endpoint left cheap.
+type DeepHealthChecker struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// cache knows how to load Kubernetes objects.
+	cache cache.Cache
+	// runtime is the container runtime detected on the node.
+	runtime containerruntime.Runtime
+	// dataRoot is the root directory of kubediag data.
+	dataRoot string
+	// processorRouter returns the current processor router. It is a function since the router
+	// may be swapped at runtime by the configuration reloader.
+	processorRouter func() http.Handler
+	// cacheSynced indicates whether the cache has synchronized. It is accessed atomically.
+	cacheSynced int32
+}
+
+// NewDeepHealthChecker creates a new DeepHealthChecker.
+func NewDeepHealthChecker(
+	ctx context.Context,
+	logger logr.Logger,
+	cache cache.Cache,
+	runtime containerruntime.Runtime,
+	dataRoot string,
+	processorRouter func() http.Handler,
+) *DeepHealthChecker {
+	return &DeepHealthChecker{
+		Context:         ctx,
+		Logger:          logger,
+		cache:           cache,
+		runtime:         runtime,
+		dataRoot:        dataRoot,
+		processorRouter: processorRouter,
+	}
+}
+
+// Run marks the cache as synchronized once it has synced. It returns when the stop channel is
+// closed.
+func (dc *DeepHealthChecker) Run(stopCh <-chan struct{}) {
+	if !dc.cache.WaitForCacheSync(stopCh) {
+		return
+	}
+	atomic.StoreInt32(&dc.cacheSynced, 1)
+	<-stopCh
+}
+
+// Handler handles http requests for deep health checks of the agent. It responds with a status
+// code of 200 if all checks pass and 503 otherwise. The body contains the results of all checks
+// and the registered processors in json.
+func (dc *DeepHealthChecker) Handler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		deepHealthCheckCount.Inc()
+
+		processors := dc.registeredProcessors()
+		checks := []checkResult{
+			dc.checkRuntime(),
+			dc.checkDataRoot(),
+			dc.checkCacheSync(),
+			dc.checkProcessors(processors),
+		}
+
+		report := deepHealthReport{
+			Healthy:    true,
+			Checks:     checks,
+			Processors: processors,
+		}
+		for _, check := range checks {
+			if check.Healthy {
+				deepHealthCheckStatus.WithLabelValues(check.Name).Set(1)
+			} else {
+				deepHealthCheckStatus.WithLabelValues(check.Name).Set(0)
+				report.Healthy = false
+			}
+		}
+
+		data, err := json.Marshal(report)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to marshal deep health report: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(data)
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// checkRuntime verifies connectivity to the container runtime detected on the node. The docker
+// api is pinged on docker nodes and the runtime socket is probed on other runtimes.
+func (dc *DeepHealthChecker) checkRuntime() checkResult {
+	result := checkResult{Name: "runtime"}
+
+	if dc.runtime.Name != containerruntime.Docker {
+		if !containerruntime.Reachable(dc.runtime.Endpoint) {
+			result.Message = fmt.Sprintf("unable to reach %s endpoint %s", dc.runtime.Name, dc.runtime.Endpoint)
+			return result
+		}
+
+		result.Healthy = true
+		return result
+	}
+
+	cli, err := dockerclient.NewClientWithOpts(dockerclient.WithHost(dc.runtime.Endpoint))
+	if err != nil {
+		result.Message = fmt.Sprintf("unable to create docker client: %v", err)
+		return result
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(dc, runtimePingTimeout)
+	defer cancel()
+	if _, err := cli.Ping(ctx); err != nil {
+		result.Message = fmt.Sprintf("unable to ping docker endpoint %s: %v", dc.runtime.Endpoint, err)
+		return result
+	}
+
+	result.Healthy = true
+	return result
+}
+
+// checkDataRoot verifies that the data root is writable and that its filesystem usage is below
+// the usage threshold.
+func (dc *DeepHealthChecker) checkDataRoot() checkResult {
+	result := checkResult{Name: "dataRoot"}
+
+	file, err := ioutil.TempFile(dc.dataRoot, ".healthcheck")
+	if err != nil {
+		result.Message = fmt.Sprintf("data root %s is not writable: %v", dc.dataRoot, err)
+		return result
+	}
+	file.Close()
+	os.Remove(file.Name())
+
+	totalBytes := util.GetTotalBytes(dc.dataRoot)
+	if totalBytes != 0 {
+		usage := float64(totalBytes-util.GetAvailableBytes(dc.dataRoot)) / float64(totalBytes)
+		if usage > dataRootUsageThreshold {
+			result.Message = fmt.Sprintf("filesystem of data root %s is %.0f%% used", dc.dataRoot, usage*100)
+			return result
+		}
+	}
+
+	result.Healthy = true
+	return result
+}
+
+// checkCacheSync verifies that the cache has synchronized.
+func (dc *DeepHealthChecker) checkCacheSync() checkResult {
+	result := checkResult{Name: "cacheSync"}
+	if atomic.LoadInt32(&dc.cacheSynced) != 1 {
+		result.Message = "cache has not synchronized"
+		return result
+	}
+
+	result.Healthy = true
+	return result
+}
+
+// checkProcessors verifies that processors are registered on the processor router.
+func (dc *DeepHealthChecker) checkProcessors(processors []string) checkResult {
+	result := checkResult{Name: "processors"}
+	if len(processors) == 0 {
+		result.Message = "no processors are registered"
+		return result
+	}
+
+	result.Healthy = true
+	return result
+}
+
+// registeredProcessors returns the sorted paths of all processors registered on the current
+// processor router and updates the processor registration metric.
+func (dc *DeepHealthChecker) registeredProcessors() []string {
+	processors := make([]string, 0)
+	router, ok := dc.processorRouter().(*mux.Router)
+	if !ok {
+		return processors
+	}
+
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err == nil && strings.HasPrefix(path, "/processor/") {
+			processors = append(processors, path)
+		}
+		return nil
+	})
+	sort.Strings(processors)
+
+	deepHealthProcessorRegistered.Reset()
+	for _, processor := range processors {
+		deepHealthProcessorRegistered.WithLabelValues(processor).Set(1)
+	}
+
+	return processors
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthchecker
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const (
+	// housekeepingInterval specifies how long to wait between processor health check cycles.
+	housekeepingInterval = 30 * time.Second
+	// probeTimeout is the timeout of a single processor health check request.
+	probeTimeout = 10 * time.Second
+)
+
+var (
+	operationHealthCheckCycleCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "operation_health_check_cycle_count",
+			Help: "Counter of operation processor health check cycles",
+		},
+	)
+	operationHealthCheckErrorCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "operation_health_check_error_count",
+			Help: "Counter of erroneous operation processor health checks",
+		},
+	)
+	operationProcessorHealth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "operation_processor_health",
+			Help: "Health of registered operation processor endpoints",
+		},
+		[]string{"operation"},
+	)
+)
+
+// OperationHealthChecker tracks processor endpoints of registered operations and performs periodic
+// health checks. The result of the last health check is surfaced in operation status so that a
+// broken external processor is obvious before it fails a live diagnosis.
+type OperationHealthChecker struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// client knows how to perform CRUD operations on Kubernetes objects.
+	client client.Client
+	// cache knows how to load Kubernetes objects.
+	cache cache.Cache
+	// transport is the transport for sending http requests to processors.
+	transport *http.Transport
+}
+
+// NewOperationHealthChecker creates a new OperationHealthChecker.
+func NewOperationHealthChecker(
+	ctx context.Context,
+	logger logr.Logger,
+	cli client.Client,
+	cache cache.Cache,
+) *OperationHealthChecker {
+	metrics.Registry.MustRegister(
+		operationHealthCheckCycleCount,
+		operationHealthCheckErrorCount,
+		operationProcessorHealth,
+	)
+
+	transport := utilnet.SetTransportDefaults(
+		&http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+			Proxy:             http.ProxyURL(nil),
+		})
+
+	return &OperationHealthChecker{
+		Context:   ctx,
+		Logger:    logger,
+		client:    cli,
+		cache:     cache,
+		transport: transport,
+	}
+}
+
+// Run runs the operation health checker.
+func (oc *OperationHealthChecker) Run(stopCh <-chan struct{}) {
+	// Wait for all caches to sync before processing.
+	if !oc.cache.WaitForCacheSync(stopCh) {
+		return
+	}
+
+	go wait.Until(func() {
+		oc.Info("running operation processor health checks")
+		operationHealthCheckCycleCount.Inc()
+
+		operations, err := oc.listOperations()
+		if err != nil {
+			operationHealthCheckErrorCount.Inc()
+			oc.Error(err, "failed to list operations")
+			return
+		}
+
+		for _, operation := range operations {
+			// Only operations with an external http server endpoint are probed. Script runner and
+			// function processors are executed locally by kubediag agent.
+			if operation.Spec.Processor.HTTPServer == nil || operation.Spec.Processor.HTTPServer.Address == nil {
+				continue
+			}
+
+			err := oc.probeOperation(operation)
+			now := metav1.Now()
+			operation.Status.LastProbeTime = &now
+			if err != nil {
+				operation.Status.Phase = diagnosisv1.OperationUnhealthy
+				operation.Status.Message = err.Error()
+				operationProcessorHealth.WithLabelValues(operation.Name).Set(0)
+			} else {
+				operation.Status.Phase = diagnosisv1.OperationReady
+				operation.Status.Message = ""
+				operationProcessorHealth.WithLabelValues(operation.Name).Set(1)
+			}
+
+			if err := oc.client.Status().Update(oc, &operation); err != nil {
+				operationHealthCheckErrorCount.Inc()
+				oc.Error(err, "unable to update Operation", "operation", operation.Name)
+			}
+		}
+	}, housekeepingInterval, stopCh)
+}
+
+// probeOperation sends a health check request to the processor endpoint of the operation.
+// It returns nil if the processor responds with a status code of 200.
+func (oc *OperationHealthChecker) probeOperation(operation diagnosisv1.Operation) error {
+	host := *operation.Spec.Processor.HTTPServer.Address
+	var port int32
+	if operation.Spec.Processor.HTTPServer.Port != nil {
+		port = *operation.Spec.Processor.HTTPServer.Port
+	} else {
+		return fmt.Errorf("port of processor endpoint not specified")
+	}
+	scheme := "http"
+	if operation.Spec.Processor.HTTPServer.Scheme != nil {
+		scheme = strings.ToLower(string(*operation.Spec.Processor.HTTPServer.Scheme))
+	}
+	url := util.FormatURL(scheme, host, strconv.Itoa(int(port)), "/healthz")
+
+	cli := &http.Client{
+		Timeout:   probeTimeout,
+		Transport: oc.transport,
+	}
+	res, err := cli.Get(url.String())
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check responded with erroneous status: %s", res.Status)
+	}
+
+	return nil
+}
+
+// listOperations lists Operations from cache.
+func (oc *OperationHealthChecker) listOperations() ([]diagnosisv1.Operation, error) {
+	var operationList diagnosisv1.OperationList
+	if err := oc.cache.List(oc, &operationList); err != nil {
+		return nil, err
+	}
+
+	return operationList.Items, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Client runs host commands through the privileged helper over its unix socket.
+type Client struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// client is the http client connecting to the helper socket.
+	client *http.Client
+}
+
+// NewClient creates a new Client connecting to the helper socket.
+func NewClient(
+	ctx context.Context,
+	logger logr.Logger,
+	socketPath string,
+) *Client {
+	return &Client{
+		Context: ctx,
+		Logger:  logger,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// RunCommand runs the command through the helper and returns its combined output. It has the
+// same contract as util.BlockingRunCommandWithTimeout so it can be installed as the command
+// runner of the agent.
+func (cli *Client) RunCommand(command []string, timeoutSeconds int32) ([]byte, error) {
+	request := commandRequest{
+		Command:        command,
+		TimeoutSeconds: timeoutSeconds,
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal command request: %v", err)
+	}
+
+	// The http timeout covers the command timeout with headroom for the request itself.
+	ctx, cancel := context.WithTimeout(cli, time.Duration(timeoutSeconds)*time.Second+30*time.Second)
+	defer cancel()
+	req, err := http.NewRequest("POST", "http://helper"+commandPath, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := cli.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach helper: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return nil, fmt.Errorf("helper responded with status %d: %s", res.StatusCode, string(body))
+	}
+
+	var response commandResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("unable to decode command response: %v", err)
+	}
+	if response.Error != "" {
+		return response.Output, fmt.Errorf(response.Error)
+	}
+
+	return response.Output, nil
+}
@@ -0,0 +1,331 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package helper implements a small privileged helper for the kubediag agent. The agent runs
+// unprivileged and delegates the few host commands which need host namespaces to the helper over
+// a unix socket, so a compromised agent cannot run arbitrary commands on the host. The helper
+// only runs commands from its allowlist.
+package helper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-logr/logr"
+
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const (
+	// commandPath is the http path of the command endpoint on the helper socket.
+	commandPath = "/v1/command"
+)
+
+// trustedDirectories contains the root owned directories allowlisted commands are resolved
+// from. Commands are never resolved from the environment PATH or from a caller supplied path,
+// so a compromised agent cannot point the helper at a binary it wrote itself under the agent
+// writable data root.
+var trustedDirectories = []string{"/usr/sbin", "/usr/bin", "/sbin", "/bin"}
+
+var (
+	// interfaceRegexp matches a network interface name passed to tcpdump.
+	interfaceRegexp = regexp.MustCompile(`^[a-zA-Z0-9@._:-]+$`)
+	// numberRegexp matches an unsigned decimal number.
+	numberRegexp = regexp.MustCompile(`^[0-9]+$`)
+	// signalRegexp matches the signal argument of kill, such as -9, -USR1 or -SIGUSR1.
+	signalRegexp = regexp.MustCompile(`^-(?:[0-9]+|(?:SIG)?[A-Z][A-Z0-9+]*)$`)
+	// psOutputRegexp matches the output format argument of ps -o.
+	psOutputRegexp = regexp.MustCompile(`^[a-zA-Z0-9,.:%+=_-]+$`)
+	// pidListRegexp matches the comma separated pid list argument of ps -p.
+	pidListRegexp = regexp.MustCompile(`^[0-9]+(,[0-9]+)*$`)
+)
+
+// argumentValidators maps a command name to the validator of its argument shape. The name
+// check alone is not enough to contain a compromised agent: several allowlisted binaries can
+// be turned into arbitrary command runners or file writers through their own flags, such as
+// tcpdump -z or tcpdump -w. A command is only run if its arguments validate, and a command
+// allowlisted without a validator is rejected.
+var argumentValidators = map[string]func(args []string) error{
+	"tcpdump": validateTcpdumpArguments,
+	"kill":    validateKillArguments,
+	"du":      validateDuArguments,
+	"ps":      validatePsArguments,
+}
+
+// validateTcpdumpArguments validates the arguments of a tcpdump command. Only the interface,
+// packet count and snapshot length flags are accepted; the remaining arguments form the filter
+// expression and must not be flags. This rejects the flags which run commands or write files,
+// such as -z, -G with -w and -F.
+func validateTcpdumpArguments(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			// A filter expression token.
+			continue
+		}
+		switch arg {
+		case "-i":
+			i++
+			if i >= len(args) || !interfaceRegexp.MatchString(args[i]) {
+				return fmt.Errorf("flag %s requires an interface name", arg)
+			}
+		case "-c", "-s":
+			i++
+			if i >= len(args) || !numberRegexp.MatchString(args[i]) {
+				return fmt.Errorf("flag %s requires a number", arg)
+			}
+		case "-n", "-nn", "-e", "-q", "-v", "-vv":
+			// Output formatting flags without a value.
+		default:
+			return fmt.Errorf("flag %s is not allowed", arg)
+		}
+	}
+
+	return nil
+}
+
+// validateKillArguments validates the arguments of a kill command. An optional signal is
+// followed by one or more pids.
+func validateKillArguments(args []string) error {
+	if len(args) > 0 && signalRegexp.MatchString(args[0]) {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("at least one pid is required")
+	}
+	for _, arg := range args {
+		if !numberRegexp.MatchString(arg) {
+			return fmt.Errorf("pid %s is not a number", arg)
+		}
+	}
+
+	return nil
+}
+
+// validateDuArguments validates the arguments of a du command. Only summarizing and unit flags
+// are accepted and the paths must be absolute without parent directory elements.
+func validateDuArguments(args []string) error {
+	for _, arg := range args {
+		switch {
+		case arg == "-s" || arg == "-b" || arg == "-k" || arg == "-m" || arg == "-h" || arg == "-x" || arg == "--summarize" || arg == "--bytes":
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("flag %s is not allowed", arg)
+		case !filepath.IsAbs(arg) || strings.Contains(arg, ".."):
+			return fmt.Errorf("path %s must be absolute without parent directory elements", arg)
+		}
+	}
+
+	return nil
+}
+
+// validatePsArguments validates the arguments of a ps command. Only the selection and output
+// format flags used by the collectors are accepted.
+func validatePsArguments(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-e", "-A", "-f", "-l", "aux", "axu":
+		case "-o":
+			i++
+			if i >= len(args) || !psOutputRegexp.MatchString(args[i]) {
+				return fmt.Errorf("flag %s requires an output format", arg)
+			}
+		case "-p":
+			i++
+			if i >= len(args) || !pidListRegexp.MatchString(args[i]) {
+				return fmt.Errorf("flag %s requires a pid list", arg)
+			}
+		default:
+			return fmt.Errorf("argument %s is not allowed", arg)
+		}
+	}
+
+	return nil
+}
+
+// resolveCommand resolves an allowlisted command name to an executable in one of the trusted
+// directories.
+func resolveCommand(name string) (string, error) {
+	for _, directory := range trustedDirectories {
+		path := filepath.Join(directory, name)
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Mode()&0111 == 0 {
+			continue
+		}
+		return path, nil
+	}
+
+	return "", fmt.Errorf("command %s is not found in a trusted directory", name)
+}
+
+// commandRequest is the request to run a host command through the helper.
+type commandRequest struct {
+	// Command is the command to run with its arguments.
+	Command []string `json:"command"`
+	// TimeoutSeconds is the timeout of the command in seconds.
+	TimeoutSeconds int32 `json:"timeoutSeconds"`
+}
+
+// commandResponse is the response of a host command run through the helper.
+type commandResponse struct {
+	// Output is the combined standard output and standard error of the command.
+	Output []byte `json:"output"`
+	// Error is the error message if the command failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Helper serves the privileged command endpoint on a unix socket.
+type Helper struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// socketPath is the path of the unix socket to serve on.
+	socketPath string
+	// allowedCommands contains the names of commands the helper is permitted to run.
+	allowedCommands map[string]bool
+}
+
+// NewHelper creates a new Helper.
+func NewHelper(
+	ctx context.Context,
+	logger logr.Logger,
+	socketPath string,
+	allowedCommands []string,
+) *Helper {
+	allowed := make(map[string]bool)
+	for _, command := range allowedCommands {
+		allowed[command] = true
+	}
+
+	return &Helper{
+		Context:         ctx,
+		Logger:          logger,
+		socketPath:      socketPath,
+		allowedCommands: allowed,
+	}
+}
+
+// Run serves the command endpoint on the unix socket until the stop channel is closed.
+func (helper *Helper) Run(stopCh <-chan struct{}) error {
+	if err := os.MkdirAll(filepath.Dir(helper.socketPath), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create socket directory: %v", err)
+	}
+	// Remove a stale socket left behind by a previous helper process.
+	if err := os.Remove(helper.socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove stale socket: %v", err)
+	}
+
+	listener, err := net.Listen("unix", helper.socketPath)
+	if err != nil {
+		return fmt.Errorf("unable to listen on socket %s: %v", helper.socketPath, err)
+	}
+	if err := os.Chmod(helper.socketPath, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("unable to set socket permissions: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(commandPath, helper.commandHandler)
+	server := &http.Server{
+		Handler: mux,
+	}
+	go func() {
+		<-stopCh
+		server.Close()
+	}()
+
+	helper.Info("helper is serving on socket", "socket", helper.socketPath)
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// commandHandler handles http requests for running host commands.
+func (helper *Helper) commandHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		var request commandRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("unable to decode command request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(request.Command) == 0 {
+			http.Error(w, "a command must be specified", http.StatusBadRequest)
+			return
+		}
+		name := request.Command[0]
+		if name != filepath.Base(name) || strings.ContainsAny(name, `/\`) {
+			helper.Info("command must be a bare name", "command", name)
+			http.Error(w, fmt.Sprintf("command %s must be a bare name without a path", name), http.StatusForbidden)
+			return
+		}
+		if !helper.allowedCommands[name] {
+			helper.Info("command is not allowed", "command", request.Command[0])
+			http.Error(w, fmt.Sprintf("command %s is not allowed", request.Command[0]), http.StatusForbidden)
+			return
+		}
+		validate, ok := argumentValidators[name]
+		if !ok {
+			helper.Info("command has no argument validator", "command", request.Command[0])
+			http.Error(w, fmt.Sprintf("command %s has no argument validator", request.Command[0]), http.StatusForbidden)
+			return
+		}
+		if err := validate(request.Command[1:]); err != nil {
+			helper.Info("command arguments are not allowed", "command", request.Command, "error", err.Error())
+			http.Error(w, fmt.Sprintf("arguments of command %s are not allowed: %v", name, err), http.StatusForbidden)
+			return
+		}
+
+		path, err := resolveCommand(name)
+		if err != nil {
+			helper.Info("command can not be resolved", "command", name, "error", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		command := append([]string{path}, request.Command[1:]...)
+		helper.Info("running host command", "command", command)
+		output, err := util.BlockingRunCommandWithTimeout(command, request.TimeoutSeconds)
+		response := commandResponse{
+			Output: output,
+		}
+		if err != nil {
+			response.Error = err.Error()
+		}
+
+		data, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to marshal command response: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
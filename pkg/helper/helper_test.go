@@ -0,0 +1,168 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// newTestHelper creates a helper permitting the provided commands.
+func newTestHelper(allowedCommands []string) *Helper {
+	return NewHelper(context.Background(), ctrl.Log.WithName("helper"), "", allowedCommands)
+}
+
+// postCommand posts the command request to the command handler and returns the response.
+func postCommand(t *testing.T, helper *Helper, request commandRequest) *httptest.ResponseRecorder {
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("unable to marshal command request: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	helper.commandHandler(recorder, httptest.NewRequest("POST", commandPath, bytes.NewReader(body)))
+	return recorder
+}
+
+// installTrustedCommand installs an executable with the provided name into a temporary trusted
+// directory and restores the original trusted directories when the test finishes.
+func installTrustedCommand(t *testing.T, name string, script string) {
+	directory, err := ioutil.TempDir("", "helper-test")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	original := trustedDirectories
+	trustedDirectories = []string{directory}
+	t.Cleanup(func() {
+		trustedDirectories = original
+		os.RemoveAll(directory)
+	})
+
+	if err := ioutil.WriteFile(filepath.Join(directory, name), []byte(script), 0755); err != nil {
+		t.Fatalf("unable to write command: %v", err)
+	}
+}
+
+func TestCommandHandlerRejectsPaths(t *testing.T) {
+	tests := []struct {
+		command []string
+		desc    string
+	}{
+		{
+			command: []string{"/var/lib/kubediag/artifacts/tcpdump", "-i", "eth0"},
+			desc:    "an absolute path is refused",
+		},
+		{
+			command: []string{"./tcpdump", "-i", "eth0"},
+			desc:    "a relative path is refused",
+		},
+		{
+			command: []string{"../sbin/tcpdump", "-i", "eth0"},
+			desc:    "a parent directory path is refused",
+		},
+		{
+			command: []string{"artifacts/tcpdump", "-i", "eth0"},
+			desc:    "a path without a leading slash is refused",
+		},
+	}
+
+	helper := newTestHelper([]string{"tcpdump"})
+	for _, test := range tests {
+		recorder := postCommand(t, helper, commandRequest{Command: test.command})
+		assert.Equal(t, http.StatusForbidden, recorder.Code, test.desc)
+	}
+}
+
+func TestCommandHandlerRejectsDisallowedCommands(t *testing.T) {
+	tests := []struct {
+		allowedCommands []string
+		command         []string
+		desc            string
+	}{
+		{
+			allowedCommands: []string{"tcpdump"},
+			command:         []string{"mv", "/tmp/a", "/tmp/b"},
+			desc:            "a command outside the allowlist is refused",
+		},
+		{
+			allowedCommands: []string{"sh"},
+			command:         []string{"sh", "-c", "id"},
+			desc:            "an allowlisted command without an argument validator is refused",
+		},
+		{
+			allowedCommands: []string{"tcpdump"},
+			command:         []string{"tcpdump", "-i", "eth0", "-z", "sh"},
+			desc:            "a command with disallowed arguments is refused",
+		},
+	}
+
+	for _, test := range tests {
+		recorder := postCommand(t, newTestHelper(test.allowedCommands), commandRequest{Command: test.command})
+		assert.Equal(t, http.StatusForbidden, recorder.Code, test.desc)
+	}
+}
+
+func TestCommandHandlerRunsResolvedCommand(t *testing.T) {
+	installTrustedCommand(t, "ps", "#!/bin/sh\necho resolved\n")
+	helper := newTestHelper([]string{"ps"})
+
+	recorder := postCommand(t, helper, commandRequest{Command: []string{"ps", "-e"}, TimeoutSeconds: 10})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var response commandResponse
+	err := json.Unmarshal(recorder.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "resolved\n", string(response.Output))
+	assert.Empty(t, response.Error)
+}
+
+func TestCommandHandlerRequiresResolvableCommand(t *testing.T) {
+	installTrustedCommand(t, "ps", "#!/bin/sh\n")
+	helper := newTestHelper([]string{"ps", "tcpdump"})
+
+	recorder := postCommand(t, helper, commandRequest{Command: []string{"tcpdump", "-i", "eth0"}})
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code, "an allowlisted command missing from the trusted directories is refused")
+}
+
+func TestResolveCommandSkipsNonExecutableFiles(t *testing.T) {
+	directory, err := ioutil.TempDir("", "helper-test")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	original := trustedDirectories
+	trustedDirectories = []string{directory}
+	t.Cleanup(func() {
+		trustedDirectories = original
+		os.RemoveAll(directory)
+	})
+
+	if err := ioutil.WriteFile(filepath.Join(directory, "ps"), []byte("not executable"), 0644); err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+	_, err = resolveCommand("ps")
+	assert.Error(t, err, "a non executable file is not resolved")
+}
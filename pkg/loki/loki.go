@@ -0,0 +1,135 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package loki ships operation result text to a loki push endpoint labeled with diagnosis and
+// operation metadata, so results live next to the logs teams already query.
+package loki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// pushTimeout is the timeout of a push request.
+	pushTimeout = 30 * time.Second
+)
+
+// pushRequest is the payload of the loki push API.
+type pushRequest struct {
+	// Streams contains log streams to push.
+	Streams []stream `json:"streams"`
+}
+
+// stream is a labeled set of log entries.
+type stream struct {
+	// Stream contains labels of the stream.
+	Stream map[string]string `json:"stream"`
+	// Values contains log entries as timestamp and line pairs. Timestamps are unix epoch
+	// nanoseconds encoded as strings.
+	Values [][2]string `json:"values"`
+}
+
+// Client pushes labeled log lines to a loki push endpoint.
+type Client struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// pushURL is the url of the loki push endpoint.
+	pushURL string
+	// tenant is the tenant id sent with push requests. Pushes are sent without a tenant header
+	// if empty.
+	tenant string
+	// client is the http client for pushing log lines.
+	client *http.Client
+}
+
+// NewClient creates a new Client.
+func NewClient(
+	ctx context.Context,
+	logger logr.Logger,
+	pushURL string,
+	tenant string,
+) (*Client, error) {
+	if pushURL == "" {
+		return nil, fmt.Errorf("loki push url is not specified")
+	}
+
+	return &Client{
+		Context: ctx,
+		Logger:  logger,
+		pushURL: pushURL,
+		tenant:  tenant,
+		client: &http.Client{
+			Timeout: pushTimeout,
+		},
+	}, nil
+}
+
+// Push sends the lines as one stream with the labels to the loki push endpoint.
+func (cl *Client) Push(labels map[string]string, lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	values := make([][2]string, 0, len(lines))
+	for _, line := range lines {
+		values = append(values, [2]string{now, line})
+	}
+
+	payload, err := json.Marshal(pushRequest{
+		Streams: []stream{
+			{
+				Stream: labels,
+				Values: values,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal push request: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", cl.pushURL, bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cl.tenant != "" {
+		req.Header.Set("X-Scope-OrgID", cl.tenant)
+	}
+
+	res, err := cl.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("loki responded with erroneous status: %s", res.Status)
+	}
+
+	return nil
+}
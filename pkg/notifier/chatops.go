@@ -0,0 +1,119 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// SlackProvider posts messages to a slack incoming webhook.
+	SlackProvider = "slack"
+	// DingTalkProvider posts messages to a dingtalk robot webhook.
+	DingTalkProvider = "dingtalk"
+	// WeComProvider posts messages to a wecom robot webhook.
+	WeComProvider = "wecom"
+
+	// chatOpsTimeout is the timeout of a webhook delivery.
+	chatOpsTimeout = 10 * time.Second
+)
+
+// chatOpsNotifier posts diagnosis summaries to a chat webhook.
+type chatOpsNotifier struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// provider is the type of the webhook. It must be one of slack, dingtalk or wecom.
+	provider string
+	// webhookURL is the url of the webhook.
+	webhookURL string
+	// client is the http client for delivering messages.
+	client *http.Client
+}
+
+// NewChatOpsNotifier creates a new chatOpsNotifier. It returns an error if the provider is not
+// supported.
+func NewChatOpsNotifier(
+	ctx context.Context,
+	logger logr.Logger,
+	provider string,
+	webhookURL string,
+) (Notifier, error) {
+	switch provider {
+	case SlackProvider, DingTalkProvider, WeComProvider:
+	default:
+		return nil, fmt.Errorf("unsupported chatops provider: %s", provider)
+	}
+
+	return &chatOpsNotifier{
+		Context:    ctx,
+		Logger:     logger,
+		provider:   provider,
+		webhookURL: webhookURL,
+		client: &http.Client{
+			Timeout: chatOpsTimeout,
+		},
+	}, nil
+}
+
+// Notify posts the summary to the chat webhook.
+func (cn *chatOpsNotifier) Notify(summary DiagnosisSummary) error {
+	payload, err := cn.buildPayload(summary.Text())
+	if err != nil {
+		return fmt.Errorf("failed to build webhook payload: %v", err)
+	}
+
+	res, err := cn.client.Post(cn.webhookURL, "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("webhook responded with erroneous status: %s", res.Status)
+	}
+
+	cn.Info("diagnosis summary delivered to chat webhook", "provider", cn.provider, "diagnosis", summary.Namespace+"/"+summary.Name)
+	return nil
+}
+
+// buildPayload encodes the message in the format expected by the provider.
+func (cn *chatOpsNotifier) buildPayload(message string) ([]byte, error) {
+	switch cn.provider {
+	case SlackProvider:
+		return json.Marshal(map[string]string{
+			"text": message,
+		})
+	default:
+		// DingTalk and WeCom robots share the same text message format.
+		return json.Marshal(map[string]interface{}{
+			"msgtype": "text",
+			"text": map[string]string{
+				"content": message,
+			},
+		})
+	}
+}
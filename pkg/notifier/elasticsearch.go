@@ -0,0 +1,100 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	elasticsearch "github.com/elastic/go-elasticsearch/v7"
+	"github.com/go-logr/logr"
+)
+
+// elasticsearchSink indexes completed diagnosis documents into elasticsearch so historical
+// diagnoses are searchable alongside logs.
+type elasticsearchSink struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// client is the elasticsearch client for indexing documents.
+	client *elasticsearch.Client
+	// index is the name of the index to write diagnosis documents to.
+	index string
+}
+
+// diagnosisDocument is the document indexed for a completed diagnosis.
+type diagnosisDocument struct {
+	DiagnosisSummary `json:",inline"`
+	// Timestamp is the time at which the document was indexed.
+	Timestamp time.Time `json:"@timestamp"`
+}
+
+// NewElasticsearchSink creates a new elasticsearchSink.
+func NewElasticsearchSink(
+	ctx context.Context,
+	logger logr.Logger,
+	addresses []string,
+	username string,
+	password string,
+	index string,
+) (Notifier, error) {
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: addresses,
+		Username:  username,
+		Password:  password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create elasticsearch client: %v", err)
+	}
+
+	return &elasticsearchSink{
+		Context: ctx,
+		Logger:  logger,
+		client:  client,
+		index:   index,
+	}, nil
+}
+
+// Notify indexes the summary as a document into elasticsearch.
+func (es *elasticsearchSink) Notify(summary DiagnosisSummary) error {
+	document := diagnosisDocument{
+		DiagnosisSummary: summary,
+		Timestamp:        time.Now(),
+	}
+	body, err := json.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("failed to marshal diagnosis document: %v", err)
+	}
+
+	res, err := es.client.Index(es.index, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to index diagnosis document: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("elasticsearch responded with erroneous status: %s", res.Status())
+	}
+
+	es.Info("diagnosis document indexed", "diagnosis", summary.Namespace+"/"+summary.Name, "index", es.index)
+	return nil
+}
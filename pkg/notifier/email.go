@@ -0,0 +1,127 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+
+	"github.com/go-logr/logr"
+
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+// defaultEmailTemplate renders a diagnosis report with succeeded and failed paths and result
+// excerpts. A custom template can be provided to override it.
+const defaultEmailTemplate = `Subject: [KubeDiag] Diagnosis {{ .Namespace }}/{{ .Name }} {{ .Phase }}
+
+Diagnosis {{ .Namespace }}/{{ .Name }} completed with phase {{ .Phase }}.
+Operation set: {{ .OperationSet }}
+{{ if .SucceededPath }}Succeeded path: {{ range .SucceededPath }}{{ . }} {{ end }}
+{{ end }}{{ range .FailedPaths }}Failed path: {{ range . }}{{ . }} {{ end }}
+{{ end }}{{ if .Results }}Results:
+{{ range $key, $value := .Results }}  {{ $key }}: {{ $value }}
+{{ end }}{{ end }}`
+
+// emailNotifier sends rendered diagnosis reports to configured recipients over smtp.
+type emailNotifier struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// smtpServer is the address of the smtp server in host:port format.
+	smtpServer string
+	// auth is the smtp authentication. It is nil if the server does not require authentication.
+	auth smtp.Auth
+	// from is the sender address.
+	from string
+	// recipients contains the recipient addresses.
+	recipients []string
+	// namespaces filters diagnoses by namespace. All namespaces are reported if empty.
+	namespaces []string
+	// template renders the report body.
+	template *template.Template
+}
+
+// NewEmailNotifier creates a new emailNotifier. The report is rendered with templateText, or with
+// the default template if templateText is empty.
+func NewEmailNotifier(
+	ctx context.Context,
+	logger logr.Logger,
+	smtpServer string,
+	username string,
+	password string,
+	from string,
+	recipients []string,
+	namespaces []string,
+	templateText string,
+) (Notifier, error) {
+	if templateText == "" {
+		templateText = defaultEmailTemplate
+	}
+	reportTemplate, err := template.New("email").Parse(templateText)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email template: %v", err)
+	}
+
+	var auth smtp.Auth
+	if username != "" {
+		host, _, err := net.SplitHostPort(smtpServer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid smtp server address %s: %v", smtpServer, err)
+		}
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &emailNotifier{
+		Context:    ctx,
+		Logger:     logger,
+		smtpServer: smtpServer,
+		auth:       auth,
+		from:       from,
+		recipients: recipients,
+		namespaces: namespaces,
+		template:   reportTemplate,
+	}, nil
+}
+
+// Notify sends the rendered report to all recipients. Diagnoses outside the configured namespaces
+// are skipped.
+func (en *emailNotifier) Notify(summary DiagnosisSummary) error {
+	if len(en.namespaces) != 0 && !util.Contains(en.namespaces, summary.Namespace) {
+		return nil
+	}
+
+	var body bytes.Buffer
+	if err := en.template.Execute(&body, summary); err != nil {
+		return fmt.Errorf("failed to render email template: %v", err)
+	}
+
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\n%s", en.from, strings.Join(en.recipients, ", "), body.String())
+	if err := smtp.SendMail(en.smtpServer, en.auth, en.from, en.recipients, []byte(message)); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+
+	en.Info("diagnosis report sent by email", "diagnosis", summary.Namespace+"/"+summary.Name, "recipients", en.recipients)
+	return nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const (
+	// JiraProvider is the jira itsm provider.
+	JiraProvider = "jira"
+	// ServiceNowProvider is the servicenow itsm provider.
+	ServiceNowProvider = "servicenow"
+
+	// itsmTimeout is the timeout of a ticket creation request.
+	itsmTimeout = 30 * time.Second
+	// jiraIssueType is the issue type of tickets opened in jira.
+	jiraIssueType = "Bug"
+)
+
+// itsmNotifier opens a ticket in an itsm system when a diagnosis ends failed, so operators track
+// unresolved failures in their existing workflow. Ticket creation can be restricted to diagnoses
+// in specific namespaces.
+type itsmNotifier struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// provider is the type of the itsm system. It must be one of jira or servicenow.
+	provider string
+	// apiURL is the base url of the itsm system.
+	apiURL string
+	// username is the username for authentication.
+	username string
+	// password is the password or api token for authentication.
+	password string
+	// project is the project key of opened jira issues. It is ignored by servicenow.
+	project string
+	// namespaces contains namespaces of diagnoses to open tickets for. Failed diagnoses in all
+	// namespaces result in tickets if empty.
+	namespaces []string
+	// client is the http client for opening tickets.
+	client *http.Client
+}
+
+// NewITSMNotifier creates a new itsmNotifier.
+func NewITSMNotifier(
+	ctx context.Context,
+	logger logr.Logger,
+	provider string,
+	apiURL string,
+	username string,
+	password string,
+	project string,
+	namespaces []string,
+) (Notifier, error) {
+	if provider != JiraProvider && provider != ServiceNowProvider {
+		return nil, fmt.Errorf("invalid itsm provider %s", provider)
+	}
+	if apiURL == "" {
+		return nil, fmt.Errorf("itsm api url is not specified")
+	}
+	if provider == JiraProvider && project == "" {
+		return nil, fmt.Errorf("jira project key is not specified")
+	}
+
+	return &itsmNotifier{
+		Context:    ctx,
+		Logger:     logger,
+		provider:   provider,
+		apiURL:     strings.TrimSuffix(apiURL, "/"),
+		username:   username,
+		password:   password,
+		project:    project,
+		namespaces: namespaces,
+		client: &http.Client{
+			Timeout: itsmTimeout,
+		},
+	}, nil
+}
+
+// Notify opens a ticket with the failure summary if the diagnosis ended failed.
+func (in *itsmNotifier) Notify(summary DiagnosisSummary) error {
+	if summary.Phase != diagnosisv1.DiagnosisFailed {
+		return nil
+	}
+	if len(in.namespaces) != 0 && !util.Contains(in.namespaces, summary.Namespace) {
+		return nil
+	}
+
+	title := fmt.Sprintf("Diagnosis %s/%s failed", summary.Namespace, summary.Name)
+	var endpoint string
+	var payload interface{}
+	switch in.provider {
+	case JiraProvider:
+		endpoint = in.apiURL + "/rest/api/2/issue"
+		payload = map[string]interface{}{
+			"fields": map[string]interface{}{
+				"project":     map[string]string{"key": in.project},
+				"summary":     title,
+				"description": summary.Text(),
+				"issuetype":   map[string]string{"name": jiraIssueType},
+			},
+		}
+	case ServiceNowProvider:
+		endpoint = in.apiURL + "/api/now/table/incident"
+		payload = map[string]string{
+			"short_description": title,
+			"description":       summary.Text(),
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ticket: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(in.username, in.password)
+
+	res, err := in.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("itsm system responded with erroneous status: %s", res.Status)
+	}
+
+	in.Info("ticket opened for failed diagnosis", "diagnosis", summary.Namespace+"/"+summary.Name, "provider", in.provider)
+	return nil
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+const (
+	// KafkaFormatJSON serializes diagnosis summaries as plain json documents.
+	KafkaFormatJSON = "json"
+	// KafkaFormatCloudEvents serializes diagnosis summaries as cloudevents in structured json mode.
+	KafkaFormatCloudEvents = "cloudevents"
+
+	// kafkaEventType is the cloudevents type of a completed diagnosis event.
+	kafkaEventType = "org.kubediag.diagnosis.completed"
+	// kafkaEventSource is the cloudevents source of diagnosis events.
+	kafkaEventSource = "kubediag"
+	// kafkaWriteTimeout is the timeout of a single kafka message delivery.
+	kafkaWriteTimeout = 10 * time.Second
+)
+
+// kafkaPublisher publishes completed diagnosis summaries to a kafka topic so downstream data
+// pipelines can build failure analytics on top of kubediag.
+type kafkaPublisher struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// writer provides a high-level API for producing messages to kafka.
+	writer *kafkago.Writer
+	// format is the serialization format of published messages.
+	format string
+}
+
+// cloudEvent is a cloudevents envelope in structured json mode.
+type cloudEvent struct {
+	// SpecVersion is the version of the cloudevents specification.
+	SpecVersion string `json:"specversion"`
+	// Type is the type of the event.
+	Type string `json:"type"`
+	// Source identifies the context in which the event happened.
+	Source string `json:"source"`
+	// ID is the identifier of the event.
+	ID string `json:"id"`
+	// Time is the time at which the event occurred.
+	Time time.Time `json:"time"`
+	// DataContentType is the content type of the data value.
+	DataContentType string `json:"datacontenttype"`
+	// Data is the event payload.
+	Data DiagnosisSummary `json:"data"`
+}
+
+// NewKafkaPublisher creates a new kafkaPublisher. The format must be either json or cloudevents.
+func NewKafkaPublisher(
+	ctx context.Context,
+	logger logr.Logger,
+	brokers []string,
+	topic string,
+	format string,
+) (Notifier, error) {
+	if len(brokers) == 0 || topic == "" {
+		return nil, fmt.Errorf("kafka broker and topic are not specified")
+	}
+	if format != KafkaFormatJSON && format != KafkaFormatCloudEvents {
+		return nil, fmt.Errorf("invalid kafka serialization format %s", format)
+	}
+
+	writer := kafkago.NewWriter(kafkago.WriterConfig{
+		Brokers:      brokers,
+		Topic:        topic,
+		Balancer:     &kafkago.Hash{},
+		WriteTimeout: kafkaWriteTimeout,
+	})
+
+	return &kafkaPublisher{
+		Context: ctx,
+		Logger:  logger,
+		writer:  writer,
+		format:  format,
+	}, nil
+}
+
+// Notify publishes the summary to the kafka topic. Messages are keyed by the diagnosis namespace
+// and name so results of the same diagnosis land in the same partition.
+func (kp *kafkaPublisher) Notify(summary DiagnosisSummary) error {
+	var value []byte
+	var err error
+	switch kp.format {
+	case KafkaFormatCloudEvents:
+		now := time.Now()
+		value, err = json.Marshal(cloudEvent{
+			SpecVersion:     "1.0",
+			Type:            kafkaEventType,
+			Source:          kafkaEventSource,
+			ID:              fmt.Sprintf("%s.%s.%d", summary.Namespace, summary.Name, now.UnixNano()),
+			Time:            now,
+			DataContentType: "application/json",
+			Data:            summary,
+		})
+	default:
+		value, err = json.Marshal(summary)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal diagnosis summary: %v", err)
+	}
+
+	err = kp.writer.WriteMessages(kp, kafkago.Message{
+		Key:   []byte(summary.Namespace + "/" + summary.Name),
+		Value: value,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish diagnosis summary to kafka: %v", err)
+	}
+
+	kp.Info("diagnosis summary published to kafka", "diagnosis", summary.Namespace+"/"+summary.Name, "format", kp.format)
+	return nil
+}
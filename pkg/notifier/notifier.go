@@ -0,0 +1,128 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notifier delivers summaries of completed diagnoses to external systems such as chat
+// webhooks, email recipients or generic http receivers.
+package notifier
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/alertmanager"
+)
+
+// maxSummaryResults is the maximum number of operation result entries included in a summary.
+const maxSummaryResults = 10
+
+// Notifier delivers a summary of a completed diagnosis to an external system.
+type Notifier interface {
+	// Notify delivers the summary. It returns an error if the delivery fails.
+	Notify(summary DiagnosisSummary) error
+}
+
+// DiagnosisSummary contains the key facts of a completed diagnosis.
+type DiagnosisSummary struct {
+	// Namespace is the namespace of the diagnosis.
+	Namespace string `json:"namespace"`
+	// Name is the name of the diagnosis.
+	Name string `json:"name"`
+	// Phase is the terminal phase of the diagnosis.
+	Phase diagnosisv1.DiagnosisPhase `json:"phase"`
+	// OperationSet is the name of the executed operation set.
+	OperationSet string `json:"operationSet"`
+	// SucceededPath contains operation names of the succeeded path.
+	// +optional
+	SucceededPath []string `json:"succeededPath,omitempty"`
+	// FailedPaths contains operation names of all failed paths.
+	// +optional
+	FailedPaths [][]string `json:"failedPaths,omitempty"`
+	// Results contains key results collected during the diagnosis execution.
+	// +optional
+	Results map[string]string `json:"results,omitempty"`
+	// AlertFingerprint is the fingerprint of the prometheus alert that triggered the diagnosis.
+	// It is empty if the diagnosis was not generated from a prometheus alert.
+	// +optional
+	AlertFingerprint string `json:"alertFingerprint,omitempty"`
+}
+
+// NewDiagnosisSummary builds a summary from a completed diagnosis.
+func NewDiagnosisSummary(diagnosis diagnosisv1.Diagnosis) DiagnosisSummary {
+	summary := DiagnosisSummary{
+		Namespace:        diagnosis.Namespace,
+		Name:             diagnosis.Name,
+		Phase:            diagnosis.Status.Phase,
+		OperationSet:     diagnosis.Spec.OperationSet,
+		AlertFingerprint: diagnosis.Annotations[alertmanager.PrometheusAlertFingerprintAnnotation],
+	}
+
+	for _, node := range diagnosis.Status.SucceededPath {
+		summary.SucceededPath = append(summary.SucceededPath, node.Operation)
+	}
+	for _, path := range diagnosis.Status.FailedPaths {
+		operations := make([]string, 0, len(path))
+		for _, node := range path {
+			operations = append(operations, node.Operation)
+		}
+		summary.FailedPaths = append(summary.FailedPaths, operations)
+	}
+
+	// Flatten operation results of all tasks into key value pairs.
+	if diagnosis.Status.Context != nil {
+		results := make(map[string]string)
+		for operation, operationContext := range diagnosis.Status.Context.Operations {
+			for task, taskContext := range operationContext {
+				for key, value := range taskContext {
+					results[operation+"."+task+"."+key] = value
+				}
+			}
+		}
+		summary.Results = results
+	}
+
+	return summary
+}
+
+// Text renders the summary as a human readable message.
+func (summary DiagnosisSummary) Text() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "Diagnosis %s/%s %s (operation set %s)\n", summary.Namespace, summary.Name, summary.Phase, summary.OperationSet)
+	if len(summary.SucceededPath) != 0 {
+		fmt.Fprintf(&builder, "Succeeded path: %s\n", strings.Join(summary.SucceededPath, " -> "))
+	}
+	for _, path := range summary.FailedPaths {
+		fmt.Fprintf(&builder, "Failed path: %s\n", strings.Join(path, " -> "))
+	}
+
+	if len(summary.Results) != 0 {
+		keys := make([]string, 0, len(summary.Results))
+		for key := range summary.Results {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if len(keys) > maxSummaryResults {
+			keys = keys[:maxSummaryResults]
+		}
+		builder.WriteString("Results:\n")
+		for _, key := range keys {
+			fmt.Fprintf(&builder, "  %s: %s\n", key, summary.Results[key])
+		}
+	}
+
+	return builder.String()
+}
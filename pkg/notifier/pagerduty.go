@@ -0,0 +1,117 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/pagerdutyeventer"
+)
+
+const (
+	// DefaultPagerDutyEventsAPIURL is the default url of the pagerduty events api v2.
+	DefaultPagerDutyEventsAPIURL = "https://events.pagerduty.com/v2/enqueue"
+
+	// pagerDutyResolveAction is the event action for resolving an incident.
+	pagerDutyResolveAction = "resolve"
+	// pagerDutyTimeout is the timeout of an event delivery.
+	pagerDutyTimeout = 10 * time.Second
+)
+
+// pagerDutyResolver sends resolve events to the pagerduty events api v2 when a diagnosis
+// generated from a prometheus alert completes successfully after running a recovery path.
+// Events are keyed by the fingerprint of the originating alert so the matching incident is
+// resolved without paging an operator.
+type pagerDutyResolver struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// apiURL is the url of the pagerduty events api.
+	apiURL string
+	// routingKey is the integration key of the pagerduty service.
+	routingKey string
+	// client is the http client for delivering events.
+	client *http.Client
+}
+
+// NewPagerDutyResolver creates a new pagerDutyResolver.
+func NewPagerDutyResolver(
+	ctx context.Context,
+	logger logr.Logger,
+	apiURL string,
+	routingKey string,
+) (Notifier, error) {
+	if routingKey == "" {
+		return nil, fmt.Errorf("pagerduty routing key is not specified")
+	}
+	if apiURL == "" {
+		apiURL = DefaultPagerDutyEventsAPIURL
+	}
+
+	return &pagerDutyResolver{
+		Context:    ctx,
+		Logger:     logger,
+		apiURL:     apiURL,
+		routingKey: routingKey,
+		client: &http.Client{
+			Timeout: pagerDutyTimeout,
+		},
+	}, nil
+}
+
+// Notify sends a resolve event keyed by the originating alert fingerprint if the diagnosis was
+// generated from a prometheus alert, completed successfully and ran at least one operation.
+func (pd *pagerDutyResolver) Notify(summary DiagnosisSummary) error {
+	if summary.Phase != diagnosisv1.DiagnosisSucceeded {
+		return nil
+	}
+	if summary.AlertFingerprint == "" || len(summary.SucceededPath) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(pagerdutyeventer.PagerDutyMessage{
+		RoutingKey:  pd.routingKey,
+		DedupKey:    summary.AlertFingerprint,
+		EventAction: pagerDutyResolveAction,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal pagerduty message: %v", err)
+	}
+
+	res, err := pd.client.Post(pd.apiURL, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("pagerduty responded with erroneous status: %s", res.Status)
+	}
+
+	pd.Info("pagerduty incident resolved", "diagnosis", summary.Namespace+"/"+summary.Name, "fingerprint", summary.AlertFingerprint)
+	return nil
+}
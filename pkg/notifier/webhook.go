@@ -0,0 +1,107 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// webhookTimeout is the timeout of an outbound webhook delivery.
+const webhookTimeout = 10 * time.Second
+
+// webhookNotifier posts a templated json payload to a generic http receiver on diagnosis phase
+// transitions, so external automation can consume kubediag outcomes without watching the API.
+type webhookNotifier struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// url is the url of the receiver.
+	url string
+	// template renders the payload. The whole summary is encoded as a json object if nil.
+	template *template.Template
+	// client is the http client for delivering payloads.
+	client *http.Client
+}
+
+// NewWebhookNotifier creates a new webhookNotifier. The payload is rendered with templateText, or
+// the whole summary is encoded as a json object if templateText is empty.
+func NewWebhookNotifier(
+	ctx context.Context,
+	logger logr.Logger,
+	url string,
+	templateText string,
+) (Notifier, error) {
+	var payloadTemplate *template.Template
+	if templateText != "" {
+		var err error
+		payloadTemplate, err = template.New("webhook").Parse(templateText)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse webhook template: %v", err)
+		}
+	}
+
+	return &webhookNotifier{
+		Context:  ctx,
+		Logger:   logger,
+		url:      url,
+		template: payloadTemplate,
+		client: &http.Client{
+			Timeout: webhookTimeout,
+		},
+	}, nil
+}
+
+// Notify posts the rendered payload to the receiver.
+func (wn *webhookNotifier) Notify(summary DiagnosisSummary) error {
+	var payload []byte
+	if wn.template != nil {
+		var body bytes.Buffer
+		if err := wn.template.Execute(&body, summary); err != nil {
+			return fmt.Errorf("failed to render webhook template: %v", err)
+		}
+		payload = body.Bytes()
+	} else {
+		var err error
+		payload, err = json.Marshal(summary)
+		if err != nil {
+			return fmt.Errorf("failed to marshal summary: %v", err)
+		}
+	}
+
+	res, err := wn.client.Post(wn.url, "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook responded with erroneous status: %s", res.Status)
+	}
+
+	wn.Info("diagnosis summary delivered to webhook", "diagnosis", summary.Namespace+"/"+summary.Name, "url", wn.url)
+	return nil
+}
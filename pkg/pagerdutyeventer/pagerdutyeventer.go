@@ -95,6 +95,9 @@ type pagerdutyEventer struct {
 	webhookAddress string
 	// pagerdutyEventerEnabled indicates whether pagerdutyEventer is enabled.
 	pagerdutyEventerEnabled bool
+	// elected is closed once this replica has been elected leader. Pagerduty events are only
+	// processed by the leader, so an HA deployment does not generate duplicate diagnoses.
+	elected <-chan struct{}
 }
 
 // NewPagerDutyEventer creates a new PagerDutyEventer.
@@ -108,6 +111,7 @@ func NewPagerDutyEventer(
 	sinkEventToWebhookReceiver bool,
 	webhookAddress string,
 	pagerdutyEventerEnabled bool,
+	elected <-chan struct{},
 ) PagerDutyEventer {
 	metrics.Registry.MustRegister(
 		pagerdutyEventReceivedCount,
@@ -123,6 +127,7 @@ func NewPagerDutyEventer(
 		sinkEventToWebhookReceiver: sinkEventToWebhookReceiver,
 		webhookAddress:             webhookAddress,
 		pagerdutyEventerEnabled:    pagerdutyEventerEnabled,
+		elected:                    elected,
 	}
 }
 
@@ -133,6 +138,15 @@ func (pe *pagerdutyEventer) Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only the elected leader generates diagnoses from pagerduty events, so an HA deployment
+	// does not create duplicates.
+	select {
+	case <-pe.elected:
+	default:
+		http.Error(w, "kubediag master is not the leader", http.StatusServiceUnavailable)
+		return
+	}
+
 	switch r.Method {
 	case "POST":
 		pagerdutyEventReceivedCount.Inc()
@@ -0,0 +1,163 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package policy implements a permission model for mutating operations. An operation declares the
+// resource kinds it mutates with an annotation, and a policy file allowlists which operations are
+// permitted to mutate which resource kinds, namespaces and nodes. The executor enforces the
+// policy before invoking a processor, so a mistyped operation set cannot mutate resources it was
+// never meant to touch.
+package policy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const (
+	// MutatedResourcesAnnotation is the annotation set on an operation to declare the resource
+	// kinds it mutates as a comma separated list, such as "pod,node". Operations without the
+	// annotation are considered read only and are not subject to the policy.
+	MutatedResourcesAnnotation = util.KubeDiagPrefix + "mutates"
+)
+
+// Rule allows a set of operations to mutate a set of resource kinds. All patterns support shell
+// style wildcards and an empty list matches everything.
+type Rule struct {
+	// Operations contains name patterns of operations the rule applies to.
+	Operations []string `json:"operations,omitempty"`
+	// Resources contains resource kind patterns the operations are permitted to mutate.
+	Resources []string `json:"resources,omitempty"`
+	// Namespaces contains namespace patterns the operations are permitted to mutate resources in.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Nodes contains node name patterns the operations are permitted to run on.
+	Nodes []string `json:"nodes,omitempty"`
+}
+
+// policyFile is the serialized form of a policy.
+type policyFile struct {
+	// Rules contains the allowlist rules of the policy.
+	Rules []Rule `json:"rules"`
+}
+
+// Policy decides whether an operation is permitted to mutate a resource. A mutating operation is
+// permitted if any rule allows it.
+type Policy struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// rules contains the allowlist rules of the policy.
+	rules []Rule
+}
+
+// NewPolicy creates a new Policy from the policy file.
+func NewPolicy(
+	ctx context.Context,
+	logger logr.Logger,
+	policyFile string,
+) (*Policy, error) {
+	data, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read policy file %s: %v", policyFile, err)
+	}
+
+	policy := &Policy{
+		Context: ctx,
+		Logger:  logger,
+	}
+	if err := policy.load(data); err != nil {
+		return nil, fmt.Errorf("unable to load policy file %s: %v", policyFile, err)
+	}
+
+	return policy, nil
+}
+
+// Allow returns true if any rule permits the operation to mutate the resource kind in the
+// namespace on the node.
+func (policy *Policy) Allow(operation string, resource string, namespace string, node string) bool {
+	for _, rule := range policy.rules {
+		if matchAny(rule.Operations, operation) && matchAny(rule.Resources, resource) && matchAny(rule.Namespaces, namespace) && matchAny(rule.Nodes, node) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MutatedResources returns the resource kinds declared by the mutated resources annotation. It
+// returns an empty list if the annotations do not declare any mutated resource.
+func MutatedResources(annotations map[string]string) []string {
+	value := annotations[MutatedResourcesAnnotation]
+	if value == "" {
+		return nil
+	}
+
+	resources := make([]string, 0)
+	for _, resource := range strings.Split(value, ",") {
+		resource = strings.TrimSpace(resource)
+		if resource != "" {
+			resources = append(resources, strings.ToLower(resource))
+		}
+	}
+
+	return resources
+}
+
+// load parses the serialized policy and validates its patterns.
+func (policy *Policy) load(data []byte) error {
+	var file policyFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return err
+	}
+
+	for _, rule := range file.Rules {
+		for _, patterns := range [][]string{rule.Operations, rule.Resources, rule.Namespaces, rule.Nodes} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+				}
+			}
+		}
+	}
+	policy.rules = file.Rules
+
+	return nil
+}
+
+// matchAny returns true if the value matches any of the patterns. An empty pattern list matches
+// everything.
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, value); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package processorproto contains the messages of the operation processor grpc service
+// defined in api/proto/processor.proto. The messages are encoded in proto3 wire format by
+// hand, so the repository does not depend on a protobuf toolchain while the agent remains
+// interoperable with processors built from the published proto.
+package processorproto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// ProcessRequest carries the operation context of a task. It corresponds to the
+// processor.ProcessRequest message defined in api/proto/processor.proto.
+type ProcessRequest struct {
+	// Context contains the diagnosis parameters and the results of completed operations
+	// of the diagnosis.
+	Context map[string]string
+}
+
+// Marshal encodes the request in proto3 wire format.
+func (m *ProcessRequest) Marshal() []byte {
+	return appendMapField(nil, 1, m.Context)
+}
+
+// Unmarshal decodes the request from proto3 wire format.
+func (m *ProcessRequest) Unmarshal(data []byte) error {
+	m.Context = nil
+	return parseFields(data, func(fieldNumber int, wireType int, varint uint64, value []byte) error {
+		if fieldNumber == 1 && wireType == 2 {
+			if m.Context == nil {
+				m.Context = make(map[string]string)
+			}
+			return unmarshalMapEntry(value, m.Context)
+		}
+		return nil
+	})
+}
+
+// ProcessResponse carries a chunk of operation results. It corresponds to the
+// processor.ProcessResponse message defined in api/proto/processor.proto.
+type ProcessResponse struct {
+	// Succeeded reports whether the operation succeeded. The flag of the last chunk of a
+	// stream is authoritative.
+	Succeeded bool
+	// Result contains a chunk of operation result entries.
+	Result map[string]string
+}
+
+// Marshal encodes the response in proto3 wire format.
+func (m *ProcessResponse) Marshal() []byte {
+	var encoded []byte
+	if m.Succeeded {
+		encoded = appendUvarint(append(encoded, 1<<3), 1)
+	}
+	return appendMapField(encoded, 2, m.Result)
+}
+
+// Unmarshal decodes the response from proto3 wire format.
+func (m *ProcessResponse) Unmarshal(data []byte) error {
+	m.Succeeded = false
+	m.Result = nil
+	return parseFields(data, func(fieldNumber int, wireType int, varint uint64, value []byte) error {
+		switch {
+		case fieldNumber == 1 && wireType == 0:
+			m.Succeeded = varint != 0
+		case fieldNumber == 2 && wireType == 2:
+			if m.Result == nil {
+				m.Result = make(map[string]string)
+			}
+			return unmarshalMapEntry(value, m.Result)
+		}
+		return nil
+	})
+}
+
+// Codec encodes and decodes processor messages in proto3 wire format. It implements the
+// grpc encoding.Codec interface.
+type Codec struct{}
+
+// Marshal implements the grpc encoding.Codec interface.
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	switch message := v.(type) {
+	case *ProcessRequest:
+		return message.Marshal(), nil
+	case *ProcessResponse:
+		return message.Marshal(), nil
+	}
+	return nil, fmt.Errorf("unsupported message type %T", v)
+}
+
+// Unmarshal implements the grpc encoding.Codec interface.
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	switch message := v.(type) {
+	case *ProcessRequest:
+		return message.Unmarshal(data)
+	case *ProcessResponse:
+		return message.Unmarshal(data)
+	}
+	return fmt.Errorf("unsupported message type %T", v)
+}
+
+// Name implements the grpc encoding.Codec interface. The name of the standard proto codec
+// is returned since messages are encoded in proto3 wire format.
+func (Codec) Name() string {
+	return "proto"
+}
+
+// appendUvarint appends the varint encoding of the value.
+func appendUvarint(encoded []byte, value uint64) []byte {
+	var buffer [binary.MaxVarintLen64]byte
+	length := binary.PutUvarint(buffer[:], value)
+	return append(encoded, buffer[:length]...)
+}
+
+// appendLengthDelimited appends a length delimited field with the provided field number.
+func appendLengthDelimited(encoded []byte, fieldNumber int, value []byte) []byte {
+	encoded = appendUvarint(encoded, uint64(fieldNumber)<<3|2)
+	encoded = appendUvarint(encoded, uint64(len(value)))
+	return append(encoded, value...)
+}
+
+// appendMapField appends a map field with the provided field number. Entries are encoded
+// in lexical key order, so the encoding is deterministic.
+func appendMapField(encoded []byte, fieldNumber int, entries map[string]string) []byte {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		entry := appendLengthDelimited(nil, 1, []byte(key))
+		entry = appendLengthDelimited(entry, 2, []byte(entries[key]))
+		encoded = appendLengthDelimited(encoded, fieldNumber, entry)
+	}
+
+	return encoded
+}
+
+// unmarshalMapEntry decodes a map entry message into the provided map.
+func unmarshalMapEntry(data []byte, entries map[string]string) error {
+	var entryKey, entryValue string
+	if err := parseFields(data, func(fieldNumber int, wireType int, varint uint64, value []byte) error {
+		switch {
+		case fieldNumber == 1 && wireType == 2:
+			entryKey = string(value)
+		case fieldNumber == 2 && wireType == 2:
+			entryValue = string(value)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	entries[entryKey] = entryValue
+	return nil
+}
+
+// parseFields walks all fields of a message in proto3 wire format and invokes the handler
+// for every field. Unknown fields are skipped, so messages from newer revisions of the
+// proto remain decodable.
+func parseFields(data []byte, handle func(fieldNumber int, wireType int, varint uint64, value []byte) error) error {
+	for len(data) > 0 {
+		tag, length := binary.Uvarint(data)
+		if length <= 0 {
+			return fmt.Errorf("invalid field tag")
+		}
+		data = data[length:]
+		fieldNumber := int(tag >> 3)
+		wireType := int(tag & 0x7)
+
+		switch wireType {
+		case 0:
+			value, length := binary.Uvarint(data)
+			if length <= 0 {
+				return fmt.Errorf("invalid varint of field %d", fieldNumber)
+			}
+			data = data[length:]
+			if err := handle(fieldNumber, wireType, value, nil); err != nil {
+				return err
+			}
+		case 1:
+			if len(data) < 8 {
+				return fmt.Errorf("invalid fixed64 of field %d", fieldNumber)
+			}
+			data = data[8:]
+		case 2:
+			value, length := binary.Uvarint(data)
+			if length <= 0 || uint64(len(data)-length) < value {
+				return fmt.Errorf("invalid length of field %d", fieldNumber)
+			}
+			if err := handle(fieldNumber, wireType, 0, data[length:length+int(value)]); err != nil {
+				return err
+			}
+			data = data[length+int(value):]
+		case 5:
+			if len(data) < 4 {
+				return fmt.Errorf("invalid fixed32 of field %d", fieldNumber)
+			}
+			data = data[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d of field %d", wireType, fieldNumber)
+		}
+	}
+
+	return nil
+}
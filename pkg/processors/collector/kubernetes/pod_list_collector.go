@@ -21,19 +21,51 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/kubediag/kubediag/pkg/processors"
+	"github.com/kubediag/kubediag/pkg/processors/utils"
 	"github.com/kubediag/kubediag/pkg/util"
 )
 
 const (
-	ContextKeyPodList = "collector.kubernetes.pod.list"
+	ContextKeyPodList        = "collector.kubernetes.pod.list"
+	ContextKeyPodListSummary = "collector.kubernetes.pod.list.summary"
+
+	ParameterKeyPodListNamespace = "param.collector.kubernetes.pod_list.namespace"
+	ParameterKeyPodListFields    = "param.collector.kubernetes.pod_list.fields"
+	ParameterKeyPodListOffset    = "param.collector.kubernetes.pod_list.offset"
+	ParameterKeyPodListLimit     = "param.collector.kubernetes.pod_list.limit"
+	ParameterKeyPodListMaxBytes  = "param.collector.kubernetes.pod_list.max_bytes"
+
+	// defaultPodListLimit is the default maximum number of pods returned in one result.
+	defaultPodListLimit = 500
+	// defaultPodListMaxBytes is the default maximum size of the marshaled pod list in bytes.
+	defaultPodListMaxBytes = 1 << 20
 )
 
+// podListSummary describes the pod list result and how it was truncated. It is returned alongside
+// the pod list so that operations consuming the result can paginate with the offset parameter.
+type podListSummary struct {
+	// Total is the number of pods on the node after namespace filtering.
+	Total int `json:"total"`
+	// Offset is the index of the first returned pod.
+	Offset int `json:"offset"`
+	// Returned is the number of pods in the result.
+	Returned int `json:"returned"`
+	// Truncated indicates whether pods were omitted due to the limit or max bytes cap.
+	Truncated bool `json:"truncated"`
+	// ContinueOffset is the offset to fetch the next page with if the result is truncated.
+	ContinueOffset int `json:"continueOffset,omitempty"`
+}
+
 // podListCollector manages information of all pods on the node.
 type podListCollector struct {
 	// Context carries values across API boundaries.
@@ -75,21 +107,94 @@ func (pc *podListCollector) Handler(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case "POST":
+		contexts, err := utils.ExtractParametersFromHTTPContext(r)
+		if err != nil {
+			pc.Error(err, "extract contexts failed")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		offset, err := parseNonNegativeInt(contexts, ParameterKeyPodListOffset, 0)
+		if err != nil {
+			pc.Error(err, "invalid offset field")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := parseNonNegativeInt(contexts, ParameterKeyPodListLimit, defaultPodListLimit)
+		if err != nil {
+			pc.Error(err, "invalid limit field")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		maxBytes, err := parseNonNegativeInt(contexts, ParameterKeyPodListMaxBytes, defaultPodListMaxBytes)
+		if err != nil {
+			pc.Error(err, "invalid max bytes field")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fields, err := parsePodFields(contexts[ParameterKeyPodListFields])
+		if err != nil {
+			pc.Error(err, "invalid fields field")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// List all pods on the node.
-		pods, err := pc.listPods()
+		pods, err := pc.listPods(contexts[ParameterKeyPodListNamespace])
 		if err != nil {
 			http.Error(w, fmt.Sprintf("failed to list pods: %v", err), http.StatusInternalServerError)
 			return
 		}
 
-		raw, err := json.Marshal(pods)
+		summary := podListSummary{
+			Total:  len(pods),
+			Offset: offset,
+		}
+		if offset > len(pods) {
+			offset = len(pods)
+		}
+		pods = pods[offset:]
+		if len(pods) > limit {
+			pods = pods[:limit]
+			summary.Truncated = true
+		}
+
+		// Marshal pods one by one and stop once the result would exceed the max bytes cap. The
+		// extra bytes per pod account for the json array delimiters.
+		items := make([]json.RawMessage, 0, len(pods))
+		size := 2
+		for _, pod := range pods {
+			raw, err := marshalPodFields(pod, fields)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to marshal pod: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if size+len(raw)+1 > maxBytes {
+				summary.Truncated = true
+				break
+			}
+			size += len(raw) + 1
+			items = append(items, raw)
+		}
+		summary.Returned = len(items)
+		if summary.Truncated {
+			summary.ContinueOffset = summary.Offset + summary.Returned
+		}
+
+		raw, err := json.Marshal(items)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("failed to marshal pods: %v", err), http.StatusInternalServerError)
 			return
 		}
+		rawSummary, err := json.Marshal(summary)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to marshal summary: %v", err), http.StatusInternalServerError)
+			return
+		}
 
 		result := make(map[string]string)
 		result[ContextKeyPodList] = string(raw)
+		result[ContextKeyPodListSummary] = string(rawSummary)
 		data, err := json.Marshal(result)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("failed to marshal result: %v", err), http.StatusInternalServerError)
@@ -103,16 +208,93 @@ func (pc *podListCollector) Handler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// listPods lists Pods from cache.
-func (pc *podListCollector) listPods() ([]corev1.Pod, error) {
+// listPods lists Pods from cache. Pods are sorted by namespace and name to keep pagination stable
+// across requests.
+func (pc *podListCollector) listPods(namespace string) ([]corev1.Pod, error) {
 	pc.Info("listing Pods on node")
 
 	var podList corev1.PodList
-	if err := pc.cache.List(pc, &podList); err != nil {
+	if err := pc.cache.List(pc, &podList, client.MatchingFields{util.NodeNameIndexField: pc.nodeName}); err != nil {
 		return nil, err
 	}
 
-	podsOnNode := util.RetrievePodsOnNode(podList.Items, pc.nodeName)
+	podsOnNode := podList.Items
+	if namespace != "" {
+		filtered := make([]corev1.Pod, 0, len(podsOnNode))
+		for _, pod := range podsOnNode {
+			if pod.Namespace == namespace {
+				filtered = append(filtered, pod)
+			}
+		}
+		podsOnNode = filtered
+	}
+
+	sort.Slice(podsOnNode, func(i, j int) bool {
+		if podsOnNode[i].Namespace != podsOnNode[j].Namespace {
+			return podsOnNode[i].Namespace < podsOnNode[j].Namespace
+		}
+		return podsOnNode[i].Name < podsOnNode[j].Name
+	})
 
 	return podsOnNode, nil
 }
+
+// parseNonNegativeInt parses an optional non negative integer parameter from contexts.
+func parseNonNegativeInt(contexts map[string]string, key string, defaultValue int) (int, error) {
+	value, ok := contexts[key]
+	if !ok || value == "" {
+		return defaultValue, nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if parsed < 0 {
+		return 0, fmt.Errorf("parameter %s must not be negative", key)
+	}
+
+	return parsed, nil
+}
+
+// parsePodFields parses the comma separated fields parameter. Valid fields are metadata, spec and
+// status. An empty parameter selects all fields.
+func parsePodFields(value string) (map[string]bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	fields := make(map[string]bool)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		switch field {
+		case "metadata", "spec", "status":
+			fields[field] = true
+		default:
+			return nil, fmt.Errorf("invalid pod field %q, must be one of metadata, spec and status", field)
+		}
+	}
+
+	return fields, nil
+}
+
+// marshalPodFields marshals the pod with only selected fields. A nil field set marshals the
+// complete pod.
+func marshalPodFields(pod corev1.Pod, fields map[string]bool) ([]byte, error) {
+	if fields == nil {
+		return json.Marshal(pod)
+	}
+
+	trimmed := make(map[string]interface{})
+	if fields["metadata"] {
+		trimmed["metadata"] = pod.ObjectMeta
+	}
+	if fields["spec"] {
+		trimmed["spec"] = pod.Spec
+	}
+	if fields["status"] {
+		trimmed["status"] = pod.Status
+	}
+
+	return json.Marshal(trimmed)
+}
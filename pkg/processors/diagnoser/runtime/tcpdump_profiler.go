@@ -21,6 +21,7 @@ import (
 	crtclient "sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/kubediag/kubediag/pkg/processors"
+	"github.com/kubediag/kubediag/pkg/safeexec"
 )
 
 type TcpdumpProfilerType string
@@ -235,11 +236,19 @@ func (t *tcpdumpProfiler) Handler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// buildTcpdumpCommand builds tcpdump command with parameters in tcpDumpProfilerConfig.
+// buildTcpdumpCommand builds tcpdump command with parameters in tcpDumpProfilerConfig. The
+// interface and filter are derived from user controlled parameters and are validated before
+// being used as command arguments.
 func buildTcpdumpCommand(config *tcpdumpProfilerConfig) ([]string, error) {
 	if config.Interface == "" {
 		config.Interface = "any"
 	}
+	if err := safeexec.ValidateName(config.Interface); err != nil {
+		return nil, fmt.Errorf("invalid tcpdump interface: %v", err)
+	}
+	if err := safeexec.ValidateFilter(config.Filter); err != nil {
+		return nil, fmt.Errorf("invalid tcpdump filter: %v", err)
+	}
 	tcpdumpConfig := []string{"tcpdump", "-i", config.Interface, config.Filter}
 
 	return tcpdumpConfig, nil
@@ -288,7 +297,11 @@ func (t *tcpdumpProfiler) buildTcpdumpHTTPServer(config *tcpdumpProfilerConfig,
 	nsenterCommand := []string{"nsenter", "-t", pid, "-n"}
 	exec.Command(nsenterCommand[0], nsenterCommand[1:]...).Run()
 
-	tcpdumpCommand, _ := buildTcpdumpCommand(config)
+	tcpdumpCommand, err := buildTcpdumpCommand(config)
+	if err != nil {
+		t.Error(err, "build tcpdump command failed")
+		return nil, err
+	}
 	wsServer := buildWebsocketHandler(tcpdumpCommand[0], tcpdumpCommand[1:])
 
 	serveMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -28,6 +28,7 @@ import (
 	"github.com/kubediag/kubediag/pkg/processors"
 	"github.com/kubediag/kubediag/pkg/processors/diagnoser/kubernetes"
 	"github.com/kubediag/kubediag/pkg/processors/utils"
+	"github.com/kubediag/kubediag/pkg/safeexec"
 )
 
 const (
@@ -80,6 +81,14 @@ func (srr *subPathRemountRecover) Handler(w http.ResponseWriter, r *http.Request
 			return
 		}
 
+		// The target path is derived from user controlled parameters, so validate it before
+		// passing it to the umount command.
+		if err := safeexec.ValidatePath(target); err != nil {
+			srr.Error(err, "invalid umount target", "target", target)
+			http.Error(w, fmt.Sprintf("invalid umount target: %v", err), http.StatusBadRequest)
+			return
+		}
+
 		srr.Info("will umount subpath", "target", target)
 		err = unmountInHost(target)
 		if err != nil {
@@ -9,6 +9,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
+	"github.com/kubediag/kubediag/pkg/containerruntime"
 	"github.com/kubediag/kubediag/pkg/features"
 	kubecollector "github.com/kubediag/kubediag/pkg/processors/collector/kubernetes"
 	logcollector "github.com/kubediag/kubediag/pkg/processors/collector/log"
@@ -18,14 +19,16 @@ import (
 	kubediagnoser "github.com/kubediag/kubediag/pkg/processors/diagnoser/kubernetes"
 	runtimediagnoser "github.com/kubediag/kubediag/pkg/processors/diagnoser/runtime"
 	kuberecover "github.com/kubediag/kubediag/pkg/processors/recover/kubernetes"
+	verifier "github.com/kubediag/kubediag/pkg/processors/verifier"
 )
 
 // RegistryOption contains options of all kinds of Processors, it might be append in the future.
 type RegistryOption struct {
 	// NodeName specifies the node name.
 	NodeName string
-	// DockerEndpoint specifies the docker endpoint.
-	DockerEndpoint string
+	// Runtime is the container runtime detected on the node. Processors speaking the docker api
+	// are disabled if the detected runtime is not docker.
+	Runtime containerruntime.Runtime
 	// DataRoot is root directory of persistent kubediag data.
 	DataRoot string
 	// BindAddress is the address on which to advertise.
@@ -38,6 +41,8 @@ func RegisterProcessors(mgr manager.Manager,
 	featureGate features.KubeDiagFeatureGate,
 	router *mux.Router,
 	setupLog logr.Logger) error {
+	dockerAvailable := opts.Runtime.Name == containerruntime.Docker
+
 	// Setup operation processors.
 	podListCollector := kubecollector.NewPodListCollector(
 		context.Background(),
@@ -56,8 +61,8 @@ func RegisterProcessors(mgr manager.Manager,
 	containerCollector, err := kubecollector.NewContainerCollector(
 		context.Background(),
 		ctrl.Log.WithName("processor/containerCollector"),
-		opts.DockerEndpoint,
-		featureGate.Enabled(features.ContainerCollector),
+		opts.Runtime.Endpoint,
+		featureGate.Enabled(features.ContainerCollector) && dockerAvailable,
 	)
 	if err != nil {
 		setupLog.Error(err, "unable to create processor", "processors", "containerCollector")
@@ -71,8 +76,8 @@ func RegisterProcessors(mgr manager.Manager,
 	dockerInfoCollector, err := kubecollector.NewDockerInfoCollector(
 		context.Background(),
 		ctrl.Log.WithName("processor/dockerInfoCollector"),
-		opts.DockerEndpoint,
-		featureGate.Enabled(features.DockerInfoCollector),
+		opts.Runtime.Endpoint,
+		featureGate.Enabled(features.DockerInfoCollector) && dockerAvailable,
 	)
 	if err != nil {
 		setupLog.Error(err, "unable to create processor", "processors", "dockerInfoCollector")
@@ -82,7 +87,7 @@ func RegisterProcessors(mgr manager.Manager,
 		context.Background(),
 		ctrl.Log.WithName("processor/dockerdGoroutineCollector"),
 		opts.DataRoot,
-		featureGate.Enabled(features.DockerdGoroutineCollector),
+		featureGate.Enabled(features.DockerdGoroutineCollector) && dockerAvailable,
 	)
 	containerdGoroutineCollector := runtimecollector.NewContainerdGoroutineCollector(
 		context.Background(),
@@ -114,8 +119,8 @@ func RegisterProcessors(mgr manager.Manager,
 	coreFileProfiler, err := runtimediagnoser.NewCoreFileProfiler(
 		context.Background(),
 		ctrl.Log.WithName("processor/coreFileProfiler"),
-		opts.DockerEndpoint,
-		featureGate.Enabled(features.CoreFileProfiler),
+		opts.Runtime.Endpoint,
+		featureGate.Enabled(features.CoreFileProfiler) && dockerAvailable,
 		opts.DataRoot)
 	if err != nil {
 		setupLog.Error(err, "unable to create processor", "processors", "coreFileProfiler")
@@ -124,7 +129,7 @@ func RegisterProcessors(mgr manager.Manager,
 	tcpdumpProfiler, err := runtimediagnoser.NewTcpdumpProfiler(
 		context.Background(),
 		ctrl.Log.WithName("processor/tcpdumpProfiler"),
-		opts.DockerEndpoint,
+		opts.Runtime.Endpoint,
 		mgr.GetCache(),
 		opts.DataRoot,
 		featureGate.Enabled(features.TcpdumpProfiler),
@@ -165,6 +170,12 @@ func RegisterProcessors(mgr manager.Manager,
 		mgr.GetCache(),
 		featureGate.Enabled(features.StatefulSetDetailCollector),
 	)
+	symptomVerifier := verifier.NewSymptomVerifier(
+		context.Background(),
+		ctrl.Log.WithName("processor/symptomVerifier"),
+		mgr.GetCache(),
+		featureGate.Enabled(features.SymptomVerifier),
+	)
 	statefulsetStuck := kuberecover.NewStatefuSetStuck(
 		context.Background(),
 		ctrl.Log.WithName("/processor/statefulsetStuck"),
@@ -196,5 +207,8 @@ func RegisterProcessors(mgr manager.Manager,
 	router.HandleFunc("/processor/subpathRemountRecover", subpathRemountRecover.Handler)
 	router.HandleFunc("/processor/statefulsetStuck", statefulsetStuck.Handler)
 	router.HandleFunc("/processor/sonobuoyResultDiagnoser", sonobuoyResultDiagnoser.Handler)
+
+	// Handlers for verifying resolved symptoms.
+	router.HandleFunc("/processor/symptomVerifier", symptomVerifier.Handler)
 	return nil
 }
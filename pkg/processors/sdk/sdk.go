@@ -0,0 +1,146 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sdk contains helpers for writing conformant operation processors. External teams can use
+// the package to parse the operation context, report structured results or stream artifacts, honor
+// timeouts and expose health endpoints without copying kubediag internal code.
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kubediag/kubediag/pkg/executor"
+)
+
+// Handle is the entry point of an operation processor implemented by a processor author. It takes
+// the request data sent by kubediag agent and returns the result to be recorded in task status.
+type Handle func(ctx context.Context, data map[string]string) (map[string]string, error)
+
+// ParseRequest decodes the request data sent by kubediag agent from an http request.
+func ParseRequest(r *http.Request) (map[string]string, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %v", err)
+	}
+
+	data := make(map[string]string)
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal request body: %v", err)
+	}
+
+	return data, nil
+}
+
+// ParseContextEnvelope decodes the versioned context envelope from request data. The envelope
+// contains the task, the owning diagnosis with all prior operation results, and snapshots of the
+// target pod and node.
+func ParseContextEnvelope(data map[string]string) (*executor.ContextEnvelope, error) {
+	raw, ok := data[executor.ContextEnvelopeTelemetryKey]
+	if !ok {
+		return nil, fmt.Errorf("context envelope not found in request data")
+	}
+
+	var envelope executor.ContextEnvelope
+	err := json.Unmarshal([]byte(raw), &envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal context envelope: %v", err)
+	}
+
+	return &envelope, nil
+}
+
+// WriteResult encodes the result as a json object and writes it to the response. The result is
+// recorded in task status by kubediag agent.
+func WriteResult(w http.ResponseWriter, result map[string]string) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	return err
+}
+
+// StreamArtifact declares a streaming response and copies the reader to the response body. The
+// kubediag agent writes the body to artifact storage instead of buffering it in memory, so the
+// output is not limited by max data size.
+func StreamArtifact(w http.ResponseWriter, reader io.Reader) (int64, error) {
+	w.Header().Set(executor.StreamingResponseHeader, "true")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	return io.Copy(w, reader)
+}
+
+// ContextWithTimeout derives a context which is canceled after the timeout declared in request
+// data, or after defaultTimeoutSeconds if no timeout is declared. A processor should stop its work
+// once the context is done since the agent has already given up on the request.
+func ContextWithTimeout(ctx context.Context, data map[string]string, timeoutSecondsKey string, defaultTimeoutSeconds int32) (context.Context, context.CancelFunc) {
+	timeoutSeconds := defaultTimeoutSeconds
+	if value, ok := data[timeoutSecondsKey]; ok {
+		if parsed, err := strconv.ParseInt(value, 10, 32); err == nil && parsed > 0 {
+			timeoutSeconds = int32(parsed)
+		}
+	}
+
+	return context.WithTimeout(ctx, time.Duration(timeoutSeconds)*time.Second)
+}
+
+// HealthCheckHandler handles health check requests of a processor.
+func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Write([]byte("OK"))
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// NewHandler wraps a Handle into an http handler which enforces the processor contract. Only POST
+// requests are accepted, request data is decoded from the body and the result is encoded as a json
+// object with a response code of 200 on success.
+func NewHandler(handle Handle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			data, err := ParseRequest(r)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to parse request: %v", err), http.StatusBadRequest)
+				return
+			}
+
+			result, err := handle(r.Context(), data)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to process request: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			if err := WriteResult(w, result); err != nil {
+				http.Error(w, fmt.Sprintf("failed to write result: %v", err), http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+		}
+	}
+}
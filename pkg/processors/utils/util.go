@@ -27,7 +27,27 @@ import (
 	"github.com/kubediag/kubediag/pkg/executor"
 )
 
+// DecodeContextEnvelope unmarshals the versioned context envelope from request data. The envelope
+// contains the task, the owning diagnosis with all prior operation results, and snapshots of the
+// target pod and node.
+func DecodeContextEnvelope(data map[string]string) (*executor.ContextEnvelope, error) {
+	raw, ok := data[executor.ContextEnvelopeTelemetryKey]
+	if !ok {
+		return nil, fmt.Errorf("context envelope not found in request data")
+	}
+
+	var envelope executor.ContextEnvelope
+	err := json.Unmarshal([]byte(raw), &envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal context envelope: %v", err)
+	}
+
+	return &envelope, nil
+}
+
 // DecodeOperationContext unmarshals json encoding into a map[string][]byte, which is the format of operation context.
+//
+// Deprecated: processors should use DecodeContextEnvelope to retrieve the versioned operation context.
 func DecodeOperationContext(body []byte) (map[string][]byte, error) {
 	data := make(map[string][]byte)
 	err := json.Unmarshal(body, &data)
@@ -0,0 +1,221 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kubediag/kubediag/pkg/executor"
+	"github.com/kubediag/kubediag/pkg/processors"
+	"github.com/kubediag/kubediag/pkg/processors/utils"
+)
+
+const (
+	// ParameterKeySymptomVerifierProbeEndpoint is the http endpoint which must answer with a
+	// 2xx status code for the symptom to be considered resolved.
+	ParameterKeySymptomVerifierProbeEndpoint = "param.verifier.symptom_verifier.probe.endpoint"
+	// ParameterKeySymptomVerifierAlertmanagerEndpoint is the alertmanager endpoint queried to
+	// verify that the alert has been cleared.
+	ParameterKeySymptomVerifierAlertmanagerEndpoint = "param.verifier.symptom_verifier.alertmanager.endpoint"
+	// ParameterKeySymptomVerifierAlertName is the name of the alert which must not be firing
+	// anymore for the symptom to be considered resolved.
+	ParameterKeySymptomVerifierAlertName = "param.verifier.symptom_verifier.alert.name"
+
+	ContextKeySymptomVerifierResult = "verifier.symptom_verifier.result"
+
+	// symptomVerifierTimeout is the timeout of probe and alertmanager requests.
+	symptomVerifierTimeout = 30 * time.Second
+)
+
+// symptomVerifier re-checks the original symptom of a diagnosis after recovery operations have
+// run. It probes an http endpoint, checks the readiness of the target pod and queries the
+// alertmanager api for the originating alert, and fails if any configured check finds the
+// symptom unresolved, so the diagnosis falls back to an alternative path in the graph.
+type symptomVerifier struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// cache knows how to load Kubernetes objects.
+	cache cache.Cache
+	// symptomVerifierEnabled indicates whether symptomVerifier is enabled.
+	symptomVerifierEnabled bool
+}
+
+// NewSymptomVerifier creates a new symptomVerifier.
+func NewSymptomVerifier(
+	ctx context.Context,
+	logger logr.Logger,
+	cache cache.Cache,
+	symptomVerifierEnabled bool,
+) processors.Processor {
+	return &symptomVerifier{
+		Context:                ctx,
+		Logger:                 logger,
+		cache:                  cache,
+		symptomVerifierEnabled: symptomVerifierEnabled,
+	}
+}
+
+// Handler handles http requests for verifying that the original symptom has been resolved.
+func (sv *symptomVerifier) Handler(w http.ResponseWriter, r *http.Request) {
+	if !sv.symptomVerifierEnabled {
+		http.Error(w, fmt.Sprintf("symptom verification is not enabled"), http.StatusUnprocessableEntity)
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		contexts, err := utils.ExtractParametersFromHTTPContext(r)
+		if err != nil {
+			sv.Error(err, "extract contexts failed")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		checked := 0
+		if endpoint := contexts[ParameterKeySymptomVerifierProbeEndpoint]; endpoint != "" {
+			checked++
+			if err := sv.verifyProbe(endpoint); err != nil {
+				sv.Info("probe found the symptom unresolved", "endpoint", endpoint, "reason", err)
+				http.Error(w, fmt.Sprintf("probe of %s failed: %v", endpoint, err), http.StatusInternalServerError)
+				return
+			}
+		}
+		if contexts[executor.PodNamespaceTelemetryKey] != "" && contexts[executor.PodNameTelemetryKey] != "" {
+			checked++
+			if err := sv.verifyPodReady(contexts[executor.PodNamespaceTelemetryKey], contexts[executor.PodNameTelemetryKey]); err != nil {
+				sv.Info("target pod is not ready", "pod", contexts[executor.PodNamespaceTelemetryKey]+"/"+contexts[executor.PodNameTelemetryKey], "reason", err)
+				http.Error(w, fmt.Sprintf("pod %s/%s is not ready: %v", contexts[executor.PodNamespaceTelemetryKey], contexts[executor.PodNameTelemetryKey], err), http.StatusInternalServerError)
+				return
+			}
+		}
+		if endpoint := contexts[ParameterKeySymptomVerifierAlertmanagerEndpoint]; endpoint != "" {
+			checked++
+			alertName := contexts[ParameterKeySymptomVerifierAlertName]
+			if alertName == "" {
+				http.Error(w, fmt.Sprintf("parameter %s must be specified to verify the alert is cleared", ParameterKeySymptomVerifierAlertName), http.StatusUnprocessableEntity)
+				return
+			}
+			if err := sv.verifyAlertCleared(endpoint, alertName); err != nil {
+				sv.Info("alert is not cleared", "endpoint", endpoint, "alert", alertName, "reason", err)
+				http.Error(w, fmt.Sprintf("alert %s is not cleared: %v", alertName, err), http.StatusInternalServerError)
+				return
+			}
+		}
+		if checked == 0 {
+			http.Error(w, "no verification check is configured", http.StatusUnprocessableEntity)
+			return
+		}
+
+		result := make(map[string]string)
+		result[ContextKeySymptomVerifierResult] = fmt.Sprintf("%d checks passed", checked)
+		data, err := json.Marshal(result)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to marshal result: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// verifyProbe sends a http request to the probe endpoint and returns an error if the endpoint
+// does not answer with a 2xx status code.
+func (sv *symptomVerifier) verifyProbe(endpoint string) error {
+	httpClient := &http.Client{
+		Timeout: symptomVerifierTimeout,
+	}
+	response, err := httpClient.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status code %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+// verifyPodReady returns an error if the pod is not running with a true Ready condition.
+func (sv *symptomVerifier) verifyPodReady(namespace string, name string) error {
+	var pod corev1.Pod
+	if err := sv.cache.Get(sv, client.ObjectKey{
+		Namespace: namespace,
+		Name:      name,
+	}, &pod); err != nil {
+		return err
+	}
+
+	if pod.Status.Phase != corev1.PodRunning {
+		return fmt.Errorf("pod is in phase %s", pod.Status.Phase)
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			if condition.Status == corev1.ConditionTrue {
+				return nil
+			}
+			return fmt.Errorf("ready condition is %s: %s", condition.Status, condition.Message)
+		}
+	}
+
+	return fmt.Errorf("pod has no ready condition")
+}
+
+// verifyAlertCleared queries the alertmanager api for active alerts with the alert name and
+// returns an error if any alert is still firing.
+func (sv *symptomVerifier) verifyAlertCleared(endpoint string, alertName string) error {
+	filter := url.QueryEscape(fmt.Sprintf("alertname=%q", alertName))
+	httpClient := &http.Client{
+		Timeout: symptomVerifierTimeout,
+	}
+	response, err := httpClient.Get(fmt.Sprintf("%s/api/v2/alerts?active=true&filter=%s", strings.TrimSuffix(endpoint, "/"), filter))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d from alertmanager", response.StatusCode)
+	}
+	var alerts []interface{}
+	if err := json.NewDecoder(response.Body).Decode(&alerts); err != nil {
+		return fmt.Errorf("failed to decode alertmanager response: %v", err)
+	}
+	if len(alerts) != 0 {
+		return fmt.Errorf("%d alerts named %s are still firing", len(alerts), alertName)
+	}
+
+	return nil
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package profilerproxy serves profiler result pages of agents through an authenticated reverse
+// proxy on the kubediag master. Profiler endpoints such as pprof servers listen on arbitrary
+// agent ports which anyone on the node network can browse, so the proxy issues expiring per
+// diagnosis session urls instead of exposing the raw endpoints.
+package profilerproxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+)
+
+const (
+	// PathPrefix is the path prefix proxied profiler sessions are served under.
+	PathPrefix = "/profilerproxy/"
+
+	// resultEndpointKeySuffix is the suffix of operation result keys holding a profiler
+	// endpoint, such as diagnoser.runtime.go_profiler.result.endpoint.
+	resultEndpointKeySuffix = ".result.endpoint"
+	// sessionTokenBytes is the number of random bytes in a session token.
+	sessionTokenBytes = 16
+)
+
+var (
+	profilerProxySessionCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "profiler_proxy_session_count",
+			Help: "Counter of created profiler proxy sessions.",
+		},
+	)
+	profilerProxyDeniedCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "profiler_proxy_denied_count",
+			Help: "Counter of denied profiler proxy requests.",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		profilerProxySessionCount,
+		profilerProxyDeniedCount,
+	)
+}
+
+// Session is a proxied profiler session returned on session creation.
+type Session struct {
+	// Operation is the operation result key the profiler endpoint was recorded under.
+	Operation string `json:"operation"`
+	// Path is the path on the master the profiler is served under.
+	Path string `json:"path"`
+	// ExpirationTime is the time at which the session expires.
+	ExpirationTime time.Time `json:"expirationTime"`
+}
+
+// session holds the proxy target of an issued session token.
+type session struct {
+	// target is the profiler endpoint requests are proxied to.
+	target *url.URL
+	// expirationTime is the time at which the session expires.
+	expirationTime time.Time
+}
+
+// ProfilerProxy issues expiring session urls for profiler endpoints recorded in diagnosis
+// results and reverse proxies requests on those urls to the agents.
+type ProfilerProxy struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// cache knows how to load Kubernetes objects.
+	cache cache.Cache
+	// sharedSecret is the bearer token required to create a session.
+	sharedSecret string
+	// sessionTTL is the duration after which a session expires.
+	sessionTTL time.Duration
+	// lock guards writes to sessions.
+	lock sync.Mutex
+	// sessions contains active sessions keyed by token.
+	sessions map[string]session
+}
+
+// NewProfilerProxy creates a new ProfilerProxy. The shared secret file holds the bearer token
+// required to create sessions.
+func NewProfilerProxy(
+	ctx context.Context,
+	logger logr.Logger,
+	cache cache.Cache,
+	sharedSecretFile string,
+	sessionTTL time.Duration,
+) (*ProfilerProxy, error) {
+	data, err := ioutil.ReadFile(sharedSecretFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read shared secret file %s: %v", sharedSecretFile, err)
+	}
+	sharedSecret := strings.TrimSpace(string(data))
+	if sharedSecret == "" {
+		return nil, fmt.Errorf("shared secret file %s is empty", sharedSecretFile)
+	}
+
+	return &ProfilerProxy{
+		Context:      ctx,
+		Logger:       logger,
+		cache:        cache,
+		sharedSecret: sharedSecret,
+		sessionTTL:   sessionTTL,
+		sessions:     make(map[string]session),
+	}, nil
+}
+
+// CreateSessionHandler handles http requests for creating proxied profiler sessions. A POST
+// request authorized with the shared secret creates a session for every profiler endpoint
+// recorded in the results of the diagnosis and returns the session urls.
+func (pp *ProfilerProxy) CreateSessionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		if err := pp.authorize(r); err != nil {
+			profilerProxyDeniedCount.Inc()
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		vars := mux.Vars(r)
+		var diagnosis diagnosisv1.Diagnosis
+		if err := pp.cache.Get(pp, client.ObjectKey{
+			Namespace: vars["namespace"],
+			Name:      vars["name"],
+		}, &diagnosis); err != nil {
+			http.Error(w, fmt.Sprintf("unable to fetch diagnosis: %v", err), http.StatusNotFound)
+			return
+		}
+
+		sessions := make([]Session, 0)
+		for operation, endpoint := range profilerEndpoints(&diagnosis) {
+			target, err := url.Parse(endpoint)
+			if err != nil {
+				pp.Error(err, "invalid profiler endpoint", "operation", operation, "endpoint", endpoint)
+				continue
+			}
+			token, err := sessionToken()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("unable to create session token: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			expirationTime := time.Now().Add(pp.sessionTTL)
+			pp.lock.Lock()
+			pp.sessions[token] = session{
+				target:         target,
+				expirationTime: expirationTime,
+			}
+			pp.lock.Unlock()
+			profilerProxySessionCount.Inc()
+
+			sessions = append(sessions, Session{
+				Operation:      operation,
+				Path:           PathPrefix + token + "/",
+				ExpirationTime: expirationTime,
+			})
+		}
+		if len(sessions) == 0 {
+			http.Error(w, fmt.Sprintf("diagnosis %s/%s has no profiler endpoints", vars["namespace"], vars["name"]), http.StatusNotFound)
+			return
+		}
+
+		data, err := json.Marshal(sessions)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to marshal sessions: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(data)
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// ProxyHandler reverse proxies requests on a session url to the profiler endpoint of the
+// session. Expired sessions are removed and answered with 410 Gone.
+func (pp *ProfilerProxy) ProxyHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	pp.lock.Lock()
+	pp.purgeExpired()
+	active, ok := pp.sessions[token]
+	pp.lock.Unlock()
+	if !ok {
+		profilerProxyDeniedCount.Inc()
+		http.Error(w, "profiler session is expired or does not exist", http.StatusGone)
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(active.target)
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, PathPrefix+token)
+	proxy.ServeHTTP(w, r)
+}
+
+// authorize validates the bearer token of the request against the shared secret.
+func (pp *ProfilerProxy) authorize(r *http.Request) error {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return fmt.Errorf("an authorization header is required")
+	}
+	if !strings.HasPrefix(header, "Bearer ") {
+		return fmt.Errorf("the authorization header must contain a bearer token")
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(pp.sharedSecret)) != 1 {
+		return fmt.Errorf("invalid shared secret")
+	}
+
+	return nil
+}
+
+// purgeExpired removes expired sessions. The caller must hold the lock.
+func (pp *ProfilerProxy) purgeExpired() {
+	now := time.Now()
+	for token, active := range pp.sessions {
+		if now.After(active.expirationTime) {
+			delete(pp.sessions, token)
+		}
+	}
+}
+
+// profilerEndpoints returns profiler endpoints recorded in the results of the diagnosis keyed
+// by operation result key.
+func profilerEndpoints(diagnosis *diagnosisv1.Diagnosis) map[string]string {
+	endpoints := make(map[string]string)
+	if diagnosis.Status.Context == nil {
+		return endpoints
+	}
+	for _, operationContext := range diagnosis.Status.Context.Operations {
+		for _, taskContext := range operationContext {
+			for key, value := range taskContext {
+				if strings.HasSuffix(strings.ToLower(key), resultEndpointKeySuffix) {
+					endpoints[key] = value
+				}
+			}
+		}
+	}
+
+	return endpoints
+}
+
+// sessionToken returns a random session token.
+func sessionToken() (string, error) {
+	buffer := make([]byte, sessionTokenBytes)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buffer), nil
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package progress relays progress reports of long running operation processors into diagnosis
+// statuses. A processor posts percentage and phase messages to the callback url it received in
+// the request data, so users watching a long capture or analysis run can see it is alive.
+package progress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+var (
+	progressReportSuccessCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "progress_report_success_count",
+			Help: "Counter of progress reports relayed into diagnosis statuses.",
+		},
+	)
+	progressReportErrorCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "progress_report_error_count",
+			Help: "Counter of progress reports which could not be relayed.",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		progressReportSuccessCount,
+		progressReportErrorCount,
+	)
+}
+
+// Report is the progress report sent by an operation processor.
+type Report struct {
+	// Percentage is the completion percentage of the operation between 0 and 100.
+	Percentage int32 `json:"percentage"`
+	// Message is a human readable description of the current phase of the operation.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// ProgressManager relays progress reports of operation processors into the status of the
+// diagnosis the reporting task belongs to.
+type ProgressManager struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// client knows how to perform CRUD operations on Kubernetes objects.
+	client client.Client
+}
+
+// NewProgressManager creates a new ProgressManager.
+func NewProgressManager(
+	ctx context.Context,
+	logger logr.Logger,
+	cli client.Client,
+) *ProgressManager {
+	return &ProgressManager{
+		Context: ctx,
+		Logger:  logger,
+		client:  cli,
+	}
+}
+
+// Handler handles http requests for reporting operation progress. A POST request on the
+// callback url of a task updates the progress of the operation the task runs in the status of
+// the diagnosis the task belongs to.
+func (pm *ProgressManager) Handler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		var report Report
+		if err := json.Unmarshal(body, &report); err != nil {
+			http.Error(w, fmt.Sprintf("failed to unmarshal request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if report.Percentage < 0 || report.Percentage > 100 {
+			http.Error(w, fmt.Sprintf("percentage %d is not between 0 and 100", report.Percentage), http.StatusUnprocessableEntity)
+			return
+		}
+
+		vars := mux.Vars(r)
+		var task diagnosisv1.Task
+		if err := pm.client.Get(pm, client.ObjectKey{
+			Namespace: vars["namespace"],
+			Name:      vars["name"],
+		}, &task); err != nil {
+			http.Error(w, fmt.Sprintf("unable to fetch task: %v", err), http.StatusNotFound)
+			return
+		}
+
+		if err := pm.relay(task, report); err != nil {
+			progressReportErrorCount.Inc()
+			pm.Error(err, "unable to relay progress report", "task", client.ObjectKey{
+				Name:      task.Name,
+				Namespace: task.Namespace,
+			})
+			http.Error(w, fmt.Sprintf("unable to relay progress report: %v", err), http.StatusInternalServerError)
+			return
+		}
+		progressReportSuccessCount.Inc()
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// relay updates the progress of the operation the task runs in the status of the diagnosis
+// the task belongs to. Reports of tasks which do not belong to a diagnosis are discarded.
+func (pm *ProgressManager) relay(task diagnosisv1.Task, report Report) error {
+	namespace := task.Labels["diagnosis-namespace"]
+	name := task.Labels["diagnosis-name"]
+	if namespace == "" || name == "" {
+		return fmt.Errorf("task %s/%s does not belong to a diagnosis", task.Namespace, task.Name)
+	}
+
+	var diagnosis diagnosisv1.Diagnosis
+	if err := pm.client.Get(pm, client.ObjectKey{
+		Namespace: namespace,
+		Name:      name,
+	}, &diagnosis); err != nil {
+		return fmt.Errorf("unable to fetch diagnosis: %v", err)
+	}
+
+	if diagnosis.Status.Progress == nil {
+		diagnosis.Status.Progress = make(map[string]*diagnosisv1.OperationProgress)
+	}
+	diagnosis.Status.Progress[task.Spec.Operation] = &diagnosisv1.OperationProgress{
+		Percentage: report.Percentage,
+		Message:    report.Message,
+		UpdateTime: v1.Now(),
+	}
+
+	return util.PatchDiagnosisStatus(pm, pm.client, &diagnosis)
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// baseRetryDelay is the delay before the first retry of a failed item. The delay is doubled on
+// every subsequent retry up to maxRetryDelay.
+const baseRetryDelay = 1 * time.Second
+
+// maxRetryDelay is the upper bound of the retry delay of a failed item.
+const maxRetryDelay = 5 * time.Minute
+
+// PriorityQueue is a work queue of object keys in namespace/name format ordered by priority.
+// Keys with a higher priority are handed to workers before keys with a lower priority, and keys
+// of equal priority are processed in insertion order. Pending keys are deduplicated, and keys
+// are retried with exponential backoff on handler errors until maxRetries attempts.
+type PriorityQueue struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// name is the name of the priority queue.
+	name string
+
+	// lock protects all fields below.
+	lock sync.Mutex
+	// cond signals workers waiting for pending items.
+	cond *sync.Cond
+	// items is the heap of pending items ordered by priority.
+	items priorityHeap
+	// pending contains all keys currently in the heap.
+	pending map[string]bool
+	// retries contains the number of failed attempts of keys.
+	retries map[string]int
+	// sequence is a monotonically increasing insertion counter used to order keys of equal
+	// priority.
+	sequence uint64
+	// shuttingDown indicates whether the priority queue is shutting down.
+	shuttingDown bool
+}
+
+// priorityItem is a pending key with its priority and insertion sequence.
+type priorityItem struct {
+	key      string
+	priority int32
+	sequence uint64
+}
+
+// priorityHeap orders items by descending priority and ascending insertion sequence.
+type priorityHeap []*priorityItem
+
+func (ph priorityHeap) Len() int {
+	return len(ph)
+}
+
+func (ph priorityHeap) Less(i, j int) bool {
+	if ph[i].priority != ph[j].priority {
+		return ph[i].priority > ph[j].priority
+	}
+	return ph[i].sequence < ph[j].sequence
+}
+
+func (ph priorityHeap) Swap(i, j int) {
+	ph[i], ph[j] = ph[j], ph[i]
+}
+
+func (ph *priorityHeap) Push(x interface{}) {
+	*ph = append(*ph, x.(*priorityItem))
+}
+
+func (ph *priorityHeap) Pop() interface{} {
+	old := *ph
+	item := old[len(old)-1]
+	*ph = old[:len(old)-1]
+	return item
+}
+
+// NewPriorityQueue creates a new PriorityQueue.
+func NewPriorityQueue(
+	ctx context.Context,
+	logger logr.Logger,
+	name string,
+) *PriorityQueue {
+	pq := &PriorityQueue{
+		Context: ctx,
+		Logger:  logger,
+		name:    name,
+		items:   make(priorityHeap, 0),
+		pending: make(map[string]bool),
+		retries: make(map[string]int),
+	}
+	pq.cond = sync.NewCond(&pq.lock)
+
+	return pq
+}
+
+// Add adds a key with the default priority to the priority queue.
+func (pq *PriorityQueue) Add(key string) {
+	pq.AddWithPriority(key, 0)
+}
+
+// AddWithPriority adds a key with the provided priority to the priority queue. Keys already
+// pending in the queue are deduplicated.
+func (pq *PriorityQueue) AddWithPriority(key string, priority int32) {
+	pq.lock.Lock()
+	defer pq.lock.Unlock()
+
+	if pq.shuttingDown || pq.pending[key] {
+		return
+	}
+
+	pq.sequence++
+	heap.Push(&pq.items, &priorityItem{
+		key:      key,
+		priority: priority,
+		sequence: pq.sequence,
+	})
+	pq.pending[key] = true
+	pq.cond.Signal()
+}
+
+// Len returns the number of keys pending in the priority queue.
+func (pq *PriorityQueue) Len() int {
+	pq.lock.Lock()
+	defer pq.lock.Unlock()
+
+	return len(pq.items)
+}
+
+// Run starts workers which process keys in priority order with the handler until the stop
+// channel is closed. A key is retried with exponential backoff if the handler returns an error,
+// and dropped after maxRetries failed attempts.
+func (pq *PriorityQueue) Run(workers int, handler func(key string) error, stopCh <-chan struct{}) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for pq.processNextItem(handler) {
+			}
+		}()
+	}
+
+	<-stopCh
+
+	pq.lock.Lock()
+	pq.shuttingDown = true
+	pq.cond.Broadcast()
+	pq.lock.Unlock()
+}
+
+// processNextItem processes the pending key with the highest priority. It returns false if the
+// priority queue has been shut down.
+func (pq *PriorityQueue) processNextItem(handler func(key string) error) bool {
+	pq.lock.Lock()
+	for len(pq.items) == 0 && !pq.shuttingDown {
+		pq.cond.Wait()
+	}
+	if pq.shuttingDown {
+		pq.lock.Unlock()
+		return false
+	}
+	item := heap.Pop(&pq.items).(*priorityItem)
+	delete(pq.pending, item.key)
+	pq.lock.Unlock()
+
+	err := handler(item.key)
+
+	pq.lock.Lock()
+	defer pq.lock.Unlock()
+	if err == nil {
+		delete(pq.retries, item.key)
+		return true
+	}
+
+	pq.retries[item.key]++
+	if pq.retries[item.key] < maxRetries {
+		pq.Error(err, "failed to process item, requeuing", "queue", pq.name, "key", item.key)
+		delay := baseRetryDelay << uint(pq.retries[item.key]-1)
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		time.AfterFunc(delay, func() {
+			pq.AddWithPriority(item.key, item.priority)
+		})
+		return true
+	}
+
+	pq.Error(err, "dropping item after retries exhausted", "queue", pq.name, "key", item.key)
+	delete(pq.retries, item.key)
+	return true
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// newTestPriorityQueue creates a priority queue for tests.
+func newTestPriorityQueue() *PriorityQueue {
+	return NewPriorityQueue(context.Background(), ctrl.Log.WithName("priorityqueue"), "test")
+}
+
+// drain processes all pending keys and returns them in processing order.
+func drain(pq *PriorityQueue) []string {
+	var processed []string
+	for pq.Len() > 0 {
+		pq.processNextItem(func(key string) error {
+			processed = append(processed, key)
+			return nil
+		})
+	}
+	return processed
+}
+
+func TestPriorityOrdering(t *testing.T) {
+	tests := []struct {
+		add      map[string]int32
+		expected []string
+		desc     string
+	}{
+		{
+			add: map[string]int32{
+				"default/low":    0,
+				"default/high":   10,
+				"default/medium": 5,
+			},
+			expected: []string{"default/high", "default/medium", "default/low"},
+			desc:     "keys are processed in descending priority order",
+		},
+		{
+			add: map[string]int32{
+				"default/negative": -5,
+				"default/zero":     0,
+			},
+			expected: []string{"default/zero", "default/negative"},
+			desc:     "negative priorities are processed last",
+		},
+	}
+
+	for _, test := range tests {
+		pq := newTestPriorityQueue()
+		// Map iteration order is random, so the ordering cannot come from insertion order
+		// alone for distinct priorities.
+		for key, priority := range test.add {
+			pq.AddWithPriority(key, priority)
+		}
+		assert.Equal(t, test.expected, drain(pq), test.desc)
+	}
+}
+
+func TestEqualPriorityInsertionOrder(t *testing.T) {
+	pq := newTestPriorityQueue()
+	var expected []string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("default/task-%d", i)
+		pq.Add(key)
+		expected = append(expected, key)
+	}
+
+	assert.Equal(t, expected, drain(pq), "keys of equal priority keep insertion order")
+}
+
+func TestPendingKeyDeduplication(t *testing.T) {
+	pq := newTestPriorityQueue()
+	pq.Add("default/task")
+	pq.AddWithPriority("default/task", 10)
+	assert.Equal(t, 1, pq.Len(), "a pending key is not queued twice")
+
+	// A key is eligible for queueing again once it has been handed to a worker.
+	assert.Equal(t, []string{"default/task"}, drain(pq))
+	pq.Add("default/task")
+	assert.Equal(t, 1, pq.Len())
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	pq := newTestPriorityQueue()
+	pq.AddWithPriority("default/task", 7)
+
+	pq.processNextItem(func(key string) error {
+		return fmt.Errorf("transient failure")
+	})
+	assert.Equal(t, 0, pq.Len(), "a failed key is requeued after the retry delay, not immediately")
+	assert.Equal(t, 1, pq.retries["default/task"], "the failed attempt is counted")
+
+	// The key must reappear with its original priority after the base retry delay.
+	err := wait.Poll(50*time.Millisecond, 3*baseRetryDelay, func() (bool, error) {
+		return pq.Len() == 1, nil
+	})
+	assert.NoError(t, err, "the failed key is requeued")
+	pq.lock.Lock()
+	assert.Equal(t, int32(7), pq.items[0].priority, "the requeued key keeps its priority")
+	pq.lock.Unlock()
+
+	pq.processNextItem(func(key string) error {
+		return nil
+	})
+	assert.Equal(t, 0, pq.retries["default/task"], "a successful attempt resets the retry count")
+}
+
+func TestDropAfterRetriesExhausted(t *testing.T) {
+	pq := newTestPriorityQueue()
+	pq.Add("default/task")
+	pq.retries["default/task"] = maxRetries - 1
+
+	pq.processNextItem(func(key string) error {
+		return fmt.Errorf("persistent failure")
+	})
+	assert.Equal(t, 0, pq.Len(), "an exhausted key is not requeued")
+	assert.Equal(t, 0, pq.retries["default/task"], "the retry count of a dropped key is released")
+
+	// The key must not reappear after the retry delay.
+	time.Sleep(baseRetryDelay + 100*time.Millisecond)
+	assert.Equal(t, 0, pq.Len())
+}
+
+func TestShutdown(t *testing.T) {
+	pq := newTestPriorityQueue()
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		pq.Run(1, func(key string) error {
+			return nil
+		}, stopCh)
+		close(done)
+	}()
+
+	close(stopCh)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("priority queue did not shut down")
+	}
+
+	pq.Add("default/task")
+	assert.Equal(t, 0, pq.Len(), "keys are not accepted after shutdown")
+	assert.False(t, pq.processNextItem(func(key string) error {
+		return nil
+	}), "workers exit after shutdown")
+}
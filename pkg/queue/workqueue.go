@@ -0,0 +1,111 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package queue provides rate limited work queues for handing objects between controllers and
+// managers. Unlike the channel based plumbing it replaces, a work queue deduplicates pending
+// items, retries failed items with backoff and does not drop work under load.
+package queue
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// maxRetries is the number of times an item is retried before it is dropped from the queue.
+const maxRetries = 15
+
+// WorkQueue is a rate limited work queue of object keys in namespace/name format. Items are
+// retried with backoff on handler errors and dropped after maxRetries attempts.
+type WorkQueue struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// name is the name of the work queue.
+	name string
+	// queue is the underlying rate limited work queue.
+	queue workqueue.RateLimitingInterface
+}
+
+// NewWorkQueue creates a new WorkQueue.
+func NewWorkQueue(
+	ctx context.Context,
+	logger logr.Logger,
+	name string,
+) *WorkQueue {
+	return &WorkQueue{
+		Context: ctx,
+		Logger:  logger,
+		name:    name,
+		queue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), name),
+	}
+}
+
+// Add adds a key to the work queue. Keys already pending in the queue are deduplicated.
+func (wq *WorkQueue) Add(key string) {
+	wq.queue.Add(key)
+}
+
+// Len returns the number of keys pending in the work queue.
+func (wq *WorkQueue) Len() int {
+	return wq.queue.Len()
+}
+
+// Run starts workers which process keys with the handler until the stop channel is closed. A key
+// is retried with rate limited backoff if the handler returns an error, and dropped after
+// maxRetries failed attempts.
+func (wq *WorkQueue) Run(workers int, handler func(key string) error, stopCh <-chan struct{}) {
+	for i := 0; i < workers; i++ {
+		go wait.Until(func() {
+			for wq.processNextItem(handler) {
+			}
+		}, 0, stopCh)
+	}
+
+	<-stopCh
+	wq.queue.ShutDown()
+}
+
+// processNextItem processes one key from the work queue. It returns false if the queue has been
+// shut down.
+func (wq *WorkQueue) processNextItem(handler func(key string) error) bool {
+	item, shutdown := wq.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer wq.queue.Done(item)
+
+	key := item.(string)
+	err := handler(key)
+	if err == nil {
+		wq.queue.Forget(item)
+		return true
+	}
+
+	if wq.queue.NumRequeues(item) < maxRetries {
+		wq.Error(err, "failed to process item, requeuing", "queue", wq.name, "key", key)
+		wq.queue.AddRateLimited(item)
+		return true
+	}
+
+	wq.Error(err, "dropping item after retries exhausted", "queue", wq.name, "key", key)
+	wq.queue.Forget(item)
+	return true
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package redactor redacts secrets and personally identifiable information from operation
+// results before they are stored in task status or exported. Values are redacted if their key
+// looks sensitive or if they contain material matching a sensitive pattern, such as bearer
+// tokens or password assignments. An operation can opt out of redaction with an annotation if
+// its results are known to be safe.
+package redactor
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const (
+	// RedactionDisabledAnnotation is the annotation set on an operation to opt out of result
+	// redaction.
+	RedactionDisabledAnnotation = util.KubeDiagPrefix + "disable-redaction"
+
+	// redactedPlaceholder replaces redacted material in operation results.
+	redactedPlaceholder = "[REDACTED]"
+)
+
+var (
+	redactorRedactedValueCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "redactor_redacted_value_count",
+			Help: "Counter of operation result values which contained redacted material",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		redactorRedactedValueCount,
+	)
+}
+
+// sensitiveKeyPattern matches result keys which hold secrets by convention.
+var sensitiveKeyPattern = regexp.MustCompile(`(?i)(password|passwd|token|secret|credential|authorization|api[_-]?key|access[_-]?key|private[_-]?key)`)
+
+// defaultValuePatterns match secret material embedded in result values.
+var defaultValuePatterns = []string{
+	// Authorization headers and bearer tokens.
+	`(?i)authorization\s*:\s*\S+\s+\S+`,
+	`(?i)bearer\s+[A-Za-z0-9._~+/=-]+`,
+	// Password, token and key assignments.
+	`(?i)(password|passwd|pwd|token|secret|api[_-]?key|access[_-]?key)\s*[=:]\s*\S+`,
+	// JSON web tokens.
+	`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\b`,
+	// AWS access key ids.
+	`\bAKIA[0-9A-Z]{16}\b`,
+	// Private key blocks.
+	`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`,
+}
+
+// Redactor redacts secrets and personally identifiable information from operation results.
+type Redactor struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// valuePatterns match secret material embedded in result values.
+	valuePatterns []*regexp.Regexp
+}
+
+// NewRedactor creates a new Redactor with the default patterns and the additional patterns.
+func NewRedactor(
+	ctx context.Context,
+	logger logr.Logger,
+	additionalPatterns []string,
+) (*Redactor, error) {
+	patterns := append(append([]string{}, defaultValuePatterns...), additionalPatterns...)
+	valuePatterns := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %v", pattern, err)
+		}
+		valuePatterns = append(valuePatterns, compiled)
+	}
+
+	return &Redactor{
+		Context:       ctx,
+		Logger:        logger,
+		valuePatterns: valuePatterns,
+	}, nil
+}
+
+// RedactResults redacts the operation result in place. A value is replaced entirely if its key
+// looks sensitive, otherwise secret material matching a value pattern is replaced.
+func (redactor *Redactor) RedactResults(result map[string]string) {
+	for key, value := range result {
+		redacted := redactor.redactValue(key, value)
+		if redacted != value {
+			redactorRedactedValueCount.Inc()
+			result[key] = redacted
+		}
+	}
+}
+
+// redactValue redacts secret material from one result value.
+func (redactor *Redactor) redactValue(key string, value string) string {
+	if sensitiveKeyPattern.MatchString(key) {
+		return redactedPlaceholder
+	}
+
+	for _, pattern := range redactor.valuePatterns {
+		value = pattern.ReplaceAllString(value, redactedPlaceholder)
+	}
+
+	return value
+}
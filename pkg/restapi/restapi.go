@@ -0,0 +1,376 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package restapi exposes a read only REST API on the kubediag master for listing diagnoses and
+// retrieving artifact files proxied from agents, so external tools do not need direct access to
+// agent endpoints.
+package restapi
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/gorilla/mux"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
+	"github.com/kubediag/kubediag/pkg/graphbuilder"
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const (
+	// proxyTimeout is the timeout of a proxied request to an agent.
+	proxyTimeout = 5 * time.Minute
+)
+
+// API serves read only endpoints for diagnoses and their artifacts. Artifact requests are
+// proxied to the agent on the node which produced the artifact.
+type API struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// cache knows how to load Kubernetes objects.
+	cache cache.Cache
+	// agentPort is the port kubediag agents serve on.
+	agentPort int
+	// agentScheme is the url scheme of agent endpoints. It is https if mutual tls is enabled.
+	agentScheme string
+	// client is the http client for proxying requests to agents.
+	client *http.Client
+}
+
+// NewAPI creates a new API. If a tls config is provided, requests to agents are sent over https
+// with the configured client certificate.
+func NewAPI(
+	ctx context.Context,
+	logger logr.Logger,
+	cache cache.Cache,
+	agentPort int,
+	tlsConfig *tls.Config,
+) *API {
+	agentScheme := "http"
+	client := &http.Client{
+		Timeout: proxyTimeout,
+	}
+	if tlsConfig != nil {
+		agentScheme = "https"
+		client.Transport = &http.Transport{
+			TLSClientConfig: tlsConfig,
+		}
+	}
+
+	return &API{
+		Context:     ctx,
+		Logger:      logger,
+		cache:       cache,
+		agentPort:   agentPort,
+		agentScheme: agentScheme,
+		client:      client,
+	}
+}
+
+// ListDiagnosesHandler handles http requests for listing diagnoses. The result can be filtered
+// with the namespace, phase and operationSet query parameters.
+func (api *API) ListDiagnosesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		namespace := r.URL.Query().Get("namespace")
+		phase := r.URL.Query().Get("phase")
+		operationSet := r.URL.Query().Get("operationSet")
+
+		var diagnosisList diagnosisv1.DiagnosisList
+		listOptions := make([]client.ListOption, 0)
+		if namespace != "" {
+			listOptions = append(listOptions, client.InNamespace(namespace))
+		}
+		if phase != "" {
+			listOptions = append(listOptions, client.MatchingFields{util.DiagnosisPhaseIndexField: phase})
+		}
+		if err := api.cache.List(api, &diagnosisList, listOptions...); err != nil {
+			http.Error(w, fmt.Sprintf("unable to list diagnoses: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		diagnoses := make([]diagnosisv1.Diagnosis, 0)
+		for _, diagnosis := range diagnosisList.Items {
+			if operationSet != "" && diagnosis.Spec.OperationSet != operationSet {
+				continue
+			}
+			if err := util.DecompressDiagnosisResults(&diagnosis); err != nil {
+				api.Error(err, "unable to decompress operation results", "diagnosis", client.ObjectKey{
+					Name:      diagnosis.Name,
+					Namespace: diagnosis.Namespace,
+				})
+			}
+			diagnoses = append(diagnoses, diagnosis)
+		}
+
+		data, err := json.Marshal(diagnoses)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to marshal diagnoses: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// GetDiagnosisHandler handles http requests for retrieving a single diagnosis with its status.
+func (api *API) GetDiagnosisHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		vars := mux.Vars(r)
+		var diagnosis diagnosisv1.Diagnosis
+		if err := api.cache.Get(api, client.ObjectKey{
+			Namespace: vars["namespace"],
+			Name:      vars["name"],
+		}, &diagnosis); err != nil {
+			http.Error(w, fmt.Sprintf("unable to fetch diagnosis: %v", err), http.StatusNotFound)
+			return
+		}
+
+		if err := util.DecompressDiagnosisResults(&diagnosis); err != nil {
+			api.Error(err, "unable to decompress operation results", "diagnosis", client.ObjectKey{
+				Namespace: vars["namespace"],
+				Name:      vars["name"],
+			})
+		}
+
+		data, err := json.Marshal(diagnosis)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to marshal diagnosis: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// ListArtifactsHandler handles http requests for listing artifacts of a diagnosis. The request
+// is proxied to the agents on all nodes the diagnosis ran on and the results are aggregated.
+func (api *API) ListArtifactsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		vars := mux.Vars(r)
+		var diagnosis diagnosisv1.Diagnosis
+		if err := api.cache.Get(api, client.ObjectKey{
+			Namespace: vars["namespace"],
+			Name:      vars["name"],
+		}, &diagnosis); err != nil {
+			http.Error(w, fmt.Sprintf("unable to fetch diagnosis: %v", err), http.StatusNotFound)
+			return
+		}
+
+		artifacts := make([]json.RawMessage, 0)
+		for _, nodeName := range diagnosis.Status.NodeNames {
+			address, err := api.nodeAddress(nodeName)
+			if err != nil {
+				api.Error(err, "unable to resolve node address", "node", nodeName)
+				continue
+			}
+
+			agentURL := fmt.Sprintf("%s://%s:%d/api/v1/artifacts?diagnosisNamespace=%s&diagnosisName=%s", api.agentScheme, address, api.agentPort, url.QueryEscape(diagnosis.Namespace), url.QueryEscape(diagnosis.Name))
+			res, err := api.client.Get(agentURL)
+			if err != nil {
+				api.Error(err, "unable to list artifacts from agent", "node", nodeName)
+				continue
+			}
+			var nodeArtifacts []json.RawMessage
+			err = json.NewDecoder(res.Body).Decode(&nodeArtifacts)
+			res.Body.Close()
+			if err != nil {
+				api.Error(err, "unable to decode artifacts from agent", "node", nodeName)
+				continue
+			}
+			artifacts = append(artifacts, nodeArtifacts...)
+		}
+
+		data, err := json.Marshal(artifacts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to marshal artifacts: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// GraphHandler handles http requests for rendering the adjacency list of an operation set in
+// the dot graph description language or as svg. If a diagnosis is specified with the
+// diagnosisNamespace and diagnosisName query parameters, its executed path is highlighted on
+// the graph.
+func (api *API) GraphHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		vars := mux.Vars(r)
+		var operationSet diagnosisv1.OperationSet
+		if err := api.cache.Get(api, client.ObjectKey{Name: vars["name"]}, &operationSet); err != nil {
+			http.Error(w, fmt.Sprintf("unable to fetch operation set: %v", err), http.StatusNotFound)
+			return
+		}
+
+		var executedPath diagnosisv1.Path
+		diagnosisNamespace := r.URL.Query().Get("diagnosisNamespace")
+		diagnosisName := r.URL.Query().Get("diagnosisName")
+		if diagnosisNamespace != "" && diagnosisName != "" {
+			var diagnosis diagnosisv1.Diagnosis
+			if err := api.cache.Get(api, client.ObjectKey{
+				Namespace: diagnosisNamespace,
+				Name:      diagnosisName,
+			}, &diagnosis); err != nil {
+				http.Error(w, fmt.Sprintf("unable to fetch diagnosis: %v", err), http.StatusNotFound)
+				return
+			}
+			executedPath = diagnosis.Status.SucceededPath
+		}
+
+		dot := graphbuilder.RenderDOT(operationSet, executedPath)
+		switch r.URL.Query().Get("format") {
+		case "", "dot":
+			w.Header().Set("Content-Type", "text/vnd.graphviz")
+			w.Write([]byte(dot))
+		case "svg":
+			svg, err := graphbuilder.RenderSVG(dot)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("unable to render svg: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write(svg)
+		default:
+			http.Error(w, fmt.Sprintf("format %s is not supported", r.URL.Query().Get("format")), http.StatusBadRequest)
+		}
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// ProcessorHandler handles http requests for invoking a processor on an agent. The request body
+// is relayed to the processor endpoint of the agent on the node, so processors can be invoked
+// without direct network access to agents in restricted network mode.
+func (api *API) ProcessorHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		vars := mux.Vars(r)
+		address, err := api.nodeAddress(vars["node"])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to resolve node address: %v", err), http.StatusNotFound)
+			return
+		}
+
+		agentURL := fmt.Sprintf("%s://%s:%d/processor/%s", api.agentScheme, address, api.agentPort, url.PathEscape(vars["processor"]))
+		req, err := http.NewRequest("POST", agentURL, r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to create processor request: %v", err), http.StatusInternalServerError)
+			return
+		}
+		req.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+
+		res, err := api.client.Do(req)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to invoke processor on agent: %v", err), http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+
+		w.Header().Set("Content-Type", res.Header.Get("Content-Type"))
+		w.WriteHeader(res.StatusCode)
+		if _, err := io.Copy(w, res.Body); err != nil {
+			api.Error(err, "failed to stream processor response", "node", vars["node"], "processor", vars["processor"])
+		}
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// DownloadArtifactHandler handles http requests for downloading an artifact file. The artifact
+// content is streamed from the agent on the node specified by the node query parameter.
+func (api *API) DownloadArtifactHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		nodeName := r.URL.Query().Get("node")
+		path := r.URL.Query().Get("path")
+		if nodeName == "" || path == "" {
+			http.Error(w, "node and path must be specified", http.StatusBadRequest)
+			return
+		}
+
+		address, err := api.nodeAddress(nodeName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to resolve node address: %v", err), http.StatusNotFound)
+			return
+		}
+
+		agentURL := fmt.Sprintf("%s://%s:%d/api/v1/artifacts/download?path=%s", api.agentScheme, address, api.agentPort, url.QueryEscape(path))
+		res, err := api.client.Get(agentURL)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to download artifact from agent: %v", err), http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+
+		w.Header().Set("Content-Type", res.Header.Get("Content-Type"))
+		w.Header().Set("Content-Disposition", res.Header.Get("Content-Disposition"))
+		w.WriteHeader(res.StatusCode)
+		if _, err := io.Copy(w, res.Body); err != nil {
+			api.Error(err, "failed to stream artifact file", "node", nodeName, "path", path)
+		}
+	default:
+		http.Error(w, fmt.Sprintf("method %s is not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// nodeAddress returns the internal ip address of the node, or its hostname if the node has no
+// internal ip address.
+func (api *API) nodeAddress(nodeName string) (string, error) {
+	var node corev1.Node
+	if err := api.cache.Get(api, client.ObjectKey{Name: nodeName}, &node); err != nil {
+		return "", fmt.Errorf("unable to fetch node %s: %v", nodeName, err)
+	}
+
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP {
+			return address.Address, nil
+		}
+	}
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeHostName {
+			return address.Address, nil
+		}
+	}
+
+	return "", fmt.Errorf("node %s has no internal ip or hostname address", nodeName)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package safeexec validates parameter derived arguments before they are used in host commands.
+// Parameters come from user controlled custom resource fields, so processors which execute
+// commands such as tcpdump, du or signal senders must validate paths, pids and filters with this
+// package instead of interpolating them directly.
+package safeexec
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// shellMetacharacters are rejected in all parameter derived arguments. They have no legitimate
+// use in the arguments processors build and would allow command or flag injection if a command
+// is ever run through a shell.
+const shellMetacharacters = ";&|$`<>(){}\"'\\\n\r\x00"
+
+// namePattern matches simple names such as network interfaces, container names and signals.
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9._:@-]+$`)
+
+// filterPattern matches pcap filter expressions. It permits the words, numbers, grouping and
+// comparison syntax of pcap filters while rejecting quoting and shell metacharacters.
+var filterPattern = regexp.MustCompile(`^[a-zA-Z0-9 ._:/!=<>\[\]()-]*$`)
+
+// ValidateArgument validates a parameter derived command argument. It rejects shell
+// metacharacters and arguments which would be parsed as a flag.
+func ValidateArgument(value string) error {
+	if strings.ContainsAny(value, shellMetacharacters) {
+		return fmt.Errorf("argument %q contains a shell metacharacter", value)
+	}
+	if strings.HasPrefix(value, "-") {
+		return fmt.Errorf("argument %q would be parsed as a flag", value)
+	}
+
+	return nil
+}
+
+// ValidatePath validates a parameter derived file path. The path must be absolute, clean and
+// free of shell metacharacters, so a crafted path cannot escape the intended directory or
+// inject a flag.
+func ValidatePath(path string) error {
+	if err := ValidateArgument(path); err != nil {
+		return err
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("path %q is not absolute", path)
+	}
+	if filepath.Clean(path) != path {
+		return fmt.Errorf("path %q is not clean", path)
+	}
+
+	return nil
+}
+
+// ValidatePID validates a parameter derived process id and returns it as an integer.
+func ValidatePID(value string) (int, error) {
+	pid, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("pid %q is not a number", value)
+	}
+	if pid <= 0 {
+		return 0, fmt.Errorf("pid %d is not positive", pid)
+	}
+
+	return pid, nil
+}
+
+// ValidateName validates a parameter derived simple name such as a network interface, a
+// container name or a signal name.
+func ValidateName(value string) error {
+	if !namePattern.MatchString(value) {
+		return fmt.Errorf("name %q contains an invalid character", value)
+	}
+
+	return nil
+}
+
+// ValidateFilter validates a parameter derived pcap filter expression.
+func ValidateFilter(value string) error {
+	if !filterPattern.MatchString(value) {
+		return fmt.Errorf("filter %q contains an invalid character", value)
+	}
+
+	return nil
+}
+
+// ValidateCommand validates all arguments of a command after the binary name.
+func ValidateCommand(command []string) error {
+	for _, argument := range command[1:] {
+		if err := ValidateArgument(argument); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package scopedcache provides a manager cache for the kubediag agent which watches only pods
+// scheduled on the local node. Without the scope every agent caches every pod in the cluster and
+// its memory usage grows with cluster size.
+package scopedcache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+// podResyncPeriod is the resync period of the node scoped pod informer.
+const podResyncPeriod = 10 * time.Hour
+
+// NodeScopedCacheBuilder returns a cache builder which serves pods from an informer restricted
+// to pods scheduled on the node with a field selector, and delegates all other objects to the
+// default cache. An optional list of namespaces restricts the default cache to those namespaces.
+func NodeScopedCacheBuilder(nodeName string, namespaces []string) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		newDefaultCache := cache.New
+		if len(namespaces) != 0 {
+			newDefaultCache = cache.MultiNamespacedCacheBuilder(namespaces)
+		}
+		defaultCache, err := newDefaultCache(config, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		podInformer := toolscache.NewSharedIndexInformer(
+			&toolscache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					options.FieldSelector = "spec.nodeName=" + nodeName
+					return clientset.CoreV1().Pods(corev1.NamespaceAll).List(options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					options.FieldSelector = "spec.nodeName=" + nodeName
+					return clientset.CoreV1().Pods(corev1.NamespaceAll).Watch(options)
+				},
+			},
+			&corev1.Pod{},
+			podResyncPeriod,
+			toolscache.Indexers{toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc},
+		)
+
+		return &nodeScopedCache{
+			defaultCache: defaultCache,
+			podInformer:  podInformer,
+		}, nil
+	}
+}
+
+// nodeScopedCache serves pods from a node scoped informer and delegates all other objects to the
+// default cache.
+type nodeScopedCache struct {
+	// defaultCache serves all objects other than pods.
+	defaultCache cache.Cache
+	// podInformer is the informer of pods scheduled on the local node.
+	podInformer toolscache.SharedIndexInformer
+}
+
+var _ cache.Cache = &nodeScopedCache{}
+
+// Get retrieves an object for the given object key.
+func (nc *nodeScopedCache) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nc.defaultCache.Get(ctx, key, obj)
+	}
+
+	item, exists, err := nc.podInformer.GetStore().GetByKey(key.Namespace + "/" + key.Name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return apierrors.NewNotFound(corev1.Resource("pods"), key.Name)
+	}
+
+	cached, ok := item.(*corev1.Pod)
+	if !ok {
+		return fmt.Errorf("unexpected object %T in pod informer store", item)
+	}
+	cached.DeepCopyInto(pod)
+	return nil
+}
+
+// List retrieves a list of objects for the given namespace and list options.
+func (nc *nodeScopedCache) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	podList, ok := list.(*corev1.PodList)
+	if !ok {
+		return nc.defaultCache.List(ctx, list, opts...)
+	}
+
+	listOptions := client.ListOptions{}
+	listOptions.ApplyOptions(opts)
+	selector := labels.Everything()
+	if listOptions.LabelSelector != nil {
+		selector = listOptions.LabelSelector
+	}
+
+	podList.Items = nil
+	for _, item := range nc.podInformer.GetStore().List() {
+		pod, ok := item.(*corev1.Pod)
+		if !ok {
+			return fmt.Errorf("unexpected object %T in pod informer store", item)
+		}
+		if listOptions.Namespace != "" && pod.Namespace != listOptions.Namespace {
+			continue
+		}
+		if !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		if listOptions.FieldSelector != nil && !listOptions.FieldSelector.Matches(fields.Set{util.NodeNameIndexField: pod.Spec.NodeName}) {
+			continue
+		}
+		podList.Items = append(podList.Items, *pod.DeepCopy())
+	}
+
+	return nil
+}
+
+// GetInformer fetches or constructs an informer for the given object.
+func (nc *nodeScopedCache) GetInformer(obj runtime.Object) (cache.Informer, error) {
+	if _, ok := obj.(*corev1.Pod); ok {
+		return nc.podInformer, nil
+	}
+
+	return nc.defaultCache.GetInformer(obj)
+}
+
+// GetInformerForKind fetches or constructs an informer for the given group version kind.
+func (nc *nodeScopedCache) GetInformerForKind(gvk schema.GroupVersionKind) (cache.Informer, error) {
+	if gvk == corev1.SchemeGroupVersion.WithKind("Pod") {
+		return nc.podInformer, nil
+	}
+
+	return nc.defaultCache.GetInformerForKind(gvk)
+}
+
+// Start runs all the informers known to this cache until the stop channel is closed.
+func (nc *nodeScopedCache) Start(stopCh <-chan struct{}) error {
+	go nc.podInformer.Run(stopCh)
+	return nc.defaultCache.Start(stopCh)
+}
+
+// WaitForCacheSync waits for all the caches to sync.
+func (nc *nodeScopedCache) WaitForCacheSync(stop <-chan struct{}) bool {
+	if !toolscache.WaitForCacheSync(stop, nc.podInformer.HasSynced) {
+		return false
+	}
+
+	return nc.defaultCache.WaitForCacheSync(stop)
+}
+
+// IndexField adds a field index to the cache. The node name index on pods is satisfied by
+// filtering the node scoped pod informer during List, other field indexes are not supported on
+// the pod informer.
+func (nc *nodeScopedCache) IndexField(obj runtime.Object, field string, extractValue client.IndexerFunc) error {
+	if _, ok := obj.(*corev1.Pod); ok {
+		if field == util.NodeNameIndexField {
+			return nil
+		}
+		return fmt.Errorf("field indexes other than %s are not supported on the node scoped pod informer", util.NodeNameIndexField)
+	}
+
+	return nc.defaultCache.IndexField(obj, field, extractValue)
+}
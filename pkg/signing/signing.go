@@ -0,0 +1,138 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package signing verifies signatures on operation and operation set objects. The signature is
+// carried in an annotation and covers the json encoded specification of the object, in the style
+// of cosign detached signatures. With verification enabled, unsigned or tampered pipelines are
+// refused before any operation runs on a node, so regulated environments can control what
+// automation is permitted to run.
+package signing
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/go-logr/logr"
+
+	"github.com/kubediag/kubediag/pkg/util"
+)
+
+const (
+	// SignatureAnnotation is the annotation carrying the base64 encoded signature of the json
+	// encoded specification of an operation or operation set.
+	SignatureAnnotation = util.KubeDiagPrefix + "signature"
+
+	// VerificationModeNone disables signature verification.
+	VerificationModeNone = "None"
+	// VerificationModeEnforce refuses to execute unsigned or tampered objects.
+	VerificationModeEnforce = "Enforce"
+)
+
+// ecdsaSignature is the asn1 encoded form of an ecdsa signature.
+type ecdsaSignature struct {
+	R *big.Int
+	S *big.Int
+}
+
+// Verifier verifies signatures on operation and operation set objects with a public key.
+type Verifier struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// publicKey is the ecdsa or ed25519 public key used to verify signatures.
+	publicKey interface{}
+}
+
+// NewVerifier creates a new Verifier with the pem encoded public key in the key file.
+func NewVerifier(
+	ctx context.Context,
+	logger logr.Logger,
+	publicKeyFile string,
+) (*Verifier, error) {
+	data, err := ioutil.ReadFile(publicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read public key file %s: %v", publicKeyFile, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("public key file %s does not contain a pem block", publicKeyFile)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse public key: %v", err)
+	}
+	switch publicKey.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("public key type %T is not supported, must be ecdsa or ed25519", publicKey)
+	}
+
+	return &Verifier{
+		Context:   ctx,
+		Logger:    logger,
+		publicKey: publicKey,
+	}, nil
+}
+
+// Verify verifies the signature annotation of the object against its json encoded specification.
+// It returns an error if the object is unsigned, the signature is malformed or the specification
+// does not match the signature.
+func (verifier *Verifier) Verify(annotations map[string]string, spec interface{}) error {
+	encoded, ok := annotations[SignatureAnnotation]
+	if !ok || encoded == "" {
+		return fmt.Errorf("object is not signed")
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("signature is not valid base64: %v", err)
+	}
+
+	payload, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("unable to marshal specification: %v", err)
+	}
+
+	switch publicKey := verifier.publicKey.(type) {
+	case *ecdsa.PublicKey:
+		var decoded ecdsaSignature
+		if _, err := asn1.Unmarshal(signature, &decoded); err != nil {
+			return fmt.Errorf("signature is not a valid ecdsa signature: %v", err)
+		}
+		digest := sha256.Sum256(payload)
+		if !ecdsa.Verify(publicKey, digest[:], decoded.R, decoded.S) {
+			return fmt.Errorf("signature does not match the specification")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(publicKey, payload, signature) {
+			return fmt.Errorf("signature does not match the specification")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signing
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// testSpec is a specification payload signed in tests.
+type testSpec struct {
+	Operation string            `json:"operation"`
+	Processor string            `json:"processor,omitempty"`
+	Arguments map[string]string `json:"arguments,omitempty"`
+}
+
+// writePublicKey writes the pem encoded public key to a file in a temporary directory.
+func writePublicKey(t *testing.T, publicKey interface{}) string {
+	dir, err := ioutil.TempDir("", "signing-test")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	encoded, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatalf("unable to marshal public key: %v", err)
+	}
+	path := filepath.Join(dir, "key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: encoded})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("unable to write public key file: %v", err)
+	}
+	return path
+}
+
+// newTestVerifier creates a verifier for the provided public key.
+func newTestVerifier(t *testing.T, publicKey interface{}) *Verifier {
+	verifier, err := NewVerifier(context.Background(), ctrl.Log.WithName("signing"), writePublicKey(t, publicKey))
+	if err != nil {
+		t.Fatalf("unable to create verifier: %v", err)
+	}
+	return verifier
+}
+
+// signECDSA signs the json encoded specification with the ecdsa private key and returns the
+// signature annotation value.
+func signECDSA(t *testing.T, privateKey *ecdsa.PrivateKey, spec interface{}) string {
+	payload, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatalf("unable to marshal specification: %v", err)
+	}
+	digest := sha256.Sum256(payload)
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, digest[:])
+	if err != nil {
+		t.Fatalf("unable to sign specification: %v", err)
+	}
+	signature, err := asn1.Marshal(ecdsaSignature{R: r, S: s})
+	if err != nil {
+		t.Fatalf("unable to marshal signature: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+// signEd25519 signs the json encoded specification with the ed25519 private key and returns the
+// signature annotation value.
+func signEd25519(t *testing.T, privateKey ed25519.PrivateKey, spec interface{}) string {
+	payload, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatalf("unable to marshal specification: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, payload))
+}
+
+func TestVerifyECDSA(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	verifier := newTestVerifier(t, &privateKey.PublicKey)
+
+	spec := testSpec{Operation: "memory-profiler", Arguments: map[string]string{"duration": "30s"}}
+	annotations := map[string]string{SignatureAnnotation: signECDSA(t, privateKey, spec)}
+	assert.NoError(t, verifier.Verify(annotations, spec), "a valid signature verifies")
+
+	tampered := spec
+	tampered.Arguments = map[string]string{"duration": "600s"}
+	assert.Error(t, verifier.Verify(annotations, tampered), "a modified specification is refused")
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	annotations = map[string]string{SignatureAnnotation: signECDSA(t, otherKey, spec)}
+	assert.Error(t, verifier.Verify(annotations, spec), "a signature by another key is refused")
+}
+
+func TestVerifyEd25519(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	verifier := newTestVerifier(t, publicKey)
+
+	spec := testSpec{Operation: "goroutine-collector", Processor: "http"}
+	annotations := map[string]string{SignatureAnnotation: signEd25519(t, privateKey, spec)}
+	assert.NoError(t, verifier.Verify(annotations, spec), "a valid signature verifies")
+
+	tampered := spec
+	tampered.Processor = "script"
+	assert.Error(t, verifier.Verify(annotations, tampered), "a modified specification is refused")
+}
+
+func TestVerifyMalformedSignatures(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	verifier := newTestVerifier(t, &privateKey.PublicKey)
+	spec := testSpec{Operation: "memory-profiler"}
+
+	tests := []struct {
+		annotations map[string]string
+		desc        string
+	}{
+		{
+			annotations: nil,
+			desc:        "an unsigned object is refused",
+		},
+		{
+			annotations: map[string]string{SignatureAnnotation: ""},
+			desc:        "an empty signature is refused",
+		},
+		{
+			annotations: map[string]string{SignatureAnnotation: "not base64!"},
+			desc:        "invalid base64 is refused",
+		},
+		{
+			annotations: map[string]string{SignatureAnnotation: base64.StdEncoding.EncodeToString([]byte("garbage"))},
+			desc:        "a signature which is not valid asn1 is refused",
+		},
+	}
+
+	for _, test := range tests {
+		assert.Error(t, verifier.Verify(test.annotations, spec), test.desc)
+	}
+}
+
+func TestNewVerifierRejectsUnsupportedKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	_, err = NewVerifier(context.Background(), ctrl.Log.WithName("signing"), writePublicKey(t, &rsaKey.PublicKey))
+	assert.Error(t, err, "rsa keys are not supported")
+
+	dir, err := ioutil.TempDir("", "signing-test")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	path := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(path, []byte("not a pem block"), 0600); err != nil {
+		t.Fatalf("unable to write key file: %v", err)
+	}
+	_, err = NewVerifier(context.Background(), ctrl.Log.WithName("signing"), path)
+	assert.Error(t, err, "a file without a pem block is refused")
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package storage uploads artifacts produced by operations to external object storage.
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// unsignedPayload is the content sha256 value for streaming uploads without payload signing.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// uploadTimeout is the timeout of a single artifact upload.
+	uploadTimeout = 30 * time.Minute
+)
+
+// S3Uploader uploads artifact files to s3 compatible object storage with aws signature version 4.
+// Object keys are prefixed with the upload date so bucket lifecycle rules can expire old artifacts.
+type S3Uploader struct {
+	// Context carries values across API boundaries.
+	context.Context
+	// Logger represents the ability to log messages.
+	logr.Logger
+
+	// endpoint is the url of the s3 compatible endpoint.
+	endpoint *url.URL
+	// bucket is the name of the bucket to upload artifacts to.
+	bucket string
+	// region is the region of the bucket.
+	region string
+	// accessKey is the access key id for authentication.
+	accessKey string
+	// secretKey is the secret access key for authentication.
+	secretKey string
+	// keyPrefix is the prefix of all object keys.
+	keyPrefix string
+	// client is the http client for uploading artifacts.
+	client *http.Client
+}
+
+// NewS3Uploader creates a new S3Uploader.
+func NewS3Uploader(
+	ctx context.Context,
+	logger logr.Logger,
+	endpoint string,
+	bucket string,
+	region string,
+	accessKey string,
+	secretKey string,
+	keyPrefix string,
+) (*S3Uploader, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint %s: %v", endpoint, err)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 bucket must be specified")
+	}
+
+	return &S3Uploader{
+		Context:   ctx,
+		Logger:    logger,
+		endpoint:  parsed,
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		keyPrefix: keyPrefix,
+		client: &http.Client{
+			Timeout: uploadTimeout,
+		},
+	}, nil
+}
+
+// UploadFile uploads the file to object storage and returns the object url. The object key is
+// composed of the key prefix, the upload date, the operation name and the file name.
+func (su *S3Uploader) UploadFile(operation string, filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("unable to open artifact file %s: %v", filePath, err)
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("unable to stat artifact file %s: %v", filePath, err)
+	}
+
+	now := time.Now().UTC()
+	key := path.Join(su.keyPrefix, now.Format("2006/01/02"), operation, path.Base(filePath))
+
+	return su.putObject(key, file, fileInfo.Size(), now)
+}
+
+// putObject uploads the reader as an object with a path style url and signature version 4.
+func (su *S3Uploader) putObject(key string, reader io.Reader, size int64, now time.Time) (string, error) {
+	objectURL := *su.endpoint
+	objectURL.Path = path.Join(objectURL.Path, su.bucket, key)
+
+	req, err := http.NewRequest("PUT", objectURL.String(), reader)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("Authorization", su.authorization(req, now))
+
+	res, err := su.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("object storage responded with erroneous status: %s", res.Status)
+	}
+
+	su.Info("artifact uploaded to object storage", "key", key, "size", size)
+	return objectURL.String(), nil
+}
+
+// authorization computes the aws signature version 4 authorization header of the request.
+func (su *S3Uploader) authorization(req *http.Request, now time.Time) string {
+	amzDate := now.Format("20060102T150405Z")
+	shortDate := now.Format("20060102")
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + unsignedPayload,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	scope := strings.Join([]string{shortDate, su.region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+su.secretKey), shortDate)
+	signingKey = hmacSHA256(signingKey, su.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	return fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", su.accessKey, scope, signedHeaders, signature)
+}
+
+// hexSHA256 returns the hex encoded sha256 sum of data.
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the hmac-sha256 of data with the key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tlsutil provides file based tls configurations for mutual tls between the kubediag
+// master and agents. Certificates are expected to be provisioned by cert-manager or the
+// kubernetes csr api and mounted as files. Certificates are reloaded from disk on every
+// handshake, so rotated certificates are picked up without a restart.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Config holds the certificate files for mutual tls between the kubediag master and agents.
+type Config struct {
+	// CertFile is the path of the certificate presented to peers.
+	CertFile string
+	// KeyFile is the path of the private key of the certificate.
+	KeyFile string
+	// CAFile is the path of the certificate authority bundle used to verify peers.
+	CAFile string
+}
+
+// Enabled returns true if all certificate files are specified.
+func (c Config) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != "" && c.CAFile != ""
+}
+
+// ServerTLSConfig returns a tls config which presents the serving certificate and requires and
+// verifies client certificates signed by the configured certificate authority.
+func (c Config) ServerTLSConfig() (*tls.Config, error) {
+	pool, err := c.certPool()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return c.loadCertificate()
+		},
+	}, nil
+}
+
+// ClientTLSConfig returns a tls config which presents the client certificate and verifies the
+// server against the configured certificate authority.
+func (c Config) ClientTLSConfig() (*tls.Config, error) {
+	pool, err := c.certPool()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    pool,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return c.loadCertificate()
+		},
+	}, nil
+}
+
+// RequirePeerIdentity returns middleware which only passes requests whose verified peer
+// certificate carries the common name. It is used by agents in restricted network mode to only
+// accept requests relayed through the master. Requests to exempt paths, such as health checks,
+// are passed through unchanged.
+func RequirePeerIdentity(identity string, exemptPaths ...string) func(http.Handler) http.Handler {
+	exempt := make(map[string]bool)
+	for _, path := range exemptPaths {
+		exempt[path] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if exempt[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+				http.Error(w, "a verified peer certificate is required", http.StatusForbidden)
+				return
+			}
+			if r.TLS.PeerCertificates[0].Subject.CommonName != identity {
+				http.Error(w, fmt.Sprintf("peer %s is not permitted", r.TLS.PeerCertificates[0].Subject.CommonName), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// loadCertificate loads the certificate and private key from disk.
+func (c Config) loadCertificate() (*tls.Certificate, error) {
+	certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load certificate from %s and %s: %v", c.CertFile, c.KeyFile, err)
+	}
+
+	return &certificate, nil
+}
+
+// certPool returns a certificate pool containing the configured certificate authority bundle.
+func (c Config) certPool() (*x509.CertPool, error) {
+	ca, err := ioutil.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read certificate authority bundle %s: %v", c.CAFile, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("unable to parse certificate authority bundle %s", c.CAFile)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import "syscall"
+
+// fsStat returns total, free and available bytes of the filesystem containing the path using
+// statfs. It works on all unix GOOS and GOARCH targets including arm64.
+func fsStat(path string) (total uint64, free uint64, available uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, 0, err
+	}
+
+	return stat.Blocks * uint64(stat.Bsize), stat.Bfree * uint64(stat.Bsize), stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,48 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// fsStat returns total, free and available bytes of the filesystem containing the path using
+// GetDiskFreeSpaceEx, since statfs is not available on windows.
+func fsStat(path string) (total uint64, free uint64, available uint64, err error) {
+	pathPointer, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var availableBytes, totalBytes, freeBytes uint64
+	getDiskFreeSpaceEx := syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+	ret, _, callErr := getDiskFreeSpaceEx.Call(
+		uintptr(unsafe.Pointer(pathPointer)),
+		uintptr(unsafe.Pointer(&availableBytes)),
+		uintptr(unsafe.Pointer(&totalBytes)),
+		uintptr(unsafe.Pointer(&freeBytes)),
+	)
+	if ret == 0 {
+		return 0, 0, 0, callErr
+	}
+
+	return totalBytes, freeBytes, availableBytes, nil
+}
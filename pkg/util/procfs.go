@@ -0,0 +1,135 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procRoot is the mount point of the proc filesystem.
+const procRoot = "/proc"
+
+// ProcessInfo describes a process discovered by scanning the proc filesystem.
+type ProcessInfo struct {
+	// PID is the process id.
+	PID int `json:"pid"`
+	// Comm is the command name of the process.
+	Comm string `json:"comm"`
+	// Cmdline contains the command line arguments of the process.
+	Cmdline []string `json:"cmdline,omitempty"`
+	// CgroupPath is the cgroup path of the process.
+	CgroupPath string `json:"cgroupPath,omitempty"`
+}
+
+// FindProcesses scans the proc filesystem and returns processes whose command name or command
+// line matches the program. Processes are additionally filtered by a substring of their cgroup
+// path if the cgroup filter is not empty, enabling lookups of processes inside a specific
+// container. Processes which vanish during the scan are tolerated.
+func FindProcesses(program string, cgroupFilter string) ([]ProcessInfo, error) {
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", procRoot, err)
+	}
+
+	processes := make([]ProcessInfo, 0)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		comm := readComm(pid)
+		cmdline := readCmdline(pid)
+		if comm != program && !cmdlineMatches(cmdline, program) {
+			continue
+		}
+
+		cgroupPath := readCgroupPath(pid)
+		if cgroupFilter != "" && !strings.Contains(cgroupPath, cgroupFilter) {
+			continue
+		}
+
+		processes = append(processes, ProcessInfo{
+			PID:        pid,
+			Comm:       comm,
+			Cmdline:    cmdline,
+			CgroupPath: cgroupPath,
+		})
+	}
+
+	return processes, nil
+}
+
+// readComm returns the command name of the process from its comm file.
+func readComm(pid int) string {
+	data, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// readCmdline returns the command line arguments of the process from its cmdline file.
+func readCmdline(pid int) []string {
+	data, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return nil
+	}
+
+	cmdline := make([]string, 0)
+	for _, argument := range strings.Split(string(data), "\x00") {
+		if argument != "" {
+			cmdline = append(cmdline, argument)
+		}
+	}
+
+	return cmdline
+}
+
+// readCgroupPath returns the cgroup path of the process from the first entry of its cgroup
+// file.
+func readCgroupPath(pid int) string {
+	data, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return ""
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) == 0 {
+		return ""
+	}
+	fields := strings.SplitN(lines[0], ":", 3)
+	if len(fields) != 3 {
+		return ""
+	}
+
+	return fields[2]
+}
+
+// cmdlineMatches indicates whether the base name of the executed command matches the program.
+func cmdlineMatches(cmdline []string, program string) bool {
+	if len(cmdline) == 0 {
+		return false
+	}
+
+	return filepath.Base(cmdline[0]) == program
+}
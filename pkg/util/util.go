@@ -17,8 +17,12 @@ limitations under the License.
 package util
 
 import (
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"hash/fnv"
 	"io"
@@ -29,14 +33,19 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	diagnosisv1 "github.com/kubediag/kubediag/api/v1"
 )
@@ -76,8 +85,27 @@ const (
 	// OperationSetUniqueLabelKey is the default key of the label that is added to existing OperationSets and Diagnoses
 	// to prevent conflicts on changed OperationSets and running Diagnoses.
 	OperationSetUniqueLabelKey = "adjacency-list-hash"
+	// ReplayAnnotation marks a task as part of a replayed diagnosis. Agents skip node mutating
+	// operations of replayed tasks.
+	ReplayAnnotation = KubeDiagPrefix + "replay"
 	// AlphaNums omits vowels from the set of available characters to reduce the chances of "bad words" being formed.
 	AlphaNums = "bcdfghjklmnpqrstvwxz2456789"
+	// CompressedValuePrefix marks a value which has been gzip compressed and base64 encoded.
+	CompressedValuePrefix = "gzip+base64:"
+	// OffloadedValuePrefix marks a result value whose payload has been offloaded to artifact
+	// storage. The remainder of the value is a JSON encoded OffloadedValue reference.
+	OffloadedValuePrefix = "offloaded:"
+	// NodeNameIndexField is the field index of pods and tasks by node name.
+	NodeNameIndexField = "spec.nodeName"
+	// DiagnosisPhaseIndexField is the field index of diagnoses by phase.
+	DiagnosisPhaseIndexField = "status.phase"
+	// DiagnosisNodeNameIndexField is the field index of diagnoses by node name.
+	DiagnosisNodeNameIndexField = "status.nodeNames"
+	// diskUsageWorkers is the maximum number of concurrent directory reads during a disk usage
+	// calculation.
+	diskUsageWorkers = 4
+	// diskUsageTimeout is the timeout of a disk usage calculation.
+	diskUsageTimeout = 60 * time.Second
 )
 
 // UpdateDiagnosisCondition updates existing diagnosis condition or creates a new one. Sets
@@ -291,52 +319,204 @@ func FormatURL(scheme string, host string, port string, path string) *url.URL {
 	return u
 }
 
-// QueueDiagnosis sends a diagnosis to a channel. It returns an error if the channel is blocked.
-func QueueDiagnosis(ctx context.Context, channel chan diagnosisv1.Diagnosis, diagnosis diagnosisv1.Diagnosis) error {
-	select {
-	case <-ctx.Done():
-		return nil
-	case channel <- diagnosis:
-		return nil
-	default:
-		return fmt.Errorf("channel is blocked")
+// PatchDiagnosisStatus patches the status of the diagnosis with a json merge patch constructed
+// from the diagnosis status. Merge patches do not carry a resource version and therefore avoid
+// the optimistic concurrency conflicts between master and agent that full updates frequently hit.
+// Conflicts reported by the api server are still retried with backoff.
+func PatchDiagnosisStatus(ctx context.Context, cli client.Client, diagnosis *diagnosisv1.Diagnosis) error {
+	// Record completion time and duration when the diagnosis reaches a terminal phase. All
+	// status transitions go through this function, so completion is recorded exactly once.
+	if (diagnosis.Status.Phase == diagnosisv1.DiagnosisSucceeded || diagnosis.Status.Phase == diagnosisv1.DiagnosisFailed) && diagnosis.Status.CompletionTime == nil {
+		now := metav1.Now()
+		diagnosis.Status.CompletionTime = &now
+		if !diagnosis.Status.StartTime.IsZero() {
+			diagnosis.Status.Duration = now.Sub(diagnosis.Status.StartTime.Time).Round(time.Second).String()
+		}
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"status": diagnosis.Status})
+	if err != nil {
+		return err
 	}
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		return cli.Status().Patch(ctx, diagnosis, client.RawPatch(types.MergePatchType, data))
+	})
 }
 
-// QueueTask sends a task to a channel. It returns an error if the channel is blocked.
-func QueueTask(ctx context.Context, channel chan diagnosisv1.Task, task diagnosisv1.Task) error {
-	select {
-	case <-ctx.Done():
-		return nil
-	case channel <- task:
-		return nil
-	default:
-		return fmt.Errorf("channel is blocked")
+// PatchTaskStatus patches the status of the task with a json merge patch constructed from the
+// task status. See PatchDiagnosisStatus for details on why patches are preferred over updates.
+func PatchTaskStatus(ctx context.Context, cli client.Client, task *diagnosisv1.Task) error {
+	data, err := json.Marshal(map[string]interface{}{"status": task.Status})
+	if err != nil {
+		return err
 	}
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		return cli.Status().Patch(ctx, task, client.RawPatch(types.MergePatchType, data))
+	})
 }
 
-// QueueOperationSet sends an operation set to a channel. It returns an error if the channel is blocked.
-func QueueOperationSet(ctx context.Context, channel chan diagnosisv1.OperationSet, operationSet diagnosisv1.OperationSet) error {
-	select {
-	case <-ctx.Done():
-		return nil
-	case channel <- operationSet:
-		return nil
-	default:
-		return fmt.Errorf("channel is blocked")
+// PatchTaskSetStatus patches the status of the task set with a json merge patch constructed
+// from the task set status. See PatchDiagnosisStatus for details on why patches are preferred
+// over updates.
+func PatchTaskSetStatus(ctx context.Context, cli client.Client, taskSet *diagnosisv1.TaskSet) error {
+	data, err := json.Marshal(map[string]interface{}{"status": taskSet.Status})
+	if err != nil {
+		return err
 	}
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		return cli.Status().Patch(ctx, taskSet, client.RawPatch(types.MergePatchType, data))
+	})
 }
 
-// QueueEvent sends an event to a channel. It returns an error if the channel is blocked.
-func QueueEvent(ctx context.Context, channel chan corev1.Event, event corev1.Event) error {
-	select {
-	case <-ctx.Done():
-		return nil
-	case channel <- event:
+// CompressLargeValue gzip compresses the value and encodes it with base64 if its size reaches
+// the threshold, reducing etcd object sizes for verbose operation results. Compressed values are
+// marked with CompressedValuePrefix. The original value is returned if it is below the threshold,
+// already compressed or compression does not make it smaller.
+func CompressLargeValue(value string, thresholdBytes int) (string, error) {
+	if len(value) < thresholdBytes || strings.HasPrefix(value, CompressedValuePrefix) {
+		return value, nil
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(value)); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	compressed := CompressedValuePrefix + base64.StdEncoding.EncodeToString(buf.Bytes())
+	if len(compressed) >= len(value) {
+		return value, nil
+	}
+
+	return compressed, nil
+}
+
+// OffloadedValue references a result payload which has been offloaded to artifact storage
+// instead of being stored in the object, so huge collector outputs do not hit the etcd
+// object size limit.
+type OffloadedValue struct {
+	// Location is the artifact file path on the node or the object storage url of the payload.
+	Location string `json:"location"`
+	// NodeName is the node holding the artifact file, or empty if the payload has been
+	// uploaded to object storage.
+	NodeName string `json:"nodeName,omitempty"`
+	// Checksum is the hex encoded sha256 checksum of the payload.
+	Checksum string `json:"checksum"`
+	// SizeBytes is the size of the payload in bytes.
+	SizeBytes int64 `json:"sizeBytes"`
+}
+
+// EncodeOffloadedValue encodes an offloaded value reference for storing in a result.
+func EncodeOffloadedValue(reference OffloadedValue) (string, error) {
+	encoded, err := json.Marshal(reference)
+	if err != nil {
+		return "", err
+	}
+
+	return OffloadedValuePrefix + string(encoded), nil
+}
+
+// ParseOffloadedValue parses an offloaded value reference from a result value. It returns
+// false if the value is not an offloaded value reference.
+func ParseOffloadedValue(value string) (OffloadedValue, bool, error) {
+	if !strings.HasPrefix(value, OffloadedValuePrefix) {
+		return OffloadedValue{}, false, nil
+	}
+
+	var reference OffloadedValue
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(value, OffloadedValuePrefix)), &reference); err != nil {
+		return OffloadedValue{}, false, err
+	}
+
+	return reference, true, nil
+}
+
+// DecompressValue decompresses a value compressed with CompressLargeValue. Values without the
+// CompressedValuePrefix are returned unchanged.
+func DecompressValue(value string) (string, error) {
+	if !strings.HasPrefix(value, CompressedValuePrefix) {
+		return value, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, CompressedValuePrefix))
+	if err != nil {
+		return "", err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return "", err
+	}
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	if err := reader.Close(); err != nil {
+		return "", err
+	}
+
+	return string(decompressed), nil
+}
+
+// DecompressResults returns a copy of results with all compressed values decompressed.
+func DecompressResults(results map[string]string) (map[string]string, error) {
+	if results == nil {
+		return nil, nil
+	}
+
+	decompressed := make(map[string]string, len(results))
+	for key, value := range results {
+		value, err := DecompressValue(value)
+		if err != nil {
+			return nil, err
+		}
+		decompressed[key] = value
+	}
+
+	return decompressed, nil
+}
+
+// DecompressDiagnosisResults decompresses all compressed operation results in the diagnosis
+// status context in place.
+func DecompressDiagnosisResults(diagnosis *diagnosisv1.Diagnosis) error {
+	if diagnosis.Status.Context == nil {
 		return nil
-	default:
-		return fmt.Errorf("channel is blocked")
 	}
+
+	for operationKey, operationValue := range diagnosis.Status.Context.Operations {
+		for taskName, results := range operationValue {
+			decompressed, err := DecompressResults(results)
+			if err != nil {
+				return err
+			}
+			operationValue[taskName] = decompressed
+		}
+		diagnosis.Status.Context.Operations[operationKey] = operationValue
+	}
+
+	return nil
+}
+
+// UpdateWithRetry fetches the latest version of the object by key, applies the mutation and
+// updates it, retrying on optimistic concurrency conflicts so that transient conflicts do not
+// surface as reconcile errors. The mutation is reapplied on every attempt since the object is
+// refetched before each update.
+func UpdateWithRetry(ctx context.Context, cli client.Client, key client.ObjectKey, obj runtime.Object, mutate func() error) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		if err := cli.Get(ctx, key, obj); err != nil {
+			return err
+		}
+		if err := mutate(); err != nil {
+			return err
+		}
+
+		return cli.Update(ctx, obj)
+	})
 }
 
 // IsDiagnosisCompleted return true if Diagnosis is failed or succeed
@@ -394,71 +574,129 @@ func GetOwnerReference(kind, apiVersion, name string, uid types.UID) ([]metav1.O
 
 // GetTotalBytes gets total bytes in filesystem.
 func GetTotalBytes(path string) uint64 {
-	var stat syscall.Statfs_t
-	syscall.Statfs(path, &stat)
+	total, _, _, err := fsStat(path)
+	if err != nil {
+		return 0
+	}
 
-	return stat.Blocks * uint64(stat.Bsize)
+	return total
 }
 
 // GetFreeBytes gets free bytes in filesystem.
 func GetFreeBytes(path string) uint64 {
-	var stat syscall.Statfs_t
-	syscall.Statfs(path, &stat)
+	_, free, _, err := fsStat(path)
+	if err != nil {
+		return 0
+	}
 
-	return stat.Bfree * uint64(stat.Bsize)
+	return free
 }
 
 // GetAvailableBytes gets available bytes in filesystem.
 func GetAvailableBytes(path string) uint64 {
-	var stat syscall.Statfs_t
-	syscall.Statfs(path, &stat)
+	_, _, available, err := fsStat(path)
+	if err != nil {
+		return 0
+	}
 
-	return stat.Bavail * uint64(stat.Bsize)
+	return available
 }
 
 // GetUsedBytes gets used bytes in filesystem.
 func GetUsedBytes(path string) uint64 {
-	var stat syscall.Statfs_t
-	syscall.Statfs(path, &stat)
+	total, free, _, err := fsStat(path)
+	if err != nil {
+		return 0
+	}
 
-	return (stat.Blocks - stat.Bfree) * uint64(stat.Bsize)
+	return total - free
 }
 
-// DiskUsage calculates the disk usage of a directory by executing "du" command.
+// DiskUsage calculates the disk usage of a directory in bytes by walking the file tree natively.
+// The traversal is cancelled once diskUsageTimeout elapses.
 func DiskUsage(path string) (int, error) {
-	// Uses the same niceness level as cadvisor.fs does when running "du".
-	// Uses -B 1 to always scale to a blocksize of 1 byte.
-	// Set 10 seconds timeout for "du" command.
-	command := []string{"nice", "-n", "19", "du", "-s", "-B", "1", path}
-	out, err := BlockingRunCommandWithTimeout(command, 60)
+	ctx, cancel := context.WithTimeout(context.Background(), diskUsageTimeout)
+	defer cancel()
+
+	return DiskUsageWithContext(ctx, path)
+}
+
+// DiskUsageWithContext calculates the disk usage of a directory in bytes by walking the file tree
+// natively instead of executing "du". Directory reads are bounded by diskUsageWorkers so large
+// volumes do not hammer io, and the traversal stops once the context is cancelled.
+func DiskUsageWithContext(ctx context.Context, path string) (int, error) {
+	info, err := os.Lstat(path)
 	if err != nil {
-		return 0, fmt.Errorf("execute command du ($ nice -n 19 du -s -B 1) on path %s with error %v", path, err)
+		return 0, fmt.Errorf("unable to calculate disk usage of %s with error %v", path, err)
+	}
+
+	var (
+		size    int64
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		walkErr error
+	)
+	// The semaphore bounds the number of concurrent directory reads.
+	semaphore := make(chan struct{}, diskUsageWorkers)
+
+	var walk func(dir string)
+	walk = func(dir string) {
+		defer wg.Done()
+		if ctx.Err() != nil {
+			errOnce.Do(func() { walkErr = ctx.Err() })
+			return
+		}
+
+		semaphore <- struct{}{}
+		entries, err := ioutil.ReadDir(dir)
+		<-semaphore
+		if err != nil {
+			// Tolerate entries removed during the traversal.
+			if os.IsNotExist(err) {
+				return
+			}
+			errOnce.Do(func() { walkErr = err })
+			return
+		}
+
+		for _, entry := range entries {
+			// Skip symbolic links to avoid counting targets outside the directory.
+			if entry.Mode()&os.ModeSymlink != 0 {
+				continue
+			}
+			atomic.AddInt64(&size, entry.Size())
+			if entry.IsDir() {
+				wg.Add(1)
+				go walk(filepath.Join(dir, entry.Name()))
+			}
+		}
 	}
 
-	size, err := strconv.Atoi(strings.Fields(string(out))[0])
-	if err != nil {
-		return 0, fmt.Errorf("unable to parse du output %s due to error %v", out, err)
+	atomic.AddInt64(&size, info.Size())
+	if info.IsDir() {
+		wg.Add(1)
+		walk(path)
+		wg.Wait()
+	}
+
+	if walkErr != nil {
+		return 0, fmt.Errorf("unable to calculate disk usage of %s with error %v", path, walkErr)
 	}
 
-	return size, nil
+	return int(atomic.LoadInt64(&size)), nil
 }
 
-// GetProgramPID finds the process ID of a running program by executing "pidof" command.
+// GetProgramPID finds the process IDs of a running program by scanning the proc filesystem,
+// matching on the command name and command line of every process.
 func GetProgramPID(program string) ([]int, error) {
-	command := []string{"pidof", program}
-	out, err := BlockingRunCommandWithTimeout(command, 60)
+	processes, err := FindProcesses(program, "")
 	if err != nil {
-		return nil, fmt.Errorf("execute command pidof %s with error %v", program, err)
+		return nil, err
 	}
 
-	pids := make([]int, 0)
-	pidStrs := strings.Fields(string(out))
-	for _, pidStr := range pidStrs {
-		pid, err := strconv.Atoi(pidStr)
-		if err != nil {
-			return nil, fmt.Errorf("unable to parse pid string %s due to error %v", pidStr, err)
-		}
-		pids = append(pids, pid)
+	pids := make([]int, 0, len(processes))
+	for _, process := range processes {
+		pids = append(pids, process.PID)
 	}
 
 	if len(pids) == 0 {
@@ -576,8 +814,7 @@ func MoveFiles(src string, dst string) error {
 
 		// Move file to dst directory.
 		FilePath = filepath.Join(dst, file.Name())
-		_, err := BlockingRunCommandWithTimeout([]string{"mv", filepath.Join(src, file.Name()), FilePath}, 30)
-		if err != nil {
+		if err := MoveFile(filepath.Join(src, file.Name()), FilePath); err != nil {
 			return err
 		}
 	}
@@ -585,12 +822,38 @@ func MoveFiles(src string, dst string) error {
 	return nil
 }
 
-// RemoveFile removes a file or a directory by executing "rm" command.
-func RemoveFile(path string) error {
-	command := []string{"rm", "-r", "-f", path}
-	_, err := BlockingRunCommandWithTimeout(command, 60)
+// MoveFile moves a file or a directory with os.Rename and falls back to a copy and remove when
+// the source and destination are on different file systems.
+func MoveFile(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	if linkErr, ok := err.(*os.LinkError); !ok || linkErr.Err != syscall.EXDEV {
+		return err
+	}
+
+	info, err := os.Stat(src)
 	if err != nil {
-		return fmt.Errorf("execute command rm ($ rm -r -f) on path %s with error %v", path, err)
+		return err
+	}
+	if info.IsDir() {
+		if err := CopyDir(src, dst); err != nil {
+			return err
+		}
+	} else {
+		if err := CopyFile(src, dst); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(src)
+}
+
+// RemoveFile removes a file or a directory.
+func RemoveFile(path string) error {
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("unable to remove path %s with error %v", path, err)
 	}
 
 	return nil
@@ -619,25 +882,25 @@ func ParseHPROFFile(workdir string, path string, timeoutSeconds int32) error {
 	return nil
 }
 
-// DecompressHPROFFileArchives decompresses result archives from hprof files by executing "unzip" command.
-func DecompressHPROFFileArchives(dirname string, fileInfo os.FileInfo, timeoutSeconds int32) (string, string, string, error) {
+// DecompressHPROFFileArchives decompresses result archives from hprof files.
+func DecompressHPROFFileArchives(dirname string, fileInfo os.FileInfo) (string, string, string, error) {
 	leakSuspectsFilePath := filepath.Join(dirname, strings.TrimSuffix(fileInfo.Name(), filepath.Ext(fileInfo.Name()))+MemoryAnalyzerLeakSuspectsSuffix+".zip")
 	leakSuspectsDirectoryPath := filepath.Join(dirname, strings.TrimSuffix(fileInfo.Name(), filepath.Ext(fileInfo.Name()))+MemoryAnalyzerLeakSuspectsSuffix)
-	err := Unzip(leakSuspectsFilePath, leakSuspectsDirectoryPath, timeoutSeconds)
+	err := Unzip(leakSuspectsFilePath, leakSuspectsDirectoryPath)
 	if err != nil {
 		return "", "", "", err
 	}
 
 	systemOverviewFilePath := filepath.Join(dirname, strings.TrimSuffix(fileInfo.Name(), filepath.Ext(fileInfo.Name()))+MemoryAnalyzerSystemOverviewSuffix+".zip")
 	systemOverviewDirectoryPath := filepath.Join(dirname, strings.TrimSuffix(fileInfo.Name(), filepath.Ext(fileInfo.Name()))+MemoryAnalyzerSystemOverviewSuffix)
-	err = Unzip(systemOverviewFilePath, systemOverviewDirectoryPath, timeoutSeconds)
+	err = Unzip(systemOverviewFilePath, systemOverviewDirectoryPath)
 	if err != nil {
 		return "", "", "", err
 	}
 
 	topComponentsFilePath := filepath.Join(dirname, strings.TrimSuffix(fileInfo.Name(), filepath.Ext(fileInfo.Name()))+MemoryAnalyzerTopComponentsSuffix+".zip")
 	topComponentsDirectoryPath := filepath.Join(dirname, strings.TrimSuffix(fileInfo.Name(), filepath.Ext(fileInfo.Name()))+MemoryAnalyzerTopComponentsSuffix)
-	err = Unzip(topComponentsFilePath, topComponentsDirectoryPath, timeoutSeconds)
+	err = Unzip(topComponentsFilePath, topComponentsDirectoryPath)
 	if err != nil {
 		return "", "", "", err
 	}
@@ -645,28 +908,92 @@ func DecompressHPROFFileArchives(dirname string, fileInfo os.FileInfo, timeoutSe
 	return leakSuspectsDirectoryPath, systemOverviewDirectoryPath, topComponentsDirectoryPath, nil
 }
 
-// Unzip decompresses a zip archive, moving all files and folders within the zip file to an output directory
-// by executing "unzip" command.
-// It takes source zip file, destination output directory and timeout seconds as parameters.
-func Unzip(src string, dst string, timeoutSeconds int32) error {
-	_, err := BlockingRunCommandWithTimeout([]string{"unzip", src, "-d", dst}, timeoutSeconds)
+// Unzip decompresses a zip archive, moving all files and folders within the zip file to an output directory.
+// It takes source zip file and destination output directory as parameters.
+func Unzip(src string, dst string) error {
+	reader, err := zip.OpenReader(src)
 	if err != nil {
 		return fmt.Errorf("unzip file %s to %s with error %v", src, dst, err)
 	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		path := filepath.Join(dst, file.Name)
+		// Reject file paths escaping the output directory.
+		if !strings.HasPrefix(path, filepath.Clean(dst)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid file path %s in archive %s", file.Name, src)
+		}
+
+		if file.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, file.Mode()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := ensureBaseDir(path); err != nil {
+			return err
+		}
+		in, err := file.Open()
+		if err != nil {
+			return err
+		}
+		out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
+		if err != nil {
+			in.Close()
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		out.Close()
+		if err != nil {
+			return fmt.Errorf("unzip file %s to %s with error %v", src, dst, err)
+		}
+	}
 
 	return nil
 }
 
-// BlockingRunCommandWithTimeout executes command in blocking mode with timeout seconds.
+// shutdownContext is the base context of executed commands. It is replaced with a cancellable
+// context during startup, so in-flight child processes such as tcpdump and memory analyzer runs
+// are terminated cleanly on shutdown instead of being orphaned.
+var shutdownContext = context.Background()
+
+// SetShutdownContext installs the base context of executed commands. Child processes are killed
+// once the context is cancelled.
+func SetShutdownContext(ctx context.Context) {
+	shutdownContext = ctx
+}
+
+// CommandRunner runs a command with a timeout in seconds and returns its combined output.
+type CommandRunner func(command []string, timeoutSeconds int32) ([]byte, error)
+
+// commandRunner is the installed command runner. Commands are executed in process if nil.
+var commandRunner CommandRunner
+
+// SetCommandRunner installs a command runner which host commands are delegated to. It is used by
+// an unprivileged agent to run host commands through the privileged helper.
+func SetCommandRunner(runner CommandRunner) {
+	commandRunner = runner
+}
+
+// BlockingRunCommandWithTimeout executes command in blocking mode with timeout seconds. The
+// command is killed once the timeout elapses. Commands are delegated to the installed command
+// runner if one is set.
 func BlockingRunCommandWithTimeout(command []string, timeoutSeconds int32) ([]byte, error) {
-	timeoutCommand := []string{"timeout", fmt.Sprintf("%ds", timeoutSeconds)}
-	timeoutCommand = append(timeoutCommand, command...)
-	out, err := exec.Command(timeoutCommand[0], timeoutCommand[1:]...).CombinedOutput()
-	if err != nil {
-		return out, err
+	if commandRunner != nil {
+		return commandRunner(command, timeoutSeconds)
 	}
 
-	return out, nil
+	ctx, cancel := context.WithTimeout(shutdownContext, time.Duration(timeoutSeconds)*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, command[0], command[1:]...).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return out, fmt.Errorf("command %v timed out after %d seconds", command, timeoutSeconds)
+	}
+
+	return out, err
 }
 
 // GetAvailablePort returns a free open port that is ready to use.
@@ -698,6 +1025,18 @@ func StringToNamespacedName(s string) (types.NamespacedName, error) {
 	}, nil
 }
 
+// ShardForKey returns the shard a key in namespace/name format belongs to. Keys are distributed
+// over shards with a fnv hash so diagnoses can be partitioned across multiple master replicas.
+func ShardForKey(key string, totalShards int) int {
+	if totalShards <= 1 {
+		return 0
+	}
+
+	hasher := fnv.New32a()
+	hasher.Write([]byte(key))
+	return int(hasher.Sum32() % uint32(totalShards))
+}
+
 // ComputeHash returns a hash value calculated from a template. The hash will be safe encoded to avoid bad words.
 func ComputeHash(template interface{}) string {
 	hasher := fnv.New32a()
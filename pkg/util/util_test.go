@@ -17,6 +17,7 @@ limitations under the License.
 package util
 
 import (
+	"fmt"
 	"net/url"
 	"testing"
 
@@ -759,3 +760,49 @@ func TestRemoveDuplicateStrings(t *testing.T) {
 		assert.Equal(t, test.expected, dedup, test.desc)
 	}
 }
+
+func TestShardForKey(t *testing.T) {
+	tests := []struct {
+		key         string
+		totalShards int
+		desc        string
+	}{
+		{
+			key:         "default/diagnosis",
+			totalShards: 1,
+			desc:        "single shard",
+		},
+		{
+			key:         "default/diagnosis",
+			totalShards: 3,
+			desc:        "multiple shards",
+		},
+		{
+			key:         "",
+			totalShards: 3,
+			desc:        "empty key",
+		},
+		{
+			key:         "kube-system/node-memory-investigation",
+			totalShards: 16,
+			desc:        "many shards",
+		},
+	}
+
+	for _, test := range tests {
+		shard := ShardForKey(test.key, test.totalShards)
+		assert.True(t, shard >= 0 && shard < test.totalShards, test.desc)
+		assert.Equal(t, shard, ShardForKey(test.key, test.totalShards), test.desc)
+	}
+
+	assert.Equal(t, 0, ShardForKey("default/diagnosis", 0), "zero total shards")
+	assert.Equal(t, 0, ShardForKey("default/diagnosis", -1), "negative total shards")
+
+	// Keys must spread over all shards instead of clustering on a few of them.
+	totalShards := 4
+	seen := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		seen[ShardForKey(fmt.Sprintf("default/diagnosis-%d", i), totalShards)] = true
+	}
+	assert.Equal(t, totalShards, len(seen), "keys are distributed over all shards")
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wasm
+
+import (
+	"fmt"
+	"time"
+)
+
+// HostFunction is a function provided by the host to a module. Arguments and results are
+// passed as raw WebAssembly values.
+type HostFunction func(instance *Instance, args []uint64) ([]uint64, error)
+
+// Instance is an instantiated module ready for execution.
+type Instance struct {
+	// module is the decoded module of the instance.
+	module *Module
+	// memory is the linear memory of the instance.
+	memory []byte
+	// globals contains the values of all global variables.
+	globals []uint64
+	// table contains function indexes, with negative entries marking empty slots.
+	table []int
+	// hostFunctions contains the resolved host functions aligned with the function imports
+	// of the module.
+	hostFunctions []HostFunction
+	// deadline is the point in time at which execution traps, or zero for no deadline.
+	deadline time.Time
+	// steps counts executed instructions for periodic deadline checks.
+	steps uint64
+	// depth is the current depth of nested calls.
+	depth int
+}
+
+// Instantiate instantiates a module with the provided host functions keyed by the module and
+// field name of the import joined with a dot. The start function of the module is run as
+// part of instantiation.
+func Instantiate(module *Module, hostFunctions map[string]HostFunction) (*Instance, error) {
+	instance := &Instance{
+		module: module,
+		memory: make([]byte, int(module.MemoryMin)*PageSize),
+	}
+
+	for _, imported := range module.ImportedFunctions {
+		hostFunction, ok := hostFunctions[imported.Module+"."+imported.Name]
+		if !ok {
+			return nil, fmt.Errorf("unresolved import %s.%s", imported.Module, imported.Name)
+		}
+		instance.hostFunctions = append(instance.hostFunctions, hostFunction)
+	}
+
+	for _, global := range module.Globals {
+		value, err := instance.evalConstExpr(global.Init)
+		if err != nil {
+			return nil, fmt.Errorf("unable to initialize global: %s", err)
+		}
+		instance.globals = append(instance.globals, value)
+	}
+
+	instance.table = make([]int, module.TableMin)
+	for i := range instance.table {
+		instance.table[i] = -1
+	}
+	for _, element := range module.Elements {
+		offset, err := instance.evalConstExpr(element.Offset)
+		if err != nil {
+			return nil, fmt.Errorf("unable to initialize table: %s", err)
+		}
+		for i, index := range element.Indexes {
+			position := int(uint32(offset)) + i
+			if position >= len(instance.table) {
+				return nil, fmt.Errorf("table element offset %d out of bounds", position)
+			}
+			instance.table[position] = int(index)
+		}
+	}
+
+	for _, segment := range module.DataSegments {
+		offset, err := instance.evalConstExpr(segment.Offset)
+		if err != nil {
+			return nil, fmt.Errorf("unable to initialize memory: %s", err)
+		}
+		position := int(uint32(offset))
+		if position+len(segment.Bytes) > len(instance.memory) {
+			return nil, fmt.Errorf("data segment offset %d out of bounds", position)
+		}
+		copy(instance.memory[position:], segment.Bytes)
+	}
+
+	if module.StartIndex != nil {
+		if _, err := instance.call(*module.StartIndex, nil); err != nil {
+			return nil, fmt.Errorf("unable to run start function: %s", err)
+		}
+	}
+
+	return instance, nil
+}
+
+// SetDeadline sets the point in time at which execution traps.
+func (in *Instance) SetDeadline(deadline time.Time) {
+	in.deadline = deadline
+}
+
+// Memory returns the linear memory of the instance.
+func (in *Instance) Memory() []byte {
+	return in.memory
+}
+
+// Call invokes an exported function of the instance.
+func (in *Instance) Call(name string, args ...uint64) ([]uint64, error) {
+	export, ok := in.module.Exports[name]
+	if !ok || export.Kind != FunctionKind {
+		return nil, fmt.Errorf("function %s is not exported", name)
+	}
+	return in.call(export.Index, args)
+}
+
+// call invokes the function with the provided index converting execution panics of
+// malformed modules into traps.
+func (in *Instance) call(index uint32, args []uint64) (results []uint64, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			results = nil
+			err = newTrap("invalid module execution: %v", recovered)
+		}
+	}()
+	return in.callFunction(index, args)
+}
+
+// evalConstExpr evaluates a constant initializer expression.
+func (in *Instance) evalConstExpr(expr []byte) (uint64, error) {
+	r := &reader{data: expr}
+	var value uint64
+	for {
+		opcode, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		switch opcode {
+		case 0x0B:
+			return value, nil
+		case 0x41:
+			signed, err := r.int64()
+			if err != nil {
+				return 0, err
+			}
+			value = uint64(uint32(int32(signed)))
+		case 0x42:
+			signed, err := r.int64()
+			if err != nil {
+				return 0, err
+			}
+			value = uint64(signed)
+		case 0x43:
+			raw, err := r.bytes(4)
+			if err != nil {
+				return 0, err
+			}
+			value = uint64(uint32(raw[0]) | uint32(raw[1])<<8 | uint32(raw[2])<<16 | uint32(raw[3])<<24)
+		case 0x44:
+			raw, err := r.bytes(8)
+			if err != nil {
+				return 0, err
+			}
+			value = 0
+			for i := 7; i >= 0; i-- {
+				value = value<<8 | uint64(raw[i])
+			}
+		case 0x23:
+			index, err := r.uint32()
+			if err != nil {
+				return 0, err
+			}
+			if int(index) >= len(in.globals) {
+				return 0, fmt.Errorf("invalid global index %d", index)
+			}
+			value = in.globals[index]
+		default:
+			return 0, fmt.Errorf("unsupported constant expression opcode %#x", opcode)
+		}
+	}
+}
@@ -0,0 +1,655 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wasm
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// maxCallDepth bounds the depth of nested calls, so a runaway recursion in a module traps
+// instead of exhausting the agent stack.
+const maxCallDepth = 512
+
+// maxMemoryPages bounds the linear memory of a module to one gigabyte, so a runaway module
+// cannot exhaust the agent memory.
+const maxMemoryPages = 16384
+
+// loadSizes maps a memory load opcode to the width of its access in bytes.
+var loadSizes = map[byte]int{0x28: 4, 0x29: 8, 0x2A: 4, 0x2B: 8, 0x2C: 1, 0x2D: 1, 0x2E: 2, 0x2F: 2, 0x30: 1, 0x31: 1, 0x32: 2, 0x33: 2, 0x34: 4, 0x35: 4}
+
+// storeSizes maps a memory store opcode to the width of its access in bytes.
+var storeSizes = map[byte]int{0x36: 4, 0x37: 8, 0x38: 4, 0x39: 8, 0x3A: 1, 0x3B: 2, 0x3C: 1, 0x3D: 2, 0x3E: 4}
+
+// index locates the matching end and else opcodes of all structured control instructions of
+// the function body, so branches are direct jumps during execution.
+func (c *Code) index() error {
+	c.ends = make(map[int]int)
+	c.elses = make(map[int]int)
+	r := &reader{data: c.Body}
+	var openings []int
+	for !r.empty() {
+		offset := r.pos
+		opcode, err := r.byte()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case 0x02, 0x03, 0x04:
+			if _, err := r.int64(); err != nil {
+				return err
+			}
+			openings = append(openings, offset)
+		case 0x05:
+			if len(openings) == 0 {
+				return fmt.Errorf("else without an open if at offset %d", offset)
+			}
+			c.elses[openings[len(openings)-1]] = r.pos
+		case 0x0B:
+			if len(openings) != 0 {
+				c.ends[openings[len(openings)-1]] = r.pos
+				openings = openings[:len(openings)-1]
+			}
+		default:
+			if err := skipImmediates(r, opcode); err != nil {
+				return err
+			}
+		}
+	}
+	if len(openings) != 0 {
+		return fmt.Errorf("unclosed block at offset %d", openings[len(openings)-1])
+	}
+	return nil
+}
+
+// skipImmediates advances the reader past the immediates of the instruction.
+func skipImmediates(r *reader, opcode byte) error {
+	switch {
+	case opcode == 0x00 || opcode == 0x01 || opcode == 0x0F || opcode == 0x1A || opcode == 0x1B:
+		return nil
+	case opcode == 0x0C || opcode == 0x0D || opcode == 0x10:
+		_, err := r.uint32()
+		return err
+	case opcode == 0x0E:
+		count, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		for i := uint32(0); i <= count; i++ {
+			if _, err := r.uint32(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case opcode == 0x11:
+		if _, err := r.uint32(); err != nil {
+			return err
+		}
+		_, err := r.uint32()
+		return err
+	case opcode >= 0x20 && opcode <= 0x24:
+		_, err := r.uint32()
+		return err
+	case opcode >= 0x28 && opcode <= 0x3E:
+		if _, err := r.uint32(); err != nil {
+			return err
+		}
+		_, err := r.uint32()
+		return err
+	case opcode == 0x3F || opcode == 0x40:
+		_, err := r.uint32()
+		return err
+	case opcode == 0x41 || opcode == 0x42:
+		_, err := r.int64()
+		return err
+	case opcode == 0x43:
+		_, err := r.bytes(4)
+		return err
+	case opcode == 0x44:
+		_, err := r.bytes(8)
+		return err
+	case opcode >= 0x45 && opcode <= 0xC4:
+		return nil
+	case opcode == 0xFC:
+		sub, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		switch {
+		case sub <= 7:
+			return nil
+		case sub == 10:
+			if _, err := r.uint32(); err != nil {
+				return err
+			}
+			_, err := r.uint32()
+			return err
+		case sub == 11:
+			_, err := r.uint32()
+			return err
+		default:
+			return fmt.Errorf("unsupported extended opcode %d", sub)
+		}
+	default:
+		return fmt.Errorf("unsupported opcode %#x", opcode)
+	}
+}
+
+// trap is an error raised by the execution of a module.
+type trap struct {
+	reason string
+}
+
+// Error implements the error interface.
+func (t *trap) Error() string {
+	return fmt.Sprintf("wasm trap: %s", t.reason)
+}
+
+// newTrap returns a trap with the provided reason.
+func newTrap(format string, args ...interface{}) error {
+	return &trap{reason: fmt.Sprintf(format, args...)}
+}
+
+// label tracks an open structured control instruction during execution.
+type label struct {
+	// isLoop indicates whether a branch continues at the start of the body.
+	isLoop bool
+	// arity is the number of values carried by a branch to the label.
+	arity int
+	// height is the operand stack height at entry of the block.
+	height int
+	// cont is the instruction offset a branch to the label jumps to.
+	cont int
+}
+
+// frame is the operand stack of a single function activation.
+type frame struct {
+	stack []uint64
+}
+
+// push pushes a value.
+func (f *frame) push(v uint64) {
+	f.stack = append(f.stack, v)
+}
+
+// pop pops a value.
+func (f *frame) pop() uint64 {
+	v := f.stack[len(f.stack)-1]
+	f.stack = f.stack[:len(f.stack)-1]
+	return v
+}
+
+// pushI32 pushes a 32 bit integer.
+func (f *frame) pushI32(v uint32) {
+	f.push(uint64(v))
+}
+
+// popI32 pops a 32 bit integer.
+func (f *frame) popI32() uint32 {
+	return uint32(f.pop())
+}
+
+// pushBool pushes a boolean as a 32 bit integer.
+func (f *frame) pushBool(v bool) {
+	if v {
+		f.push(1)
+	} else {
+		f.push(0)
+	}
+}
+
+// pushF32 pushes a 32 bit float.
+func (f *frame) pushF32(v float32) {
+	f.push(uint64(math.Float32bits(v)))
+}
+
+// popF32 pops a 32 bit float.
+func (f *frame) popF32() float32 {
+	return math.Float32frombits(uint32(f.pop()))
+}
+
+// pushF64 pushes a 64 bit float.
+func (f *frame) pushF64(v float64) {
+	f.push(math.Float64bits(v))
+}
+
+// popF64 pops a 64 bit float.
+func (f *frame) popF64() float64 {
+	return math.Float64frombits(f.pop())
+}
+
+// blockArity returns the parameter and result count of a block type.
+func (in *Instance) blockArity(blockType int64) (int, int, error) {
+	switch blockType {
+	case -64:
+		return 0, 0, nil
+	case -1, -2, -3, -4:
+		return 0, 1, nil
+	default:
+		if blockType < 0 || int(blockType) >= len(in.module.Types) {
+			return 0, 0, newTrap("invalid block type %d", blockType)
+		}
+		funcType := in.module.Types[blockType]
+		return len(funcType.Params), len(funcType.Results), nil
+	}
+}
+
+// callFunction invokes the function with the provided index in the function index space.
+func (in *Instance) callFunction(index uint32, args []uint64) ([]uint64, error) {
+	in.depth++
+	defer func() { in.depth-- }()
+	if in.depth > maxCallDepth {
+		return nil, newTrap("call stack exhausted")
+	}
+
+	if int(index) < len(in.module.ImportedFunctions) {
+		hostFunc := in.hostFunctions[index]
+		if hostFunc == nil {
+			imported := in.module.ImportedFunctions[index]
+			return nil, newTrap("unresolved import %s.%s", imported.Module, imported.Name)
+		}
+		return hostFunc(in, args)
+	}
+
+	definedIndex := int(index) - len(in.module.ImportedFunctions)
+	if definedIndex >= len(in.module.Codes) {
+		return nil, newTrap("invalid function index %d", index)
+	}
+	code := &in.module.Codes[definedIndex]
+	funcType, err := in.module.typeOfFunction(index)
+	if err != nil {
+		return nil, newTrap("%s", err)
+	}
+
+	locals := make([]uint64, len(funcType.Params)+len(code.Locals))
+	copy(locals, args)
+
+	return in.execute(code, locals, len(funcType.Results))
+}
+
+// execute runs a function body and returns its results.
+func (in *Instance) execute(code *Code, locals []uint64, resultCount int) ([]uint64, error) {
+	f := &frame{stack: make([]uint64, 0, 16)}
+	var labels []label
+	body := code.Body
+	r := &reader{data: body}
+
+	// branch performs a branch to the label with the provided relative depth.
+	branch := func(depth int) error {
+		if depth >= len(labels) {
+			return newTrap("invalid branch depth %d", depth)
+		}
+		target := labels[len(labels)-1-depth]
+		values := make([]uint64, target.arity)
+		copy(values, f.stack[len(f.stack)-target.arity:])
+		f.stack = append(f.stack[:target.height], values...)
+		r.pos = target.cont
+		if target.isLoop {
+			labels = labels[:len(labels)-depth]
+		} else {
+			labels = labels[:len(labels)-1-depth]
+		}
+		return nil
+	}
+
+	for {
+		in.steps++
+		if in.steps&0xFFFF == 0 && !in.deadline.IsZero() && time.Now().After(in.deadline) {
+			return nil, newTrap("execution timed out")
+		}
+
+		offset := r.pos
+		opcode, err := r.byte()
+		if err != nil {
+			return nil, newTrap("unexpected end of function body")
+		}
+
+		switch opcode {
+		case 0x00:
+			return nil, newTrap("unreachable executed")
+		case 0x01:
+		case 0x02, 0x03:
+			blockType, err := r.int64()
+			if err != nil {
+				return nil, err
+			}
+			params, results, err := in.blockArity(blockType)
+			if err != nil {
+				return nil, err
+			}
+			if opcode == 0x03 {
+				labels = append(labels, label{isLoop: true, arity: params, height: len(f.stack) - params, cont: r.pos})
+			} else {
+				labels = append(labels, label{arity: results, height: len(f.stack) - params, cont: code.ends[offset]})
+			}
+		case 0x04:
+			blockType, err := r.int64()
+			if err != nil {
+				return nil, err
+			}
+			params, results, err := in.blockArity(blockType)
+			if err != nil {
+				return nil, err
+			}
+			condition := f.popI32()
+			labels = append(labels, label{arity: results, height: len(f.stack) - params, cont: code.ends[offset]})
+			if condition == 0 {
+				if elseOffset := code.elses[offset]; elseOffset != 0 {
+					r.pos = elseOffset
+				} else {
+					r.pos = code.ends[offset]
+					labels = labels[:len(labels)-1]
+				}
+			}
+		case 0x05:
+			// The then branch is complete, so execution continues after the matching end.
+			r.pos = labels[len(labels)-1].cont
+			labels = labels[:len(labels)-1]
+		case 0x0B:
+			if len(labels) == 0 {
+				results := make([]uint64, resultCount)
+				copy(results, f.stack[len(f.stack)-resultCount:])
+				return results, nil
+			}
+			labels = labels[:len(labels)-1]
+		case 0x0C:
+			depth, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			if err := branch(int(depth)); err != nil {
+				return nil, err
+			}
+		case 0x0D:
+			depth, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			if f.popI32() != 0 {
+				if err := branch(int(depth)); err != nil {
+					return nil, err
+				}
+			}
+		case 0x0E:
+			count, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			targets := make([]uint32, count+1)
+			for i := range targets {
+				if targets[i], err = r.uint32(); err != nil {
+					return nil, err
+				}
+			}
+			index := f.popI32()
+			if index >= count {
+				index = count
+			}
+			if err := branch(int(targets[index])); err != nil {
+				return nil, err
+			}
+		case 0x0F:
+			results := make([]uint64, resultCount)
+			copy(results, f.stack[len(f.stack)-resultCount:])
+			return results, nil
+		case 0x10:
+			index, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			if err := in.invoke(f, index); err != nil {
+				return nil, err
+			}
+		case 0x11:
+			typeIndex, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := r.uint32(); err != nil {
+				return nil, err
+			}
+			element := f.popI32()
+			if int(element) >= len(in.table) || in.table[element] < 0 {
+				return nil, newTrap("undefined table element %d", element)
+			}
+			index := uint32(in.table[element])
+			funcType, err := in.module.typeOfFunction(index)
+			if err != nil {
+				return nil, newTrap("%s", err)
+			}
+			if int(typeIndex) >= len(in.module.Types) || !sameFuncType(funcType, in.module.Types[typeIndex]) {
+				return nil, newTrap("indirect call signature mismatch")
+			}
+			if err := in.invoke(f, index); err != nil {
+				return nil, err
+			}
+		case 0x1A:
+			f.pop()
+		case 0x1B:
+			condition := f.popI32()
+			second := f.pop()
+			first := f.pop()
+			if condition != 0 {
+				f.push(first)
+			} else {
+				f.push(second)
+			}
+		case 0x20:
+			index, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			f.push(locals[index])
+		case 0x21:
+			index, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			locals[index] = f.pop()
+		case 0x22:
+			index, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			locals[index] = f.stack[len(f.stack)-1]
+		case 0x23:
+			index, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			f.push(in.globals[index])
+		case 0x24:
+			index, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			in.globals[index] = f.pop()
+		case 0x28, 0x29, 0x2A, 0x2B, 0x2C, 0x2D, 0x2E, 0x2F, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35:
+			if err := in.load(f, r, opcode); err != nil {
+				return nil, err
+			}
+		case 0x36, 0x37, 0x38, 0x39, 0x3A, 0x3B, 0x3C, 0x3D, 0x3E:
+			if err := in.store(f, r, opcode); err != nil {
+				return nil, err
+			}
+		case 0x3F:
+			if _, err := r.uint32(); err != nil {
+				return nil, err
+			}
+			f.pushI32(uint32(len(in.memory) / PageSize))
+		case 0x40:
+			if _, err := r.uint32(); err != nil {
+				return nil, err
+			}
+			f.pushI32(in.growMemory(f.popI32()))
+		case 0x41:
+			value, err := r.int64()
+			if err != nil {
+				return nil, err
+			}
+			f.pushI32(uint32(int32(value)))
+		case 0x42:
+			value, err := r.int64()
+			if err != nil {
+				return nil, err
+			}
+			f.push(uint64(value))
+		case 0x43:
+			raw, err := r.bytes(4)
+			if err != nil {
+				return nil, err
+			}
+			f.push(uint64(uint32(raw[0]) | uint32(raw[1])<<8 | uint32(raw[2])<<16 | uint32(raw[3])<<24))
+		case 0x44:
+			raw, err := r.bytes(8)
+			if err != nil {
+				return nil, err
+			}
+			var value uint64
+			for i := 7; i >= 0; i-- {
+				value = value<<8 | uint64(raw[i])
+			}
+			f.push(value)
+		default:
+			if err := in.executeNumeric(f, r, opcode); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// invoke calls a function popping its arguments from and pushing its results to the frame.
+func (in *Instance) invoke(f *frame, index uint32) error {
+	funcType, err := in.module.typeOfFunction(index)
+	if err != nil {
+		return newTrap("%s", err)
+	}
+	args := make([]uint64, len(funcType.Params))
+	copy(args, f.stack[len(f.stack)-len(args):])
+	f.stack = f.stack[:len(f.stack)-len(args)]
+	results, err := in.callFunction(index, args)
+	if err != nil {
+		return err
+	}
+	f.stack = append(f.stack, results...)
+	return nil
+}
+
+// sameFuncType reports whether two function signatures are identical.
+func sameFuncType(a, b FuncType) bool {
+	if len(a.Params) != len(b.Params) || len(a.Results) != len(b.Results) {
+		return false
+	}
+	for i := range a.Params {
+		if a.Params[i] != b.Params[i] {
+			return false
+		}
+	}
+	for i := range a.Results {
+		if a.Results[i] != b.Results[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// effectiveAddress pops the base address and returns the bounds checked effective address of
+// a memory access.
+func (in *Instance) effectiveAddress(f *frame, r *reader, size int) (int, error) {
+	if _, err := r.uint32(); err != nil {
+		return 0, err
+	}
+	offset, err := r.uint32()
+	if err != nil {
+		return 0, err
+	}
+	address := uint64(f.popI32()) + uint64(offset)
+	if address+uint64(size) > uint64(len(in.memory)) {
+		return 0, newTrap("out of bounds memory access at %d", address)
+	}
+	return int(address), nil
+}
+
+// load executes a memory load instruction.
+func (in *Instance) load(f *frame, r *reader, opcode byte) error {
+	address, err := in.effectiveAddress(f, r, loadSizes[opcode])
+	if err != nil {
+		return err
+	}
+	memory := in.memory[address:]
+	var raw uint64
+	for i := loadSizes[opcode] - 1; i >= 0; i-- {
+		raw = raw<<8 | uint64(memory[i])
+	}
+	switch opcode {
+	case 0x28, 0x2A:
+		f.push(raw)
+	case 0x29, 0x2B:
+		f.push(raw)
+	case 0x2C:
+		f.pushI32(uint32(int32(int8(raw))))
+	case 0x2D:
+		f.pushI32(uint32(raw))
+	case 0x2E:
+		f.pushI32(uint32(int32(int16(raw))))
+	case 0x2F:
+		f.pushI32(uint32(raw))
+	case 0x30:
+		f.push(uint64(int64(int8(raw))))
+	case 0x31:
+		f.push(raw)
+	case 0x32:
+		f.push(uint64(int64(int16(raw))))
+	case 0x33:
+		f.push(raw)
+	case 0x34:
+		f.push(uint64(int64(int32(raw))))
+	case 0x35:
+		f.push(raw)
+	}
+	return nil
+}
+
+// store executes a memory store instruction.
+func (in *Instance) store(f *frame, r *reader, opcode byte) error {
+	value := f.pop()
+	address, err := in.effectiveAddress(f, r, storeSizes[opcode])
+	if err != nil {
+		return err
+	}
+	memory := in.memory[address:]
+	for i := 0; i < storeSizes[opcode]; i++ {
+		memory[i] = byte(value >> (8 * uint(i)))
+	}
+	return nil
+}
+
+// growMemory grows the linear memory by the provided number of pages and returns the
+// previous size, or the all ones value if the memory cannot grow.
+func (in *Instance) growMemory(pages uint32) uint32 {
+	current := uint32(len(in.memory) / PageSize)
+	max := in.module.MemoryMax
+	if max == 0 || max > maxMemoryPages {
+		max = maxMemoryPages
+	}
+	if uint64(current)+uint64(pages) > uint64(max) {
+		return 0xFFFFFFFF
+	}
+	in.memory = append(in.memory, make([]byte, int(pages)*PageSize)...)
+	return current
+}
@@ -0,0 +1,869 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wasm
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// uleb encodes an unsigned integer in LEB128.
+func uleb(value uint64) []byte {
+	var out []byte
+	for {
+		b := byte(value & 0x7F)
+		value >>= 7
+		if value != 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if value == 0 {
+			return out
+		}
+	}
+}
+
+// sleb encodes a signed integer in LEB128.
+func sleb(value int64) []byte {
+	var out []byte
+	for {
+		b := byte(value & 0x7F)
+		value >>= 7
+		if (value == 0 && b&0x40 == 0) || (value == -1 && b&0x40 != 0) {
+			out = append(out, b)
+			return out
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// cat concatenates byte slices.
+func cat(parts ...[]byte) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
+
+// moduleBuilder assembles WebAssembly modules in the binary format for tests.
+type moduleBuilder struct {
+	types       [][]byte
+	imports     [][]byte
+	importCount int
+	funcs       []uint32
+	table       []byte
+	memory      []byte
+	globals     [][]byte
+	exports     [][]byte
+	elements    [][]byte
+	codes       [][]byte
+	data        [][]byte
+}
+
+// addType appends a function signature and returns its type index.
+func (b *moduleBuilder) addType(params []ValueType, results []ValueType) uint32 {
+	entry := []byte{0x60, byte(len(params))}
+	for _, param := range params {
+		entry = append(entry, byte(param))
+	}
+	entry = append(entry, byte(len(results)))
+	for _, result := range results {
+		entry = append(entry, byte(result))
+	}
+	b.types = append(b.types, entry)
+	return uint32(len(b.types) - 1)
+}
+
+// addImport appends a function import and returns its function index.
+func (b *moduleBuilder) addImport(module string, name string, typeIndex uint32) uint32 {
+	entry := cat(uleb(uint64(len(module))), []byte(module), uleb(uint64(len(name))), []byte(name), []byte{FunctionKind}, uleb(uint64(typeIndex)))
+	b.imports = append(b.imports, entry)
+	b.importCount++
+	return uint32(b.importCount - 1)
+}
+
+// addFunction appends a defined function and returns its function index. The function is
+// exported under name if name is not empty. The body must include the terminating end opcode.
+func (b *moduleBuilder) addFunction(name string, typeIndex uint32, locals []ValueType, body []byte) uint32 {
+	index := uint32(b.importCount + len(b.funcs))
+	b.funcs = append(b.funcs, typeIndex)
+
+	declarations := uleb(uint64(len(locals)))
+	for _, local := range locals {
+		declarations = append(declarations, 0x01, byte(local))
+	}
+	b.codes = append(b.codes, cat(declarations, body))
+
+	if name != "" {
+		b.exports = append(b.exports, cat(uleb(uint64(len(name))), []byte(name), []byte{FunctionKind}, uleb(uint64(index))))
+	}
+	return index
+}
+
+// setMemory declares a linear memory with the provided limits. A zero max leaves the memory
+// unbounded.
+func (b *moduleBuilder) setMemory(min uint32, max uint32) {
+	if max == 0 {
+		b.memory = cat([]byte{0x00}, uleb(uint64(min)))
+		return
+	}
+	b.memory = cat([]byte{0x01}, uleb(uint64(min)), uleb(uint64(max)))
+}
+
+// setTable declares a table with the provided minimum size.
+func (b *moduleBuilder) setTable(min uint32) {
+	b.table = cat([]byte{0x70, 0x00}, uleb(uint64(min)))
+}
+
+// addGlobal appends a global variable and returns its index.
+func (b *moduleBuilder) addGlobal(valueType ValueType, mutable bool, init []byte) uint32 {
+	mutability := byte(0)
+	if mutable {
+		mutability = 1
+	}
+	b.globals = append(b.globals, cat([]byte{byte(valueType), mutability}, init))
+	return uint32(len(b.globals) - 1)
+}
+
+// addElement appends a table initializer segment placing the function indexes at offset.
+func (b *moduleBuilder) addElement(offset int32, indexes []uint32) {
+	entry := cat(uleb(0), []byte{0x41}, sleb(int64(offset)), []byte{0x0B}, uleb(uint64(len(indexes))))
+	for _, index := range indexes {
+		entry = append(entry, uleb(uint64(index))...)
+	}
+	b.elements = append(b.elements, entry)
+}
+
+// addData appends a memory initializer segment placing the bytes at offset.
+func (b *moduleBuilder) addData(offset int32, bytes []byte) {
+	b.data = append(b.data, cat(uleb(0), []byte{0x41}, sleb(int64(offset)), []byte{0x0B}, uleb(uint64(len(bytes))), bytes))
+}
+
+// section frames a section with its id and size.
+func section(id byte, content []byte) []byte {
+	return cat([]byte{id}, uleb(uint64(len(content))), content)
+}
+
+// vector frames the entries of a section with their count.
+func vector(entries [][]byte) []byte {
+	out := uleb(uint64(len(entries)))
+	for _, entry := range entries {
+		out = append(out, entry...)
+	}
+	return out
+}
+
+// build assembles the module into the binary format.
+func (b *moduleBuilder) build() []byte {
+	out := []byte("\x00asm\x01\x00\x00\x00")
+	if len(b.types) > 0 {
+		out = append(out, section(1, vector(b.types))...)
+	}
+	if len(b.imports) > 0 {
+		out = append(out, section(2, vector(b.imports))...)
+	}
+	if len(b.funcs) > 0 {
+		var indexes [][]byte
+		for _, typeIndex := range b.funcs {
+			indexes = append(indexes, uleb(uint64(typeIndex)))
+		}
+		out = append(out, section(3, vector(indexes))...)
+	}
+	if b.table != nil {
+		out = append(out, section(4, vector([][]byte{b.table}))...)
+	}
+	if b.memory != nil {
+		out = append(out, section(5, vector([][]byte{b.memory}))...)
+	}
+	if len(b.globals) > 0 {
+		out = append(out, section(6, vector(b.globals))...)
+	}
+	if len(b.exports) > 0 {
+		out = append(out, section(7, vector(b.exports))...)
+	}
+	if len(b.elements) > 0 {
+		out = append(out, section(9, vector(b.elements))...)
+	}
+	if len(b.codes) > 0 {
+		var sized [][]byte
+		for _, code := range b.codes {
+			sized = append(sized, cat(uleb(uint64(len(code))), code))
+		}
+		out = append(out, section(10, vector(sized))...)
+	}
+	if len(b.data) > 0 {
+		out = append(out, section(11, vector(b.data))...)
+	}
+	return out
+}
+
+// instantiate decodes and instantiates a module failing the test on error.
+func instantiate(t *testing.T, encoded []byte, hostFunctions map[string]HostFunction) *Instance {
+	module, err := Decode(encoded)
+	if err != nil {
+		t.Fatalf("unable to decode module: %v", err)
+	}
+	instance, err := Instantiate(module, hostFunctions)
+	if err != nil {
+		t.Fatalf("unable to instantiate module: %v", err)
+	}
+	return instance
+}
+
+// runModule instantiates a module with a single exported function named run with the provided
+// signature and body and invokes it.
+func runModule(t *testing.T, params []ValueType, results []ValueType, locals []ValueType, body []byte, args ...uint64) ([]uint64, error) {
+	b := &moduleBuilder{}
+	b.addFunction("run", b.addType(params, results), locals, body)
+	return instantiate(t, b.build(), nil).Call("run", args...)
+}
+
+func TestDecodeRejectsInvalidModules(t *testing.T) {
+	tests := []struct {
+		encoded []byte
+		desc    string
+	}{
+		{
+			encoded: []byte{},
+			desc:    "empty input",
+		},
+		{
+			encoded: []byte("\x00nope\x01\x00\x00\x00"),
+			desc:    "invalid magic",
+		},
+		{
+			encoded: []byte("\x00asm\x02\x00\x00\x00"),
+			desc:    "unsupported version",
+		},
+		{
+			encoded: []byte("\x00asm\x01\x00\x00\x00\x01\xFF"),
+			desc:    "truncated section",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := Decode(test.encoded)
+		assert.Error(t, err, test.desc)
+	}
+}
+
+func TestI32Arithmetic(t *testing.T) {
+	tests := []struct {
+		opcode   byte
+		first    uint64
+		second   uint64
+		expected uint64
+		trap     bool
+		desc     string
+	}{
+		{
+			opcode:   0x6A,
+			first:    2,
+			second:   3,
+			expected: 5,
+			desc:     "i32.add",
+		},
+		{
+			opcode:   0x6B,
+			first:    2,
+			second:   3,
+			expected: uint64(uint32(0xFFFFFFFF)),
+			desc:     "i32.sub wraps around",
+		},
+		{
+			opcode:   0x6D,
+			first:    7,
+			second:   uint64(uint32(0xFFFFFFFE)),
+			expected: uint64(uint32(0xFFFFFFFD)),
+			desc:     "i32.div_s rounds toward zero",
+		},
+		{
+			opcode: 0x6D,
+			first:  1,
+			second: 0,
+			trap:   true,
+			desc:   "i32.div_s traps on divide by zero",
+		},
+		{
+			opcode: 0x6D,
+			first:  uint64(uint32(0x80000000)),
+			second: uint64(uint32(0xFFFFFFFF)),
+			trap:   true,
+			desc:   "i32.div_s traps on overflow",
+		},
+		{
+			opcode:   0x6F,
+			first:    uint64(uint32(0x80000000)),
+			second:   uint64(uint32(0xFFFFFFFF)),
+			expected: 0,
+			desc:     "i32.rem_s of minimum value by minus one is zero",
+		},
+		{
+			opcode:   0x74,
+			first:    1,
+			second:   33,
+			expected: 2,
+			desc:     "i32.shl masks the shift count",
+		},
+		{
+			opcode:   0x75,
+			first:    uint64(uint32(0x80000000)),
+			second:   1,
+			expected: uint64(uint32(0xC0000000)),
+			desc:     "i32.shr_s replicates the sign bit",
+		},
+		{
+			opcode:   0x77,
+			first:    uint64(uint32(0x80000001)),
+			second:   1,
+			expected: 3,
+			desc:     "i32.rotl",
+		},
+	}
+
+	for _, test := range tests {
+		body := []byte{0x20, 0x00, 0x20, 0x01, test.opcode, 0x0B}
+		results, err := runModule(t, []ValueType{I32, I32}, []ValueType{I32}, nil, body, test.first, test.second)
+		if test.trap {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, []uint64{test.expected}, results, test.desc)
+	}
+}
+
+func TestSignExtension(t *testing.T) {
+	tests := []struct {
+		param    ValueType
+		result   ValueType
+		opcode   byte
+		arg      uint64
+		expected uint64
+		desc     string
+	}{
+		{
+			param:    I32,
+			result:   I32,
+			opcode:   0xC0,
+			arg:      0x80,
+			expected: uint64(uint32(0xFFFFFF80)),
+			desc:     "i32.extend8_s extends a negative byte",
+		},
+		{
+			param:    I32,
+			result:   I32,
+			opcode:   0xC0,
+			arg:      0x7F,
+			expected: 0x7F,
+			desc:     "i32.extend8_s keeps a positive byte",
+		},
+		{
+			param:    I32,
+			result:   I32,
+			opcode:   0xC1,
+			arg:      0x8000,
+			expected: uint64(uint32(0xFFFF8000)),
+			desc:     "i32.extend16_s extends a negative half word",
+		},
+		{
+			param:    I64,
+			result:   I64,
+			opcode:   0xC2,
+			arg:      0x80,
+			expected: 0xFFFFFFFFFFFFFF80,
+			desc:     "i64.extend8_s extends a negative byte",
+		},
+		{
+			param:    I64,
+			result:   I64,
+			opcode:   0xC3,
+			arg:      0x8000,
+			expected: 0xFFFFFFFFFFFF8000,
+			desc:     "i64.extend16_s extends a negative half word",
+		},
+		{
+			param:    I64,
+			result:   I64,
+			opcode:   0xC4,
+			arg:      0x80000000,
+			expected: 0xFFFFFFFF80000000,
+			desc:     "i64.extend32_s extends a negative word",
+		},
+		{
+			param:    I64,
+			result:   I64,
+			opcode:   0xC4,
+			arg:      0x7FFFFFFF,
+			expected: 0x7FFFFFFF,
+			desc:     "i64.extend32_s keeps a positive word",
+		},
+	}
+
+	for _, test := range tests {
+		body := []byte{0x20, 0x00, test.opcode, 0x0B}
+		results, err := runModule(t, []ValueType{test.param}, []ValueType{test.result}, nil, body, test.arg)
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, []uint64{test.expected}, results, test.desc)
+	}
+}
+
+func TestSignExtendingLoads(t *testing.T) {
+	b := &moduleBuilder{}
+	b.setMemory(1, 0)
+	b.addData(0, []byte{0x80, 0xFF, 0x7F, 0x00})
+	// i32.load8_s at the address of the parameter.
+	b.addFunction("signed", b.addType([]ValueType{I32}, []ValueType{I32}), nil, []byte{0x20, 0x00, 0x2C, 0x00, 0x00, 0x0B})
+	// i32.load8_u at the address of the parameter.
+	b.addFunction("unsigned", b.addType([]ValueType{I32}, []ValueType{I32}), nil, []byte{0x20, 0x00, 0x2D, 0x00, 0x00, 0x0B})
+	instance := instantiate(t, b.build(), nil)
+
+	results, err := instance.Call("signed", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{uint64(uint32(0xFFFFFF80))}, results, "i32.load8_s extends the sign bit")
+
+	results, err = instance.Call("signed", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{0x7F}, results, "i32.load8_s keeps a positive byte")
+
+	results, err = instance.Call("unsigned", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{0x80}, results, "i32.load8_u zero extends")
+}
+
+func TestSaturatingConversions(t *testing.T) {
+	f32 := func(value float64) uint64 { return uint64(math.Float32bits(float32(value))) }
+	f64 := func(value float64) uint64 { return math.Float64bits(value) }
+
+	tests := []struct {
+		param    ValueType
+		result   ValueType
+		sub      byte
+		arg      uint64
+		expected uint64
+		desc     string
+	}{
+		{
+			param:    F32,
+			result:   I32,
+			sub:      0,
+			arg:      f32(math.NaN()),
+			expected: 0,
+			desc:     "i32.trunc_sat_f32_s of NaN is zero",
+		},
+		{
+			param:    F32,
+			result:   I32,
+			sub:      0,
+			arg:      f32(math.Inf(1)),
+			expected: uint64(uint32(math.MaxInt32)),
+			desc:     "i32.trunc_sat_f32_s clamps positive infinity",
+		},
+		{
+			param:    F32,
+			result:   I32,
+			sub:      0,
+			arg:      f32(math.Inf(-1)),
+			expected: uint64(uint32(0x80000000)),
+			desc:     "i32.trunc_sat_f32_s clamps negative infinity",
+		},
+		{
+			param:    F32,
+			result:   I32,
+			sub:      0,
+			arg:      f32(-3.7),
+			expected: uint64(uint32(0xFFFFFFFD)),
+			desc:     "i32.trunc_sat_f32_s truncates toward zero",
+		},
+		{
+			param:    F32,
+			result:   I32,
+			sub:      1,
+			arg:      f32(-1),
+			expected: 0,
+			desc:     "i32.trunc_sat_f32_u clamps negative values to zero",
+		},
+		{
+			param:    F64,
+			result:   I32,
+			sub:      3,
+			arg:      f64(1e10),
+			expected: math.MaxUint32,
+			desc:     "i32.trunc_sat_f64_u clamps out of range values",
+		},
+		{
+			param:    F64,
+			result:   I32,
+			sub:      2,
+			arg:      f64(3.9),
+			expected: 3,
+			desc:     "i32.trunc_sat_f64_s truncates in range values",
+		},
+		{
+			param:    F64,
+			result:   I64,
+			sub:      6,
+			arg:      f64(1e30),
+			expected: math.MaxInt64,
+			desc:     "i64.trunc_sat_f64_s clamps out of range values",
+		},
+		{
+			param:    F64,
+			result:   I64,
+			sub:      6,
+			arg:      f64(math.NaN()),
+			expected: 0,
+			desc:     "i64.trunc_sat_f64_s of NaN is zero",
+		},
+		{
+			param:    F64,
+			result:   I64,
+			sub:      7,
+			arg:      f64(1e30),
+			expected: math.MaxUint64,
+			desc:     "i64.trunc_sat_f64_u clamps out of range values",
+		},
+		{
+			param:    F32,
+			result:   I64,
+			sub:      4,
+			arg:      f32(-2.5),
+			expected: 0xFFFFFFFFFFFFFFFE,
+			desc:     "i64.trunc_sat_f32_s truncates toward zero",
+		},
+	}
+
+	for _, test := range tests {
+		body := []byte{0x20, 0x00, 0xFC, test.sub, 0x0B}
+		results, err := runModule(t, []ValueType{test.param}, []ValueType{test.result}, nil, body, test.arg)
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, []uint64{test.expected}, results, test.desc)
+	}
+}
+
+func TestTrappingConversions(t *testing.T) {
+	f32 := func(value float64) uint64 { return uint64(math.Float32bits(float32(value))) }
+	f64 := func(value float64) uint64 { return math.Float64bits(value) }
+
+	tests := []struct {
+		param    ValueType
+		opcode   byte
+		arg      uint64
+		expected *uint64
+		desc     string
+	}{
+		{
+			param:  F32,
+			opcode: 0xA8,
+			arg:    f32(math.NaN()),
+			desc:   "i32.trunc_f32_s traps on NaN",
+		},
+		{
+			param:  F32,
+			opcode: 0xA8,
+			arg:    f32(math.MaxInt32 + 4096),
+			desc:   "i32.trunc_f32_s traps on overflow",
+		},
+		{
+			param:  F64,
+			opcode: 0xAB,
+			arg:    f64(-1),
+			desc:   "i32.trunc_f64_u traps on negative values",
+		},
+	}
+
+	for _, test := range tests {
+		body := []byte{0x20, 0x00, test.opcode, 0x0B}
+		_, err := runModule(t, []ValueType{test.param}, []ValueType{I32}, nil, body, test.arg)
+		assert.Error(t, err, test.desc)
+	}
+
+	// An in range conversion must not trap.
+	results, err := runModule(t, []ValueType{F64}, []ValueType{I32}, nil, []byte{0x20, 0x00, 0xAA, 0x0B}, f64(-7.9))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{uint64(uint32(0xFFFFFFF9))}, results, "i32.trunc_f64_s truncates toward zero")
+}
+
+func TestBranchTable(t *testing.T) {
+	// Three nested blocks with a br_table dispatching on the parameter. Index zero returns 10,
+	// index one returns 20 and any other index falls through to the default target returning 30.
+	body := []byte{
+		0x02, 0x40, // block
+		0x02, 0x40, // block
+		0x02, 0x40, // block
+		0x20, 0x00, // local.get 0
+		0x0E, 0x02, 0x00, 0x01, 0x02, // br_table 0 1 default 2
+		0x0B,       // end
+		0x41, 0x0A, // i32.const 10
+		0x0F,       // return
+		0x0B,       // end
+		0x41, 0x14, // i32.const 20
+		0x0F,       // return
+		0x0B,       // end
+		0x41, 0x1E, // i32.const 30
+		0x0B, // end
+	}
+
+	tests := []struct {
+		arg      uint64
+		expected uint64
+		desc     string
+	}{
+		{
+			arg:      0,
+			expected: 10,
+			desc:     "first branch target",
+		},
+		{
+			arg:      1,
+			expected: 20,
+			desc:     "second branch target",
+		},
+		{
+			arg:      2,
+			expected: 30,
+			desc:     "default branch target",
+		},
+		{
+			arg:      uint64(uint32(0xFFFFFFFF)),
+			expected: 30,
+			desc:     "out of range index selects the default target",
+		},
+	}
+
+	for _, test := range tests {
+		results, err := runModule(t, []ValueType{I32}, []ValueType{I32}, nil, body, test.arg)
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, []uint64{test.expected}, results, test.desc)
+	}
+}
+
+func TestLoopAndBranches(t *testing.T) {
+	// Sum the integers from one to the parameter with a loop, a conditional exit branch and an
+	// unconditional continue branch.
+	body := []byte{
+		0x02, 0x40, // block
+		0x03, 0x40, // loop
+		0x20, 0x00, // local.get 0
+		0x45,       // i32.eqz
+		0x0D, 0x01, // br_if 1
+		0x20, 0x01, // local.get 1
+		0x20, 0x00, // local.get 0
+		0x6A,       // i32.add
+		0x21, 0x01, // local.set 1
+		0x20, 0x00, // local.get 0
+		0x41, 0x01, // i32.const 1
+		0x6B,       // i32.sub
+		0x21, 0x00, // local.set 0
+		0x0C, 0x00, // br 0
+		0x0B,       // end
+		0x0B,       // end
+		0x20, 0x01, // local.get 1
+		0x0B, // end
+	}
+
+	results, err := runModule(t, []ValueType{I32}, []ValueType{I32}, []ValueType{I32}, body, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{5050}, results)
+}
+
+func TestIfElse(t *testing.T) {
+	body := []byte{
+		0x20, 0x00, // local.get 0
+		0x04, 0x7F, // if (result i32)
+		0x41, 0x01, // i32.const 1
+		0x05,       // else
+		0x41, 0x02, // i32.const 2
+		0x0B, // end
+		0x0B, // end
+	}
+
+	results, err := runModule(t, []ValueType{I32}, []ValueType{I32}, nil, body, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1}, results, "then branch")
+
+	results, err = runModule(t, []ValueType{I32}, []ValueType{I32}, nil, body, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{2}, results, "else branch")
+}
+
+func TestMemoryGrowBounds(t *testing.T) {
+	b := &moduleBuilder{}
+	b.setMemory(1, 3)
+	b.addFunction("grow", b.addType([]ValueType{I32}, []ValueType{I32}), nil, []byte{0x20, 0x00, 0x40, 0x00, 0x0B})
+	b.addFunction("size", b.addType(nil, []ValueType{I32}), nil, []byte{0x3F, 0x00, 0x0B})
+	instance := instantiate(t, b.build(), nil)
+
+	results, err := instance.Call("size")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1}, results, "initial size is the declared minimum")
+
+	results, err = instance.Call("grow", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1}, results, "grow returns the previous size")
+
+	results, err = instance.Call("grow", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{uint64(uint32(0xFFFFFFFF))}, results, "grow beyond the declared maximum fails")
+
+	results, err = instance.Call("size")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{2}, results, "a failed grow leaves the size unchanged")
+
+	// A module without a declared maximum is still bounded by the interpreter limit. The
+	// oversized grow must fail without allocating.
+	unbounded := &moduleBuilder{}
+	unbounded.setMemory(1, 0)
+	unbounded.addFunction("grow", unbounded.addType([]ValueType{I32}, []ValueType{I32}), nil, []byte{0x20, 0x00, 0x40, 0x00, 0x0B})
+	instance = instantiate(t, unbounded.build(), nil)
+
+	results, err = instance.Call("grow", uint64(uint32(0x7FFFFFFF)))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{uint64(uint32(0xFFFFFFFF))}, results, "grow beyond the interpreter limit fails")
+}
+
+func TestMemoryAccessBounds(t *testing.T) {
+	b := &moduleBuilder{}
+	b.setMemory(1, 1)
+	b.addFunction("load", b.addType([]ValueType{I32}, []ValueType{I32}), nil, []byte{0x20, 0x00, 0x28, 0x02, 0x00, 0x0B})
+	b.addFunction("store", b.addType([]ValueType{I32}, nil), nil, []byte{0x20, 0x00, 0x41, 0x07, 0x36, 0x02, 0x00, 0x0B})
+	// memory.fill of ten bytes starting at the parameter.
+	b.addFunction("fill", b.addType([]ValueType{I32}, nil), nil, []byte{0x20, 0x00, 0x41, 0x01, 0x41, 0x0A, 0xFC, 0x0B, 0x00, 0x0B})
+	// memory.copy of ten bytes from address zero to the parameter.
+	b.addFunction("copy", b.addType([]ValueType{I32}, nil), nil, []byte{0x20, 0x00, 0x41, 0x00, 0x41, 0x0A, 0xFC, 0x0A, 0x00, 0x00, 0x0B})
+	instance := instantiate(t, b.build(), nil)
+
+	_, err := instance.Call("load", PageSize-4)
+	assert.NoError(t, err, "load of the last word succeeds")
+	_, err = instance.Call("load", PageSize-3)
+	assert.Error(t, err, "load crossing the end of memory traps")
+	_, err = instance.Call("load", PageSize+100)
+	assert.Error(t, err, "load past the end of memory traps")
+
+	_, err = instance.Call("store", PageSize-4)
+	assert.NoError(t, err, "store of the last word succeeds")
+	_, err = instance.Call("store", PageSize-1)
+	assert.Error(t, err, "store crossing the end of memory traps")
+
+	_, err = instance.Call("fill", PageSize-10)
+	assert.NoError(t, err, "fill of the last bytes succeeds")
+	_, err = instance.Call("fill", PageSize-5)
+	assert.Error(t, err, "fill crossing the end of memory traps")
+
+	_, err = instance.Call("copy", PageSize-10)
+	assert.NoError(t, err, "copy to the last bytes succeeds")
+	_, err = instance.Call("copy", PageSize-5)
+	assert.Error(t, err, "copy crossing the end of memory traps")
+}
+
+func TestCallIndirect(t *testing.T) {
+	b := &moduleBuilder{}
+	nullary := b.addType(nil, []ValueType{I32})
+	unary := b.addType([]ValueType{I32}, []ValueType{I32})
+	first := b.addFunction("", nullary, nil, []byte{0x41, 0x0B, 0x0B})
+	second := b.addFunction("", nullary, nil, []byte{0x41, 0x16, 0x0B})
+	mismatched := b.addFunction("", unary, nil, []byte{0x20, 0x00, 0x0B})
+	b.addFunction("run", unary, nil, cat([]byte{0x20, 0x00, 0x11}, uleb(uint64(nullary)), []byte{0x00, 0x0B}))
+	b.setTable(5)
+	b.addElement(0, []uint32{first, second, mismatched})
+	instance := instantiate(t, b.build(), nil)
+
+	results, err := instance.Call("run", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{11}, results, "dispatch to the first table entry")
+
+	results, err = instance.Call("run", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{22}, results, "dispatch to the second table entry")
+
+	_, err = instance.Call("run", 2)
+	assert.Error(t, err, "mismatched signature traps")
+
+	_, err = instance.Call("run", 3)
+	assert.Error(t, err, "uninitialized table entry traps")
+
+	_, err = instance.Call("run", 100)
+	assert.Error(t, err, "out of range table index traps")
+}
+
+func TestGlobals(t *testing.T) {
+	b := &moduleBuilder{}
+	counter := b.addGlobal(I32, true, []byte{0x41, 0x07, 0x0B})
+	b.addFunction("get", b.addType(nil, []ValueType{I32}), nil, cat([]byte{0x23}, uleb(uint64(counter)), []byte{0x0B}))
+	b.addFunction("bump", b.addType(nil, nil), nil, cat([]byte{0x23}, uleb(uint64(counter)), []byte{0x41, 0x01, 0x6A, 0x24}, uleb(uint64(counter)), []byte{0x0B}))
+	instance := instantiate(t, b.build(), nil)
+
+	results, err := instance.Call("get")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{7}, results, "global carries its initial value")
+
+	_, err = instance.Call("bump")
+	assert.NoError(t, err)
+	results, err = instance.Call("get")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{8}, results, "global.set is observable")
+}
+
+func TestHostFunctions(t *testing.T) {
+	b := &moduleBuilder{}
+	binary := b.addType([]ValueType{I32, I32}, []ValueType{I32})
+	imported := b.addImport("env", "add", binary)
+	b.addFunction("run", binary, nil, cat([]byte{0x20, 0x00, 0x20, 0x01, 0x10}, uleb(uint64(imported)), []byte{0x0B}))
+
+	instance := instantiate(t, b.build(), map[string]HostFunction{
+		"env.add": func(instance *Instance, args []uint64) ([]uint64, error) {
+			return []uint64{args[0] + args[1]}, nil
+		},
+	})
+	results, err := instance.Call("run", 19, 23)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{42}, results)
+}
+
+func TestTraps(t *testing.T) {
+	// The unreachable instruction traps.
+	_, err := runModule(t, nil, nil, nil, []byte{0x00, 0x0B})
+	assert.Error(t, err, "unreachable traps")
+
+	// Unbounded recursion traps instead of exhausting the agent stack.
+	b := &moduleBuilder{}
+	index := b.addFunction("run", b.addType(nil, nil), nil, nil)
+	b.codes[0] = cat(uleb(0), []byte{0x10}, uleb(uint64(index)), []byte{0x0B})
+	_, err = instantiate(t, b.build(), nil).Call("run")
+	assert.Error(t, err, "unbounded recursion traps")
+}
+
+func TestDeadline(t *testing.T) {
+	// An infinite loop traps once the deadline has passed.
+	body := []byte{
+		0x03, 0x40, // loop
+		0x0C, 0x00, // br 0
+		0x0B, // end
+		0x0B, // end
+	}
+	b := &moduleBuilder{}
+	b.addFunction("run", b.addType(nil, nil), nil, body)
+	instance := instantiate(t, b.build(), nil)
+	instance.SetDeadline(time.Now().Add(-time.Second))
+
+	_, err := instance.Call("run")
+	assert.Error(t, err)
+}
@@ -0,0 +1,617 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package wasm contains a small dependency free interpreter for the WebAssembly MVP binary
+// format. It runs user supplied diagnoser modules in process in the kubediag agent, so
+// custom operations are portable and sandboxed without extra containers. The interpreter
+// executes modules directly from the decoded instruction stream and bounds every run with a
+// deadline, so a misbehaving module cannot stall a task worker.
+package wasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Value types of the WebAssembly MVP.
+const (
+	// I32 is the 32 bit integer value type.
+	I32 ValueType = 0x7F
+	// I64 is the 64 bit integer value type.
+	I64 ValueType = 0x7E
+	// F32 is the 32 bit float value type.
+	F32 ValueType = 0x7D
+	// F64 is the 64 bit float value type.
+	F64 ValueType = 0x7C
+)
+
+// Kinds of module exports and imports.
+const (
+	// FunctionKind indicates that an export or import is a function.
+	FunctionKind byte = 0
+	// TableKind indicates that an export or import is a table.
+	TableKind byte = 1
+	// MemoryKind indicates that an export or import is a linear memory.
+	MemoryKind byte = 2
+	// GlobalKind indicates that an export or import is a global.
+	GlobalKind byte = 3
+)
+
+// PageSize is the size of a linear memory page in bytes.
+const PageSize = 65536
+
+// ValueType is the type of a WebAssembly value.
+type ValueType byte
+
+// FuncType is the signature of a WebAssembly function.
+type FuncType struct {
+	// Params contains the parameter types of the function.
+	Params []ValueType
+	// Results contains the result types of the function.
+	Results []ValueType
+}
+
+// Import describes an entity the module requires from the host.
+type Import struct {
+	// Module is the module name of the import.
+	Module string
+	// Name is the field name of the import.
+	Name string
+	// Kind is the kind of the import.
+	Kind byte
+	// TypeIndex is the index of the signature of an imported function.
+	TypeIndex uint32
+}
+
+// Export describes an entity the module provides to the host.
+type Export struct {
+	// Kind is the kind of the export.
+	Kind byte
+	// Index is the index of the exported entity in its index space.
+	Index uint32
+}
+
+// Global describes a global variable of the module.
+type Global struct {
+	// Type is the value type of the global.
+	Type ValueType
+	// Mutable indicates whether the global may be assigned.
+	Mutable bool
+	// Init is the constant initializer expression of the global.
+	Init []byte
+}
+
+// Code is the body of a function defined by the module.
+type Code struct {
+	// Locals contains the types of the declared locals in declaration order.
+	Locals []ValueType
+	// Body contains the instructions of the function including the terminating end opcode.
+	Body []byte
+	// ends maps the offset of a block, loop or if opcode to the offset after its matching
+	// end opcode.
+	ends map[int]int
+	// elses maps the offset of an if opcode to the offset after its else opcode, or zero if
+	// the if has no else branch.
+	elses map[int]int
+}
+
+// Element describes a table initializer segment.
+type Element struct {
+	// Offset is the constant expression yielding the start index in the table.
+	Offset []byte
+	// Indexes contains the function indexes placed into the table.
+	Indexes []uint32
+}
+
+// Data describes a linear memory initializer segment.
+type Data struct {
+	// Offset is the constant expression yielding the start address in memory.
+	Offset []byte
+	// Bytes contains the bytes copied into memory.
+	Bytes []byte
+}
+
+// Module is a decoded WebAssembly module.
+type Module struct {
+	// Types contains all function signatures of the module.
+	Types []FuncType
+	// ImportedFunctions contains all function imports in index order. Imported functions
+	// precede defined functions in the function index space.
+	ImportedFunctions []Import
+	// FunctionTypeIndexes contains the signature index of every defined function.
+	FunctionTypeIndexes []uint32
+	// TableMin is the minimum size of the table of the module.
+	TableMin uint32
+	// MemoryMin is the minimum size of the linear memory in pages.
+	MemoryMin uint32
+	// MemoryMax is the maximum size of the linear memory in pages, or zero if unbounded.
+	MemoryMax uint32
+	// Globals contains all global variables defined by the module.
+	Globals []Global
+	// Exports contains all exports of the module keyed by name.
+	Exports map[string]Export
+	// Codes contains the bodies of all defined functions.
+	Codes []Code
+	// Elements contains all table initializer segments.
+	Elements []Element
+	// DataSegments contains all memory initializer segments.
+	DataSegments []Data
+	// StartIndex is the index of the start function, or nil if the module has none.
+	StartIndex *uint32
+}
+
+// Decode decodes a module in the WebAssembly binary format.
+func Decode(encoded []byte) (*Module, error) {
+	r := &reader{data: encoded}
+	magic, err := r.bytes(4)
+	if err != nil || string(magic) != "\x00asm" {
+		return nil, fmt.Errorf("not a WebAssembly module")
+	}
+	version, err := r.bytes(4)
+	if err != nil || binary.LittleEndian.Uint32(version) != 1 {
+		return nil, fmt.Errorf("unsupported WebAssembly version")
+	}
+
+	module := &Module{Exports: make(map[string]Export)}
+	for !r.empty() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.uint32()
+		if err != nil {
+			return nil, err
+		}
+		section, err := r.bytes(int(size))
+		if err != nil {
+			return nil, err
+		}
+		if err := module.decodeSection(id, &reader{data: section}); err != nil {
+			return nil, fmt.Errorf("unable to decode section %d: %s", id, err)
+		}
+	}
+
+	for i := range module.Codes {
+		if err := module.Codes[i].index(); err != nil {
+			return nil, fmt.Errorf("unable to index function %d: %s", i, err)
+		}
+	}
+
+	return module, nil
+}
+
+// decodeSection decodes a single section of the module.
+func (m *Module) decodeSection(id byte, r *reader) error {
+	switch id {
+	case 0:
+		// Custom sections carry no semantics and are skipped.
+		return nil
+	case 1:
+		return eachEntry(r, func() error {
+			form, err := r.byte()
+			if err != nil {
+				return err
+			}
+			if form != 0x60 {
+				return fmt.Errorf("invalid function type form %#x", form)
+			}
+			var funcType FuncType
+			if funcType.Params, err = r.valueTypes(); err != nil {
+				return err
+			}
+			if funcType.Results, err = r.valueTypes(); err != nil {
+				return err
+			}
+			m.Types = append(m.Types, funcType)
+			return nil
+		})
+	case 2:
+		return eachEntry(r, func() error {
+			moduleName, err := r.name()
+			if err != nil {
+				return err
+			}
+			fieldName, err := r.name()
+			if err != nil {
+				return err
+			}
+			kind, err := r.byte()
+			if err != nil {
+				return err
+			}
+			switch kind {
+			case FunctionKind:
+				typeIndex, err := r.uint32()
+				if err != nil {
+					return err
+				}
+				m.ImportedFunctions = append(m.ImportedFunctions, Import{Module: moduleName, Name: fieldName, Kind: kind, TypeIndex: typeIndex})
+				return nil
+			default:
+				// Modules built against the kubediag host ABI define their own memory,
+				// table and globals.
+				return fmt.Errorf("unsupported import kind %d of %s.%s", kind, moduleName, fieldName)
+			}
+		})
+	case 3:
+		return eachEntry(r, func() error {
+			typeIndex, err := r.uint32()
+			if err != nil {
+				return err
+			}
+			m.FunctionTypeIndexes = append(m.FunctionTypeIndexes, typeIndex)
+			return nil
+		})
+	case 4:
+		return eachEntry(r, func() error {
+			elemType, err := r.byte()
+			if err != nil {
+				return err
+			}
+			if elemType != 0x70 {
+				return fmt.Errorf("invalid table element type %#x", elemType)
+			}
+			min, _, err := r.limits()
+			if err != nil {
+				return err
+			}
+			m.TableMin = min
+			return nil
+		})
+	case 5:
+		return eachEntry(r, func() error {
+			min, max, err := r.limits()
+			if err != nil {
+				return err
+			}
+			m.MemoryMin = min
+			m.MemoryMax = max
+			return nil
+		})
+	case 6:
+		return eachEntry(r, func() error {
+			valueType, err := r.byte()
+			if err != nil {
+				return err
+			}
+			mutable, err := r.byte()
+			if err != nil {
+				return err
+			}
+			init, err := r.constExpr()
+			if err != nil {
+				return err
+			}
+			m.Globals = append(m.Globals, Global{Type: ValueType(valueType), Mutable: mutable == 1, Init: init})
+			return nil
+		})
+	case 7:
+		return eachEntry(r, func() error {
+			name, err := r.name()
+			if err != nil {
+				return err
+			}
+			kind, err := r.byte()
+			if err != nil {
+				return err
+			}
+			index, err := r.uint32()
+			if err != nil {
+				return err
+			}
+			m.Exports[name] = Export{Kind: kind, Index: index}
+			return nil
+		})
+	case 8:
+		index, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		m.StartIndex = &index
+		return nil
+	case 9:
+		return eachEntry(r, func() error {
+			tableIndex, err := r.uint32()
+			if err != nil {
+				return err
+			}
+			if tableIndex != 0 {
+				return fmt.Errorf("invalid table index %d", tableIndex)
+			}
+			offset, err := r.constExpr()
+			if err != nil {
+				return err
+			}
+			element := Element{Offset: offset}
+			if err := eachEntry(r, func() error {
+				index, err := r.uint32()
+				if err != nil {
+					return err
+				}
+				element.Indexes = append(element.Indexes, index)
+				return nil
+			}); err != nil {
+				return err
+			}
+			m.Elements = append(m.Elements, element)
+			return nil
+		})
+	case 10:
+		return eachEntry(r, func() error {
+			size, err := r.uint32()
+			if err != nil {
+				return err
+			}
+			body, err := r.bytes(int(size))
+			if err != nil {
+				return err
+			}
+			bodyReader := &reader{data: body}
+			var code Code
+			if err := eachEntry(bodyReader, func() error {
+				count, err := bodyReader.uint32()
+				if err != nil {
+					return err
+				}
+				valueType, err := bodyReader.byte()
+				if err != nil {
+					return err
+				}
+				for i := uint32(0); i < count; i++ {
+					code.Locals = append(code.Locals, ValueType(valueType))
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			code.Body = bodyReader.rest()
+			m.Codes = append(m.Codes, code)
+			return nil
+		})
+	case 11:
+		return eachEntry(r, func() error {
+			memoryIndex, err := r.uint32()
+			if err != nil {
+				return err
+			}
+			if memoryIndex != 0 {
+				return fmt.Errorf("invalid memory index %d", memoryIndex)
+			}
+			offset, err := r.constExpr()
+			if err != nil {
+				return err
+			}
+			size, err := r.uint32()
+			if err != nil {
+				return err
+			}
+			bytes, err := r.bytes(int(size))
+			if err != nil {
+				return err
+			}
+			m.DataSegments = append(m.DataSegments, Data{Offset: offset, Bytes: bytes})
+			return nil
+		})
+	default:
+		return fmt.Errorf("unknown section id %d", id)
+	}
+}
+
+// typeOfFunction returns the signature of the function with the provided index. Imported
+// functions precede defined functions in the function index space.
+func (m *Module) typeOfFunction(index uint32) (FuncType, error) {
+	if int(index) < len(m.ImportedFunctions) {
+		typeIndex := m.ImportedFunctions[index].TypeIndex
+		if int(typeIndex) >= len(m.Types) {
+			return FuncType{}, fmt.Errorf("invalid type index %d", typeIndex)
+		}
+		return m.Types[typeIndex], nil
+	}
+	definedIndex := int(index) - len(m.ImportedFunctions)
+	if definedIndex >= len(m.FunctionTypeIndexes) {
+		return FuncType{}, fmt.Errorf("invalid function index %d", index)
+	}
+	typeIndex := m.FunctionTypeIndexes[definedIndex]
+	if int(typeIndex) >= len(m.Types) {
+		return FuncType{}, fmt.Errorf("invalid type index %d", typeIndex)
+	}
+	return m.Types[typeIndex], nil
+}
+
+// reader decodes primitive values of the WebAssembly binary format.
+type reader struct {
+	data []byte
+	pos  int
+}
+
+// empty reports whether the reader is exhausted.
+func (r *reader) empty() bool {
+	return r.pos >= len(r.data)
+}
+
+// rest returns all remaining bytes.
+func (r *reader) rest() []byte {
+	return r.data[r.pos:]
+}
+
+// byte reads a single byte.
+func (r *reader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, fmt.Errorf("unexpected end of module")
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+// bytes reads the provided number of bytes.
+func (r *reader) bytes(count int) ([]byte, error) {
+	if count < 0 || r.pos+count > len(r.data) {
+		return nil, fmt.Errorf("unexpected end of module")
+	}
+	b := r.data[r.pos : r.pos+count]
+	r.pos += count
+	return b, nil
+}
+
+// uint32 reads an unsigned LEB128 encoded 32 bit integer.
+func (r *reader) uint32() (uint32, error) {
+	var result uint64
+	var shift uint
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		result |= uint64(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift >= 35 {
+			return 0, fmt.Errorf("invalid unsigned integer encoding")
+		}
+	}
+	if result > math.MaxUint32 {
+		return 0, fmt.Errorf("invalid unsigned integer encoding")
+	}
+	return uint32(result), nil
+}
+
+// int64 reads a signed LEB128 encoded 64 bit integer.
+func (r *reader) int64() (int64, error) {
+	var result int64
+	var shift uint
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		result |= int64(b&0x7F) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				result |= -1 << shift
+			}
+			break
+		}
+		if shift >= 70 {
+			return 0, fmt.Errorf("invalid signed integer encoding")
+		}
+	}
+	return result, nil
+}
+
+// name reads a length prefixed UTF-8 string.
+func (r *reader) name() (string, error) {
+	size, err := r.uint32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(int(size))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// valueTypes reads a vector of value types.
+func (r *reader) valueTypes() ([]ValueType, error) {
+	count, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	types := make([]ValueType, 0, count)
+	for i := uint32(0); i < count; i++ {
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, ValueType(b))
+	}
+	return types, nil
+}
+
+// limits reads resource limits of a table or memory.
+func (r *reader) limits() (uint32, uint32, error) {
+	flags, err := r.byte()
+	if err != nil {
+		return 0, 0, err
+	}
+	min, err := r.uint32()
+	if err != nil {
+		return 0, 0, err
+	}
+	var max uint32
+	if flags&1 != 0 {
+		if max, err = r.uint32(); err != nil {
+			return 0, 0, err
+		}
+	}
+	return min, max, nil
+}
+
+// constExpr reads a constant initializer expression including its end opcode.
+func (r *reader) constExpr() ([]byte, error) {
+	start := r.pos
+	for {
+		opcode, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case 0x0B:
+			return r.data[start:r.pos], nil
+		case 0x41:
+			if _, err := r.int64(); err != nil {
+				return nil, err
+			}
+		case 0x42:
+			if _, err := r.int64(); err != nil {
+				return nil, err
+			}
+		case 0x43:
+			if _, err := r.bytes(4); err != nil {
+				return nil, err
+			}
+		case 0x44:
+			if _, err := r.bytes(8); err != nil {
+				return nil, err
+			}
+		case 0x23:
+			if _, err := r.uint32(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported constant expression opcode %#x", opcode)
+		}
+	}
+}
+
+// eachEntry reads a vector length and invokes decode once per entry.
+func eachEntry(r *reader, decode func() error) error {
+	count, err := r.uint32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		if err := decode(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
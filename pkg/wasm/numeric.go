@@ -0,0 +1,497 @@
+/*
+Copyright 2022 The KubeDiag Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wasm
+
+import (
+	"math"
+	"math/bits"
+)
+
+// executeNumeric executes a numeric instruction.
+func (in *Instance) executeNumeric(f *frame, r *reader, opcode byte) error {
+	switch opcode {
+	case 0x45:
+		f.pushBool(f.popI32() == 0)
+	case 0x46:
+		f.pushBool(f.popI32() == f.popI32())
+	case 0x47:
+		f.pushBool(f.popI32() != f.popI32())
+	case 0x48:
+		second, first := int32(f.popI32()), int32(f.popI32())
+		f.pushBool(first < second)
+	case 0x49:
+		second, first := f.popI32(), f.popI32()
+		f.pushBool(first < second)
+	case 0x4A:
+		second, first := int32(f.popI32()), int32(f.popI32())
+		f.pushBool(first > second)
+	case 0x4B:
+		second, first := f.popI32(), f.popI32()
+		f.pushBool(first > second)
+	case 0x4C:
+		second, first := int32(f.popI32()), int32(f.popI32())
+		f.pushBool(first <= second)
+	case 0x4D:
+		second, first := f.popI32(), f.popI32()
+		f.pushBool(first <= second)
+	case 0x4E:
+		second, first := int32(f.popI32()), int32(f.popI32())
+		f.pushBool(first >= second)
+	case 0x4F:
+		second, first := f.popI32(), f.popI32()
+		f.pushBool(first >= second)
+	case 0x50:
+		f.pushBool(f.pop() == 0)
+	case 0x51:
+		f.pushBool(f.pop() == f.pop())
+	case 0x52:
+		f.pushBool(f.pop() != f.pop())
+	case 0x53:
+		second, first := int64(f.pop()), int64(f.pop())
+		f.pushBool(first < second)
+	case 0x54:
+		second, first := f.pop(), f.pop()
+		f.pushBool(first < second)
+	case 0x55:
+		second, first := int64(f.pop()), int64(f.pop())
+		f.pushBool(first > second)
+	case 0x56:
+		second, first := f.pop(), f.pop()
+		f.pushBool(first > second)
+	case 0x57:
+		second, first := int64(f.pop()), int64(f.pop())
+		f.pushBool(first <= second)
+	case 0x58:
+		second, first := f.pop(), f.pop()
+		f.pushBool(first <= second)
+	case 0x59:
+		second, first := int64(f.pop()), int64(f.pop())
+		f.pushBool(first >= second)
+	case 0x5A:
+		second, first := f.pop(), f.pop()
+		f.pushBool(first >= second)
+	case 0x5B:
+		second, first := f.popF32(), f.popF32()
+		f.pushBool(first == second)
+	case 0x5C:
+		second, first := f.popF32(), f.popF32()
+		f.pushBool(first != second)
+	case 0x5D:
+		second, first := f.popF32(), f.popF32()
+		f.pushBool(first < second)
+	case 0x5E:
+		second, first := f.popF32(), f.popF32()
+		f.pushBool(first > second)
+	case 0x5F:
+		second, first := f.popF32(), f.popF32()
+		f.pushBool(first <= second)
+	case 0x60:
+		second, first := f.popF32(), f.popF32()
+		f.pushBool(first >= second)
+	case 0x61:
+		second, first := f.popF64(), f.popF64()
+		f.pushBool(first == second)
+	case 0x62:
+		second, first := f.popF64(), f.popF64()
+		f.pushBool(first != second)
+	case 0x63:
+		second, first := f.popF64(), f.popF64()
+		f.pushBool(first < second)
+	case 0x64:
+		second, first := f.popF64(), f.popF64()
+		f.pushBool(first > second)
+	case 0x65:
+		second, first := f.popF64(), f.popF64()
+		f.pushBool(first <= second)
+	case 0x66:
+		second, first := f.popF64(), f.popF64()
+		f.pushBool(first >= second)
+	case 0x67:
+		f.pushI32(uint32(bits.LeadingZeros32(f.popI32())))
+	case 0x68:
+		f.pushI32(uint32(bits.TrailingZeros32(f.popI32())))
+	case 0x69:
+		f.pushI32(uint32(bits.OnesCount32(f.popI32())))
+	case 0x6A:
+		f.pushI32(f.popI32() + f.popI32())
+	case 0x6B:
+		second, first := f.popI32(), f.popI32()
+		f.pushI32(first - second)
+	case 0x6C:
+		f.pushI32(f.popI32() * f.popI32())
+	case 0x6D:
+		second, first := int32(f.popI32()), int32(f.popI32())
+		if second == 0 {
+			return newTrap("integer divide by zero")
+		}
+		if first == math.MinInt32 && second == -1 {
+			return newTrap("integer overflow")
+		}
+		f.pushI32(uint32(first / second))
+	case 0x6E:
+		second, first := f.popI32(), f.popI32()
+		if second == 0 {
+			return newTrap("integer divide by zero")
+		}
+		f.pushI32(first / second)
+	case 0x6F:
+		second, first := int32(f.popI32()), int32(f.popI32())
+		if second == 0 {
+			return newTrap("integer divide by zero")
+		}
+		if first == math.MinInt32 && second == -1 {
+			f.pushI32(0)
+		} else {
+			f.pushI32(uint32(first % second))
+		}
+	case 0x70:
+		second, first := f.popI32(), f.popI32()
+		if second == 0 {
+			return newTrap("integer divide by zero")
+		}
+		f.pushI32(first % second)
+	case 0x71:
+		f.pushI32(f.popI32() & f.popI32())
+	case 0x72:
+		f.pushI32(f.popI32() | f.popI32())
+	case 0x73:
+		f.pushI32(f.popI32() ^ f.popI32())
+	case 0x74:
+		second, first := f.popI32(), f.popI32()
+		f.pushI32(first << (second % 32))
+	case 0x75:
+		second, first := f.popI32(), f.popI32()
+		f.pushI32(uint32(int32(first) >> (second % 32)))
+	case 0x76:
+		second, first := f.popI32(), f.popI32()
+		f.pushI32(first >> (second % 32))
+	case 0x77:
+		second, first := f.popI32(), f.popI32()
+		f.pushI32(bits.RotateLeft32(first, int(second%32)))
+	case 0x78:
+		second, first := f.popI32(), f.popI32()
+		f.pushI32(bits.RotateLeft32(first, -int(second%32)))
+	case 0x79:
+		f.push(uint64(bits.LeadingZeros64(f.pop())))
+	case 0x7A:
+		f.push(uint64(bits.TrailingZeros64(f.pop())))
+	case 0x7B:
+		f.push(uint64(bits.OnesCount64(f.pop())))
+	case 0x7C:
+		f.push(f.pop() + f.pop())
+	case 0x7D:
+		second, first := f.pop(), f.pop()
+		f.push(first - second)
+	case 0x7E:
+		f.push(f.pop() * f.pop())
+	case 0x7F:
+		second, first := int64(f.pop()), int64(f.pop())
+		if second == 0 {
+			return newTrap("integer divide by zero")
+		}
+		if first == math.MinInt64 && second == -1 {
+			return newTrap("integer overflow")
+		}
+		f.push(uint64(first / second))
+	case 0x80:
+		second, first := f.pop(), f.pop()
+		if second == 0 {
+			return newTrap("integer divide by zero")
+		}
+		f.push(first / second)
+	case 0x81:
+		second, first := int64(f.pop()), int64(f.pop())
+		if second == 0 {
+			return newTrap("integer divide by zero")
+		}
+		if first == math.MinInt64 && second == -1 {
+			f.push(0)
+		} else {
+			f.push(uint64(first % second))
+		}
+	case 0x82:
+		second, first := f.pop(), f.pop()
+		if second == 0 {
+			return newTrap("integer divide by zero")
+		}
+		f.push(first % second)
+	case 0x83:
+		f.push(f.pop() & f.pop())
+	case 0x84:
+		f.push(f.pop() | f.pop())
+	case 0x85:
+		f.push(f.pop() ^ f.pop())
+	case 0x86:
+		second, first := f.pop(), f.pop()
+		f.push(first << (second % 64))
+	case 0x87:
+		second, first := f.pop(), f.pop()
+		f.push(uint64(int64(first) >> (second % 64)))
+	case 0x88:
+		second, first := f.pop(), f.pop()
+		f.push(first >> (second % 64))
+	case 0x89:
+		second, first := f.pop(), f.pop()
+		f.push(bits.RotateLeft64(first, int(second%64)))
+	case 0x8A:
+		second, first := f.pop(), f.pop()
+		f.push(bits.RotateLeft64(first, -int(second%64)))
+	case 0x8B:
+		f.pushF32(float32(math.Abs(float64(f.popF32()))))
+	case 0x8C:
+		f.pushF32(-f.popF32())
+	case 0x8D:
+		f.pushF32(float32(math.Ceil(float64(f.popF32()))))
+	case 0x8E:
+		f.pushF32(float32(math.Floor(float64(f.popF32()))))
+	case 0x8F:
+		f.pushF32(float32(math.Trunc(float64(f.popF32()))))
+	case 0x90:
+		f.pushF32(float32(math.RoundToEven(float64(f.popF32()))))
+	case 0x91:
+		f.pushF32(float32(math.Sqrt(float64(f.popF32()))))
+	case 0x92:
+		f.pushF32(f.popF32() + f.popF32())
+	case 0x93:
+		second, first := f.popF32(), f.popF32()
+		f.pushF32(first - second)
+	case 0x94:
+		f.pushF32(f.popF32() * f.popF32())
+	case 0x95:
+		second, first := f.popF32(), f.popF32()
+		f.pushF32(first / second)
+	case 0x96:
+		second, first := f.popF32(), f.popF32()
+		f.pushF32(float32(math.Min(float64(first), float64(second))))
+	case 0x97:
+		second, first := f.popF32(), f.popF32()
+		f.pushF32(float32(math.Max(float64(first), float64(second))))
+	case 0x98:
+		second, first := f.popF32(), f.popF32()
+		f.pushF32(float32(math.Copysign(float64(first), float64(second))))
+	case 0x99:
+		f.pushF64(math.Abs(f.popF64()))
+	case 0x9A:
+		f.pushF64(-f.popF64())
+	case 0x9B:
+		f.pushF64(math.Ceil(f.popF64()))
+	case 0x9C:
+		f.pushF64(math.Floor(f.popF64()))
+	case 0x9D:
+		f.pushF64(math.Trunc(f.popF64()))
+	case 0x9E:
+		f.pushF64(math.RoundToEven(f.popF64()))
+	case 0x9F:
+		f.pushF64(math.Sqrt(f.popF64()))
+	case 0xA0:
+		f.pushF64(f.popF64() + f.popF64())
+	case 0xA1:
+		second, first := f.popF64(), f.popF64()
+		f.pushF64(first - second)
+	case 0xA2:
+		f.pushF64(f.popF64() * f.popF64())
+	case 0xA3:
+		second, first := f.popF64(), f.popF64()
+		f.pushF64(first / second)
+	case 0xA4:
+		f.pushF64(math.Min(f.popF64(), f.popF64()))
+	case 0xA5:
+		f.pushF64(math.Max(f.popF64(), f.popF64()))
+	case 0xA6:
+		second, first := f.popF64(), f.popF64()
+		f.pushF64(math.Copysign(first, second))
+	case 0xA7:
+		f.pushI32(uint32(f.pop()))
+	case 0xA8:
+		value := float64(f.popF32())
+		if math.IsNaN(value) || value >= math.MaxInt32+1 || value < math.MinInt32 {
+			return newTrap("invalid conversion to integer")
+		}
+		f.pushI32(uint32(int32(value)))
+	case 0xA9:
+		value := float64(f.popF32())
+		if math.IsNaN(value) || value >= math.MaxUint32+1 || value <= -1 {
+			return newTrap("invalid conversion to integer")
+		}
+		f.pushI32(uint32(value))
+	case 0xAA:
+		value := f.popF64()
+		if math.IsNaN(value) || value >= math.MaxInt32+1 || value < math.MinInt32 {
+			return newTrap("invalid conversion to integer")
+		}
+		f.pushI32(uint32(int32(value)))
+	case 0xAB:
+		value := f.popF64()
+		if math.IsNaN(value) || value >= math.MaxUint32+1 || value <= -1 {
+			return newTrap("invalid conversion to integer")
+		}
+		f.pushI32(uint32(value))
+	case 0xAC:
+		f.push(uint64(int64(int32(f.popI32()))))
+	case 0xAD:
+		f.push(uint64(f.popI32()))
+	case 0xAE:
+		value := float64(f.popF32())
+		if math.IsNaN(value) || value >= math.MaxInt64 || value < math.MinInt64 {
+			return newTrap("invalid conversion to integer")
+		}
+		f.push(uint64(int64(value)))
+	case 0xAF:
+		value := float64(f.popF32())
+		if math.IsNaN(value) || value >= math.MaxUint64 || value <= -1 {
+			return newTrap("invalid conversion to integer")
+		}
+		f.push(uint64(value))
+	case 0xB0:
+		value := f.popF64()
+		if math.IsNaN(value) || value >= math.MaxInt64 || value < math.MinInt64 {
+			return newTrap("invalid conversion to integer")
+		}
+		f.push(uint64(int64(value)))
+	case 0xB1:
+		value := f.popF64()
+		if math.IsNaN(value) || value >= math.MaxUint64 || value <= -1 {
+			return newTrap("invalid conversion to integer")
+		}
+		f.push(uint64(value))
+	case 0xB2:
+		f.pushF32(float32(int32(f.popI32())))
+	case 0xB3:
+		f.pushF32(float32(f.popI32()))
+	case 0xB4:
+		f.pushF32(float32(int64(f.pop())))
+	case 0xB5:
+		f.pushF32(float32(f.pop()))
+	case 0xB6:
+		f.pushF32(float32(f.popF64()))
+	case 0xB7:
+		f.pushF64(float64(int32(f.popI32())))
+	case 0xB8:
+		f.pushF64(float64(f.popI32()))
+	case 0xB9:
+		f.pushF64(float64(int64(f.pop())))
+	case 0xBA:
+		f.pushF64(float64(f.pop()))
+	case 0xBB:
+		f.pushF64(float64(f.popF32()))
+	case 0xBC:
+		f.pushI32(f.popI32())
+	case 0xBD:
+		f.push(f.pop())
+	case 0xBE:
+		f.pushI32(f.popI32())
+	case 0xBF:
+		f.push(f.pop())
+	case 0xC0:
+		f.pushI32(uint32(int32(int8(f.popI32()))))
+	case 0xC1:
+		f.pushI32(uint32(int32(int16(f.popI32()))))
+	case 0xC2:
+		f.push(uint64(int64(int8(f.pop()))))
+	case 0xC3:
+		f.push(uint64(int64(int16(f.pop()))))
+	case 0xC4:
+		f.push(uint64(int64(int32(f.pop()))))
+	case 0xFC:
+		return in.executeExtended(f, r)
+	default:
+		return newTrap("unsupported opcode %#x", opcode)
+	}
+	return nil
+}
+
+// executeExtended executes an instruction of the extended opcode space, which holds the
+// saturating truncations and bulk memory instructions emitted by recent compilers.
+func (in *Instance) executeExtended(f *frame, r *reader) error {
+	sub, err := r.uint32()
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case 0:
+		f.pushI32(uint32(saturateSigned(float64(f.popF32()), math.MinInt32, math.MaxInt32)))
+	case 1:
+		f.pushI32(uint32(saturateUnsigned(float64(f.popF32()), math.MaxUint32)))
+	case 2:
+		f.pushI32(uint32(saturateSigned(f.popF64(), math.MinInt32, math.MaxInt32)))
+	case 3:
+		f.pushI32(uint32(saturateUnsigned(f.popF64(), math.MaxUint32)))
+	case 4:
+		f.push(uint64(saturateSigned(float64(f.popF32()), math.MinInt64, math.MaxInt64)))
+	case 5:
+		f.push(saturateUnsigned(float64(f.popF32()), math.MaxUint64))
+	case 6:
+		f.push(uint64(saturateSigned(f.popF64(), math.MinInt64, math.MaxInt64)))
+	case 7:
+		f.push(saturateUnsigned(f.popF64(), math.MaxUint64))
+	case 10:
+		if _, err := r.uint32(); err != nil {
+			return err
+		}
+		if _, err := r.uint32(); err != nil {
+			return err
+		}
+		count := f.popI32()
+		source := f.popI32()
+		destination := f.popI32()
+		if uint64(source)+uint64(count) > uint64(len(in.memory)) || uint64(destination)+uint64(count) > uint64(len(in.memory)) {
+			return newTrap("out of bounds memory access")
+		}
+		copy(in.memory[destination:destination+count], in.memory[source:source+count])
+	case 11:
+		if _, err := r.uint32(); err != nil {
+			return err
+		}
+		count := f.popI32()
+		value := byte(f.popI32())
+		destination := f.popI32()
+		if uint64(destination)+uint64(count) > uint64(len(in.memory)) {
+			return newTrap("out of bounds memory access")
+		}
+		for i := uint32(0); i < count; i++ {
+			in.memory[destination+i] = value
+		}
+	default:
+		return newTrap("unsupported extended opcode %d", sub)
+	}
+	return nil
+}
+
+// saturateSigned converts a float to a signed integer clamping out of range values.
+func saturateSigned(value float64, min int64, max int64) int64 {
+	if math.IsNaN(value) {
+		return 0
+	}
+	if value < float64(min) {
+		return min
+	}
+	if value >= float64(max) {
+		return max
+	}
+	return int64(value)
+}
+
+// saturateUnsigned converts a float to an unsigned integer clamping out of range values.
+func saturateUnsigned(value float64, max uint64) uint64 {
+	if math.IsNaN(value) || value <= 0 {
+		return 0
+	}
+	if value >= float64(max) {
+		return max
+	}
+	return uint64(value)
+}